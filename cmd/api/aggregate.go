@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aggregateDimensions and aggregateMetrics whitelist what GET /aggregate may
+// group by and compute, mapping each name to its SQL over note.
+var aggregateDimensions = map[string]string{
+	"classification":        `COALESCE(classification, '')`,
+	"believable":            `COALESCE(believable, '')`,
+	"harmful":               `COALESCE(harmful, '')`,
+	"validation_difficulty": `COALESCE(validationdifficulty, '')`,
+	"is_media_note":         `is_media_note::text`,
+	"is_collaborative_note": `is_collaborative_note::text`,
+	"trustworthy_sources":   `has_trustworthy_sources::text`,
+}
+
+var aggregateMetrics = map[string]string{
+	"count":                `COUNT(*)`,
+	"authors":              `COUNT(DISTINCT noteauthorparticipantid)`,
+	"tweets":               `COUNT(DISTINCT tweet_id)`,
+	"trustworthy_share":    `AVG(has_trustworthy_sources::int)`,
+	"media_share":          `AVG(is_media_note::int)`,
+	"avg_summary_length":   `AVG(length(summary))`,
+	"notes_per_author":     `COUNT(*)::float8 / NULLIF(COUNT(DISTINCT noteauthorparticipantid), 0)`,
+	"misleading_share":     `AVG((classification = 'MISINFORMED_OR_POTENTIALLY_MISLEADING')::int)`,
+	"not_misleading_share": `AVG((classification = 'NOT_MISLEADING')::int)`,
+}
+
+var aggregateBuckets = []string{"day", "week", "month", "year"}
+
+const maxAggregateGroups = 10000
+
+type AggregateRow struct {
+	Bucket *time.Time        `json:"bucket,omitempty"`
+	Group  map[string]string `json:"group"`
+	Value  *float64          `json:"value"`
+}
+
+type AggregateResponse struct {
+	GroupBy   []string       `json:"group_by"`
+	Metric    string         `json:"metric"`
+	Bucket    string         `json:"bucket,omitempty"`
+	Rows      []AggregateRow `json:"rows"`
+	Truncated bool           `json:"truncated"`
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// getAggregate groups notes by up to three whitelisted dimensions and an
+// optional time bucket on created_at, computing one metric per group.
+func getAggregate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	groupBy := splitList(q.Get("group_by"))
+	if len(groupBy) > 3 {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "group_by takes at most 3 dimensions")
+		return
+	}
+	var selects, groups []string
+	for _, d := range groupBy {
+		expr, ok := aggregateDimensions[d]
+		if !ok {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "group_by must be among "+strings.Join(sortedKeys(aggregateDimensions), ", "))
+			return
+		}
+		selects = append(selects, expr)
+		groups = append(groups, strconv.Itoa(len(selects)+1))
+	}
+
+	metric := q.Get("metric")
+	if metric == "" {
+		metric = "count"
+	}
+	metricSQL, ok := aggregateMetrics[metric]
+	if !ok {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "metric must be one of "+strings.Join(sortedKeys(aggregateMetrics), ", "))
+		return
+	}
+
+	bucket := q.Get("bucket")
+	bucketSQL := `NULL::timestamptz`
+	if bucket != "" {
+		if !slices.Contains(aggregateBuckets, bucket) {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "bucket must be one of "+strings.Join(aggregateBuckets, ", "))
+			return
+		}
+		bucketSQL = fmt.Sprintf(`date_trunc('%s', created_at, 'UTC')`, bucket)
+		groups = append([]string{"1"}, groups...)
+	}
+
+	where := []string{notExcluded("n")}
+	var args []any
+	if v := q.Get("from"); v != "" {
+		from, err := parseFilterTime(v, false)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "from must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+			return
+		}
+		args = append(args, from)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := parseFilterTime(v, true)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "to must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+			return
+		}
+		args = append(args, to)
+		where = append(where, fmt.Sprintf("created_at <= $%d", len(args)))
+	}
+
+	query := `SELECT ` + bucketSQL
+	for _, s := range selects {
+		query += ", " + s
+	}
+	query += ", (" + metricSQL + ")::float8 FROM {note} n WHERE " + strings.Join(where, " AND ")
+	if len(groups) > 0 {
+		query += " GROUP BY " + strings.Join(groups, ", ") + " ORDER BY " + strings.Join(groups, ", ")
+	}
+	args = append(args, maxAggregateGroups+1)
+	query += fmt.Sprintf(" LIMIT $%d", len(args))
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, query), args...)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to aggregate notes: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := AggregateResponse{GroupBy: groupBy, Metric: metric, Bucket: bucket, Rows: []AggregateRow{}}
+	if resp.GroupBy == nil {
+		resp.GroupBy = []string{}
+	}
+	for rows.Next() {
+		if len(resp.Rows) == maxAggregateGroups {
+			resp.Truncated = true
+			break
+		}
+		var b sql.NullTime
+		var value sql.NullFloat64
+		keys := make([]string, len(groupBy))
+		dest := []any{&b}
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		dest = append(dest, &value)
+		if err := rows.Scan(dest...); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to aggregate notes: "+err.Error())
+			return
+		}
+		row := AggregateRow{Bucket: nullTimeToTimePtr(b), Group: map[string]string{}, Value: nullFloat64ToFloat64Ptr(value)}
+		for i, d := range groupBy {
+			row.Group[d] = keys[i]
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to aggregate notes: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
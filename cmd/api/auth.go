@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	authEnabled       = getEnvBool("AUTH_ENABLED", true)
+	authAnonymousRead = getEnvBool("AUTH_ANONYMOUS_READ", true)
+	adminAPIKey       = getEnv("ADMIN_API_KEY", "")
+	internalAPIKey    = generateAPIKey()
+)
+
+const (
+	roleReader = "reader"
+	roleAdmin  = "admin"
+)
+
+const (
+	triggerUser     = "user"
+	triggerAPIKey   = "api-key"
+	triggerSchedule = "schedule"
+	triggerStartup  = "startup"
+)
+
+var importTriggers = []string{triggerUser, triggerAPIKey, triggerSchedule, triggerStartup}
+
+type principal struct {
+	Name string
+	Role string
+	Kind string
+}
+
+type principalKey struct{}
+
+func generateAPIKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ensureAdminAPIKey generates a bootstrap admin key when auth is on and
+// ADMIN_API_KEY is unset, so a fresh install is locked down but still usable.
+// The key only lasts until the next restart.
+func ensureAdminAPIKey() {
+	if !authEnabled || adminAPIKey != "" {
+		return
+	}
+	adminAPIKey = generateAPIKey()
+	logger.Warn("ADMIN_API_KEY not set, generated an admin key for this run", "admin_api_key", adminAPIKey)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return ""
+}
+
+func lookupAPIKey(ctx context.Context, key string) (principal, bool) {
+	if subtle.ConstantTimeCompare([]byte(key), []byte(internalAPIKey)) == 1 {
+		return principal{Name: "scheduler", Role: roleAdmin, Kind: triggerSchedule}, true
+	}
+	if adminAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(adminAPIKey)) == 1 {
+		return principal{Name: "admin", Role: roleAdmin, Kind: triggerAPIKey}, true
+	}
+
+	p := principal{Kind: triggerAPIKey}
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		UPDATE {api_keys} SET last_used_at = NOW()
+		WHERE key_hash = $1
+		RETURNING name, role
+	`), hashAPIKey(key)).Scan(&p.Name, &p.Role)
+	if err != nil {
+		return principal{}, false
+	}
+	return p, true
+}
+
+func principalFromContext(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(principal)
+	return p, ok
+}
+
+func requiredRole(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/admin/keys") || strings.HasPrefix(r.URL.Path, "/admin/replication/") || strings.HasPrefix(r.URL.Path, "/debug/") || r.URL.Path == "/webhooks" || strings.HasPrefix(r.URL.Path, "/webhooks/") {
+		return roleAdmin
+	}
+	if r.URL.Path == "/query" || r.URL.Path == "/exports" {
+		return roleReader
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return roleReader
+	}
+	return roleAdmin
+}
+
+func hasRole(p principal, required string) bool {
+	switch required {
+	case roleReader:
+		return p.Role == roleReader || p.Role == roleAdmin
+	case roleAdmin:
+		return p.Role == roleAdmin
+	}
+	return false
+}
+
+func isPublicPath(path string) bool {
+	return path == "/health" || path == "/freshness" || path == "/version" || path == "/config"
+}
+
+// allowsAnonymous keeps snapshot downloads behind a key even when anonymous
+// reads are on, since a mirror is an easy way to burn bandwidth, and likewise
+// ad-hoc queries and exports, which can burn database time.
+func allowsAnonymous(r *http.Request, required string) bool {
+	if required != roleReader || !authAnonymousRead {
+		return false
+	}
+	return r.URL.Path != "/cache" && !strings.HasPrefix(r.URL.Path, "/cache/") && r.URL.Path != "/query" &&
+		r.URL.Path != "/exports" && !strings.HasPrefix(r.URL.Path, "/exports/")
+}
+
+func authenticate(ctx context.Context, key string) (principal, error) {
+	if oidcEnabled() && looksLikeJWT(key) {
+		p, err := authenticateJWT(ctx, key)
+		if err != nil {
+			logger.Warn("Bearer token rejected", "error", err)
+			return principal{}, errors.New("Invalid bearer token")
+		}
+		return p, nil
+	}
+	p, ok := lookupAPIKey(ctx, key)
+	if !ok {
+		return principal{}, errors.New("Invalid API key")
+	}
+	return p, nil
+}
+
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authEnabled || isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		required := requiredRole(r)
+
+		key := requestAPIKey(r)
+		if key == "" {
+			if allowsAnonymous(r, required) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="x-notes"`)
+			writeProblem(w, http.StatusUnauthorized, errCodeUnauthorized, "API key required")
+			return
+		}
+
+		p, err := authenticate(r.Context(), key)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="x-notes", error="invalid_token"`)
+			writeProblem(w, http.StatusUnauthorized, errCodeInvalidToken, err.Error())
+			return
+		}
+
+		if !hasRole(p, required) {
+			writeProblem(w, http.StatusForbidden, errCodeForbidden, "Role '"+required+"' required")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if req.Name == "" {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "name is required")
+		return
+	}
+	if req.Role != roleReader && req.Role != roleAdmin {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "role must be 'reader' or 'admin'")
+		return
+	}
+
+	key := generateAPIKey()
+	var k APIKey
+	err := db.QueryRowContext(r.Context(), expandSQL(r.Context(), `
+		INSERT INTO {api_keys} (name, role, key_hash, created_at)
+		VALUES ($1, $2, $3, NOW())
+		RETURNING id, name, role, created_at
+	`), req.Name, req.Role, hashAPIKey(key)).Scan(&k.ID, &k.Name, &k.Role, &k.CreatedAt)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to create API key: "+err.Error())
+		return
+	}
+	k.Key = key
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(k)
+}
+
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), expandSQL(r.Context(), `SELECT id, name, role, created_at, last_used_at FROM {api_keys} ORDER BY id`))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list API keys: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Role, &k.CreatedAt, &lastUsedAt); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list API keys: "+err.Error())
+			return
+		}
+		k.LastUsedAt = nullTimeToTimePtr(lastUsedAt)
+		keys = append(keys, k)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	result, err := db.ExecContext(r.Context(), expandSQL(r.Context(), `DELETE FROM {api_keys} WHERE id = $1`), r.PathValue("id"))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to delete API key: "+err.Error())
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		writeProblem(w, http.StatusNotFound, errCodeAPIKeyNotFound, "API key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
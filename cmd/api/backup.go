@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	pgDumpPath    = getEnv("PG_DUMP_PATH", "pg_dump")
+	pgRestorePath = getEnv("PG_RESTORE_PATH", "pg_restore")
+)
+
+// backupTables are the dataset tables a backup holds; import bookkeeping,
+// API keys and logs stay out so that a restore does not rewind them.
+var backupTables = []string{
+	"note",
+	"note_fingerprints",
+	"note_schema_versions",
+	"note_embeddings",
+	"note_duplicates",
+	"note_topics",
+	"topic_clusters",
+}
+
+type Backup struct {
+	BackupID     string     `json:"backup_id"`
+	Kind         string     `json:"kind"`
+	Status       string     `json:"status"`
+	StartedAt    time.Time  `json:"started_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	FileName     *string    `json:"file_name,omitempty"`
+	SizeBytes    *int64     `json:"size_bytes,omitempty"`
+	ObjectKey    *string    `json:"object_key,omitempty"`
+	Tables       []string   `json:"tables"`
+	SourceID     *string    `json:"source_backup_id,omitempty"`
+	Note         *string    `json:"note,omitempty"`
+	ErrorMessage *string    `json:"error_message,omitempty"`
+}
+
+type BackupRequest struct {
+	Note string `json:"note"`
+}
+
+type RestoreRequest struct {
+	BackupID string `json:"backup_id"`
+}
+
+const backupColumns = `backup_id, kind, status, started_at, completed_at, file_name, size_bytes, object_key, tables, source_backup_id::text, note, error_message`
+
+func scanBackup(row rowScanner) (Backup, error) {
+	var b Backup
+	var completedAt sql.NullTime
+	var fileName, objectKey, sourceID, note, errMsg sql.NullString
+	var size sql.NullInt64
+	err := row.Scan(&b.BackupID, &b.Kind, &b.Status, &b.StartedAt, &completedAt, &fileName, &size, &objectKey, scanArray(&b.Tables), &sourceID, &note, &errMsg)
+	if err != nil {
+		return b, err
+	}
+	b.CompletedAt = nullTimeToTimePtr(completedAt)
+	b.FileName = nullStringToStrPtr(fileName)
+	b.SizeBytes = nullInt64ToInt64Ptr(size)
+	b.ObjectKey = nullStringToStrPtr(objectKey)
+	b.SourceID = nullStringToStrPtr(sourceID)
+	b.Note = nullStringToStrPtr(note)
+	b.ErrorMessage = nullStringToStrPtr(errMsg)
+	if b.Tables == nil {
+		b.Tables = []string{}
+	}
+	return b, nil
+}
+
+func backupDir(ctx context.Context) string {
+	return filepath.Join(workspaceFromContext(ctx).dataDir(), "backups")
+}
+
+// pgEnv passes the connection settings to pg_dump and pg_restore through the
+// libpq environment so the password stays off the command line.
+func pgEnv() []string {
+	return append(os.Environ(), "PGHOST="+dbHost, "PGPORT="+dbPort, "PGUSER="+dbUser, "PGPASSWORD="+dbPassword, "PGDATABASE="+dbName, "PGSSLMODE=disable")
+}
+
+// existingBackupTables returns the backupTables present in the workspace, as
+// pg_dump fails on a -t pattern that matches nothing.
+func existingBackupTables(ctx context.Context) ([]string, error) {
+	var tables []string
+	for _, t := range backupTables {
+		var exists bool
+		if err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, qualifiedTable(ctx, t)).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			tables = append(tables, t)
+		}
+	}
+	return tables, nil
+}
+
+func runPgTool(ctx context.Context, path string, args ...string) error {
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = pgEnv()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", filepath.Base(path), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func finishBackup(ctx context.Context, id string, size int64, err error) {
+	if err != nil {
+		logger.Error("Backup job failed", "backup_id", id, "error", err)
+		db.ExecContext(ctx, expandSQL(ctx, `UPDATE {backup_history} SET status = 'failed', error_message = $1, completed_at = NOW() WHERE backup_id = $2`), err.Error(), id)
+		return
+	}
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {backup_history} SET status = 'completed', size_bytes = NULLIF($1, 0), completed_at = NOW() WHERE backup_id = $2`), size, id)
+}
+
+// runBackup dumps the dataset tables in pg_dump's custom format to path,
+// writing to a temporary file first so a partial dump never looks complete.
+func runBackup(ctx context.Context, id, path string, tables []string, lock *importLock) {
+	defer lock.release()
+	logger.Info("Backup started", "backup_id", id, "file", filepath.Base(path))
+
+	args := []string{"--format=custom", "--file=" + path + ".tmp"}
+	for _, t := range tables {
+		args = append(args, "--table="+qualifiedTable(ctx, t))
+	}
+	err := runPgTool(ctx, pgDumpPath, args...)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	os.Remove(path + ".tmp")
+
+	var size int64
+	if info, serr := os.Stat(path); err == nil && serr == nil {
+		size = info.Size()
+	}
+	finishBackup(ctx, id, size, err)
+	if err != nil {
+		return
+	}
+	logger.Info("Backup completed", "backup_id", id, "bytes", size)
+
+	// The local dump stays for fast restores; the bucket copy survives the
+	// loss of the data volume.
+	if exportBucket != nil {
+		key := exportBucket.key(workspaceFromContext(ctx).Name, "backups/"+filepath.Base(path))
+		if err := exportBucket.upload(ctx, key, path); err != nil {
+			logger.Warn("Failed to upload backup offsite", "backup_id", id, "error", err)
+			return
+		}
+		db.ExecContext(ctx, expandSQL(ctx, `UPDATE {backup_history} SET object_key = $1 WHERE backup_id = $2`), key, id)
+		logger.Info("Backup uploaded offsite", "backup_id", id, "key", key)
+	}
+}
+
+// runRestore replaces the dataset tables with the dump's in one transaction,
+// then reloads PostgREST's schema cache since the tables were recreated. A
+// dump missing from local disk is fetched from the bucket first.
+func runRestore(ctx context.Context, id, path string, objectKey *string, lock *importLock) {
+	defer lock.release()
+	logger.Info("Restore started", "backup_id", id, "file", filepath.Base(path))
+
+	var err error
+	if _, serr := os.Stat(path); serr != nil && objectKey != nil {
+		logger.Info("Fetching backup from the bucket", "backup_id", id, "key", *objectKey)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = exportBucket.download(ctx, *objectKey, path)
+		}
+	}
+	if err == nil {
+		err = runPgTool(ctx, pgRestorePath, "--clean", "--if-exists", "--single-transaction", "--no-owner", "--dbname="+dbName, path)
+	}
+	if err == nil {
+		db.ExecContext(ctx, `NOTIFY pgrst, 'reload schema'`)
+	}
+	finishBackup(ctx, id, 0, err)
+	invalidateResponseCache(ctx)
+	if err == nil {
+		logger.Info("Restore completed", "backup_id", id)
+	}
+}
+
+// lockDataset takes the import lock for a backup or restore, which must not
+// overlap a load, and fails with 409 while a job is active or paused.
+func lockDataset(w http.ResponseWriter, ctx context.Context) *importLock {
+	if _, err := exec.LookPath(pgDumpPath); err != nil {
+		writeProblem(w, http.StatusServiceUnavailable, errCodeBackupUnavailable, "pg_dump not found; set PG_DUMP_PATH and PG_RESTORE_PATH")
+		return nil
+	}
+	lock := lockImports(w, ctx)
+	if lock == nil {
+		return nil
+	}
+	var active int
+	db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COUNT(*) FROM {import_history} WHERE status IN ('importing', 'downloading', 'indexing', 'paused')`)).Scan(&active)
+	if active > 0 {
+		lock.release()
+		writeProblem(w, http.StatusConflict, errCodeImportInProgress, "Import in progress or paused")
+		return nil
+	}
+	return lock
+}
+
+func createBackup(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
+
+	var req BackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	lock := lockDataset(w, ctx)
+	if lock == nil {
+		return
+	}
+	defer func() { lock.release() }()
+
+	tables, err := existingBackupTables(ctx)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list tables: "+err.Error())
+		return
+	}
+	dir := backupDir(ctx)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to create backup directory: "+err.Error())
+		return
+	}
+
+	var note *string
+	if req.Note != "" {
+		note = &req.Note
+	}
+	fileName := fmt.Sprintf("backup-%s.dump", time.Now().UTC().Format("20060102T150405Z"))
+	b, err := scanBackup(db.QueryRowContext(ctx, expandSQL(ctx, `
+		INSERT INTO {backup_history} (kind, status, started_at, file_name, tables, note, owner_instance)
+		VALUES ('backup', 'running', NOW(), $1, $2, $3, $4)
+		RETURNING `+backupColumns), fileName, tables, note, instanceID))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to create backup job: "+err.Error())
+		return
+	}
+
+	go runBackup(ctx, b.BackupID, filepath.Join(dir, fileName), tables, lock)
+	lock = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/admin/backups/"+b.BackupID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(b)
+}
+
+func restoreBackup(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
+
+	var req RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if req.BackupID == "" {
+		writeFieldProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid restore request", []FieldError{{Field: "backup_id", Detail: "is required"}})
+		return
+	}
+
+	var fileName string
+	var objectKey sql.NullString
+	var tables []string
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT file_name, object_key, tables FROM {backup_history}
+		WHERE backup_id::text = $1 AND kind = 'backup' AND status = 'completed'
+	`), req.BackupID).Scan(&fileName, &objectKey, scanArray(&tables))
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeBackupNotFound, "No completed backup with that id")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get backup: "+err.Error())
+		return
+	}
+	path := filepath.Join(backupDir(ctx), fileName)
+	key := nullStringToStrPtr(objectKey)
+	if exportBucket == nil {
+		key = nil
+	}
+	if _, err := os.Stat(path); err != nil && key == nil {
+		writeProblem(w, http.StatusNotFound, errCodeBackupNotFound, "Backup file "+fileName+" is missing")
+		return
+	}
+
+	lock := lockDataset(w, ctx)
+	if lock == nil {
+		return
+	}
+	defer func() { lock.release() }()
+
+	b, err := scanBackup(db.QueryRowContext(ctx, expandSQL(ctx, `
+		INSERT INTO {backup_history} (kind, status, started_at, file_name, tables, source_backup_id, owner_instance)
+		VALUES ('restore', 'running', NOW(), $1, $2, $3, $4)
+		RETURNING `+backupColumns), fileName, tables, req.BackupID, instanceID))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to create restore job: "+err.Error())
+		return
+	}
+
+	go runRestore(ctx, b.BackupID, path, key, lock)
+	lock = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/admin/backups/"+b.BackupID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(b)
+}
+
+func listBackups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT `+backupColumns+` FROM {backup_history} ORDER BY started_at DESC LIMIT $1`), limit)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list backups: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	backups := []Backup{}
+	for rows.Next() {
+		b, err := scanBackup(rows)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list backups: "+err.Error())
+			return
+		}
+		backups = append(backups, b)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list backups: "+err.Error())
+		return
+	}
+	writeList(w, r, backups)
+}
+
+func getBackup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	b, err := scanBackup(db.QueryRowContext(ctx, expandSQL(ctx, `SELECT `+backupColumns+` FROM {backup_history} WHERE backup_id::text = $1`), r.PathValue("backup_id")))
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeBackupNotFound, "Backup not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get backup: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
+
+// sanitizeBackupStatus fails backups and restores this instance left running
+// when it died; pg_dump and pg_restore went down with it.
+func sanitizeBackupStatus() {
+	for _, ws := range workspaceOrder {
+		ctx := withWorkspace(context.Background(), ws)
+		_, err := db.ExecContext(ctx, expandSQL(ctx, `UPDATE {backup_history} SET status = 'failed', error_message = 'Interrupted', completed_at = NOW() WHERE status = 'running' AND (owner_instance IS NULL OR owner_instance = $1)`), instanceID)
+		if err != nil {
+			logger.Warn("Failed to sanitize backup status", "workspace", ws.Name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	benchmarkTable   = "note_benchmark"
+	maxBenchmarkRows = 50_000_000
+)
+
+var benchmarkMu sync.Mutex
+
+type BenchmarkRequest struct {
+	Rows    int   `json:"rows"`
+	Files   int   `json:"files"`
+	Indexes *bool `json:"indexes"`
+	Seed    int64 `json:"seed"`
+}
+
+type BenchmarkResult struct {
+	Rows            int64   `json:"rows"`
+	Files           int     `json:"files"`
+	Bytes           int64   `json:"bytes"`
+	GenerateSeconds float64 `json:"generate_seconds"`
+	CopySeconds     float64 `json:"copy_seconds"`
+	IndexSeconds    float64 `json:"index_seconds"`
+	TotalSeconds    float64 `json:"total_seconds"`
+	RowsPerSec      float64 `json:"rows_per_sec"`
+	MBPerSec        float64 `json:"mb_per_sec"`
+	CopyFromStdin   bool    `json:"copy_from_stdin"`
+}
+
+func decodeBenchmarkRequest(r *http.Request) (BenchmarkRequest, []FieldError, error) {
+	var req BenchmarkRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return req, []FieldError{{Field: typeErr.Field, Detail: "must be a " + typeErr.Type.String()}}, nil
+		}
+		return req, nil, err
+	}
+
+	var errs []FieldError
+	if req.Rows < 0 || req.Rows > maxBenchmarkRows {
+		errs = append(errs, FieldError{Field: "rows", Detail: fmt.Sprintf("must be between 1 and %d", maxBenchmarkRows)})
+	}
+	if req.Files < 0 || req.Files > 100 {
+		errs = append(errs, FieldError{Field: "files", Detail: "must be between 1 and 100"})
+	}
+	return req, errs, nil
+}
+
+// runBenchmark COPYs rows synthetic notes, split over files TSVs, into a
+// scratch copy of note on a load session and rebuilds note's indexes there,
+// so the timings reflect the database alone; note itself is not touched.
+func runBenchmark(ctx context.Context, req BenchmarkRequest) (BenchmarkResult, error) {
+	result := BenchmarkResult{Files: req.Files, CopyFromStdin: copyFromStdin}
+	start := time.Now()
+
+	dir := workspaceFromContext(ctx).dataDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return result, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	rng := rand.New(rand.NewPCG(uint64(req.Seed), uint64(req.Seed)))
+	paths := make([]string, req.Files)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("benchmark-%05d.tsv", i))
+		defer os.Remove(paths[i])
+
+		rows := req.Rows / req.Files
+		if i < req.Rows%req.Files {
+			rows++
+		}
+		size, err := writeSyntheticNotesFile(paths[i], rows, int64(i*maxBenchmarkRows+1), rng)
+		if err != nil {
+			return result, fmt.Errorf("failed to generate %s: %w", paths[i], err)
+		}
+		result.Bytes += size
+	}
+	result.GenerateSeconds = time.Since(start).Seconds()
+
+	header, err := readTSVHeader(paths[0])
+	if err != nil {
+		return result, err
+	}
+	plan, err := planColumns(header)
+	if err != nil {
+		return result, err
+	}
+	existing, err := tableColumns(ctx, "note")
+	if err != nil {
+		return result, fmt.Errorf("failed to read note columns: %w", err)
+	}
+	columnTypes := map[string]string{}
+	for _, c := range existing {
+		columnTypes[c.Name] = c.DataType
+	}
+
+	session, err := openLoadSession(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("failed to open load session: %w", err)
+	}
+	defer session.Close()
+
+	if _, err := session.conn.ExecContext(ctx, expandSQL(ctx, `DROP TABLE IF EXISTS {note_benchmark}`)); err != nil {
+		return result, err
+	}
+	if _, err := session.conn.ExecContext(ctx, expandSQL(ctx, `CREATE TABLE {note_benchmark} (LIKE {note} INCLUDING DEFAULTS INCLUDING GENERATED)`)); err != nil {
+		return result, fmt.Errorf("failed to create scratch table: %w", err)
+	}
+	defer session.conn.ExecContext(context.Background(), expandSQL(ctx, `DROP TABLE IF EXISTS {note_benchmark}`))
+
+	copyStart := time.Now()
+	for _, path := range paths {
+		n, err := copyNoteFile(ctx, session.conn, session, benchmarkTable, plan, columnTypes, path, 0, false)
+		if err != nil {
+			return result, fmt.Errorf("failed to copy %s: %w", filepath.Base(path), err)
+		}
+		result.Rows += n
+	}
+	result.CopySeconds = time.Since(copyStart).Seconds()
+
+	if req.Indexes == nil || *req.Indexes {
+		indexStart := time.Now()
+		for _, idx := range noteIndexes {
+			if _, err := session.conn.ExecContext(ctx, createIndexSQL(ctx, idx, benchmarkTable, "_benchmark")); err != nil {
+				return result, fmt.Errorf("failed to build index %s: %w", idx.Name, err)
+			}
+		}
+		result.IndexSeconds = time.Since(indexStart).Seconds()
+	}
+
+	result.TotalSeconds = time.Since(start).Seconds()
+	if load := result.CopySeconds + result.IndexSeconds; load > 0 {
+		result.RowsPerSec = float64(result.Rows) / load
+	}
+	if result.CopySeconds > 0 {
+		result.MBPerSec = float64(result.Bytes) / (1 << 20) / result.CopySeconds
+	}
+	return result, nil
+}
+
+func postBenchmark(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, fieldErrs, err := decodeBenchmarkRequest(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if len(fieldErrs) > 0 {
+		writeFieldProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid benchmark request", fieldErrs)
+		return
+	}
+	if req.Rows == 0 {
+		req.Rows = 100_000
+	}
+	if req.Files == 0 {
+		req.Files = 1
+	}
+
+	if h, err := currentImport(ctx); err == nil && h != nil {
+		writeProblem(w, http.StatusConflict, errCodeImportInProgress, "Import in progress; a benchmark would compete with it")
+		return
+	}
+	if !benchmarkMu.TryLock() {
+		writeProblem(w, http.StatusConflict, errCodeBenchmarkInProgress, "Another benchmark is running")
+		return
+	}
+	defer benchmarkMu.Unlock()
+
+	logger.Info("Benchmark started", "rows", req.Rows, "files", req.Files)
+	result, err := runBenchmark(ctx, req)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Benchmark failed: "+err.Error())
+		return
+	}
+	logger.Info("Benchmark completed", "rows", result.Rows, "rows_per_sec", int(result.RowsPerSec), "mb_per_sec", result.MBPerSec)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
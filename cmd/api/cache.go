@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	snapshotBaseURL = strings.TrimSuffix(getEnv("SNAPSHOT_BASE_URL", "https://ton.twimg.com/birdwatch-public-data"), "/")
+	snapshotAPIKey  = getEnv("SNAPSHOT_API_KEY", "")
+)
+
+type CachedFile struct {
+	Name       string    `json:"name"`
+	Date       string    `json:"date"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+func snapshotURL(date string, index int) string {
+	return fmt.Sprintf("%s/%s/notes/%s.zip", snapshotBaseURL, formatDateForURL(date), formatFileName(index))
+}
+
+// newSnapshotRequest targets SNAPSHOT_BASE_URL, which is either the public
+// dataset or the /cache tree of another instance; the API key is only needed
+// for the latter.
+func newSnapshotRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if snapshotAPIKey != "" {
+		req.Header.Set("X-API-Key", snapshotAPIKey)
+	}
+	return req, nil
+}
+
+func listCachedFiles(w http.ResponseWriter, r *http.Request) {
+	dir := workspaceFromContext(r.Context()).dataDir()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list cache: "+err.Error())
+		return
+	}
+
+	files := []CachedFile{}
+	for _, entry := range entries {
+		m := localNoteFilePattern.FindStringSubmatch(entry.Name())
+		if m == nil || m[2] == "tsv" || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, CachedFile{Name: entry.Name(), Date: m[1], Size: info.Size(), ModifiedAt: info.ModTime()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+func getCachedFile(w http.ResponseWriter, r *http.Request) {
+	serveCachedFile(w, r, r.PathValue("file"))
+}
+
+// getMirroredFile serves the same files under the upstream
+// {yyyy}/{mm}/{dd}/notes/notes-NNNNN.zip layout, so a peer can point
+// SNAPSHOT_BASE_URL at this instance's /cache.
+func getMirroredFile(w http.ResponseWriter, r *http.Request) {
+	date := r.PathValue("year") + "-" + r.PathValue("month") + "-" + r.PathValue("day")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		writeProblem(w, http.StatusNotFound, errCodeCachedFileNotFound, "Cached file not found")
+		return
+	}
+	serveCachedFile(w, r, date+"-"+r.PathValue("file"))
+}
+
+func serveCachedFile(w http.ResponseWriter, r *http.Request, name string) {
+	m := localNoteFilePattern.FindStringSubmatch(name)
+	if m == nil || m[2] == "tsv" {
+		writeProblem(w, http.StatusNotFound, errCodeCachedFileNotFound, "Cached file not found")
+		return
+	}
+
+	path := filepath.Join(workspaceFromContext(r.Context()).dataDir(), name)
+	f, err := os.Open(path)
+	if err != nil && m[2] == "zip" {
+		if _, zerr := os.Stat(compressedCachePath(path)); zerr == nil {
+			serveZstdAsZip(w, r, compressedCachePath(path), name)
+			return
+		}
+	}
+	if err != nil {
+		writeProblem(w, http.StatusNotFound, errCodeCachedFileNotFound, "Cached file not found")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		writeProblem(w, http.StatusNotFound, errCodeCachedFileNotFound, "Cached file not found")
+		return
+	}
+
+	if m[2] == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+	} else {
+		w.Header().Set("Content-Type", "application/zstd")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
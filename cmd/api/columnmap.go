@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var columnMappingFile = getEnv("COLUMN_MAPPING_FILE", "")
+
+type columnMappingEntry struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Cast        string `json:"cast"`
+	Skip        bool   `json:"skip"`
+	Transform   string `json:"transform"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+
+	transform fieldTransform
+}
+
+type columnMappingConfig struct {
+	IgnoreUnmapped bool                 `json:"ignore_unmapped"`
+	Columns        []columnMappingEntry `json:"columns"`
+}
+
+var columnMapping atomic.Pointer[columnMappingConfig]
+
+var castExpressions = map[string]string{
+	"text":             "%s",
+	"integer":          "NULLIF(%s, '')::integer",
+	"bigint":           "NULLIF(%s, '')::bigint",
+	"numeric":          "NULLIF(%s, '')::numeric",
+	"double precision": "NULLIF(%s, '')::double precision",
+	"boolean":          "NULLIF(%s, '')::boolean",
+	"date":             "NULLIF(%s, '')::date",
+	"timestamptz":      "NULLIF(%s, '')::timestamptz",
+	"epoch_ms":         "to_timestamp(NULLIF(%s, '')::bigint / 1000.0)",
+}
+
+var castColumnTypes = map[string]string{
+	"text":             "TEXT",
+	"integer":          "INTEGER",
+	"bigint":           "BIGINT",
+	"numeric":          "NUMERIC",
+	"double precision": "DOUBLE PRECISION",
+	"boolean":          "BOOLEAN",
+	"date":             "DATE",
+	"timestamptz":      "TIMESTAMPTZ",
+	"epoch_ms":         "TIMESTAMPTZ",
+}
+
+// castInputTypes is what a staged value must parse as for its cast to succeed,
+// where that is not the cast's own type.
+var castInputTypes = map[string]string{
+	"epoch_ms": "bigint",
+}
+
+func loadColumnMapping() error {
+	if columnMappingFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(columnMappingFile)
+	if err != nil {
+		return fmt.Errorf("failed to read column mapping: %w", err)
+	}
+
+	var cfg columnMappingConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("failed to parse column mapping: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for i := range cfg.Columns {
+		c := &cfg.Columns[i]
+		c.Source = strings.ToLower(c.Source)
+		if c.Source == "" {
+			return fmt.Errorf("column mapping entry %d has no source", i)
+		}
+		if seen[c.Source] {
+			return fmt.Errorf("column mapping has duplicate source %q", c.Source)
+		}
+		seen[c.Source] = true
+		if c.Skip {
+			continue
+		}
+		if c.Target == "" {
+			c.Target = c.Source
+		}
+		if !identifierPattern.MatchString(c.Target) {
+			return fmt.Errorf("column mapping target %q is not a valid column name", c.Target)
+		}
+		if _, ok := castExpressions[c.Cast]; c.Cast != "" && !ok {
+			return fmt.Errorf("column mapping for %q has unsupported cast %q", c.Source, c.Cast)
+		}
+		if c.Transform != "" {
+			if c.transform, err = buildFieldTransform(*c); err != nil {
+				return fmt.Errorf("column mapping for %q: %w", c.Source, err)
+			}
+		}
+	}
+
+	columnMapping.Store(&cfg)
+	logger.Info("Loaded column mapping", "path", columnMappingFile, "columns", len(cfg.Columns), "ignore_unmapped", cfg.IgnoreUnmapped)
+	return nil
+}
+
+type plannedColumn struct {
+	Source string
+	Target string
+	Cast   string
+	Skip   bool
+
+	transform fieldTransform
+}
+
+type columnPlan struct {
+	Columns []plannedColumn
+
+	purged map[string]bool
+}
+
+func planColumns(header []string) (columnPlan, error) {
+	var plan columnPlan
+	mapping := columnMapping.Load()
+	for _, source := range header {
+		pc := plannedColumn{Source: source, Target: source}
+
+		var entry *columnMappingEntry
+		if mapping != nil {
+			for i := range mapping.Columns {
+				if mapping.Columns[i].Source == source {
+					entry = &mapping.Columns[i]
+					break
+				}
+			}
+		}
+
+		switch {
+		case entry != nil && entry.Skip:
+			pc.Skip = true
+		case entry != nil:
+			pc.Target = entry.Target
+			pc.Cast = entry.Cast
+			pc.transform = entry.transform
+		case mapping != nil && mapping.IgnoreUnmapped:
+			pc.Skip = true
+		case source == "createdatmillis":
+			pc.Target, pc.Cast = "created_at", "epoch_ms"
+		}
+
+		if !pc.Skip && pc.transform == nil && pseudonymizeSecret != "" && isParticipantColumn(pc.Target) {
+			pc.transform = pseudonymizeField
+		}
+		if !pc.Skip && !identifierPattern.MatchString(pc.Target) {
+			return plan, fmt.Errorf("unsupported column name %q in TSV header", pc.Target)
+		}
+		plan.Columns = append(plan.Columns, pc)
+	}
+	return plan, nil
+}
+
+func (p columnPlan) targets() []string {
+	var targets []string
+	for _, c := range p.Columns {
+		if !c.Skip {
+			targets = append(targets, c.Target)
+		}
+	}
+	return targets
+}
+
+// direct reports whether the file can be COPY'd straight into note; renames
+// alone are handled by COPY's column list, skips and casts need staging.
+func (p columnPlan) direct() bool {
+	for _, c := range p.Columns {
+		if c.Skip || c.Cast != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (p columnPlan) upsertAssignments() string {
+	var sets []string
+	for _, c := range p.targets() {
+		if c != "noteid" {
+			col := quoteIdent(c)
+			sets = append(sets, col+" = EXCLUDED."+col)
+		}
+	}
+	return strings.Join(sets, ", ")
+}
+
+func (p columnPlan) stagingColumns() string {
+	cols := make([]string, len(p.Columns))
+	for i, c := range p.Columns {
+		cols[i] = quoteIdent(c.Source) + " TEXT"
+	}
+	return strings.Join(cols, ", ")
+}
+
+func (p columnPlan) selectExpressions(columnTypes map[string]string) string {
+	var exprs []string
+	for _, c := range p.Columns {
+		if c.Skip {
+			continue
+		}
+		src := quoteIdent(c.Source)
+		switch {
+		case c.Cast != "":
+			exprs = append(exprs, fmt.Sprintf(castExpressions[c.Cast], src))
+		case columnTypes[c.Target] == "text" || columnTypes[c.Target] == "character varying":
+			exprs = append(exprs, src)
+		default:
+			exprs = append(exprs, src+"::"+columnTypes[c.Target])
+		}
+	}
+	return strings.Join(exprs, ", ")
+}
+
+// columnCheck is a staged column whose SELECT expression can fail on bad
+// input, with the predicate a value must satisfy to load.
+type columnCheck struct {
+	Source string
+	Type   string
+	Valid  string
+}
+
+// checks lists the staged columns that are cast on insert, so rows with a
+// value the cast would reject can be set aside rather than fail the file.
+func (p columnPlan) checks(columnTypes map[string]string) []columnCheck {
+	var checks []columnCheck
+	for _, c := range p.Columns {
+		if c.Skip {
+			continue
+		}
+		src := quoteIdent(c.Source)
+		typ, value := columnTypes[c.Target], src
+		if c.Cast != "" {
+			typ, value = castColumnTypes[c.Cast], "NULLIF("+src+", '')"
+			if in, ok := castInputTypes[c.Cast]; ok {
+				typ = in
+			}
+		}
+		switch strings.ToLower(typ) {
+		case "", "text", "character varying":
+			continue
+		}
+		checks = append(checks, columnCheck{
+			Source: c.Source,
+			Type:   strings.ToLower(typ),
+			Valid:  fmt.Sprintf("(%s IS NULL OR pg_input_is_valid(%s, '%s'))", value, value, strings.ToLower(typ)),
+		})
+	}
+	return checks
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestPlanColumnsLoadsCreatedAtMillisAsTimestamp(t *testing.T) {
+	plan, err := planColumns([]string{"noteid", "createdatmillis"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.targets(); len(got) != 2 || got[1] != "created_at" {
+		t.Fatalf("targets = %v", got)
+	}
+	if plan.direct() || plan.transformsRows() {
+		t.Errorf("createdAtMillis should be converted server-side through staging")
+	}
+
+	columnTypes := map[string]string{"noteid": "bigint", "created_at": "timestamp with time zone"}
+	if got, want := plan.selectExpressions(columnTypes), `"noteid"::bigint, to_timestamp(NULLIF("createdatmillis", '')::bigint / 1000.0)`; got != want {
+		t.Errorf("select = %s, want %s", got, want)
+	}
+
+	checks := plan.checks(columnTypes)
+	if len(checks) != 2 || checks[1].Source != "createdatmillis" || checks[1].Type != "bigint" {
+		t.Fatalf("checks = %+v", checks)
+	}
+	if want := `(NULLIF("createdatmillis", '') IS NULL OR pg_input_is_valid(NULLIF("createdatmillis", ''), 'bigint'))`; checks[1].Valid != want {
+		t.Errorf("check = %s, want %s", checks[1].Valid, want)
+	}
+}
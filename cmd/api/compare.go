@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var errImportNotCompleted = errors.New("import not completed")
+
+// ImportDelta is to minus from; percentages are relative to from and omitted
+// when from is zero or either side is unknown.
+type ImportDelta struct {
+	Rows             int      `json:"rows"`
+	RowsPct          *float64 `json:"rows_change_pct,omitempty"`
+	Files            *int     `json:"files,omitempty"`
+	Bytes            *int64   `json:"bytes,omitempty"`
+	BytesPct         *float64 `json:"bytes_change_pct,omitempty"`
+	TotalSeconds     float64  `json:"total_seconds"`
+	TotalSecondsPct  *float64 `json:"total_seconds_change_pct,omitempty"`
+	DownloadSeconds  *float64 `json:"download_seconds,omitempty"`
+	LoadSeconds      *float64 `json:"load_seconds,omitempty"`
+	IndexSeconds     *float64 `json:"index_seconds,omitempty"`
+	RowsPerSecPct    *float64 `json:"rows_per_sec_change_pct,omitempty"`
+	SnapshotSame     bool     `json:"snapshot_unchanged"`
+	ModeChanged      bool     `json:"mode_changed"`
+	CachedChanged    bool     `json:"cached_changed"`
+	SlowestFileIndex *int     `json:"most_slowed_file_index,omitempty"`
+}
+
+// FileComparison pairs the files two imports loaded at the same index.
+type FileComparison struct {
+	Index        int         `json:"index"`
+	From         *ImportFile `json:"from,omitempty"`
+	To           *ImportFile `json:"to,omitempty"`
+	RowsDelta    *int        `json:"rows_delta,omitempty"`
+	SizeDelta    *int64      `json:"size_delta,omitempty"`
+	SecondsDelta *int        `json:"import_seconds_delta,omitempty"`
+	Changed      bool        `json:"content_changed"`
+}
+
+type ImportComparison struct {
+	From  ImportPerformance `json:"from"`
+	To    ImportPerformance `json:"to"`
+	Delta ImportDelta       `json:"delta"`
+	Files []FileComparison  `json:"files"`
+}
+
+func loadComparedImport(ctx context.Context, jobID string) (ImportPerformance, *string, error) {
+	var fingerprint sql.NullString
+	var status string
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT status, snapshot_fingerprint FROM {import_history} WHERE job_id::text = $1`), jobID).Scan(&status, &fingerprint)
+	if err != nil {
+		return ImportPerformance{}, nil, err
+	}
+	if status != "completed" && status != "completed_with_warnings" {
+		return ImportPerformance{}, nil, errImportNotCompleted
+	}
+	p, err := scanImportPerformance(db.QueryRowContext(ctx, expandSQL(ctx, `SELECT `+performanceColumns+` FROM {import_history} WHERE job_id::text = $1`), jobID))
+	return p, nullStringToStrPtr(fingerprint), err
+}
+
+func diffPtr[T int | int64 | float64](from, to *T) *T {
+	if from == nil || to == nil {
+		return nil
+	}
+	d := *to - *from
+	return &d
+}
+
+func compareImports(from, to ImportPerformance, fromFiles, toFiles []ImportFile) ImportComparison {
+	c := ImportComparison{From: from, To: to, Files: []FileComparison{}}
+	d := &c.Delta
+	d.Rows = to.TotalRows - from.TotalRows
+	fromRows, toRows := float64(from.TotalRows), float64(to.TotalRows)
+	d.RowsPct = percentChange(&toRows, &fromRows)
+	d.Files = diffPtr(from.Files, to.Files)
+	d.Bytes = diffPtr(from.Bytes, to.Bytes)
+	if from.Bytes != nil && to.Bytes != nil {
+		fb, tb := float64(*from.Bytes), float64(*to.Bytes)
+		d.BytesPct = percentChange(&tb, &fb)
+	}
+	d.TotalSeconds = to.Phases.Total - from.Phases.Total
+	d.TotalSecondsPct = percentChange(&to.Phases.Total, &from.Phases.Total)
+	d.DownloadSeconds = diffPtr(from.Phases.Download, to.Phases.Download)
+	d.LoadSeconds = diffPtr(from.Phases.Load, to.Phases.Load)
+	d.IndexSeconds = diffPtr(from.Phases.Index, to.Phases.Index)
+	d.RowsPerSecPct = percentChange(to.RowsPerSec, from.RowsPerSec)
+	d.ModeChanged = from.Mode != to.Mode
+	d.CachedChanged = from.Cached != to.Cached
+
+	byIndex := map[int]*FileComparison{}
+	var order []int
+	pair := func(i int) *FileComparison {
+		if fc, ok := byIndex[i]; ok {
+			return fc
+		}
+		byIndex[i] = &FileComparison{Index: i}
+		order = append(order, i)
+		return byIndex[i]
+	}
+	for i := range fromFiles {
+		pair(fromFiles[i].Index).From = &fromFiles[i]
+	}
+	for i := range toFiles {
+		pair(toFiles[i].Index).To = &toFiles[i]
+	}
+
+	var slowest int
+	for _, i := range order {
+		fc := byIndex[i]
+		if fc.From != nil && fc.To != nil {
+			fc.RowsDelta = diffPtr(fc.From.RowsImported, fc.To.RowsImported)
+			fc.SizeDelta = diffPtr(fc.From.Size, fc.To.Size)
+			fc.SecondsDelta = diffPtr(fc.From.ImportDuration, fc.To.ImportDuration)
+			fc.Changed = fc.From.ContentHash == nil || fc.To.ContentHash == nil || *fc.From.ContentHash != *fc.To.ContentHash
+			if fc.SecondsDelta != nil && *fc.SecondsDelta > slowest {
+				slowest = *fc.SecondsDelta
+				d.SlowestFileIndex = &fc.Index
+			}
+		} else {
+			fc.Changed = true
+		}
+		c.Files = append(c.Files, *fc)
+	}
+	return c
+}
+
+// getImportComparison summarizes how the import to differs from the import
+// from: row, size and phase-duration deltas, then file by file, so that a
+// slow or short run can be traced to the phase or file that changed.
+func getImportComparison(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fromID, toID := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if fromID == "" || toID == "" {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "from and to job ids are required")
+		return
+	}
+
+	var runs [2]ImportPerformance
+	var fingerprints [2]*string
+	var files [2][]ImportFile
+	for i, id := range []string{fromID, toID} {
+		p, fingerprint, err := loadComparedImport(ctx, id)
+		switch {
+		case err == sql.ErrNoRows:
+			writeProblem(w, http.StatusNotFound, errCodeImportNotFound, "Import job not found: "+id)
+			return
+		case err == errImportNotCompleted:
+			writeProblem(w, http.StatusConflict, errCodeImportNotCompleted, "Import job has not completed: "+id)
+			return
+		case err != nil:
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import: "+err.Error())
+			return
+		}
+		f, err := getImportFiles(ctx, p.JobID)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import files: "+err.Error())
+			return
+		}
+		runs[i], fingerprints[i], files[i] = p, fingerprint, f
+	}
+
+	c := compareImports(runs[0], runs[1], files[0], files[1])
+	c.Delta.SnapshotSame = fingerprints[0] != nil && fingerprints[1] != nil && *fingerprints[0] == *fingerprints[1]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
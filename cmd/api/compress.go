@@ -0,0 +1,213 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	cacheCompression = getEnv("CACHE_COMPRESSION", "")
+	cacheZstdLevel   = getEnvInt("CACHE_ZSTD_LEVEL", 9)
+)
+
+func validateCacheCompression() error {
+	switch cacheCompression {
+	case "", "zstd":
+	default:
+		return fmt.Errorf("unknown CACHE_COMPRESSION %q", cacheCompression)
+	}
+	if cacheZstdLevel < 1 || cacheZstdLevel > 22 {
+		return fmt.Errorf("CACHE_ZSTD_LEVEL must be between 1 and 22")
+	}
+	return nil
+}
+
+func compressedCachePath(zipPath string) string {
+	return strings.TrimSuffix(zipPath, ".zip") + ".tsv.zst"
+}
+
+// cachedFileSize reports whether a snapshot file is already cached, either as
+// the downloaded zip or as its zstd-recompressed TSV.
+func cachedFileSize(zipPath string) (int64, bool) {
+	if info, err := os.Stat(zipPath); err == nil {
+		return info.Size(), true
+	}
+	if info, err := os.Stat(compressedCachePath(zipPath)); err == nil {
+		return info.Size(), true
+	}
+	return 0, false
+}
+
+// unpackCachedFile produces the TSV for a cached snapshot file. A zip is
+// extracted and, with CACHE_COMPRESSION=zstd, replaced by a .tsv.zst copy of
+// the extracted TSV; otherwise the .tsv.zst is decompressed.
+func unpackCachedFile(zipPath string, fileIndex int) (string, error) {
+	zstPath := compressedCachePath(zipPath)
+
+	if _, err := os.Stat(zipPath); err == nil {
+		tsvPath, err := extractTSV(zipPath, fileIndex)
+		if err != nil {
+			return "", err
+		}
+		if cacheCompression == "zstd" {
+			if err := compressFile(tsvPath, zstPath); err != nil {
+				logger.Warn("Failed to recompress cached file, keeping zip", "path", zipPath, "error", err)
+				return tsvPath, nil
+			}
+			os.Remove(zipPath)
+			logger.Info("Recompressed cached file", "path", zstPath)
+		}
+		return tsvPath, nil
+	}
+
+	tsvPath := strings.TrimSuffix(zipPath, ".zip") + ".tsv"
+	if err := decompressFile(zstPath, tsvPath); err != nil {
+		return "", err
+	}
+	logger.Info("Decompressed TSV", "path", tsvPath)
+	return tsvPath, nil
+}
+
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	enc, err := zstd.NewWriter(out, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(cacheZstdLevel)))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(enc, in); err != nil {
+		enc.Close()
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func decompressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	dec, err := zstd.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	os.Remove(dst)
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create tsv: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, dec); err != nil {
+		return fmt.Errorf("failed to decompress %s: %w", src, err)
+	}
+	return out.Close()
+}
+
+// serveZstdAsZip answers a mirror request for a zip that CACHE_COMPRESSION=zstd
+// has replaced with a .tsv.zst, by re-wrapping the TSV as the single-entry zip
+// upstream publishes. The entry is stored rather than deflated so the length
+// is known up front: peers size their downloads from HEAD's Content-Length.
+func serveZstdAsZip(w http.ResponseWriter, r *http.Request, zstPath, name string) {
+	info, err := os.Stat(zstPath)
+	if err != nil {
+		writeProblem(w, http.StatusNotFound, errCodeCachedFileNotFound, "Cached file not found")
+		return
+	}
+	crc, size, err := zstdChecksum(zstPath)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to read cached file: "+err.Error())
+		return
+	}
+	if size >= math.MaxUint32 {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Cached file too large to serve as a zip")
+		return
+	}
+
+	entry := strings.TrimSuffix(name[len("2006-01-02-"):], ".zip") + ".tsv"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(storedZipSize(entry, size), 10))
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	in, err := os.Open(zstPath)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	dec, err := zstd.NewReader(in)
+	if err != nil {
+		return
+	}
+	defer dec.Close()
+
+	zw := zip.NewWriter(w)
+	fw, err := zw.CreateRaw(&zip.FileHeader{Name: entry, Method: zip.Store, CRC32: crc, CompressedSize64: uint64(size), UncompressedSize64: uint64(size)})
+	if err != nil {
+		return
+	}
+	if _, err := io.Copy(fw, dec); err != nil {
+		logger.Warn("Failed to serve cached file as zip", "path", zstPath, "error", err)
+		return
+	}
+	zw.Close()
+}
+
+// zstdChecksum returns the CRC-32 and length of the TSV in a .tsv.zst.
+func zstdChecksum(zstPath string) (uint32, int64, error) {
+	in, err := os.Open(zstPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer in.Close()
+	dec, err := zstd.NewReader(in)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dec.Close()
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, dec)
+	return h.Sum32(), n, err
+}
+
+// storedZipSize is the length of a zip holding one stored entry of size bytes
+// and no extra fields: local header, data, central directory record and end of
+// central directory.
+func storedZipSize(entry string, size int64) int64 {
+	return 30 + int64(len(entry)) + size + 46 + int64(len(entry)) + 22
+}
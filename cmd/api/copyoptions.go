@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	copyFormat           = getEnv("COPY_FORMAT", "csv")
+	copyDelimiterSetting = getEnv("COPY_DELIMITER", "tab")
+	copyNull             = getEnv("COPY_NULL", "")
+	copyQuote            = getEnv("COPY_QUOTE", "")
+	copyEscape           = getEnv("COPY_ESCAPE", "")
+	copyEncoding         = getEnv("COPY_ENCODING", "")
+)
+
+// copyOptions is the WITH clause of every notes COPY, copyDelimiter the field
+// separator of the TSVs and copyQuoteChar and copyEscapeChar their CSV quoting,
+// all resolved from the COPY_* settings by validateCopyOptions.
+var (
+	copyOptions    = `WITH (FORMAT csv, DELIMITER E'\t', HEADER true)`
+	copyDelimiter  = "\t"
+	copyQuoteChar  = `"`
+	copyEscapeChar = `"`
+)
+
+// copyNoQuote stands in for COPY_QUOTE=none: a control character that never
+// occurs in the snapshots, so quote characters in summaries load literally.
+const copyNoQuote = "\x01"
+
+var encodingPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// unescapeCopyChar accepts the spellings of a one-byte option that survive an
+// environment variable: the character itself, tab, \t or a \xNN escape.
+func unescapeCopyChar(name, v string) (string, error) {
+	switch v {
+	case "tab", `\t`:
+		return "\t", nil
+	case "none":
+		if name == "COPY_QUOTE" {
+			return copyNoQuote, nil
+		}
+	}
+	var b byte
+	if _, err := fmt.Sscanf(v, `\x%02x`, &b); err == nil && len(v) == 4 {
+		return string([]byte{b}), nil
+	}
+	if len(v) != 1 {
+		return "", fmt.Errorf("%s must be a single character, tab, \\t or \\xNN, got %q", name, v)
+	}
+	return v, nil
+}
+
+// copyLiteral renders s as an escape string constant.
+func copyLiteral(s string) string {
+	var b strings.Builder
+	b.WriteString("E'")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+func validateCopyOptions() error {
+	if copyFormat != "csv" && copyFormat != "text" {
+		return fmt.Errorf("COPY_FORMAT must be csv or text, got %q", copyFormat)
+	}
+	delimiter, err := unescapeCopyChar("COPY_DELIMITER", copyDelimiterSetting)
+	if err != nil {
+		return err
+	}
+	copyDelimiter = delimiter
+	opts := []string{"FORMAT " + copyFormat, "DELIMITER " + copyLiteral(delimiter), "HEADER true"}
+
+	if copyNull != "" {
+		opts = append(opts, "NULL "+copyLiteral(copyNull))
+	}
+	for _, o := range []struct{ name, value, option string }{{"COPY_QUOTE", copyQuote, "QUOTE"}, {"COPY_ESCAPE", copyEscape, "ESCAPE"}} {
+		if o.value == "" {
+			continue
+		}
+		if copyFormat != "csv" {
+			return fmt.Errorf("%s only applies to COPY_FORMAT=csv", o.name)
+		}
+		c, err := unescapeCopyChar(o.name, o.value)
+		if err != nil {
+			return err
+		}
+		if c == delimiter {
+			return fmt.Errorf("%s must differ from COPY_DELIMITER", o.name)
+		}
+		opts = append(opts, o.option+" "+copyLiteral(c))
+		if o.option == "QUOTE" {
+			copyQuoteChar = c
+		} else {
+			copyEscapeChar = c
+		}
+	}
+	if copyEscape == "" {
+		copyEscapeChar = copyQuoteChar
+	}
+	if copyEncoding != "" {
+		if !encodingPattern.MatchString(copyEncoding) {
+			return fmt.Errorf("COPY_ENCODING %q is not a valid encoding name", copyEncoding)
+		}
+		opts = append(opts, "ENCODING '"+copyEncoding+"'")
+	}
+
+	copyOptions = "WITH (" + strings.Join(opts, ", ") + ")"
+	return nil
+}
@@ -1,44 +1,83 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
 var (
+	dbPool     *pgxpool.Pool
 	db         *sql.DB
 	dbHost     = getEnv("DB_HOST", "localhost")
 	dbPort     = "5432"
 	dbUser     = "postgres"
 	dbPassword = getEnv("DB_PASSWORD", "")
 	dbName     = "postgres"
+	// Each running import pins one connection for its lock and load session
+	// and briefly borrows others for status updates, so the default leaves
+	// room for imports in several workspaces alongside API traffic.
+	dbMaxConns = getEnvInt("DB_MAX_CONNS", 12)
 )
 
+// initDBWithRetry opens a pgx pool and exposes it as db through database/sql
+// for the bulk of the code; dbPool is used directly where pgx-only features
+// such as CopyFrom are needed. Both share the same connections.
 func initDBWithRetry(maxRetries int, delay time.Duration) error {
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbPassword, dbName)
 
-	var err error
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return fmt.Errorf("invalid database configuration: %w", err)
+	}
+	config.MaxConns = int32(max(dbMaxConns, 2))
+	config.MaxConnLifetime = 5 * time.Minute
+	// Unnamed statements, like lib/pq used: no per-connection statement cache
+	// to go stale when schema drift or the load-table swap changes note, and
+	// safe behind PgBouncer in transaction mode.
+	config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+
 	for i := 0; i < maxRetries; i++ {
-		db, err = sql.Open("postgres", dsn)
+		dbPool, err = pgxpool.NewWithConfig(context.Background(), config)
 		if err != nil {
 			time.Sleep(delay)
 			continue
 		}
 
-		db.SetMaxOpenConns(3)
-		db.SetMaxIdleConns(1)
-		db.SetConnMaxLifetime(5 * time.Minute)
-
-		if err = db.Ping(); err != nil {
+		if err = dbPool.Ping(context.Background()); err != nil {
+			dbPool.Close()
 			time.Sleep(delay)
 			continue
 		}
 
-		return nil
+		db = stdlib.OpenDBFromPool(dbPool)
+		jobStatus = db
+		return openQueryDB(config)
 	}
 	return fmt.Errorf("failed to connect after %d retries: %w", maxRetries, err)
 }
+
+func closeDB() {
+	db.Close()
+	dbPool.Close()
+	if queryPool != nil {
+		queryDB.Close()
+		queryPool.Close()
+	}
+}
+
+// scanArray scans a Postgres array column into dst, a pointer to a slice.
+func scanArray(dst any) sql.Scanner {
+	return pgtype.NewMap().SQLScanner(dst)
+}
+
+func quoteIdent(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	dbHealthInterval = getEnvDuration("DB_HEALTH_INTERVAL", 5*time.Second)
+	dbHealthTimeout  = getEnvDuration("DB_HEALTH_TIMEOUT", 2*time.Second)
+	dbHealthFailures = getEnvInt("DB_HEALTH_FAILURES", 2)
+)
+
+// dbUnavailable is the circuit breaker: set once DB_HEALTH_FAILURES pings in
+// a row have failed, cleared by the first ping that succeeds after that.
+var (
+	dbUnavailable atomic.Bool
+	dbDownSince   atomic.Int64
+)
+
+// dbIndependentPaths keep working while the database is unreachable.
+var dbIndependentPaths = []string{"/health", "/version", "/metrics", "/debug/", "/admin/log-level"}
+
+// startDBHealthMonitor pings the database every DB_HEALTH_INTERVAL, every
+// second while the breaker is open. It pings over a connection of its own,
+// outside the pool, so that imports holding pooled connections cannot make a
+// healthy database look down; that connection is dropped after a failure and
+// the next ping dials afresh. The pool is left alone and discards broken
+// connections itself.
+func startDBHealthMonitor() {
+	go func() {
+		var failures int
+		var conn *pgx.Conn
+		for {
+			interval := dbHealthInterval
+			if dbUnavailable.Load() {
+				interval = min(interval, time.Second)
+			}
+			time.Sleep(interval)
+
+			ctx, cancel := context.WithTimeout(context.Background(), dbHealthTimeout)
+			var err error
+			if conn == nil {
+				conn, err = pgx.ConnectConfig(ctx, dbPool.Config().ConnConfig)
+			}
+			if err == nil {
+				err = conn.Ping(ctx)
+			}
+			if err != nil && conn != nil {
+				conn.Close(context.Background())
+				conn = nil
+			}
+			cancel()
+
+			if err == nil {
+				failures = 0
+				if dbUnavailable.Swap(false) {
+					logger.Info("Database reachable again", "down_for", time.Since(time.Unix(0, dbDownSince.Load())).Round(time.Second))
+				}
+				continue
+			}
+
+			failures++
+			if failures >= dbHealthFailures && !dbUnavailable.Load() {
+				dbDownSince.Store(time.Now().UnixNano())
+				dbUnavailable.Store(true)
+				logger.Error("Database unreachable, rejecting requests that need it", "error", err, "failures", failures)
+			} else if !dbUnavailable.Load() {
+				logger.Warn("Database ping failed", "error", err, "failures", failures)
+			}
+		}
+	}()
+}
+
+// dbHealthMiddleware answers 503 while the breaker is open rather than letting
+// handlers fail with driver errors.
+func dbHealthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if dbUnavailable.Load() && !isDBIndependentPath(r.URL.Path) {
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(dbHealthInterval.Seconds()), 1)))
+			writeProblem(w, http.StatusServiceUnavailable, errCodeDatabaseUnavailable, "Database is unreachable; retry shortly")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isDBIndependentPath(path string) bool {
+	for _, p := range dbIndependentPaths {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	processStart = time.Now()
+	debugEnabled = getEnvBool("DEBUG_ENABLED", false)
+)
+
+type RuntimeStats struct {
+	Uptime        string      `json:"uptime"`
+	GoVersion     string      `json:"go_version"`
+	Goroutines    int         `json:"goroutines"`
+	GOMAXPROCS    int         `json:"gomaxprocs"`
+	HeapAlloc     uint64      `json:"heap_alloc_bytes"`
+	HeapInuse     uint64      `json:"heap_inuse_bytes"`
+	HeapObjects   uint64      `json:"heap_objects"`
+	Sys           uint64      `json:"sys_bytes"`
+	TotalAlloc    uint64      `json:"total_alloc_bytes"`
+	NumGC         uint32      `json:"num_gc"`
+	LastGC        *time.Time  `json:"last_gc,omitempty"`
+	GCPauseTotal  string      `json:"gc_pause_total"`
+	GCCPUFraction float64     `json:"gc_cpu_fraction"`
+	NextGC        uint64      `json:"next_gc_bytes"`
+	GCPauses      GCPauses    `json:"gc_pauses"`
+	DB            DBPoolStats `json:"db"`
+}
+
+type DBPoolStats struct {
+	TotalConns           int32  `json:"total_conns"`
+	IdleConns            int32  `json:"idle_conns"`
+	AcquiredConns        int32  `json:"acquired_conns"`
+	MaxConns             int32  `json:"max_conns"`
+	AcquireCount         int64  `json:"acquire_count"`
+	EmptyAcquireCount    int64  `json:"empty_acquire_count"`
+	CanceledAcquireCount int64  `json:"canceled_acquire_count"`
+	AcquireDuration      string `json:"acquire_duration"`
+}
+
+type GCPauses struct {
+	P50 string `json:"p50"`
+	P99 string `json:"p99"`
+	Max string `json:"max"`
+}
+
+// registerDebugRoutes mounts /debug/runtime and pprof only when admin keys
+// guard them or DEBUG_ENABLED asks for them on an open deployment.
+func registerDebugRoutes() {
+	if !authEnabled && !debugEnabled {
+		return
+	}
+	http.HandleFunc("GET /debug/runtime", getRuntimeStats)
+	http.HandleFunc("GET /debug/pprof/", pprof.Index)
+	http.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	http.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	http.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	http.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	http.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+}
+
+func runtimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s := RuntimeStats{
+		Uptime:        time.Since(processStart).Round(time.Second).String(),
+		GoVersion:     runtime.Version(),
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		HeapAlloc:     m.HeapAlloc,
+		HeapInuse:     m.HeapInuse,
+		HeapObjects:   m.HeapObjects,
+		Sys:           m.Sys,
+		TotalAlloc:    m.TotalAlloc,
+		NumGC:         m.NumGC,
+		GCPauseTotal:  time.Duration(m.PauseTotalNs).String(),
+		GCCPUFraction: m.GCCPUFraction,
+		NextGC:        m.NextGC,
+	}
+	if m.LastGC > 0 {
+		t := time.Unix(0, int64(m.LastGC))
+		s.LastGC = &t
+	}
+
+	gc := debug.GCStats{PauseQuantiles: make([]time.Duration, 101)}
+	debug.ReadGCStats(&gc)
+	if gc.NumGC > 0 {
+		s.GCPauses = GCPauses{P50: gc.PauseQuantiles[50].String(), P99: gc.PauseQuantiles[99].String(), Max: gc.PauseQuantiles[100].String()}
+	}
+
+	if dbPool != nil {
+		st := dbPool.Stat()
+		s.DB = DBPoolStats{
+			TotalConns:           st.TotalConns(),
+			IdleConns:            st.IdleConns(),
+			AcquiredConns:        st.AcquiredConns(),
+			MaxConns:             st.MaxConns(),
+			AcquireCount:         st.AcquireCount(),
+			EmptyAcquireCount:    st.EmptyAcquireCount(),
+			CanceledAcquireCount: st.CanceledAcquireCount(),
+			AcquireDuration:      st.AcquireDuration().String(),
+		}
+	}
+	return s
+}
+
+func getRuntimeStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runtimeStats())
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	cacheRetainDates = getEnvInt("CACHE_RETAIN_DATES", 1)
+	skipUnchanged    = getEnvBool("SKIP_UNCHANGED", true)
+)
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// snapshotFingerprint combines the per-file TSV hashes, in file order, into a
+// single hash identifying the snapshot's content.
+func snapshotFingerprint(hashes []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(hashes, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// dedupeCachedFile replaces path with a hard link to an identical file of
+// another snapshot date in the same directory. Files are only ever replaced
+// wholesale (create or rename), never modified in place, so sharing an inode
+// is safe.
+func dedupeCachedFile(path, hash string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	dir, name := filepath.Split(path)
+	m := localNoteFilePattern.FindStringSubmatch(name)
+	if m == nil {
+		return false, nil
+	}
+	suffix := strings.TrimPrefix(name, m[1])
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		other := entry.Name()
+		if other == name || !strings.HasSuffix(other, suffix) || localNoteFilePattern.FindStringSubmatch(other) == nil {
+			continue
+		}
+		otherPath := filepath.Join(dir, other)
+		otherInfo, err := os.Stat(otherPath)
+		if err != nil || otherInfo.Size() != info.Size() {
+			continue
+		}
+		if os.SameFile(info, otherInfo) {
+			return false, nil
+		}
+		if otherHash, err := hashFile(otherPath); err != nil || otherHash != hash {
+			continue
+		}
+
+		tmp := path + ".link"
+		os.Remove(tmp)
+		if err := os.Link(otherPath, tmp); err != nil {
+			return false, err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// fingerprintSnapshot hashes each file's TSV into import_files, hard-links
+// cache files that are identical to another retained date, and returns the
+// snapshot fingerprint.
+func fingerprintSnapshot(ctx context.Context, jobID string, files []FileInfo, log *slog.Logger) (string, error) {
+	hashes := make([]string, len(files))
+	linked := 0
+	for i, f := range files {
+		hash, n, err := fingerprintFile(ctx, jobID, i, f, log)
+		if err != nil {
+			return "", err
+		}
+		hashes[i] = hash
+		linked += n
+	}
+	if linked > 0 {
+		log.Info("Hard-linked cache files identical to another snapshot date", "files", linked)
+	}
+	return snapshotFingerprint(hashes), nil
+}
+
+// fingerprintFile hashes one file's TSV into import_files and dedupes its
+// cache files, returning the hash and how many files were hard-linked.
+func fingerprintFile(ctx context.Context, jobID string, i int, f FileInfo, log *slog.Logger) (string, int, error) {
+	hash, err := hashFile(f.TSVPath)
+	if err != nil {
+		return "", 0, err
+	}
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_files} SET content_hash = $1 WHERE job_id = $2 AND file_index = $3`), hash, jobID, i)
+
+	if cacheRetainDates <= 1 {
+		return hash, 0, nil
+	}
+	linked := 0
+	if ok, err := dedupeCachedFile(f.TSVPath, hash); err != nil {
+		log.Warn("Failed to dedupe cached file", "path", f.TSVPath, "error", err)
+	} else if ok {
+		linked++
+	}
+	for _, cached := range []string{f.ZipPath, compressedCachePath(f.ZipPath)} {
+		if _, err := os.Stat(cached); err != nil {
+			continue
+		}
+		cachedHash, err := hashFile(cached)
+		if err != nil {
+			continue
+		}
+		if ok, err := dedupeCachedFile(cached, cachedHash); err != nil {
+			log.Warn("Failed to dedupe cached file", "path", cached, "error", err)
+		} else if ok {
+			linked++
+		}
+	}
+	return hash, linked, nil
+}
+
+// previousFingerprint returns the fingerprint and row count of the latest
+// completed import in the workspace.
+func previousFingerprint(ctx context.Context, jobID string) (string, string, int, bool) {
+	var fingerprint, prevJobID string
+	var rows sql.NullInt64
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT snapshot_fingerprint, job_id, total_rows FROM {import_history}
+		WHERE status IN ('completed', 'completed_with_warnings') AND job_id <> $1 AND snapshot_fingerprint IS NOT NULL
+		ORDER BY completed_at DESC LIMIT 1
+	`), jobID).Scan(&fingerprint, &prevJobID, &rows)
+	if err != nil {
+		return "", "", 0, false
+	}
+	return fingerprint, prevJobID, int(rows.Int64), true
+}
+
+// cleanupOldFiles keeps the files of keepDate and of the newest
+// CACHE_RETAIN_DATES-1 other snapshot dates, and removes everything else.
+func cleanupOldFiles(dir, keepDate string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Warn("Failed to read data directory", "error", err)
+		return
+	}
+
+	var dates []string
+	for _, entry := range entries {
+		if m := localNoteFilePattern.FindStringSubmatch(entry.Name()); m != nil && m[1] != keepDate && !slices.Contains(dates, m[1]) {
+			dates = append(dates, m[1])
+		}
+	}
+	slices.Sort(dates)
+	slices.Reverse(dates)
+	keep := []string{keepDate}
+	if n := max(cacheRetainDates-1, 0); len(dates) > n {
+		keep = append(keep, dates[:n]...)
+	} else {
+		keep = append(keep, dates...)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || slices.ContainsFunc(keep, func(d string) bool { return strings.HasPrefix(name, d) }) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			logger.Warn("Failed to remove old file", "path", path, "error", err)
+		} else {
+			logger.Info("Removed old file", "path", path)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/smtp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	digestWebhookURL   = getEnv("DIGEST_WEBHOOK_URL", "")
+	digestSMTPAddr     = getEnv("DIGEST_SMTP_ADDR", "")
+	digestSMTPUser     = getEnv("DIGEST_SMTP_USER", "")
+	digestSMTPPassword = getEnv("DIGEST_SMTP_PASSWORD", "")
+	digestFrom         = getEnv("DIGEST_FROM", "")
+	digestTo           = splitList(getEnv("DIGEST_TO", ""))
+	digestWatchTweets  = splitList(getEnv("DIGEST_WATCH_TWEETS", ""))
+	digestTopNotes     = getEnvInt("DIGEST_TOP_NOTES", 10)
+)
+
+var digestHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+type Digest struct {
+	Workspace     string               `json:"workspace,omitempty"`
+	JobID         string               `json:"job_id"`
+	DataDate      string               `json:"data_date"`
+	TotalNotes    int                  `json:"total_notes"`
+	PreviousNotes *int                 `json:"previous_notes,omitempty"`
+	NewNotes      int                  `json:"new_notes"`
+	NewSince      string               `json:"new_since"`
+	TopNewNotes   []DigestNote         `json:"top_new_notes"`
+	WatchedTweets []DigestWatchedTweet `json:"watched_tweets,omitempty"`
+}
+
+type DigestNote struct {
+	NoteID         int64   `json:"note_id"`
+	TweetID        *string `json:"tweet_id,omitempty"`
+	Classification *string `json:"classification,omitempty"`
+	Summary        string  `json:"summary"`
+}
+
+type DigestWatchedTweet struct {
+	TweetID         string         `json:"tweet_id"`
+	Notes           int            `json:"notes"`
+	NewNotes        int            `json:"new_notes"`
+	Classifications map[string]int `json:"classifications"`
+}
+
+func digestEnabled() bool {
+	return digestWebhookURL != "" || (digestSMTPAddr != "" && len(digestTo) > 0)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// buildDigest compares the finished import against the previous completed one
+// in the same workspace: notes created since that import's data date count as
+// new.
+func buildDigest(ctx context.Context, jobID, dataDate string, totalRows int) (*Digest, error) {
+	d := &Digest{Workspace: eventWorkspace(ctx), JobID: jobID, DataDate: dataDate, TotalNotes: totalRows, TopNewNotes: []DigestNote{}}
+
+	var prevDate sql.NullTime
+	var prevRows sql.NullInt64
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT data_date, total_rows FROM {import_history}
+		WHERE status IN ('completed', 'completed_with_warnings') AND job_id <> $1 AND data_date IS NOT NULL
+		ORDER BY completed_at DESC LIMIT 1
+	`), jobID).Scan(&prevDate, &prevRows)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	d.PreviousNotes = nullInt64ToIntPtr(prevRows)
+
+	since, err := time.Parse("2006-01-02", dataDate)
+	if err != nil {
+		return nil, err
+	}
+	since = since.AddDate(0, 0, -1)
+	if prevDate.Valid {
+		since = prevDate.Time
+	}
+	d.NewSince = since.Format("2006-01-02")
+	if err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COUNT(*) FROM {note} WHERE created_at >= $1`), since).Scan(&d.NewNotes); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT noteid, tweetid, classification, COALESCE(summary, '')
+		FROM {note} n
+		WHERE created_at >= $1 AND `+notExcluded("n")+`
+		ORDER BY created_at DESC
+		LIMIT $2
+	`), since, digestTopNotes)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var n DigestNote
+		var tweetID, classification sql.NullString
+		if err := rows.Scan(&n.NoteID, &tweetID, &classification, &n.Summary); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		n.TweetID = nullStringToStrPtr(tweetID)
+		n.Classification = nullStringToStrPtr(classification)
+		d.TopNewNotes = append(d.TopNewNotes, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(digestWatchTweets) == 0 {
+		return d, nil
+	}
+
+	d.WatchedTweets = make([]DigestWatchedTweet, len(digestWatchTweets))
+	watched := make(map[string]*DigestWatchedTweet, len(digestWatchTweets))
+	for i, id := range digestWatchTweets {
+		d.WatchedTweets[i] = DigestWatchedTweet{TweetID: id, Classifications: map[string]int{}}
+		watched[id] = &d.WatchedTweets[i]
+	}
+
+	rows, err = db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT tweetid, COALESCE(classification, 'UNKNOWN'), COUNT(*), COUNT(*) FILTER (WHERE created_at >= $2)
+		FROM {note}
+		WHERE tweet_id = ANY($1)
+		GROUP BY 1, 2
+	`), parseTweetIDs(digestWatchTweets), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tweetID, classification string
+		var count, fresh int
+		if err := rows.Scan(&tweetID, &classification, &count, &fresh); err != nil {
+			return nil, err
+		}
+		t := watched[tweetID]
+		t.Notes += count
+		t.NewNotes += fresh
+		t.Classifications[classification] = count
+	}
+	return d, rows.Err()
+}
+
+func (d *Digest) text() string {
+	var b strings.Builder
+	if d.Workspace != "" {
+		fmt.Fprintf(&b, "Workspace: %s\n", d.Workspace)
+	}
+	fmt.Fprintf(&b, "Import %s for %s completed.\n\n", d.JobID, d.DataDate)
+	fmt.Fprintf(&b, "Total notes: %d", d.TotalNotes)
+	if d.PreviousNotes != nil {
+		fmt.Fprintf(&b, " (%+d since previous import)", d.TotalNotes-*d.PreviousNotes)
+	}
+	fmt.Fprintf(&b, "\nNew notes since %s: %d\n", d.NewSince, d.NewNotes)
+
+	if len(d.TopNewNotes) > 0 {
+		b.WriteString("\nLatest new notes:\n")
+		for _, n := range d.TopNewNotes {
+			summary := n.Summary
+			if r := []rune(summary); len(r) > 200 {
+				summary = string(r[:200]) + "…"
+			}
+			fmt.Fprintf(&b, "- #%d", n.NoteID)
+			if n.TweetID != nil {
+				fmt.Fprintf(&b, " on https://x.com/i/status/%s", *n.TweetID)
+			}
+			if n.Classification != nil {
+				fmt.Fprintf(&b, " [%s]", *n.Classification)
+			}
+			fmt.Fprintf(&b, "\n  %s\n", strings.ReplaceAll(summary, "\n", " "))
+		}
+	}
+
+	if len(d.WatchedTweets) > 0 {
+		b.WriteString("\nWatched tweets:\n")
+		for _, t := range d.WatchedTweets {
+			fmt.Fprintf(&b, "- %s: %d notes (%d new)", t.TweetID, t.Notes, t.NewNotes)
+			for _, c := range slices.Sorted(maps.Keys(t.Classifications)) {
+				fmt.Fprintf(&b, ", %s=%d", c, t.Classifications[c])
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func sendDigest(ctx context.Context, d *Digest) error {
+	var errs []string
+	if digestWebhookURL != "" {
+		if err := postDigestWebhook(ctx, d); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if digestSMTPAddr != "" && len(digestTo) > 0 {
+		if err := mailDigest(d); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func postDigestWebhook(ctx context.Context, d *Digest) error {
+	payload, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", digestWebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := digestHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("digest webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("digest webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func mailDigest(d *Digest) error {
+	from := digestFrom
+	if from == "" {
+		from = digestSMTPUser
+	}
+	subject := "X Community Notes digest for " + d.DataDate
+	if d.Workspace != "" {
+		subject += " (" + d.Workspace + ")"
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", from, strings.Join(digestTo, ", "), subject, time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(d.text(), "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if digestSMTPUser != "" {
+		host, _, _ := net.SplitHostPort(digestSMTPAddr)
+		auth = smtp.PlainAuth("", digestSMTPUser, digestSMTPPassword, host)
+	}
+	if err := smtp.SendMail(digestSMTPAddr, auth, from, digestTo, msg.Bytes()); err != nil {
+		return fmt.Errorf("digest email failed: %w", err)
+	}
+	return nil
+}
+
+// sendImportDigest only reports on scheduled imports; manual runs are usually
+// retries or experiments that would double up the daily summary.
+func sendImportDigest(ctx context.Context, jobID, dataDate string, totalRows int, log *slog.Logger) {
+	var triggeredBy sql.NullString
+	db.QueryRowContext(ctx, expandSQL(ctx, `SELECT triggered_by FROM {import_history} WHERE job_id = $1`), jobID).Scan(&triggeredBy)
+	if triggeredBy.String != triggerSchedule {
+		return
+	}
+
+	d, err := buildDigest(ctx, jobID, dataDate, totalRows)
+	if err != nil {
+		log.Error("Failed to build import digest", "error", err)
+		return
+	}
+	if err := sendDigest(ctx, d); err != nil {
+		log.Error("Failed to send import digest", "error", err)
+		return
+	}
+	log.Info("Sent import digest", "new_notes", d.NewNotes)
+}
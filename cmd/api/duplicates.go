@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	duplicatesEnabled       = getEnvBool("DUPLICATES_ENABLED", false)
+	duplicatesThreshold     = getEnvFloat("DUPLICATES_THRESHOLD", 0.8)
+	duplicatesMaxBucketSize = getEnvInt("DUPLICATES_MAX_BUCKET_SIZE", 50)
+)
+
+const (
+	minhashBands     = 12
+	minhashRows      = 5
+	shingleSize      = 5
+	minShingleLength = 20
+)
+
+var minhashSeeds = func() []uint64 {
+	seeds := make([]uint64, minhashBands*minhashRows)
+	s := uint64(0x9e3779b97f4a7c15)
+	for i := range seeds {
+		s = splitmix64(s)
+		seeds[i] = s
+	}
+	return seeds
+}()
+
+func initDuplicates() error {
+	if !duplicatesEnabled {
+		return nil
+	}
+
+	for _, ws := range workspaceOrder {
+		ctx := withWorkspace(context.Background(), ws)
+		for _, stmt := range []string{
+			`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+			`CREATE TABLE IF NOT EXISTS {note_duplicates} (
+				noteid BIGINT NOT NULL,
+				duplicate_noteid BIGINT NOT NULL,
+				similarity REAL NOT NULL,
+				detected_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (noteid, duplicate_noteid)
+			)`,
+			`CREATE INDEX IF NOT EXISTS {prefix}idx_note_duplicates_duplicate ON {note_duplicates}(duplicate_noteid)`,
+		} {
+			if _, err := db.ExecContext(ctx, expandSQL(ctx, stmt)); err != nil {
+				return fmt.Errorf("failed to prepare duplicates table in workspace %s: %w", ws.Name, err)
+			}
+		}
+	}
+
+	logger.Info("Near-duplicate detection enabled", "threshold", duplicatesThreshold)
+	return nil
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func normalizeSummary(s string) []rune {
+	var out []rune
+	space := true
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			out = append(out, r)
+			space = false
+		} else if !space {
+			out = append(out, ' ')
+			space = true
+		}
+	}
+	return out
+}
+
+// minhashBandKeys returns one bucket key per LSH band from a MinHash signature
+// over character shingles; two summaries with Jaccard similarity J share at
+// least one bucket with probability 1-(1-J^rows)^bands.
+func minhashBandKeys(summary string) []int64 {
+	text := normalizeSummary(summary)
+	if len(text) < minShingleLength {
+		return nil
+	}
+
+	sig := make([]uint64, len(minhashSeeds))
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for i := 0; i+shingleSize <= len(text); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(string(text[i : i+shingleSize])))
+		x := h.Sum64()
+		for j, seed := range minhashSeeds {
+			if v := splitmix64(x ^ seed); v < sig[j] {
+				sig[j] = v
+			}
+		}
+	}
+
+	keys := make([]int64, minhashBands)
+	buf := make([]byte, 8)
+	for b := range keys {
+		h := fnv.New64a()
+		binary.LittleEndian.PutUint64(buf, uint64(b))
+		h.Write(buf)
+		for _, v := range sig[b*minhashRows : (b+1)*minhashRows] {
+			binary.LittleEndian.PutUint64(buf, v)
+			h.Write(buf)
+		}
+		keys[b] = int64(h.Sum64())
+	}
+	return keys
+}
+
+// detectDuplicates rebuilds note_duplicates: MinHash LSH buckets narrow the
+// candidates down, pg_trgm similarity confirms them, and only pairs attached
+// to different tweets are kept. The table is replaced in one transaction so
+// readers never see a partial result.
+func detectDuplicates(ctx context.Context, log *slog.Logger) (int64, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, `CREATE TEMP TABLE note_minhash (noteid BIGINT NOT NULL, band SMALLINT NOT NULL, bucket BIGINT NOT NULL) ON COMMIT DROP`); err != nil {
+		return 0, fmt.Errorf("failed to create minhash table: %w", err)
+	}
+
+	rows, err := dbPool.Query(ctx, expandSQL(ctx, `SELECT noteid, summary FROM {note} WHERE summary IS NOT NULL`))
+	if err != nil {
+		return 0, err
+	}
+	var hashed int
+	var id int64
+	var keys []int64
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"note_minhash"}, []string{"noteid", "band", "bucket"}, pgx.CopyFromFunc(func() ([]any, error) {
+		for len(keys) == 0 {
+			if !rows.Next() {
+				return nil, rows.Err()
+			}
+			var summary string
+			if err := rows.Scan(&id, &summary); err != nil {
+				return nil, err
+			}
+			keys = minhashBandKeys(summary)
+			hashed++
+		}
+		band := minhashBands - len(keys)
+		bucket := keys[0]
+		keys = keys[1:]
+		return []any{id, band, bucket}, nil
+	}))
+	rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy minhash bands: %w", err)
+	}
+	log.Info("Hashed note summaries for duplicate detection", "notes", hashed)
+
+	if _, err := tx.Exec(ctx, expandSQL(ctx, `DELETE FROM {note_duplicates}`)); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(ctx, expandSQL(ctx, `
+		WITH buckets AS (
+			SELECT array_agg(noteid) AS ids
+			FROM note_minhash
+			GROUP BY band, bucket
+			HAVING COUNT(*) BETWEEN 2 AND $1
+		), pairs AS (
+			SELECT DISTINCT a, b FROM buckets, unnest(ids) a, unnest(ids) b WHERE a < b
+		)
+		INSERT INTO {note_duplicates} (noteid, duplicate_noteid, similarity, detected_at)
+		SELECT p.a, p.b, similarity(n1.summary, n2.summary), NOW()
+		FROM pairs p
+		JOIN {note} n1 ON n1.noteid = p.a
+		JOIN {note} n2 ON n2.noteid = p.b
+		WHERE n1.tweetid IS DISTINCT FROM n2.tweetid
+		  AND similarity(n1.summary, n2.summary) >= $2
+	`), duplicatesMaxBucketSize, duplicatesThreshold)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute duplicate pairs: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+type DuplicateNote struct {
+	NoteID                  int64   `json:"note_id"`
+	TweetID                 *string `json:"tweet_id,omitempty"`
+	NoteAuthorParticipantID *string `json:"note_author_participant_id,omitempty"`
+	CreatedAtMillis         *int64  `json:"created_at_millis,omitempty"`
+	Summary                 string  `json:"summary"`
+	Similarity              float64 `json:"similarity"`
+}
+
+func getNoteDuplicates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !duplicatesEnabled {
+		writeProblem(w, http.StatusNotFound, errCodeDuplicatesDisabled, "Near-duplicate detection is not enabled (set DUPLICATES_ENABLED=true)")
+		return
+	}
+
+	fields, err := parseListFields[DuplicateNote](r.URL.Query().Get("fields"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	noteID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Note ID must be an integer")
+		return
+	}
+
+	var exists bool
+	db.QueryRowContext(ctx, expandSQL(ctx, `SELECT EXISTS (SELECT 1 FROM {note} n WHERE noteid = $1 AND `+notExcluded("n")+`)`), noteID).Scan(&exists)
+	if !exists {
+		writeProblem(w, http.StatusNotFound, errCodeNoteNotFound, "Note not found")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT n.noteid, n.tweetid, n.noteauthorparticipantid, (EXTRACT(EPOCH FROM n.created_at) * 1000)::bigint, n.summary, d.similarity
+		FROM (
+			SELECT duplicate_noteid AS other, similarity FROM {note_duplicates} WHERE noteid = $1
+			UNION ALL
+			SELECT noteid, similarity FROM {note_duplicates} WHERE duplicate_noteid = $1
+		) d
+		JOIN {note} n ON n.noteid = d.other
+		WHERE `+notExcluded("n")+`
+		ORDER BY d.similarity DESC, n.noteid
+	`), noteID)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get duplicates: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	notes := []DuplicateNote{}
+	for rows.Next() {
+		var n DuplicateNote
+		var tweetID, authorID sql.NullString
+		var createdAt sql.NullInt64
+		if err := rows.Scan(&n.NoteID, &tweetID, &authorID, &createdAt, &n.Summary, &n.Similarity); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get duplicates: "+err.Error())
+			return
+		}
+		n.TweetID = nullStringToStrPtr(tweetID)
+		n.NoteAuthorParticipantID = nullStringToStrPtr(authorID)
+		n.CreatedAtMillis = nullInt64ToInt64Ptr(createdAt)
+		notes = append(notes, n)
+	}
+
+	writeListPage(w, r, notes, fields, &listPage{Total: len(notes)})
+}
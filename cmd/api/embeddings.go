@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	embeddingsProvider   = getEnv("EMBEDDINGS_PROVIDER", "")
+	embeddingsURL        = strings.TrimSuffix(getEnv("EMBEDDINGS_URL", ""), "/")
+	embeddingsAPIKey     = getEnv("EMBEDDINGS_API_KEY", "")
+	embeddingsModel      = getEnv("EMBEDDINGS_MODEL", "")
+	embeddingsDimensions = getEnvInt("EMBEDDINGS_DIMENSIONS", 0)
+	embeddingsBatchSize  = getEnvInt("EMBEDDINGS_BATCH_SIZE", 64)
+)
+
+type embeddingProvider interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Dimensions() int
+}
+
+var embedder embeddingProvider
+
+var embeddingsHTTPClient = &http.Client{Timeout: 2 * time.Minute}
+
+func initEmbeddings() error {
+	switch embeddingsProvider {
+	case "":
+		return nil
+	case "openai":
+		dims := embeddingsDimensions
+		if dims == 0 {
+			dims = 1536
+		}
+		embedder = &openAIEmbedder{
+			baseURL:    defaultString(embeddingsURL, "https://api.openai.com/v1"),
+			apiKey:     embeddingsAPIKey,
+			model:      defaultString(embeddingsModel, "text-embedding-3-small"),
+			dimensions: dims,
+			sendDims:   embeddingsDimensions > 0,
+		}
+	case "ollama":
+		dims := embeddingsDimensions
+		if dims == 0 {
+			dims = 768
+		}
+		embedder = &ollamaEmbedder{
+			baseURL:    defaultString(embeddingsURL, "http://localhost:11434"),
+			model:      defaultString(embeddingsModel, "nomic-embed-text"),
+			dimensions: dims,
+		}
+	default:
+		return fmt.Errorf("unknown EMBEDDINGS_PROVIDER %q", embeddingsProvider)
+	}
+
+	for _, ws := range workspaceOrder {
+		ctx := withWorkspace(context.Background(), ws)
+		for _, stmt := range []string{
+			`CREATE EXTENSION IF NOT EXISTS vector`,
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS {note_embeddings} (
+				noteid BIGINT PRIMARY KEY,
+				summary_md5 TEXT NOT NULL,
+				embedding vector(%d) NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			)`, embedder.Dimensions()),
+			`CREATE INDEX IF NOT EXISTS {prefix}idx_note_embeddings_hnsw ON {note_embeddings} USING hnsw (embedding vector_cosine_ops)`,
+		} {
+			if _, err := db.ExecContext(ctx, expandSQL(ctx, stmt)); err != nil {
+				return fmt.Errorf("failed to prepare embeddings table in workspace %s: %w", ws.Name, err)
+			}
+		}
+	}
+
+	logger.Info("Note embeddings enabled", "provider", embeddingsProvider, "dimensions", embedder.Dimensions())
+	return nil
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+type openAIEmbedder struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	dimensions int
+	sendDims   bool
+}
+
+func (e *openAIEmbedder) Dimensions() int { return e.dimensions }
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	req := map[string]any{"model": e.model, "input": texts}
+	if e.sendDims {
+		req["dimensions"] = e.dimensions
+	}
+
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := postEmbeddingRequest(ctx, e.baseURL+"/embeddings", e.apiKey, req, &resp); err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding response index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, checkEmbeddings(vectors, e.dimensions)
+}
+
+type ollamaEmbedder struct {
+	baseURL    string
+	model      string
+	dimensions int
+}
+
+func (e *ollamaEmbedder) Dimensions() int { return e.dimensions }
+
+func (e *ollamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var resp struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := postEmbeddingRequest(ctx, e.baseURL+"/api/embed", "", map[string]any{"model": e.model, "input": texts}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Embeddings))
+	}
+	return resp.Embeddings, checkEmbeddings(resp.Embeddings, e.dimensions)
+}
+
+func postEmbeddingRequest(ctx context.Context, url, apiKey string, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := embeddingsHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("embedding provider returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func checkEmbeddings(vectors [][]float32, dimensions int) error {
+	for i, v := range vectors {
+		if len(v) != dimensions {
+			return fmt.Errorf("embedding %d has %d dimensions, expected %d", i, len(v), dimensions)
+		}
+	}
+	return nil
+}
+
+func vectorLiteral(v []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, f := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(f), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// embedNotes vectorizes summaries that are new or changed since they were last
+// embedded, keyed by an md5 of the summary so reloading an unchanged snapshot
+// costs no provider calls, then drops embeddings of notes that disappeared.
+func embedNotes(ctx context.Context, log *slog.Logger) (int, error) {
+	embedded := 0
+	var after int64 = -1
+	for {
+		rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+			SELECT n.noteid, n.summary, md5(n.summary)
+			FROM {note} n
+			LEFT JOIN {note_embeddings} e ON e.noteid = n.noteid
+			WHERE n.noteid > $1 AND COALESCE(n.summary, '') <> ''
+			  AND (e.noteid IS NULL OR e.summary_md5 <> md5(n.summary))
+			ORDER BY n.noteid
+			LIMIT $2
+		`), after, embeddingsBatchSize)
+		if err != nil {
+			return embedded, err
+		}
+
+		var ids []int64
+		var texts, hashes []string
+		for rows.Next() {
+			var id int64
+			var text, hash string
+			if err := rows.Scan(&id, &text, &hash); err != nil {
+				rows.Close()
+				return embedded, err
+			}
+			ids = append(ids, id)
+			texts = append(texts, text)
+			hashes = append(hashes, hash)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return embedded, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		after = ids[len(ids)-1]
+
+		vectors, err := embedder.Embed(ctx, texts)
+		if err != nil {
+			return embedded, err
+		}
+		literals := make([]string, len(vectors))
+		for i, v := range vectors {
+			literals[i] = vectorLiteral(v)
+		}
+
+		_, err = db.ExecContext(ctx, expandSQL(ctx, `
+			INSERT INTO {note_embeddings} (noteid, summary_md5, embedding, updated_at)
+			SELECT id, hash, vec::vector, NOW()
+			FROM unnest($1::bigint[], $2::text[], $3::text[]) AS t(id, hash, vec)
+			ON CONFLICT (noteid) DO UPDATE SET summary_md5 = EXCLUDED.summary_md5, embedding = EXCLUDED.embedding, updated_at = EXCLUDED.updated_at
+		`), ids, hashes, literals)
+		if err != nil {
+			return embedded, fmt.Errorf("failed to store embeddings: %w", err)
+		}
+		embedded += len(ids)
+		log.Debug("Embedded note batch", "notes", len(ids), "total", embedded)
+	}
+
+	res, err := db.ExecContext(ctx, expandSQL(ctx, `
+		DELETE FROM {note_embeddings} e
+		WHERE NOT EXISTS (SELECT 1 FROM {note} n WHERE n.noteid = e.noteid)
+	`))
+	if err != nil {
+		return embedded, fmt.Errorf("failed to prune embeddings: %w", err)
+	}
+	if pruned, _ := res.RowsAffected(); pruned > 0 {
+		log.Info("Pruned embeddings of removed notes", "notes", pruned)
+	}
+	return embedded, nil
+}
+
+type SimilarNote struct {
+	NoteID         int64   `json:"note_id"`
+	TweetID        *string `json:"tweet_id,omitempty"`
+	Classification *string `json:"classification,omitempty"`
+	Summary        string  `json:"summary"`
+	Similarity     float64 `json:"similarity"`
+}
+
+func getSimilarNotes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if embedder == nil {
+		writeProblem(w, http.StatusNotFound, errCodeEmbeddingsDisabled, "Semantic search is not enabled (set EMBEDDINGS_PROVIDER)")
+		return
+	}
+
+	fields, err := parseListFields[SimilarNote](r.URL.Query().Get("fields"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	text := strings.TrimSpace(r.URL.Query().Get("text"))
+	if text == "" {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "text is required")
+		return
+	}
+
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	offset := 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 1000 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "offset must be between 0 and 1000")
+			return
+		}
+		offset = n
+	}
+
+	vectors, err := embedder.Embed(ctx, []string{text})
+	if err != nil {
+		writeProblem(w, http.StatusBadGateway, errCodeEmbeddingFailed, "Failed to embed query: "+err.Error())
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT n.noteid, n.tweetid, n.classification, n.summary, 1 - (e.embedding <=> $1::vector)
+		FROM {note_embeddings} e
+		JOIN {note} n ON n.noteid = e.noteid
+		WHERE `+notExcluded("n")+`
+		ORDER BY e.embedding <=> $1::vector
+		LIMIT $2 OFFSET $3
+	`), vectorLiteral(vectors[0]), limit+1, offset)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to search notes: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	notes := []SimilarNote{}
+	for rows.Next() {
+		var n SimilarNote
+		var tweetID, classification sql.NullString
+		if err := rows.Scan(&n.NoteID, &tweetID, &classification, &n.Summary, &n.Similarity); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to search notes: "+err.Error())
+			return
+		}
+		n.TweetID = nullStringToStrPtr(tweetID)
+		n.Classification = nullStringToStrPtr(classification)
+		notes = append(notes, n)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to search notes: "+err.Error())
+		return
+	}
+
+	// Every embedded note is a match, only less similar, so the total is the
+	// planner's estimate of the embedded notes rather than an exact count.
+	page := &listPage{Estimated: true}
+	db.QueryRowContext(ctx, `SELECT GREATEST(reltuples, 0)::bigint FROM pg_class WHERE oid = to_regclass($1)`, qualifiedTable(ctx, "note_embeddings")).Scan(&page.Total)
+	if len(notes) > limit {
+		notes = notes[:limit]
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(offset+limit))
+		page.Next = q
+	}
+	if offset > 0 {
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(max(offset-limit, 0)))
+		page.Prev = q
+	}
+
+	writeListPage(w, r, notes, fields, page)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var (
+	eventsBackend             = getEnv("EVENTS_BACKEND", "")
+	noteEventsInitialSnapshot = getEnvBool("NOTE_EVENTS_INITIAL_SNAPSHOT", false)
+	noteEventsBatchSize       = 500
+)
+
+const (
+	eventNoteCreated = "note.created"
+	eventNoteUpdated = "note.updated"
+	eventNoteDeleted = "note.deleted"
+
+	eventImportStarted   = "import.started"
+	eventImportCompleted = "import.completed"
+	eventImportFailed    = "import.failed"
+	eventImportPaused    = "import.paused"
+	eventImportSkipped   = "import.skipped"
+)
+
+type event struct {
+	Type      string         `json:"type"`
+	Workspace string         `json:"workspace,omitempty"`
+	JobID     string         `json:"job_id"`
+	NoteID    *int64         `json:"note_id,omitempty"`
+	DataDate  string         `json:"data_date,omitempty"`
+	Note      map[string]any `json:"note,omitempty"`
+	Rows      *int           `json:"rows,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	ErrorCode string         `json:"error_code,omitempty"`
+	EmittedAt time.Time      `json:"emitted_at"`
+}
+
+func (e event) key() string {
+	if e.NoteID != nil {
+		return fmt.Sprint(*e.NoteID)
+	}
+	return e.JobID
+}
+
+type eventPublisher interface {
+	Publish(ctx context.Context, events []event) error
+	Close() error
+}
+
+var publisher eventPublisher
+
+func initEventPublisher() error {
+	var err error
+	switch eventsBackend {
+	case "":
+		return nil
+	case "kafka":
+		publisher, err = newKafkaPublisher()
+	case "nats":
+		publisher, err = newNATSPublisher()
+	default:
+		return fmt.Errorf("unknown EVENTS_BACKEND %q", eventsBackend)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("Event publishing enabled", "backend", eventsBackend)
+	return nil
+}
+
+func publishImportEvent(ctx context.Context, e event) {
+	e.Workspace = eventWorkspace(ctx)
+	e.EmittedAt = time.Now()
+	notifyWebhooks(ctx, e.Type, []WebhookEvent{importWebhookEvent(e)})
+	if publisher == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := publisher.Publish(ctx, []event{e}); err != nil {
+		logger.Warn("Failed to publish import event", "job_id", e.JobID, "type", e.Type, "error", err)
+	}
+}
+
+// eventWorkspace leaves the field empty for the default workspace so
+// single-tenant consumers see unchanged payloads.
+func eventWorkspace(ctx context.Context) string {
+	if ws := workspaceFromContext(ctx); ws != defaultWorkspace {
+		return ws.Name
+	}
+	return ""
+}
+
+func decodeNoteJSON(data []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var note map[string]any
+	if err := dec.Decode(&note); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// publishNoteChanges diffs note against the fingerprints stored by the last
+// publish and emits one event per created, updated or deleted note.
+// Fingerprints are only advanced after a batch is published, so a crash
+// re-emits rather than drops events.
+func publishNoteChanges(ctx context.Context, jobID, dataDate string, log *slog.Logger) (int, error) {
+	var seeded bool
+	db.QueryRowContext(ctx, expandSQL(ctx, `SELECT EXISTS (SELECT 1 FROM {note_fingerprints})`)).Scan(&seeded)
+	if !seeded && !noteEventsInitialSnapshot {
+		res, err := db.ExecContext(ctx, expandSQL(ctx, `
+			INSERT INTO {note_fingerprints} (noteid, hash, updated_at)
+			SELECT noteid, md5((to_jsonb(note) - `+derivedNoteColumnsSQL+`)::text), NOW() FROM {note} note
+		`))
+		if err != nil {
+			return 0, fmt.Errorf("failed to seed note fingerprints: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		log.Info("Seeded note fingerprints without publishing", "notes", n)
+		return 0, nil
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT n.noteid, f.noteid IS NULL, c.doc, md5(c.doc)
+		FROM {note} n
+		CROSS JOIN LATERAL (SELECT (to_jsonb(n) - `+derivedNoteColumnsSQL+`)::text AS doc) c
+		LEFT JOIN {note_fingerprints} f ON f.noteid = n.noteid
+		WHERE f.hash IS DISTINCT FROM md5(c.doc)
+	`))
+	if err != nil {
+		return 0, fmt.Errorf("failed to diff notes: %w", err)
+	}
+	defer rows.Close()
+
+	published := 0
+	var batch []event
+	var ids []int64
+	var hashes []string
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := publisher.Publish(ctx, batch); err != nil {
+			return fmt.Errorf("failed to publish note events: %w", err)
+		}
+		_, err := db.ExecContext(ctx, expandSQL(ctx, `
+			INSERT INTO {note_fingerprints} (noteid, hash, updated_at)
+			SELECT unnest($1::bigint[]), unnest($2::text[]), NOW()
+			ON CONFLICT (noteid) DO UPDATE SET hash = EXCLUDED.hash, updated_at = EXCLUDED.updated_at
+		`), ids, hashes)
+		if err != nil {
+			return fmt.Errorf("failed to update note fingerprints: %w", err)
+		}
+		published += len(batch)
+		batch, ids, hashes = batch[:0], ids[:0], hashes[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		var noteID int64
+		var created bool
+		var doc, hash string
+		if err := rows.Scan(&noteID, &created, &doc, &hash); err != nil {
+			return published, err
+		}
+		note, err := decodeNoteJSON([]byte(doc))
+		if err != nil {
+			return published, fmt.Errorf("failed to decode note %d: %w", noteID, err)
+		}
+
+		e := event{Type: eventNoteUpdated, Workspace: eventWorkspace(ctx), JobID: jobID, NoteID: &noteID, DataDate: dataDate, Note: note, EmittedAt: time.Now()}
+		if created {
+			e.Type = eventNoteCreated
+		}
+		batch = append(batch, e)
+		ids = append(ids, noteID)
+		hashes = append(hashes, hash)
+
+		if len(batch) >= noteEventsBatchSize {
+			if err := flush(); err != nil {
+				return published, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return published, err
+	}
+	if err := flush(); err != nil {
+		return published, err
+	}
+
+	deleted, err := publishNoteDeletions(ctx, jobID, dataDate)
+	published += deleted
+	return published, err
+}
+
+func publishNoteDeletions(ctx context.Context, jobID, dataDate string) (int, error) {
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT f.noteid FROM {note_fingerprints} f
+		WHERE NOT EXISTS (SELECT 1 FROM {note} n WHERE n.noteid = f.noteid)
+	`))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find deleted notes: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	published := 0
+	for start := 0; start < len(ids); start += noteEventsBatchSize {
+		chunk := ids[start:min(start+noteEventsBatchSize, len(ids))]
+		batch := make([]event, len(chunk))
+		for i := range chunk {
+			batch[i] = event{Type: eventNoteDeleted, Workspace: eventWorkspace(ctx), JobID: jobID, NoteID: &chunk[i], DataDate: dataDate, EmittedAt: time.Now()}
+		}
+		if err := publisher.Publish(ctx, batch); err != nil {
+			return published, fmt.Errorf("failed to publish note events: %w", err)
+		}
+		if _, err := db.ExecContext(ctx, expandSQL(ctx, `DELETE FROM {note_fingerprints} WHERE noteid = ANY($1)`), chunk); err != nil {
+			return published, fmt.Errorf("failed to update note fingerprints: %w", err)
+		}
+		published += len(chunk)
+	}
+	return published, nil
+}
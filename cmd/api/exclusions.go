@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Exclusion hides a note from the read endpoints without touching note, so it
+// survives the re-imports that truncate and reload it.
+type Exclusion struct {
+	NoteID     int64     `json:"note_id"`
+	Reason     *string   `json:"reason,omitempty"`
+	ExcludedBy *string   `json:"excluded_by,omitempty"`
+	ExcludedAt time.Time `json:"excluded_at"`
+}
+
+type ExclusionRequest struct {
+	NoteIDs []int64 `json:"note_ids"`
+	Reason  string  `json:"reason"`
+}
+
+const maxExclusionBatch = 1000
+
+// notExcluded is a WHERE condition keeping the rows of the note aliased as
+// alias that are not excluded.
+func notExcluded(alias string) string {
+	return `NOT EXISTS (SELECT 1 FROM {note_exclusions} x WHERE x.noteid = ` + alias + `.noteid)`
+}
+
+func scanExclusion(row rowScanner) (Exclusion, error) {
+	var e Exclusion
+	var reason, excludedBy sql.NullString
+	err := row.Scan(&e.NoteID, &reason, &excludedBy, &e.ExcludedAt)
+	e.Reason = nullStringToStrPtr(reason)
+	e.ExcludedBy = nullStringToStrPtr(excludedBy)
+	return e, err
+}
+
+func listExclusions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT noteid, reason, excluded_by, excluded_at FROM {note_exclusions} ORDER BY excluded_at DESC, noteid`))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list exclusions: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	exclusions := []Exclusion{}
+	for rows.Next() {
+		e, err := scanExclusion(rows)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list exclusions: "+err.Error())
+			return
+		}
+		exclusions = append(exclusions, e)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list exclusions: "+err.Error())
+		return
+	}
+	writeList(w, r, exclusions)
+}
+
+// createExclusions excludes note_ids, which need not be loaded yet; excluding
+// an already excluded note updates its reason.
+func createExclusions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req ExclusionRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if len(req.NoteIDs) == 0 || len(req.NoteIDs) > maxExclusionBatch {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "note_ids must hold between 1 and "+strconv.Itoa(maxExclusionBatch)+" note ids")
+		return
+	}
+
+	var reason, excludedBy *string
+	if req.Reason != "" {
+		reason = &req.Reason
+	}
+	if p, ok := principalFromContext(ctx); ok {
+		excludedBy = &p.Name
+	}
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		INSERT INTO {note_exclusions} (noteid, reason, excluded_by, excluded_at)
+		SELECT DISTINCT unnest($1::bigint[]), $2, $3, NOW()
+		ON CONFLICT (noteid) DO UPDATE SET reason = EXCLUDED.reason, excluded_by = EXCLUDED.excluded_by, excluded_at = EXCLUDED.excluded_at
+		RETURNING noteid, reason, excluded_by, excluded_at
+	`), req.NoteIDs, reason, excludedBy)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to exclude notes: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	exclusions := []Exclusion{}
+	for rows.Next() {
+		e, err := scanExclusion(rows)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to exclude notes: "+err.Error())
+			return
+		}
+		exclusions = append(exclusions, e)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to exclude notes: "+err.Error())
+		return
+	}
+	invalidateResponseCache(ctx)
+	logger.Info("Notes excluded", "notes", len(exclusions), "reason", req.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(exclusions)
+}
+
+func deleteExclusion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	noteID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Note ID must be an integer")
+		return
+	}
+	result, err := db.ExecContext(ctx, expandSQL(ctx, `DELETE FROM {note_exclusions} WHERE noteid = $1`), noteID)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to delete exclusion: "+err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeProblem(w, http.StatusNotFound, errCodeExclusionNotFound, "Note is not excluded")
+		return
+	}
+	invalidateResponseCache(ctx)
+	logger.Info("Note exclusion removed", "note_id", noteID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	exportMaxConcurrent = getEnvInt("EXPORT_MAX_CONCURRENT", 2)
+	exportRetention     = getEnvDuration("EXPORT_RETENTION", 24*time.Hour)
+)
+
+var exportFormats = []string{"csv", "jsonl", exportFormatDuckDB, exportFormatSQLite}
+
+// packagedExport is a database-file format, built by an external CLI from the
+// CSV the export streams first.
+type packagedExport struct {
+	tool        *string
+	contentType string
+	build       func(ctx context.Context, csvPath, dbPath string, columns []*sql.ColumnType) error
+}
+
+var packagedExports = map[string]packagedExport{
+	exportFormatDuckDB: {tool: &duckDBPath, contentType: "application/octet-stream", build: buildDuckDBExport},
+	exportFormatSQLite: {tool: &sqlitePath, contentType: "application/vnd.sqlite3", build: buildSQLiteExport},
+}
+
+// availableExportFormats leaves out the packaged formats whose CLI is not on
+// PATH, so that an image without it does not offer them.
+func availableExportFormats() []string {
+	var formats []string
+	for _, f := range exportFormats {
+		if p, ok := packagedExports[f]; ok {
+			if _, err := exec.LookPath(*p.tool); err != nil {
+				continue
+			}
+		}
+		formats = append(formats, f)
+	}
+	return formats
+}
+
+// exportSlots bounds the exports running at once across workspaces; the
+// others wait as queued.
+var exportSlots = make(chan struct{}, max(exportMaxConcurrent, 1))
+
+type ExportRequest struct {
+	Format  string            `json:"format"`
+	Filters map[string]string `json:"filters"`
+	Fields  []string          `json:"fields"`
+}
+
+type Export struct {
+	ExportID     string            `json:"export_id"`
+	Status       string            `json:"status"`
+	Format       string            `json:"format"`
+	Filters      map[string]string `json:"filters"`
+	Fields       []string          `json:"fields,omitempty"`
+	RequestedBy  *string           `json:"requested_by,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	CompletedAt  *time.Time        `json:"completed_at,omitempty"`
+	Rows         int64             `json:"rows"`
+	SizeBytes    *int64            `json:"size_bytes,omitempty"`
+	DownloadURL  *string           `json:"download_url,omitempty"`
+	ExpiresAt    *time.Time        `json:"download_expires_at,omitempty"`
+	ErrorMessage *string           `json:"error_message,omitempty"`
+
+	objectKey *string
+}
+
+const exportColumns = `export_id, status, format, filters, requested_by, created_at, started_at, completed_at, row_count, size_bytes, error_message, object_key, fields`
+
+func scanExport(row rowScanner) (Export, error) {
+	var e Export
+	var filters []byte
+	var requestedBy, errMsg, objectKey sql.NullString
+	var startedAt, completedAt sql.NullTime
+	var size sql.NullInt64
+	err := row.Scan(&e.ExportID, &e.Status, &e.Format, &filters, &requestedBy, &e.CreatedAt, &startedAt, &completedAt, &e.Rows, &size, &errMsg, &objectKey, scanArray(&e.Fields))
+	if err != nil {
+		return e, err
+	}
+	json.Unmarshal(filters, &e.Filters)
+	if e.Filters == nil {
+		e.Filters = map[string]string{}
+	}
+	e.RequestedBy = nullStringToStrPtr(requestedBy)
+	e.StartedAt = nullTimeToTimePtr(startedAt)
+	e.CompletedAt = nullTimeToTimePtr(completedAt)
+	e.SizeBytes = nullInt64ToInt64Ptr(size)
+	e.ErrorMessage = nullStringToStrPtr(errMsg)
+	e.objectKey = nullStringToStrPtr(objectKey)
+	switch {
+	case e.Status != "completed":
+	case e.objectKey != nil && exportBucket != nil:
+		url, expires := exportBucket.downloadURL(*e.objectKey, exportFileName(e.ExportID, e.Format))
+		e.DownloadURL, e.ExpiresAt = &url, &expires
+	default:
+		url := "/exports/" + e.ExportID + "/download"
+		e.DownloadURL = &url
+	}
+	return e, nil
+}
+
+func exportDir(ctx context.Context) string {
+	return filepath.Join(workspaceFromContext(ctx).dataDir(), "exports")
+}
+
+func exportFileName(id, format string) string {
+	return "export-" + id + "." + format
+}
+
+// exportWhere turns the filters into a WHERE clause over note aliased n,
+// leaving out excluded notes: from and to bound created_at, any other key must
+// be one of aggregateDimensions and matches its value exactly.
+func exportWhere(filters map[string]string) (string, []any, []FieldError) {
+	where := []string{notExcluded("n")}
+	var args []any
+	var errs []FieldError
+	for _, k := range slices.Sorted(maps.Keys(filters)) {
+		v := filters[k]
+		switch k {
+		case "from", "to":
+			t, err := parseFilterTime(v, k == "to")
+			if err != nil {
+				errs = append(errs, FieldError{Field: "filters." + k, Detail: "must be a date (YYYY-MM-DD) or RFC 3339 timestamp"})
+				continue
+			}
+			args = append(args, t)
+			op := ">="
+			if k == "to" {
+				op = "<="
+			}
+			where = append(where, fmt.Sprintf("created_at %s $%d", op, len(args)))
+		default:
+			expr, ok := aggregateDimensions[k]
+			if !ok {
+				errs = append(errs, FieldError{Field: "filters." + k, Detail: "must be from, to or one of " + strings.Join(sortedKeys(aggregateDimensions), ", ")})
+				continue
+			}
+			args = append(args, v)
+			where = append(where, fmt.Sprintf("%s = $%d", expr, len(args)))
+		}
+	}
+	return " WHERE " + strings.Join(where, " AND "), args, errs
+}
+
+// pruneExports deletes export files and objects older than EXPORT_RETENTION
+// and marks their jobs expired.
+func pruneExports(ctx context.Context) {
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		UPDATE {export_jobs} SET status = 'expired'
+		WHERE status = 'completed' AND completed_at < NOW() - make_interval(secs => $1)
+		RETURNING export_id, format, object_key
+	`), exportRetention.Seconds())
+	if err != nil {
+		logger.Warn("Failed to prune exports", "error", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, format string
+		var objectKey sql.NullString
+		if rows.Scan(&id, &format, &objectKey) != nil {
+			continue
+		}
+		os.Remove(filepath.Join(exportDir(ctx), exportFileName(id, format)))
+		if objectKey.Valid && exportBucket != nil {
+			if err := exportBucket.delete(ctx, objectKey.String); err != nil {
+				logger.Warn("Failed to delete expired export object", "export_id", id, "error", err)
+			}
+		}
+	}
+}
+
+// writeExportRows streams rows to w as CSV with a header, or as one JSON
+// object per line, recording progress on the job every 10000 rows.
+func writeExportRows(ctx context.Context, id, format string, rows *sql.Rows, w io.Writer) (int64, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	var cw *csv.Writer
+	var enc *json.Encoder
+	if format == "csv" {
+		cw = csv.NewWriter(w)
+		cw.Write(columns)
+	} else {
+		enc = json.NewEncoder(w)
+	}
+
+	values := make([]any, len(columns))
+	ptrs := make([]any, len(values))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	record := make([]string, len(columns))
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		if cw != nil {
+			for i, v := range values {
+				record[i] = exportCSVValue(v)
+			}
+			if err := cw.Write(record); err != nil {
+				return n, err
+			}
+		} else {
+			obj := make(map[string]any, len(columns))
+			for i, c := range columns {
+				obj[c] = values[i]
+			}
+			if err := enc.Encode(obj); err != nil {
+				return n, err
+			}
+		}
+		n++
+		if n%10000 == 0 {
+			db.ExecContext(ctx, expandSQL(ctx, `UPDATE {export_jobs} SET row_count = $1 WHERE export_id = $2`), n, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if cw != nil {
+		cw.Flush()
+		return n, cw.Error()
+	}
+	return n, nil
+}
+
+func exportCSVValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func finishExport(ctx context.Context, id string, rows, size int64, objectKey *string, err error) {
+	if err != nil {
+		logger.Error("Export job failed", "export_id", id, "error", err)
+		db.ExecContext(ctx, expandSQL(ctx, `UPDATE {export_jobs} SET status = 'failed', error_message = $1, row_count = $2, completed_at = NOW() WHERE export_id = $3`), err.Error(), rows, id)
+		return
+	}
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {export_jobs} SET status = 'completed', row_count = $1, size_bytes = $2, object_key = $3, completed_at = NOW() WHERE export_id = $4`), rows, size, objectKey, id)
+	logger.Info("Export completed", "export_id", id, "rows", rows, "bytes", size)
+}
+
+// exportFields resolves the requested columns of note, matched like fields=
+// on the list endpoints, to their names; none selects every column.
+func exportFields(ctx context.Context, requested []string) ([]string, []FieldError, error) {
+	if len(requested) == 0 {
+		return nil, nil, nil
+	}
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT * FROM {note} LIMIT 0`))
+	if err != nil {
+		return nil, nil, err
+	}
+	columns, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields []string
+	var errs []FieldError
+	for _, name := range requested {
+		i := slices.IndexFunc(columns, func(c string) bool { return fieldKey(c) == fieldKey(name) })
+		if i < 0 {
+			errs = append(errs, FieldError{Field: "fields", Detail: fmt.Sprintf("unknown column %q", name)})
+		} else if !slices.Contains(fields, columns[i]) {
+			fields = append(fields, columns[i])
+		}
+	}
+	return fields, errs, nil
+}
+
+// runExport waits for an export slot, then streams the filtered notes to a
+// temporary file that is renamed into place once complete, and moved to the
+// export bucket when one is configured. A packaged export is streamed as CSV
+// first and loaded into the database file from there.
+func runExport(ctx context.Context, id, format string, fields []string, where string, args []any) {
+	exportSlots <- struct{}{}
+	defer func() { <-exportSlots }()
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {export_jobs} SET status = 'running', started_at = NOW() WHERE export_id = $1`), id)
+
+	path := filepath.Join(exportDir(ctx), exportFileName(id, format))
+	rowFormat, out := format, path+".tmp"
+	packaged, isPackaged := packagedExports[format]
+	if isPackaged {
+		rowFormat, out = "csv", path+".csv.tmp"
+		defer os.Remove(path + ".tmp")
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		finishExport(ctx, id, 0, 0, nil, err)
+		return
+	}
+	defer os.Remove(out)
+	defer f.Close()
+
+	columns := "*"
+	if len(fields) > 0 {
+		columns = quoteColumns(fields)
+	}
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT `+columns+` FROM {note} n`+where+` ORDER BY noteid`), args...)
+	if err != nil {
+		finishExport(ctx, id, 0, 0, nil, err)
+		return
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		finishExport(ctx, id, 0, 0, nil, err)
+		return
+	}
+	bw := bufio.NewWriterSize(f, 1<<20)
+	n, err := writeExportRows(ctx, id, rowFormat, rows, bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err == nil && isPackaged {
+		err = packaged.build(ctx, out, path+".tmp", columnTypes)
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	var size int64
+	if info, serr := os.Stat(path); err == nil && serr == nil {
+		size = info.Size()
+	}
+	var objectKey *string
+	if err == nil && exportBucket != nil {
+		key := exportBucket.key(workspaceFromContext(ctx).Name, filepath.Base(path))
+		if err = exportBucket.upload(ctx, key, path); err == nil {
+			objectKey = &key
+			os.Remove(path)
+		}
+	}
+	finishExport(ctx, id, n, size, objectKey, err)
+}
+
+// createExport queues an export of the notes matching the filters and
+// returns 202 with the job; poll GET /exports/{export_id} for download_url.
+func createExport(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
+
+	var req ExportRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && err != io.EOF {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+	where, args, fieldErrs := exportWhere(req.Filters)
+	if formats := availableExportFormats(); !slices.Contains(formats, req.Format) {
+		fieldErrs = append(fieldErrs, FieldError{Field: "format", Detail: "must be one of " + strings.Join(formats, ", ")})
+	}
+	fields, columnErrs, err := exportFields(ctx, req.Fields)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to read note columns: "+err.Error())
+		return
+	}
+	fieldErrs = append(fieldErrs, columnErrs...)
+	if len(fieldErrs) > 0 {
+		writeFieldProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid export request", fieldErrs)
+		return
+	}
+	if req.Filters == nil {
+		req.Filters = map[string]string{}
+	}
+
+	if err := os.MkdirAll(exportDir(ctx), 0755); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to create export directory: "+err.Error())
+		return
+	}
+	pruneExports(ctx)
+
+	var requestedBy *string
+	if p, ok := principalFromContext(r.Context()); ok {
+		requestedBy = &p.Name
+	}
+	filters, _ := json.Marshal(req.Filters)
+	e, err := scanExport(db.QueryRowContext(ctx, expandSQL(ctx, `
+		INSERT INTO {export_jobs} (status, format, filters, fields, requested_by, created_at, owner_instance)
+		VALUES ('queued', $1, $2, $3, $4, NOW(), $5)
+		RETURNING `+exportColumns), req.Format, filters, fields, requestedBy, instanceID))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to create export job: "+err.Error())
+		return
+	}
+	logger.Info("Export queued", "export_id", e.ExportID, "format", e.Format, "filters", e.Filters)
+
+	go runExport(ctx, e.ExportID, e.Format, fields, where, args)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/exports/"+e.ExportID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(e)
+}
+
+func listExports(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT `+exportColumns+` FROM {export_jobs} ORDER BY created_at DESC LIMIT $1`), limit)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list exports: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	exports := []Export{}
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list exports: "+err.Error())
+			return
+		}
+		exports = append(exports, e)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list exports: "+err.Error())
+		return
+	}
+	writeList(w, r, exports)
+}
+
+func getExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	e, err := scanExport(db.QueryRowContext(ctx, expandSQL(ctx, `SELECT `+exportColumns+` FROM {export_jobs} WHERE export_id::text = $1`), r.PathValue("export_id")))
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeExportNotFound, "Export not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get export: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+// downloadExport serves a completed export's file, with range support, or
+// redirects to a pre-signed URL when it lives in the export bucket.
+func downloadExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	e, err := scanExport(db.QueryRowContext(ctx, expandSQL(ctx, `SELECT `+exportColumns+` FROM {export_jobs} WHERE export_id::text = $1`), r.PathValue("export_id")))
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeExportNotFound, "Export not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get export: "+err.Error())
+		return
+	}
+	if e.Status != "completed" {
+		writeProblem(w, http.StatusConflict, errCodeExportNotReady, "Export is "+e.Status)
+		return
+	}
+	if e.objectKey != nil && exportBucket != nil {
+		http.Redirect(w, r, *e.DownloadURL, http.StatusFound)
+		return
+	}
+
+	name := exportFileName(e.ExportID, e.Format)
+	f, err := os.Open(filepath.Join(exportDir(ctx), name))
+	if err != nil {
+		writeProblem(w, http.StatusNotFound, errCodeExportNotFound, "Export file is missing")
+		return
+	}
+	defer f.Close()
+
+	if p, ok := packagedExports[e.Format]; ok {
+		w.Header().Set("Content-Type", p.contentType)
+	} else if e.Format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/jsonl")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, name, *e.CompletedAt, f)
+}
+
+// sanitizeExportStatus fails exports this instance left queued or running
+// when it died.
+func sanitizeExportStatus() {
+	for _, ws := range workspaceOrder {
+		ctx := withWorkspace(context.Background(), ws)
+		_, err := db.ExecContext(ctx, expandSQL(ctx, `UPDATE {export_jobs} SET status = 'failed', error_message = 'Interrupted', completed_at = NOW() WHERE status IN ('queued', 'running') AND (owner_instance IS NULL OR owner_instance = $1)`), instanceID)
+		if err != nil {
+			logger.Warn("Failed to sanitize export status", "workspace", ws.Name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var duckDBPath = getEnv("DUCKDB_PATH", "duckdb")
+
+const exportFormatDuckDB = "duckdb"
+
+// duckDBTypes maps the Postgres types of note columns to DuckDB's; anything
+// else, arrays included, is kept as text.
+var duckDBTypes = map[string]string{
+	"INT2":        "SMALLINT",
+	"INT4":        "INTEGER",
+	"INT8":        "BIGINT",
+	"FLOAT4":      "REAL",
+	"FLOAT8":      "DOUBLE",
+	"NUMERIC":     "DOUBLE",
+	"BOOL":        "BOOLEAN",
+	"DATE":        "DATE",
+	"TIMESTAMP":   "TIMESTAMPTZ",
+	"TIMESTAMPTZ": "TIMESTAMPTZ",
+}
+
+func duckDBString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// buildDuckDBExport loads the CSV an export wrote into a note table of a new
+// DuckDB database at dbPath with the duckdb CLI, typing each column after its
+// Postgres type rather than DuckDB's guess.
+func buildDuckDBExport(ctx context.Context, csvPath, dbPath string, columns []*sql.ColumnType) error {
+	defs := make([]string, len(columns))
+	for i, c := range columns {
+		t, ok := duckDBTypes[c.DatabaseTypeName()]
+		if !ok {
+			t = "VARCHAR"
+		}
+		defs[i] = duckDBString(c.Name()) + ": " + duckDBString(t)
+	}
+	stmt := fmt.Sprintf(`CREATE TABLE note AS SELECT * FROM read_csv(%s, header = true, auto_detect = false, columns = {%s});`,
+		duckDBString(csvPath), strings.Join(defs, ", "))
+
+	out, err := exec.CommandContext(ctx, duckDBPath, dbPath, "-c", stmt).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("duckdb: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+var sqlitePath = getEnv("SQLITE3_PATH", "sqlite3")
+
+const exportFormatSQLite = "sqlite"
+
+// sqliteIndexedColumns are indexed in a SQLite export when selected, the
+// lookups analysts run most; noteid is the primary key.
+var sqliteIndexedColumns = []string{"tweetid", "tweet_id", "noteauthorparticipantid", "classification", "created_at"}
+
+func sqliteType(pgType string) string {
+	switch pgType {
+	case "INT2", "INT4", "INT8", "BOOL":
+		return "INTEGER"
+	case "FLOAT4", "FLOAT8", "NUMERIC":
+		return "REAL"
+	}
+	return "TEXT"
+}
+
+// buildSQLiteExport creates a note table in a new SQLite database at dbPath,
+// imports the CSV an export wrote with the sqlite3 CLI, turns the empty
+// strings .import leaves for NULLs back into NULLs and builds the indexes, so
+// the file is ready to query offline. Booleans become 0 and 1.
+func buildSQLiteExport(ctx context.Context, csvPath, dbPath string, columns []*sql.ColumnType) error {
+	var script strings.Builder
+	defs := make([]string, len(columns))
+	for i, c := range columns {
+		defs[i] = quoteIdent(c.Name()) + " " + sqliteType(c.DatabaseTypeName())
+		if c.Name() == "noteid" {
+			defs[i] += " PRIMARY KEY"
+		}
+	}
+	fmt.Fprintf(&script, "PRAGMA synchronous = OFF;\nCREATE TABLE note (%s);\n", strings.Join(defs, ", "))
+	fmt.Fprintf(&script, ".import --csv --skip 1 %s note\n", sqliteDotArg(csvPath))
+	for _, c := range columns {
+		name := quoteIdent(c.Name())
+		switch c.DatabaseTypeName() {
+		case "BOOL":
+			fmt.Fprintf(&script, "UPDATE note SET %[1]s = CASE %[1]s WHEN 'true' THEN 1 WHEN 'false' THEN 0 END;\n", name)
+		default:
+			fmt.Fprintf(&script, "UPDATE note SET %[1]s = NULL WHERE %[1]s = '';\n", name)
+		}
+	}
+	for _, c := range columns {
+		if slices.Contains(sqliteIndexedColumns, c.Name()) {
+			fmt.Fprintf(&script, "CREATE INDEX %s ON note (%s);\n", quoteIdent("note_"+c.Name()+"_idx"), quoteIdent(c.Name()))
+		}
+	}
+	script.WriteString("ANALYZE;\nVACUUM;\n")
+
+	cmd := exec.CommandContext(ctx, sqlitePath, "-bail", dbPath)
+	cmd.Stdin = strings.NewReader(script.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sqlite3: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// sqliteDotArg quotes an argument of a sqlite3 dot-command.
+func sqliteDotArg(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
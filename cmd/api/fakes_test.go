@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+var errFakeTransfer = errors.New("fake transfer interrupted")
+
+// useImportFakes swaps in the given importer dependencies (nil keeps the
+// current one) and a recorder for the job status writes, restoring the
+// previous ones when the test ends.
+func useImportFakes(t *testing.T, f Fetcher, e Extractor, l Loader) *recordingExecer {
+	prevF, prevE, prevL, prevS := snapshotFetcher, snapshotExtractor, noteLoader, jobStatus
+	if f != nil {
+		snapshotFetcher = f
+	}
+	if e != nil {
+		snapshotExtractor = e
+	}
+	if l != nil {
+		noteLoader = l
+	}
+	rec := &recordingExecer{}
+	jobStatus = rec
+	t.Cleanup(func() {
+		snapshotFetcher, snapshotExtractor, noteLoader, jobStatus = prevF, prevE, prevL, prevS
+	})
+	return rec
+}
+
+// testWorkspace points dataDir at a temporary directory and returns a context
+// carrying a workspace whose files go there, as prepareDownloads leaves it.
+func testWorkspace(t *testing.T) (context.Context, string) {
+	prev := dataDir
+	dataDir = t.TempDir()
+	t.Cleanup(func() { dataDir = prev })
+	ws := newWorkspace("test", "test", time.Hour)
+	if err := os.MkdirAll(ws.dataDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return withWorkspace(context.Background(), ws), ws.dataDir()
+}
+
+// recordingExecer stands in for the database, keeping each statement it is
+// given.
+type recordingExecer struct {
+	mu    sync.Mutex
+	stmts []string
+}
+
+func (r *recordingExecer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stmts = append(r.stmts, query)
+	return driver.RowsAffected(1), nil
+}
+
+// Count returns how many recorded statements contain substr.
+func (r *recordingExecer) Count(substr string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int
+	for _, s := range r.stmts {
+		if strings.Contains(s, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+// memFetcher serves snapshots held in memory, Files[date] being each file's
+// content in index order. A file in FailAfter has its body cut with
+// errFakeTransfer after that many bytes of every fetch, to exercise partial
+// downloads and their resume.
+type memFetcher struct {
+	Files     map[string][][]byte
+	FailAfter map[int]int64
+
+	mu      sync.Mutex
+	fetches []string
+}
+
+func (m *memFetcher) LatestDate(ctx context.Context, lookbackDays int) (string, error) {
+	for i := 0; i < lookbackDays; i++ {
+		if date := getDateDaysAgo(i); len(m.Files[date]) > 0 {
+			return date, nil
+		}
+	}
+	return "", fmt.Errorf("no data files found in the last %d days", lookbackDays)
+}
+
+func (m *memFetcher) Discover(ctx context.Context, date string) []int64 {
+	var sizes []int64
+	for _, f := range m.Files[date] {
+		sizes = append(sizes, int64(len(f)))
+	}
+	return sizes
+}
+
+func (m *memFetcher) Fetch(ctx context.Context, date string, index int, offset int64) (io.ReadCloser, int64, int64, error) {
+	m.mu.Lock()
+	m.fetches = append(m.fetches, fmt.Sprintf("%s/%d@%d", date, index, offset))
+	m.mu.Unlock()
+
+	files := m.Files[date]
+	if index >= len(files) {
+		return nil, 0, 0, fmt.Errorf("failed to download %s file %d: status 404", date, index)
+	}
+	data := files[index]
+	if offset > int64(len(data)) {
+		return nil, 0, 0, errRangeNotSatisfiable
+	}
+
+	var body io.Reader = bytes.NewReader(data[offset:])
+	if n, ok := m.FailAfter[index]; ok {
+		body = io.MultiReader(io.LimitReader(body, n), errReader{errFakeTransfer})
+	}
+	return io.NopCloser(body), int64(len(data)), offset, nil
+}
+
+// Fetches lists the fetches made so far as date/index@offset.
+func (m *memFetcher) Fetches() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.fetches...)
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// memExtractor writes TSV[index] next to the zip instead of unpacking it;
+// an index in Errors fails as a bad archive would.
+type memExtractor struct {
+	TSV    map[int]string
+	Errors map[int]error
+}
+
+func (m memExtractor) Extract(zipPath string, index int) (string, error) {
+	if err, ok := m.Errors[index]; ok {
+		return "", err
+	}
+	tsvPath := strings.TrimSuffix(zipPath, ".zip") + ".tsv"
+	if err := os.WriteFile(tsvPath, []byte(m.TSV[index]), 0644); err != nil {
+		return "", err
+	}
+	return tsvPath, nil
+}
+
+// fakeLoader counts each TSV's data lines as loaded rows without touching the
+// database. Errors[path] are returned, in order, by the first attempts to
+// load that file, so retries and COPY failures can be scripted.
+type fakeLoader struct {
+	Errors map[string][]error
+
+	mu       sync.Mutex
+	attempts map[string]int
+	loaded   map[string]int64
+}
+
+func (l *fakeLoader) CopyFile(ctx context.Context, ex execer, session *loadSession, table string, plan columnPlan, columnTypes map[string]string, path string, limit int, upsert bool) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.attempts == nil {
+		l.attempts = map[string]int{}
+	}
+	l.attempts[path]++
+	if errs := l.Errors[path]; len(errs) > 0 {
+		l.Errors[path] = errs[1:]
+		return 0, errs[0]
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	rows := int64(max(bytes.Count(data, []byte{'\n'})-1, 0))
+	if limit > 0 {
+		rows = min(rows, int64(limit))
+	}
+	if l.loaded == nil {
+		l.loaded = map[string]int64{}
+	}
+	l.loaded[path] = rows
+	return rows, nil
+}
+
+// Attempts returns how many times path was loaded, failures included.
+func (l *fakeLoader) Attempts(path string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.attempts[path]
+}
+
+// Loaded returns the rows loaded per TSV path.
+func (l *fakeLoader) Loaded() map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	loaded := make(map[string]int64, len(l.loaded))
+	for k, v := range l.loaded {
+		loaded[k] = v
+	}
+	return loaded
+}
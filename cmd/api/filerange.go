@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxFileIndex is the highest index a five-digit snapshot file name can have.
+const maxFileIndex = 99999
+
+// parseFileRange parses a selection of snapshot file indexes such as "0-3" or
+// "0,2,5-7" into ascending, distinct indexes; "" selects every file (nil).
+func parseFileRange(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var indexes []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil || first < 0 || first > maxFileIndex {
+			return nil, fmt.Errorf("%q is not a file index or range", part)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.Atoi(hi)
+			if err != nil || last < first || last > maxFileIndex {
+				return nil, fmt.Errorf("%q is not a file index or range", part)
+			}
+		}
+		for i := first; i <= last; i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	slices.Sort(indexes)
+	return slices.Compact(indexes), nil
+}
+
+// selectFileIndexes returns the indexes of a snapshot of total files that sel
+// picks, all of them when sel is nil.
+func selectFileIndexes(total int, sel []int) ([]int, error) {
+	if sel == nil {
+		indexes := make([]int, total)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes, nil
+	}
+	if last := sel[len(sel)-1]; last >= total {
+		return nil, fmt.Errorf("file index %d out of range; the snapshot has %d files", last, total)
+	}
+	return sel, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/flight"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	flightEnabled   = getEnvBool("FLIGHT_ENABLED", false)
+	flightPort      = getEnv("FLIGHT_PORT", "8815")
+	flightTables    = strings.Split(getEnv("FLIGHT_TABLES", "note"), ",")
+	flightBatchSize = 65536
+)
+
+type flightQuery struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns,omitempty"`
+	Limit   int      `json:"limit,omitempty"`
+}
+
+type flightColumn struct {
+	Name string
+	Type arrow.DataType
+	Expr string
+}
+
+type flightServer struct {
+	flight.BaseFlightServer
+}
+
+func startFlightServer() {
+	if !flightEnabled {
+		return
+	}
+
+	server := flight.NewFlightServer()
+	if err := server.Init(":" + flightPort); err != nil {
+		logger.Error("Failed to start Arrow Flight server", "error", err)
+		return
+	}
+	server.RegisterFlightService(&flightServer{})
+
+	logger.Info("Starting Arrow Flight server", "port", flightPort, "tables", flightTables)
+	go func() {
+		if err := server.Serve(); err != nil {
+			logger.Error("Arrow Flight server stopped", "error", err)
+		}
+	}()
+}
+
+// flightAuthorize resolves the workspace named by the x-workspace metadata
+// entry and checks the caller's credentials against it, returning a context
+// carrying that workspace.
+func flightAuthorize(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ws := defaultWorkspace
+	if v := md.Get("x-workspace"); len(v) > 0 && v[0] != "" {
+		var ok bool
+		if ws, ok = workspaces[v[0]]; !ok {
+			return nil, status.Errorf(codes.NotFound, "workspace %q does not exist", v[0])
+		}
+	}
+	ctx = withWorkspace(ctx, ws)
+
+	if !authEnabled {
+		return ctx, nil
+	}
+
+	var key string
+	if v := md.Get("x-api-key"); len(v) > 0 {
+		key = v[0]
+	} else if v := md.Get("authorization"); len(v) > 0 {
+		key, _ = strings.CutPrefix(v[0], "Bearer ")
+	}
+
+	if key == "" {
+		if authAnonymousRead {
+			return ctx, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "API key required")
+	}
+
+	p, err := authenticate(ctx, key)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !hasRole(p, roleReader) {
+		return nil, status.Error(codes.PermissionDenied, "Role 'reader' required")
+	}
+	return ctx, nil
+}
+
+func parseFlightQuery(cmd []byte) (flightQuery, error) {
+	var q flightQuery
+	if err := json.Unmarshal(cmd, &q); err != nil {
+		return q, status.Errorf(codes.InvalidArgument, "invalid command: %v", err)
+	}
+	return q, nil
+}
+
+func flightQueryFromDescriptor(desc *flight.FlightDescriptor) (flightQuery, error) {
+	switch desc.GetType() {
+	case flight.DescriptorPATH:
+		if len(desc.GetPath()) != 1 {
+			return flightQuery{}, status.Error(codes.InvalidArgument, "path descriptor must name a single table")
+		}
+		return flightQuery{Table: desc.GetPath()[0]}, nil
+	case flight.DescriptorCMD:
+		return parseFlightQuery(desc.GetCmd())
+	}
+	return flightQuery{}, status.Error(codes.InvalidArgument, "unsupported descriptor type")
+}
+
+func arrowTypeFor(dataType string) (arrow.DataType, string) {
+	switch dataType {
+	case "bigint":
+		return arrow.PrimitiveTypes.Int64, ""
+	case "integer":
+		return arrow.PrimitiveTypes.Int32, ""
+	case "smallint":
+		return arrow.PrimitiveTypes.Int16, ""
+	case "boolean":
+		return arrow.FixedWidthTypes.Boolean, ""
+	case "double precision":
+		return arrow.PrimitiveTypes.Float64, ""
+	case "real":
+		return arrow.PrimitiveTypes.Float32, ""
+	case "text", "character varying":
+		return arrow.BinaryTypes.String, ""
+	}
+	return arrow.BinaryTypes.String, "::text"
+}
+
+func resolveFlightColumns(ctx context.Context, q flightQuery) ([]flightColumn, *arrow.Schema, error) {
+	if !slices.Contains(flightTables, q.Table) {
+		return nil, nil, status.Errorf(codes.NotFound, "table %q is not available", q.Table)
+	}
+
+	columns, err := tableColumns(ctx, q.Table)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "failed to read columns: %v", err)
+	}
+
+	var cols []flightColumn
+	var fields []arrow.Field
+	for _, c := range columns {
+		if len(q.Columns) > 0 && !slices.Contains(q.Columns, c.Name) {
+			continue
+		}
+		t, cast := arrowTypeFor(c.DataType)
+		cols = append(cols, flightColumn{Name: c.Name, Type: t, Expr: quoteIdent(c.Name) + cast})
+		fields = append(fields, arrow.Field{Name: c.Name, Type: t, Nullable: true})
+	}
+	if len(cols) == 0 || (len(q.Columns) > 0 && len(cols) != len(q.Columns)) {
+		return nil, nil, status.Error(codes.InvalidArgument, "unknown column requested")
+	}
+
+	return cols, arrow.NewSchema(fields, nil), nil
+}
+
+func (s *flightServer) ListFlights(c *flight.Criteria, fs flight.FlightService_ListFlightsServer) error {
+	if _, err := flightAuthorize(fs.Context()); err != nil {
+		return err
+	}
+	for _, table := range flightTables {
+		info, err := s.GetFlightInfo(fs.Context(), &flight.FlightDescriptor{Type: flight.DescriptorPATH, Path: []string{table}})
+		if err != nil {
+			return err
+		}
+		if err := fs.Send(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *flightServer) GetFlightInfo(ctx context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	ctx, err := flightAuthorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q, err := flightQueryFromDescriptor(desc)
+	if err != nil {
+		return nil, err
+	}
+	_, schema, err := resolveFlightColumns(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var estimate int64 = -1
+	db.QueryRowContext(ctx, `SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass($1)`, qualifiedTable(ctx, q.Table)).Scan(&estimate)
+	if q.Limit > 0 && (estimate < 0 || int64(q.Limit) < estimate) {
+		estimate = int64(q.Limit)
+	}
+
+	ticket, _ := json.Marshal(q)
+	return &flight.FlightInfo{
+		Schema:           flight.SerializeSchema(schema, memory.DefaultAllocator),
+		FlightDescriptor: desc,
+		Endpoint:         []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: ticket}}},
+		TotalRecords:     estimate,
+		TotalBytes:       -1,
+	}, nil
+}
+
+func (s *flightServer) GetSchema(ctx context.Context, desc *flight.FlightDescriptor) (*flight.SchemaResult, error) {
+	ctx, err := flightAuthorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q, err := flightQueryFromDescriptor(desc)
+	if err != nil {
+		return nil, err
+	}
+	_, schema, err := resolveFlightColumns(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &flight.SchemaResult{Schema: flight.SerializeSchema(schema, memory.DefaultAllocator)}, nil
+}
+
+func (s *flightServer) DoGet(tkt *flight.Ticket, fs flight.FlightService_DoGetServer) error {
+	ctx, err := flightAuthorize(fs.Context())
+	if err != nil {
+		return err
+	}
+	q, err := parseFlightQuery(tkt.GetTicket())
+	if err != nil {
+		return err
+	}
+	cols, schema, err := resolveFlightColumns(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	exprs := make([]string, len(cols))
+	for i, c := range cols {
+		exprs[i] = c.Expr
+	}
+	query := fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(exprs, ", "), qualifiedTable(ctx, q.Table))
+	if q.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, q.Limit)
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return status.Errorf(codes.Internal, "query failed: %v", err)
+	}
+	defer rows.Close()
+
+	writer := flight.NewRecordWriter(fs, ipc.WithSchema(schema))
+	defer writer.Close()
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	dest := make([]any, len(cols))
+	for i, c := range cols {
+		switch c.Type.ID() {
+		case arrow.INT64, arrow.INT32, arrow.INT16:
+			dest[i] = new(sql.NullInt64)
+		case arrow.BOOL:
+			dest[i] = new(sql.NullBool)
+		case arrow.FLOAT64, arrow.FLOAT32:
+			dest[i] = new(sql.NullFloat64)
+		default:
+			dest[i] = new(sql.NullString)
+		}
+	}
+
+	flush := func() error {
+		rec := builder.NewRecordBatch()
+		defer rec.Release()
+		return writer.Write(rec)
+	}
+
+	var sent, pending int
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return status.Errorf(codes.Internal, "scan failed: %v", err)
+		}
+		for i := range cols {
+			appendArrowValue(builder.Field(i), dest[i])
+		}
+		pending++
+		if pending == flightBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+			sent += pending
+			pending = 0
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return status.Errorf(codes.Internal, "query failed: %v", err)
+	}
+	if pending > 0 || sent == 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendArrowValue(b array.Builder, v any) {
+	switch v := v.(type) {
+	case *sql.NullInt64:
+		if !v.Valid {
+			b.AppendNull()
+			return
+		}
+		switch b := b.(type) {
+		case *array.Int64Builder:
+			b.Append(v.Int64)
+		case *array.Int32Builder:
+			b.Append(int32(v.Int64))
+		case *array.Int16Builder:
+			b.Append(int16(v.Int64))
+		}
+	case *sql.NullBool:
+		if !v.Valid {
+			b.AppendNull()
+			return
+		}
+		b.(*array.BooleanBuilder).Append(v.Bool)
+	case *sql.NullFloat64:
+		if !v.Valid {
+			b.AppendNull()
+			return
+		}
+		switch b := b.(type) {
+		case *array.Float64Builder:
+			b.Append(v.Float64)
+		case *array.Float32Builder:
+			b.Append(float32(v.Float64))
+		}
+	case *sql.NullString:
+		if !v.Valid {
+			b.AppendNull()
+			return
+		}
+		b.(*array.StringBuilder).Append(v.String)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+type ForceJobRequest struct {
+	Reason string `json:"reason"`
+}
+
+// forceJob loads the job a force-fail or force-complete names, answering 404
+// or 409 itself when it cannot be forced. A job running on this instance is
+// left to abort; one whose heartbeat is fresh and still progressing elsewhere
+// is only refused for force-complete, since force-failing it aborts it.
+func forceJob(w http.ResponseWriter, r *http.Request, complete bool) (jobID, status string, req ForceJobRequest, ok bool) {
+	ctx := r.Context()
+	jobID = r.PathValue("job_id")
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	var live bool
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT status, COALESCE(heartbeat_at > NOW() - make_interval(secs => $2) AND progress_at > heartbeat_at - make_interval(secs => $3), false)
+		FROM {import_history} WHERE job_id = $1
+	`), jobID, jobHeartbeatTimeout.Seconds(), importStallAfter.Seconds()).Scan(&status, &live)
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeImportNotFound, "Import job not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to read import job: "+err.Error())
+		return
+	}
+
+	if _, running := jobProgress.Load(jobID); running {
+		writeProblem(w, http.StatusConflict, errCodeImportInProgress, "Import is running on this instance; abort it instead")
+		return
+	}
+	if complete && live {
+		writeProblem(w, http.StatusConflict, errCodeImportInProgress, "Import is still running on another instance; force-fail or abort it instead")
+		return
+	}
+	return jobID, status, req, true
+}
+
+// settlePreviousNote restores or drops the note_previous a forced job left
+// behind, under the import lock so that a job running now keeps its own.
+func settlePreviousNote(ctx context.Context, jobID string, restore bool) {
+	log := jobLogger(ctx, jobID)
+	lock, err := tryImportLock(ctx)
+	if err != nil || lock == nil {
+		log.Warn("Another import holds the import lock; leaving note_previous as is")
+		return
+	}
+	defer lock.release()
+	if restore {
+		err = restorePreviousNote(ctx)
+	} else {
+		err = dropPreviousNote(ctx)
+	}
+	if err != nil {
+		log.Warn("Failed to settle the previous dataset", "restore", restore, "error", err)
+	}
+	invalidateResponseCache(ctx)
+}
+
+// forceFailImport marks a job that died unnoticed as failed, restoring the
+// dataset it set aside like a failed job would.
+func forceFailImport(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
+	jobID, status, req, ok := forceJob(w, r, false)
+	if !ok {
+		return
+	}
+
+	msg := "Force-failed by admin"
+	if req.Reason != "" {
+		msg += ": " + req.Reason
+	}
+	result, err := db.ExecContext(ctx, expandSQL(ctx, `
+		UPDATE {import_history}
+		SET status = 'failed', error_message = $2, error_code = $3, completed_at = COALESCE(completed_at, NOW()), pause_requested = false
+		WHERE job_id = $1 AND status IN ('downloading', 'importing', 'indexing', 'paused')
+	`), jobID, msg, importErrForceFailed)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to force-fail import: "+err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeProblem(w, http.StatusConflict, errCodeImportNotActive, "Import is "+status+"; only a running or paused import can be force-failed")
+		return
+	}
+
+	by := ""
+	if p, ok := principalFromContext(ctx); ok {
+		by = p.Name
+	}
+	jobLogger(ctx, jobID).Warn("Import force-failed", "previous_status", status, "by", by, "reason", req.Reason)
+	if rollbackOnFailure {
+		settlePreviousNote(ctx, jobID, true)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forceCompleteImport marks a job whose data is in fact loaded as completed,
+// dropping the dataset it set aside like a completed job would.
+func forceCompleteImport(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
+	jobID, status, req, ok := forceJob(w, r, true)
+	if !ok {
+		return
+	}
+
+	result, err := db.ExecContext(ctx, expandSQL(ctx, `
+		UPDATE {import_history}
+		SET status = 'completed', error_message = NULL, error_code = NULL, completed_at = COALESCE(completed_at, NOW()), pause_requested = false
+		WHERE job_id = $1 AND status IN ('downloading', 'importing', 'indexing', 'paused', 'failed')
+	`), jobID)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to force-complete import: "+err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeProblem(w, http.StatusConflict, errCodeImportNotActive, "Import is "+status+"; only a running, paused or failed import can be force-completed")
+		return
+	}
+
+	by := ""
+	if p, ok := principalFromContext(ctx); ok {
+		by = p.Name
+	}
+	jobLogger(ctx, jobID).Warn("Import force-completed", "previous_status", status, "by", by, "reason", req.Reason)
+	settlePreviousNote(ctx, jobID, false)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+var freshnessMaxAge = getEnvDuration("FRESHNESS_MAX_AGE", 48*time.Hour)
+
+const (
+	freshnessFresh = "fresh"
+	freshnessStale = "stale"
+	freshnessEmpty = "empty"
+)
+
+type Freshness struct {
+	Status          string     `json:"status"`
+	DataDate        *string    `json:"data_date,omitempty"`
+	LastImportAt    *time.Time `json:"last_import_at,omitempty"`
+	LastImportJobID *string    `json:"last_import_job_id,omitempty"`
+	AgeSeconds      *float64   `json:"age_seconds,omitempty"`
+	MaxAgeSeconds   float64    `json:"max_age_seconds"`
+}
+
+// getFreshness reports how old the workspace's notes are, measured from the
+// snapshot date of the last successful import, and answers 503 when they are
+// older than FRESHNESS_MAX_AGE or were never imported.
+func getFreshness(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := Freshness{Status: freshnessEmpty, MaxAgeSeconds: freshnessMaxAge.Seconds()}
+
+	var jobID, dataDate sql.NullString
+	var completedAt sql.NullTime
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT job_id, data_date::text, completed_at FROM {import_history}
+		WHERE status IN ('completed', 'completed_with_warnings', 'skipped_unchanged') AND completed_at IS NOT NULL
+		ORDER BY completed_at DESC LIMIT 1
+	`)).Scan(&jobID, &dataDate, &completedAt)
+	if err != nil && err != sql.ErrNoRows {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get freshness: "+err.Error())
+		return
+	}
+
+	status := http.StatusServiceUnavailable
+	if err == nil {
+		f.LastImportJobID = nullStringToStrPtr(jobID)
+		f.DataDate = nullStringToStrPtr(dataDate)
+		f.LastImportAt = nullTimeToTimePtr(completedAt)
+
+		since := completedAt.Time
+		if d, err := time.Parse("2006-01-02", dataDate.String); err == nil {
+			since = d
+		}
+		age := time.Since(since).Seconds()
+		f.AgeSeconds = &age
+
+		f.Status = freshnessStale
+		if time.Since(since) <= freshnessMaxAge {
+			f.Status = freshnessFresh
+			status = http.StatusOK
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(f)
+}
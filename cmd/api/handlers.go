@@ -4,30 +4,53 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
-func isImportAborted(jobID string) bool {
+func isImportAborted(ctx context.Context, jobID string) bool {
 	var status string
-	err := db.QueryRowContext(context.Background(), `SELECT status FROM import_history WHERE job_id = $1`, jobID).Scan(&status)
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT status FROM {import_history} WHERE job_id = $1`), jobID).Scan(&status)
 	if err != nil {
 		return false
 	}
 	return status == "failed"
 }
 
-func getImportCurrent(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+func isPauseRequested(ctx context.Context, jobID string) bool {
+	var requested bool
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT pause_requested FROM {import_history} WHERE job_id = $1`), jobID).Scan(&requested)
+	if err != nil {
+		return false
+	}
+	return requested
+}
 
+const historyColumns = `id, job_id, started_at, completed_at, total_rows, status, error_message, error_code,
+		       download_percentage, download_speed, rows_processed, download_cached, download_duration, import_duration, file_size,
+		       total_files, current_file_index, files_processed, COALESCE(file_list, to_jsonb(string_to_array(NULLIF(file_names, ''), ','))),
+		       indexing_started_at, index_phase, index_blocks_done, index_blocks_total,
+		       pause_requested, paused_at, labels, note, triggered_by, triggered_by_name, schema_version, events_published, notes_embedded, offline, snapshot_fingerprint, load_mode, owner_instance, heartbeat_at, progress_at, file_range, row_limit, pseudonym_key,
+		       parent_job_id::text, (SELECT array_agg(c.job_id::text ORDER BY c.started_at) FROM {import_history} c WHERE c.parent_job_id = {import_history}.job_id),
+		       warnings`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanHistoryEntry(row rowScanner) (HistoryEntry, error) {
 	var h HistoryEntry
 	var completedAt sql.NullTime
 	var totalRows sql.NullInt64
 	var errorMessage sql.NullString
+	var errorCode sql.NullString
 	var downloadPct sql.NullInt64
 	var downloadSpeed sql.NullString
 	var rowsProcessed sql.NullInt64
@@ -38,35 +61,39 @@ func getImportCurrent(w http.ResponseWriter, r *http.Request) {
 	var totalFiles sql.NullInt64
 	var currentFileIndex sql.NullInt64
 	var filesProcessed sql.NullInt64
-	var fileNames sql.NullString
+	var fileNames []byte
 	var indexingStartedAt sql.NullTime
 	var indexPhase sql.NullString
 	var indexBlocksDone sql.NullInt64
 	var indexBlocksTotal sql.NullInt64
-
-	err := db.QueryRowContext(ctx, `
-		SELECT id, job_id, started_at, completed_at, total_rows, status, error_message,
-		       download_percentage, download_speed, rows_processed, download_cached, download_duration, import_duration, file_size,
-		       total_files, current_file_index, files_processed, file_names,
-		       indexing_started_at, index_phase, index_blocks_done, index_blocks_total
-		FROM import_history
-		ORDER BY started_at DESC
-		LIMIT 1
-	`).Scan(&h.ID, &h.JobID, &h.StartedAt, &completedAt, &totalRows, &h.Status, &errorMessage, &downloadPct, &downloadSpeed, &rowsProcessed, &downloadCached, &downloadDuration, &importDuration, &fileSize, &totalFiles, &currentFileIndex, &filesProcessed, &fileNames, &indexingStartedAt, &indexPhase, &indexBlocksDone, &indexBlocksTotal)
-
-	if err == sql.ErrNoRows {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte("null"))
-		return
-	}
+	var pauseRequested sql.NullBool
+	var pausedAt sql.NullTime
+	var note sql.NullString
+	var triggeredBy sql.NullString
+	var triggeredByName sql.NullString
+	var schemaVersion sql.NullString
+	var eventsPublished sql.NullInt64
+	var notesEmbedded sql.NullInt64
+	var offline sql.NullBool
+	var snapshotFingerprint sql.NullString
+	var loadMode sql.NullString
+	var ownerInstance sql.NullString
+	var heartbeatAt sql.NullTime
+	var progressAt sql.NullTime
+	var fileRange sql.NullString
+	var rowLimit sql.NullInt64
+	var pseudonymKey sql.NullString
+	var parentJobID sql.NullString
+
+	err := row.Scan(&h.ID, &h.JobID, &h.StartedAt, &completedAt, &totalRows, &h.Status, &errorMessage, &errorCode, &downloadPct, &downloadSpeed, &rowsProcessed, &downloadCached, &downloadDuration, &importDuration, &fileSize, &totalFiles, &currentFileIndex, &filesProcessed, &fileNames, &indexingStartedAt, &indexPhase, &indexBlocksDone, &indexBlocksTotal, &pauseRequested, &pausedAt, scanArray(&h.Labels), &note, &triggeredBy, &triggeredByName, &schemaVersion, &eventsPublished, &notesEmbedded, &offline, &snapshotFingerprint, &loadMode, &ownerInstance, &heartbeatAt, &progressAt, &fileRange, &rowLimit, &pseudonymKey, &parentJobID, scanArray(&h.RetryJobIDs), scanArray(&h.Warnings))
 	if err != nil {
-		writeProblem(w, http.StatusInternalServerError, "Internal Server Error", "Failed to get import: "+err.Error())
-		return
+		return h, err
 	}
 
 	h.CompletedAt = nullTimeToTimePtr(completedAt)
 	h.TotalRows = nullInt64ToIntPtr(totalRows)
 	h.ErrorMessage = nullStringToStrPtr(errorMessage)
+	h.ErrorCode = nullStringToStrPtr(errorCode)
 	h.DownloadPercentage = nullInt64ToIntPtr(downloadPct)
 	h.DownloadSpeed = nullStringToStrPtr(downloadSpeed)
 	h.RowsProcessed = nullInt64ToIntPtr(rowsProcessed)
@@ -77,22 +104,185 @@ func getImportCurrent(w http.ResponseWriter, r *http.Request) {
 	h.TotalFiles = nullInt64ToIntPtr(totalFiles)
 	h.CurrentFileIndex = nullInt64ToIntPtr(currentFileIndex)
 	h.FilesProcessed = nullInt64ToIntPtr(filesProcessed)
-	h.FileNames = nullStringToStrPtr(fileNames)
+	if fileNames != nil {
+		json.Unmarshal(fileNames, &h.FileNames)
+	}
 	h.IndexingStartedAt = nullTimeToTimePtr(indexingStartedAt)
 	h.IndexPhase = nullStringToStrPtr(indexPhase)
 	h.IndexBlocksDone = nullInt64ToIntPtr(indexBlocksDone)
 	h.IndexBlocksTotal = nullInt64ToIntPtr(indexBlocksTotal)
 
+	h.PauseRequested = pauseRequested.Valid && pauseRequested.Bool
+	h.PausedAt = nullTimeToTimePtr(pausedAt)
+
+	if h.Labels == nil {
+		h.Labels = []string{}
+	}
+	h.Note = nullStringToStrPtr(note)
+	h.TriggeredBy = nullStringToStrPtr(triggeredBy)
+	h.TriggeredByName = nullStringToStrPtr(triggeredByName)
+	h.SchemaVersion = nullStringToStrPtr(schemaVersion)
+	h.EventsPublished = nullInt64ToIntPtr(eventsPublished)
+	h.NotesEmbedded = nullInt64ToIntPtr(notesEmbedded)
+	h.Offline = offline.Valid && offline.Bool
+	h.SnapshotFingerprint = nullStringToStrPtr(snapshotFingerprint)
+	h.Mode = loadModeTruncate
+	if loadMode.Valid {
+		h.Mode = loadMode.String
+	}
+	h.OwnerInstance = nullStringToStrPtr(ownerInstance)
+	h.HeartbeatAt = nullTimeToTimePtr(heartbeatAt)
+	h.ProgressAt = nullTimeToTimePtr(progressAt)
+	h.Stalled = isStalled(&h)
+	h.FileRange = nullStringToStrPtr(fileRange)
+	h.Limit = nullInt64ToIntPtr(rowLimit)
+	h.PseudonymKey = nullStringToStrPtr(pseudonymKey)
+	h.ParentJobID = nullStringToStrPtr(parentJobID)
+
+	return h, nil
+}
+
+func getImportFiles(ctx context.Context, jobID string) ([]ImportFile, error) {
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT file_index, file_name, file_size, status, cached, expected_rows, rows_imported,
+		       download_duration, import_duration, imported_at, copy_attempts, content_hash, bytes_downloaded
+		FROM {import_files}
+		WHERE job_id = $1
+		ORDER BY file_index
+	`), jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []ImportFile
+	for rows.Next() {
+		var f ImportFile
+		var size sql.NullInt64
+		var cached sql.NullBool
+		var expectedRows sql.NullInt64
+		var rowsImported sql.NullInt64
+		var downloadDuration sql.NullInt64
+		var importDuration sql.NullInt64
+		var importedAt sql.NullTime
+		var copyAttempts sql.NullInt64
+		var contentHash sql.NullString
+		var bytesDownloaded sql.NullInt64
+
+		if err := rows.Scan(&f.Index, &f.Name, &size, &f.Status, &cached, &expectedRows, &rowsImported, &downloadDuration, &importDuration, &importedAt, &copyAttempts, &contentHash, &bytesDownloaded); err != nil {
+			return nil, err
+		}
+
+		f.Size = nullInt64ToInt64Ptr(size)
+		f.Cached = nullBoolToBoolPtr(cached)
+		f.ExpectedRows = nullInt64ToIntPtr(expectedRows)
+		f.RowsImported = nullInt64ToIntPtr(rowsImported)
+		f.DownloadDuration = nullInt64ToIntPtr(downloadDuration)
+		f.ImportDuration = nullInt64ToIntPtr(importDuration)
+		f.ImportedAt = nullTimeToTimePtr(importedAt)
+		f.CopyAttempts = nullInt64ToIntPtr(copyAttempts)
+		f.ContentHash = nullStringToStrPtr(contentHash)
+		f.BytesDownloaded = nullInt64ToInt64Ptr(bytesDownloaded)
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+const maxImportWait = 60 * time.Second
+
+// importState identifies what a long-polling client last saw: the job and its
+// status, or "idle". Progress updates within a status don't change it, but
+// stalling does.
+func importState(h *HistoryEntry) string {
+	if h == nil {
+		return "idle"
+	}
+	if h.Stalled {
+		return h.JobID + ":" + h.Status + ":stalled"
+	}
+	return h.JobID + ":" + h.Status
+}
+
+func currentImport(ctx context.Context) (*HistoryEntry, error) {
+	h, err := scanHistoryEntry(db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT `+historyColumns+`
+		FROM {import_history}
+		WHERE status IN ('downloading', 'importing', 'indexing', 'paused')
+		ORDER BY started_at DESC
+		LIMIT 1
+	`)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// getImportCurrent returns the running or paused import, or 204 No Content
+// when the workspace is idle; finished imports are only listed by listImports.
+// With since=<state> it holds the request until the state differs or wait
+// elapses.
+func getImportCurrent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	since := r.URL.Query().Get("since")
+	wait := 30 * time.Second
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "wait must be a duration such as 30s")
+			return
+		}
+		wait = min(d, maxImportWait)
+	}
+	deadline := time.Now().Add(wait)
+
+	var h *HistoryEntry
+	for {
+		var err error
+		h, err = currentImport(ctx)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import: "+err.Error())
+			return
+		}
+		if since == "" || importState(h) != since || !time.Now().Before(deadline) {
+			break
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(min(time.Second, time.Until(deadline))):
+		}
+	}
+
+	w.Header().Set("X-Import-State", importState(h))
+	if h == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var err error
+	h.Files, err = getImportFiles(ctx, h.JobID)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import files: "+err.Error())
+		return
+	}
+
+	computeOverallProgress(h)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(h)
 }
 
 func getImportByID(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	jobID := r.PathValue("job_id")
 
 	if jobID == "" {
-		writeProblem(w, http.StatusBadRequest, "Bad Request", "Job ID is required")
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Job ID is required")
 		return
 	}
 
@@ -101,130 +291,506 @@ func getImportByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var h HistoryEntry
-	var completedAt sql.NullTime
-	var totalRows sql.NullInt64
-	var errorMessage sql.NullString
-	var downloadPct sql.NullInt64
-	var downloadSpeed sql.NullString
-	var rowsProcessed sql.NullInt64
-	var downloadCached sql.NullBool
-	var downloadDuration sql.NullInt64
-	var importDuration sql.NullInt64
-	var fileSize sql.NullInt64
-	var totalFiles sql.NullInt64
-	var currentFileIndex sql.NullInt64
-	var filesProcessed sql.NullInt64
-	var fileNames sql.NullString
-	var indexingStartedAt sql.NullTime
-	var indexPhase sql.NullString
-	var indexBlocksDone sql.NullInt64
-	var indexBlocksTotal sql.NullInt64
-
-	err := db.QueryRowContext(ctx, `
-		SELECT id, job_id, started_at, completed_at, total_rows, status, error_message,
-		       download_percentage, download_speed, rows_processed, download_cached, download_duration, import_duration, file_size,
-		       total_files, current_file_index, files_processed, file_names,
-		       indexing_started_at, index_phase, index_blocks_done, index_blocks_total
-		FROM import_history
+	h, err := scanHistoryEntry(db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT `+historyColumns+`
+		FROM {import_history}
 		WHERE job_id = $1
-	`, jobID).Scan(&h.ID, &h.JobID, &h.StartedAt, &completedAt, &totalRows, &h.Status, &errorMessage, &downloadPct, &downloadSpeed, &rowsProcessed, &downloadCached, &downloadDuration, &importDuration, &fileSize, &totalFiles, &currentFileIndex, &filesProcessed, &fileNames, &indexingStartedAt, &indexPhase, &indexBlocksDone, &indexBlocksTotal)
+	`), jobID))
 
 	if err == sql.ErrNoRows {
-		writeProblem(w, http.StatusNotFound, "Not Found", "Import job not found")
+		writeProblem(w, http.StatusNotFound, errCodeImportNotFound, "Import job not found")
 		return
 	}
 	if err != nil {
-		writeProblem(w, http.StatusInternalServerError, "Internal Server Error", "Failed to get import: "+err.Error())
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import: "+err.Error())
 		return
 	}
 
-	h.CompletedAt = nullTimeToTimePtr(completedAt)
-	h.TotalRows = nullInt64ToIntPtr(totalRows)
-	h.ErrorMessage = nullStringToStrPtr(errorMessage)
-	h.DownloadPercentage = nullInt64ToIntPtr(downloadPct)
-	h.DownloadSpeed = nullStringToStrPtr(downloadSpeed)
-	h.RowsProcessed = nullInt64ToIntPtr(rowsProcessed)
-	h.DownloadCached = nullBoolToBoolPtr(downloadCached)
-	h.DownloadDuration = nullInt64ToIntPtr(downloadDuration)
-	h.ImportDuration = nullInt64ToIntPtr(importDuration)
-	h.FileSize = nullInt64ToInt64Ptr(fileSize)
-	h.TotalFiles = nullInt64ToIntPtr(totalFiles)
-	h.CurrentFileIndex = nullInt64ToIntPtr(currentFileIndex)
-	h.FilesProcessed = nullInt64ToIntPtr(filesProcessed)
-	h.FileNames = nullStringToStrPtr(fileNames)
-	h.IndexingStartedAt = nullTimeToTimePtr(indexingStartedAt)
-	h.IndexPhase = nullStringToStrPtr(indexPhase)
-	h.IndexBlocksDone = nullInt64ToIntPtr(indexBlocksDone)
-	h.IndexBlocksTotal = nullInt64ToIntPtr(indexBlocksTotal)
+	h.Files, err = getImportFiles(ctx, h.JobID)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import files: "+err.Error())
+		return
+	}
+
+	computeOverallProgress(&h)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(h)
 }
 
+var importStatuses = []string{"importing", "completed", "failed", "idle", "downloading", "indexing", "skipped", "paused", "skipped_unchanged", "completed_with_warnings"}
+
+var importSortColumns = map[string]string{
+	"started_at":   "started_at",
+	"completed_at": "completed_at",
+	"data_date":    "data_date",
+	"status":       "status",
+	"total_rows":   "total_rows",
+}
+
+func parseFilterTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func listImports(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > 500 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = l
+	}
+
+	offset := 0
+	if v := q.Get("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "offset must be a non-negative integer")
+			return
+		}
+		offset = o
+	}
+
+	sortParam := q.Get("sort")
+	if sortParam == "" {
+		sortParam = "-started_at"
+	}
+	desc := strings.HasPrefix(sortParam, "-")
+	sortColumn, ok := importSortColumns[strings.TrimPrefix(sortParam, "-")]
+	if !ok {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "sort must be one of started_at, completed_at, data_date, status, total_rows (prefix with - for descending)")
+		return
+	}
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+
+	var where []string
+	var args []any
+
+	if v := q.Get("status"); v != "" {
+		statuses := strings.Split(v, ",")
+		for _, s := range statuses {
+			if !slices.Contains(importStatuses, s) {
+				writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Unknown status: "+s)
+				return
+			}
+		}
+		args = append(args, statuses)
+		where = append(where, fmt.Sprintf("status = ANY($%d)", len(args)))
+	}
+
+	if v := q.Get("error_code"); v != "" {
+		args = append(args, strings.Split(v, ","))
+		where = append(where, fmt.Sprintf("error_code = ANY($%d)", len(args)))
+	}
+
+	if labels := q["label"]; len(labels) > 0 {
+		args = append(args, labels)
+		where = append(where, fmt.Sprintf("labels @> $%d", len(args)))
+	}
+
+	if v := q.Get("triggered_by"); v != "" {
+		if !validTrigger(v) {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "triggered_by must be one of "+strings.Join(importTriggers, ", "))
+			return
+		}
+		args = append(args, v)
+		where = append(where, fmt.Sprintf("triggered_by = $%d", len(args)))
+	}
+
+	if v := q.Get("from"); v != "" {
+		from, err := parseFilterTime(v, false)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "from must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+			return
+		}
+		args = append(args, from)
+		where = append(where, fmt.Sprintf("started_at >= $%d", len(args)))
+	}
+
+	if v := q.Get("to"); v != "" {
+		to, err := parseFilterTime(v, true)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "to must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+			return
+		}
+		args = append(args, to)
+		where = append(where, fmt.Sprintf("started_at < $%d", len(args)))
+	}
+
+	filterClause := ""
+	if len(where) > 0 {
+		filterClause = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COUNT(*) FROM {import_history} `)+filterClause, args...).Scan(&total); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to count imports: "+err.Error())
+		return
+	}
+
+	cursor, before := q.Get("cursor"), q.Get("before")
+	if cursor != "" || before != "" {
+		if sortColumn != "started_at" || offset != 0 || cursor != "" && before != "" {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "cursor and before are exclusive and require sort=started_at or sort=-started_at and no offset")
+			return
+		}
+		cursorID, err := strconv.Atoi(cursor + before)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid cursor")
+			return
+		}
+		op := ">"
+		if desc != (before != "") {
+			op = "<"
+		}
+		args = append(args, cursorID)
+		where = append(where, fmt.Sprintf("(started_at, id) %s (SELECT started_at, id FROM %s WHERE id = $%d)", op, qualifiedTable(ctx, "import_history"), len(args)))
+	}
+
+	pageClause := ""
+	if len(where) > 0 {
+		pageClause = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	// A before page is read backwards from the cursor and flipped back below.
+	scan := direction
+	if before != "" {
+		scan = "DESC"
+		if desc {
+			scan = "ASC"
+		}
+	}
+	args = append(args, limit+1, offset)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(expandSQL(ctx, `
+		SELECT `+historyColumns+`
+		FROM {import_history}
+		%s
+		ORDER BY %s %s NULLS LAST, id %s
+		LIMIT $%d OFFSET $%d
+	`), pageClause, sortColumn, scan, scan, len(args)-1, len(args)), args...)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list imports: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		h, err := scanHistoryEntry(rows)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list imports: "+err.Error())
+			return
+		}
+		entries = append(entries, h)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list imports: "+err.Error())
+		return
+	}
+	more := len(entries) > limit
+	if more {
+		entries = entries[:limit]
+	}
+	if before != "" {
+		slices.Reverse(entries)
+	}
+
+	page := &listPage{Total: total}
+	pageQuery := func(set map[string]string) url.Values {
+		pq := r.URL.Query()
+		for _, k := range []string{"cursor", "before", "offset"} {
+			pq.Del(k)
+		}
+		for k, v := range set {
+			pq.Set(k, v)
+		}
+		return pq
+	}
+	hasNext, hasPrev := more, offset > 0 || cursor != ""
+	if before != "" {
+		hasNext, hasPrev = true, more
+	}
+	if len(entries) > 0 && sortColumn == "started_at" {
+		if hasNext {
+			page.NextCursor = strconv.Itoa(entries[len(entries)-1].ID)
+			page.Next = pageQuery(map[string]string{"cursor": page.NextCursor})
+		}
+		if hasPrev && offset == 0 {
+			page.PrevCursor = strconv.Itoa(entries[0].ID)
+			page.Prev = pageQuery(map[string]string{"before": page.PrevCursor})
+		}
+	} else if sortColumn != "started_at" && hasNext {
+		page.Next = pageQuery(map[string]string{"offset": strconv.Itoa(offset + limit)})
+	}
+	if offset > 0 {
+		page.Prev = pageQuery(map[string]string{"offset": strconv.Itoa(max(offset-limit, 0))})
+	}
+
+	writeListPage(w, r, entries, nil, page)
+}
+
 func abortImport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
-		writeProblem(w, http.StatusMethodNotAllowed, "Method Not Allowed", "POST or DELETE method required")
+		writeProblem(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "POST or DELETE method required")
 		return
 	}
 
-	ctx := context.Background()
+	ctx := context.WithoutCancel(r.Context())
 	jobID := r.PathValue("job_id")
 
 	if jobID == "" {
-		writeProblem(w, http.StatusBadRequest, "Bad Request", "Job ID is required")
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Job ID is required")
 		return
 	}
 
-	result, err := db.ExecContext(ctx, `
-		UPDATE import_history 
-		SET status = 'failed', error_message = 'Aborted by user', completed_at = NOW() 
-		WHERE job_id = $1 AND status IN ('importing', 'downloading')
-	`, jobID)
+	result, err := db.ExecContext(ctx, expandSQL(ctx, `
+		UPDATE {import_history} 
+		SET status = 'failed', error_message = 'Aborted by user', error_code = 'cancelled', completed_at = NOW() 
+		WHERE job_id = $1 AND status IN ('importing', 'downloading', 'paused')
+	`), jobID)
 	if err != nil {
-		writeProblem(w, http.StatusInternalServerError, "Internal Server Error", "Failed to abort import: "+err.Error())
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to abort import: "+err.Error())
 		return
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		writeProblem(w, http.StatusNotFound, "Not Found", "No active import job found with that ID")
+		writeProblem(w, http.StatusNotFound, errCodeImportNotActive, "No active import job found with that ID")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func validTrigger(s string) bool {
+	return slices.Contains(importTriggers, s)
+}
+
+func validateCreateImportRequest(req CreateImportRequest) []FieldError {
+	var errs []FieldError
+	add := func(field, detail string) {
+		errs = append(errs, FieldError{Field: field, Detail: detail})
+	}
+
+	if req.Limit < 0 {
+		add("limit", "must be a positive number of rows per file")
+	}
+	if _, err := parseFileRange(req.Files); err != nil {
+		add("files", "must be file indexes or ranges such as 0-3 or 0,2,5-7: "+err.Error())
+	}
+	if req.Date != "" {
+		if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+			add("date", "must be formatted as YYYY-MM-DD")
+		} else if !req.Offline {
+			add("date", "is only supported with offline imports")
+		}
+	}
+	for i, d := range req.Datasets {
+		if d != "notes" {
+			add(fmt.Sprintf("datasets[%d]", i), "unsupported dataset "+strconv.Quote(d)+"; only notes is imported")
+		}
+	}
+	if req.Mode != "" && req.Mode != loadModeTruncate && req.Mode != loadModeUpsert {
+		add("mode", "must be one of truncate, upsert")
+	}
+	if req.Concurrency < 0 || req.Concurrency > maxDownloadConcurrency {
+		add("concurrency", fmt.Sprintf("must be between 1 and %d, or 0 for the default of 1", maxDownloadConcurrency))
+	}
+	if len(req.Labels) > 20 {
+		add("labels", "at most 20 labels are allowed")
+	}
+	for i, l := range req.Labels {
+		if l == "" || len(l) > 64 {
+			add(fmt.Sprintf("labels[%d]", i), "must be between 1 and 64 characters")
+		}
+	}
+	if len(req.Note) > 1000 {
+		add("note", "must be at most 1000 characters")
+	}
+	if req.TriggeredBy != "" && !validTrigger(req.TriggeredBy) {
+		add("triggered_by", "must be one of "+strings.Join(importTriggers, ", "))
+	}
+	return errs
+}
+
+// decodeCreateImportRequest rejects unknown fields and reports type mismatches
+// against the offending field so typos don't silently fall back to defaults.
+func decodeCreateImportRequest(r *http.Request) (CreateImportRequest, []FieldError, error) {
+	var req CreateImportRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&req)
+	if err == nil || err == io.EOF {
+		return req, nil, nil
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return req, []FieldError{{Field: typeErr.Field, Detail: "must be of type " + typeErr.Type.String()}}, nil
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return req, []FieldError{{Field: strings.Trim(field, `"`), Detail: "unknown field"}}, nil
+	}
+	return req, nil, err
+}
+
+// planImport resolves the snapshot date and the files an import would load,
+// without downloading anything, for dry runs.
+func planImport(ctx context.Context, req CreateImportRequest) (ImportPlan, error) {
+	plan := ImportPlan{DryRun: true, Datasets: req.Datasets, Mode: req.Mode, Offline: req.Offline, Limit: req.Limit, FileRange: req.Files, Concurrency: req.Concurrency, Files: []string{}}
+	dir := workspaceFromContext(ctx).dataDir()
+	sel, _ := parseFileRange(req.Files)
+
+	var err error
+	switch {
+	case req.Offline && req.Date != "":
+		plan.DataDate = req.Date
+	case req.Offline:
+		plan.DataDate, err = latestLocalDate(dir)
+	default:
+		plan.DataDate, err = findLatestDate(ctx, 7)
+	}
+	if err != nil {
+		return plan, err
+	}
+
+	if req.Offline {
+		for k := 0; sel == nil || k < len(sel); k++ {
+			i := k
+			if sel != nil {
+				i = sel[k]
+			}
+			name, ok := localNoteFile(dir, plan.DataDate, i)
+			if !ok && sel != nil {
+				return plan, fmt.Errorf("no local file %d for date %s", i, plan.DataDate)
+			}
+			if !ok {
+				break
+			}
+			plan.Files = append(plan.Files, name)
+		}
+		if len(plan.Files) == 0 {
+			return plan, fmt.Errorf("no local files found for date %s", plan.DataDate)
+		}
+		return plan, nil
+	}
+
+	available := discoverFiles(ctx, plan.DataDate)
+	if len(available) == 0 {
+		return plan, fmt.Errorf("no files found for date %s", plan.DataDate)
+	}
+	indexes, err := selectFileIndexes(len(available), sel)
+	if err != nil {
+		return plan, err
+	}
+	for _, i := range indexes {
+		plan.Files = append(plan.Files, fmt.Sprintf("%s-notes-%05d.zip", plan.DataDate, i))
+	}
+	return plan, nil
+}
+
 func createImport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		writeProblem(w, http.StatusMethodNotAllowed, "Method Not Allowed", "POST method required")
+		writeProblem(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "POST method required")
 		return
 	}
 
-	ctx := context.Background()
+	ctx := context.WithoutCancel(r.Context())
+
+	req, fieldErrs, err := decodeCreateImportRequest(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	if fieldErrs == nil {
+		fieldErrs = validateCreateImportRequest(req)
+	}
+	if len(fieldErrs) > 0 {
+		writeFieldProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid import request", fieldErrs)
+		return
+	}
+	if len(req.Datasets) == 0 {
+		req.Datasets = []string{"notes"}
+	}
+	if req.Mode == "" {
+		req.Mode = loadModeTruncate
+	}
+	if req.Concurrency == 0 {
+		req.Concurrency = 1
+	}
+
+	if req.DryRun {
+		plan, err := planImport(ctx, req)
+		if err != nil {
+			writeProblem(w, http.StatusUnprocessableEntity, errCodeSnapshotNotFound, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(plan)
+		return
+	}
+
+	if !requirePreflight(w, ctx) {
+		return
+	}
+	lock := lockImports(w, ctx)
+	if lock == nil {
+		return
+	}
+	defer func() { lock.release() }()
 
 	var active int
-	db.QueryRowContext(ctx, `SELECT COUNT(*) FROM import_history WHERE status IN ('importing', 'downloading')`).Scan(&active)
+	db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COUNT(*) FROM {import_history} WHERE status IN ('importing', 'downloading', 'indexing', 'paused')`)).Scan(&active)
 	if active > 0 {
-		writeProblem(w, http.StatusConflict, "Conflict", "Import already in progress")
+		writeProblem(w, http.StatusConflict, errCodeImportInProgress, "Import already in progress or paused")
 		return
 	}
 
-	limit := 0
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+	var triggeredByName *string
+	if p, ok := principalFromContext(r.Context()); ok {
+		triggeredByName = &p.Name
+		if req.TriggeredBy == "" {
+			req.TriggeredBy = p.Kind
 		}
 	}
+	if req.TriggeredBy == "" {
+		req.TriggeredBy = triggerUser
+	}
+
+	var note *string
+	if req.Note != "" {
+		note = &req.Note
+	}
+	if req.Labels == nil {
+		req.Labels = []string{}
+	}
 
 	var jobID string
-	err := db.QueryRowContext(ctx, `
-		INSERT INTO import_history (started_at, status, download_percentage, rows_processed)
-		VALUES (NOW(), 'downloading', 0, 0)
+	err = db.QueryRowContext(ctx, expandSQL(ctx, `
+		INSERT INTO {import_history} (started_at, status, download_percentage, rows_processed, labels, note, triggered_by, triggered_by_name, load_mode, owner_instance, heartbeat_at)
+		VALUES (NOW(), 'downloading', 0, 0, $1, $2, $3, $4, $5, $6, NOW())
 		RETURNING job_id
-	`).Scan(&jobID)
+	`), req.Labels, note, req.TriggeredBy, triggeredByName, req.Mode, instanceID).Scan(&jobID)
 	if err != nil {
-		writeProblem(w, http.StatusInternalServerError, "Internal Server Error", "Failed to create import job: "+err.Error())
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to create import job: "+err.Error())
 		return
 	}
 
@@ -232,194 +798,179 @@ func createImport(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Import started", "job_id": jobID})
 
-	go func(limit int) {
-		ctx := context.Background()
+	go runImport(jobID, importOptions{
+		limit:       req.Limit,
+		files:       req.Files,
+		offline:     req.Offline,
+		force:       req.Force,
+		date:        req.Date,
+		mode:        req.Mode,
+		concurrency: req.Concurrency,
+		requestID:   requestIDFromContext(r.Context()),
+		workspace:   workspaceFromContext(ctx),
+		lock:        lock,
+	})
+	lock = nil
+}
 
-		if isImportAborted(jobID) {
-			logger.Info("Import aborted before start", "job_id", jobID)
-			return
-		}
+func pauseImport(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
 
-		files, err := downloadNotesWithProgress(ctx, 7, jobID)
-		if err != nil {
-			setImportFailed(jobID, err.Error())
-			return
-		}
+	var jobID string
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		UPDATE {import_history} SET pause_requested = true
+		WHERE job_id = (
+			SELECT job_id FROM {import_history}
+			WHERE status IN ('importing', 'downloading')
+			ORDER BY started_at DESC LIMIT 1
+		)
+		RETURNING job_id
+	`)).Scan(&jobID)
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusConflict, errCodeImportNotPausable, "No import in a pausable phase (downloading or importing)")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to pause import: "+err.Error())
+		return
+	}
 
-		if len(files) > 0 {
-			date := strings.Split(files[0].FileName, "-notes-")[0]
-			cleanupOldFiles(date)
-		}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Import will pause at the next file boundary", "job_id": jobID})
+}
 
-		if isImportAborted(jobID) {
-			setImportFailed(jobID, "Aborted by user")
-			return
-		}
+func resumeImport(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
 
-		if limit > 0 {
-			for _, f := range files {
-				logger.Info("Truncating file", "path", f.TSVPath, "limit", limit)
-				if err := truncateTSV(f.TSVPath, limit); err != nil {
-					logger.Warn("Failed to truncate file", "path", f.TSVPath, "error", err)
-				}
-			}
-		}
+	if !requirePreflight(w, ctx) {
+		return
+	}
+	lock := lockImports(w, ctx)
+	if lock == nil {
+		return
+	}
+	defer func() { lock.release() }()
 
-		totalFiles := len(files)
-		var totalRows int // Will hold the final count
-		var expectedTotalRows int
-		var totalSize int64
+	var jobID string
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		UPDATE {import_history} SET status = 'downloading', pause_requested = false, paused_at = NULL, owner_instance = $1, heartbeat_at = NOW()
+		WHERE job_id = (
+			SELECT job_id FROM {import_history}
+			WHERE status = 'paused'
+			ORDER BY started_at DESC LIMIT 1
+		)
+		RETURNING job_id
+	`), instanceID).Scan(&jobID)
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusConflict, errCodeImportNotPaused, "No paused import to resume")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to resume import: "+err.Error())
+		return
+	}
 
-		for _, f := range files {
-			totalSize += f.FileSize
-			if lines, err := countTSVRows(f.TSVPath); err == nil {
-				expectedTotalRows += lines
-			}
-		}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Import resumed", "job_id": jobID})
 
-		var fileNames []string
-		for _, f := range files {
-			fileNames = append(fileNames, f.FileName)
-		}
-		fileNamesStr := strings.Join(fileNames, ",")
+	go runImport(jobID, importOptions{resume: true, requestID: requestIDFromContext(r.Context()), workspace: workspaceFromContext(ctx), lock: lock})
+	lock = nil
+}
 
-		db.ExecContext(ctx, `UPDATE import_history SET status = 'importing', download_percentage = 100, total_rows = $1, file_size = $2, import_started_at = NOW(), files_processed = 0, file_names = $3 WHERE job_id = $4`, expectedTotalRows, totalSize, fileNamesStr, jobID)
+func getImportLogs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	jobID := r.PathValue("job_id")
 
-		if isImportAborted(jobID) {
-			setImportFailed(jobID, "Aborted by user")
-			return
-		}
+	var status string
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT status FROM {import_history} WHERE job_id = $1`), jobID).Scan(&status)
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeImportNotFound, "Import job not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import: "+err.Error())
+		return
+	}
 
-		_, err = db.ExecContext(ctx, `DROP INDEX IF EXISTS ts_idx, idx3yl33mmhbcw582lic7c7fqqu4, idxovqwtw36x36lo9smq4lbxjcps, idxu0f5st3d4b4c55eh9kqyd3yk`)
-		if err != nil {
-			setImportFailed(jobID, "failed to drop indexes: "+err.Error())
-			return
+	tail := 0
+	if tailStr := r.URL.Query().Get("tail"); tailStr != "" {
+		if t, err := strconv.Atoi(tailStr); err == nil && t > 0 {
+			tail = t
 		}
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	query := expandSQL(ctx, `SELECT id, logged_at, level, message, attrs FROM {import_logs} WHERE job_id = $1 AND id > $2 ORDER BY id`)
+	args := []any{jobID, 0}
+	if tail > 0 {
+		query = expandSQL(ctx, `SELECT * FROM (
+			SELECT id, logged_at, level, message, attrs FROM {import_logs} WHERE job_id = $1 AND id > $2 ORDER BY id DESC LIMIT $3
+		) t ORDER BY id`)
+		args = append(args, tail)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Accel-Buffering", "no")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
 
-		_, err = db.ExecContext(ctx, `TRUNCATE note`)
+	var lastID int64
+	for first := true; ; first = false {
+		args[1] = lastID
+		n, err := writeJobLogs(ctx, enc, query, args, &lastID)
 		if err != nil {
-			setImportFailed(jobID, "failed to truncate table: "+err.Error())
+			if first {
+				writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to read logs: "+err.Error())
+			}
 			return
 		}
+		if flusher != nil {
+			flusher.Flush()
+		}
 
-		done := make(chan struct{})
-
-		var cumulativeRows int
-		var mu sync.Mutex
-
-		go func() {
-			for {
-				select {
-				case <-done:
-					return
-				case <-time.After(500 * time.Millisecond):
-					var tuplesProcessed int
-					err := db.QueryRowContext(context.Background(), `SELECT COALESCE(tuples_processed, 0) FROM pg_stat_progress_copy LIMIT 1`).Scan(&tuplesProcessed)
-					if err == nil {
-						mu.Lock()
-						currentTotal := cumulativeRows + tuplesProcessed
-						mu.Unlock()
-						db.ExecContext(context.Background(), `UPDATE import_history SET rows_processed = $1, import_duration = EXTRACT(EPOCH FROM (NOW() - import_started_at))::INTEGER WHERE job_id = $2`, currentTotal, jobID)
-					}
-				}
-			}
-		}()
-
-		if _, err = db.ExecContext(ctx, `SET synchronous_commit = off`); err != nil {
-			close(done)
-			setImportFailed(jobID, "failed to set synchronous_commit: "+err.Error())
+		if !follow {
 			return
 		}
-
-		for i, f := range files {
-			if isImportAborted(jobID) {
-				close(done)
-				setImportFailed(jobID, "Aborted by user")
-				return
-			}
-
-			db.ExecContext(ctx, `UPDATE import_history SET current_file_index = $1 WHERE job_id = $2`, i, jobID)
-
-			res, err := db.ExecContext(ctx, fmt.Sprintf(`COPY note FROM '%s' WITH (FORMAT csv, DELIMITER E'\t', HEADER true)`, f.TSVPath))
-			if err != nil {
-				close(done)
-				setImportFailed(jobID, "failed to import "+f.FileName+": "+err.Error())
-				return
-			}
-
-			rowsAffected, _ := res.RowsAffected()
-			logger.Info("COPY command output", "file", f.FileName, "rows_affected", rowsAffected)
-
-			mu.Lock()
-			cumulativeRows += int(rowsAffected)
-			totalRows = cumulativeRows
-			mu.Unlock()
-
-			db.ExecContext(ctx, `UPDATE import_history SET files_processed = $1 WHERE job_id = $2`, i+1, jobID)
-			logger.Info("File imported", "file", f.FileName, "current", i+1, "total", totalFiles)
-		}
-
-		close(done)
-
-		db.ExecContext(ctx, `SET synchronous_commit = on`)
-
-		go db.ExecContext(context.Background(), `UPDATE import_history SET status = 'indexing', indexing_started_at = NOW() WHERE job_id = $1`, jobID)
-
-		indexDone := make(chan struct{})
-		go func() {
-			for {
-				select {
-				case <-indexDone:
-					return
-				case <-time.After(2 * time.Second):
-					var phase string
-					var blocksDone, blocksTotal int
-					err := db.QueryRowContext(context.Background(), `
-						SELECT COALESCE(phase,''), COALESCE(blocks_done,0), COALESCE(blocks_total,0)
-						FROM pg_stat_progress_create_index LIMIT 1`).Scan(&phase, &blocksDone, &blocksTotal)
-					if err == nil {
-						db.ExecContext(context.Background(), `
-							UPDATE import_history SET index_phase = $1, index_blocks_done = $2, index_blocks_total = $3
-							WHERE job_id = $4`, phase, blocksDone, blocksTotal, jobID)
-					}
-				}
-			}
-		}()
-
-		for _, idxSQL := range []string{
-			`CREATE INDEX idx3yl33mmhbcw582lic7c7fqqu4 ON note USING btree (createdatmillis)`,
-			`CREATE INDEX idxovqwtw36x36lo9smq4lbxjcps ON note USING btree (noteauthorparticipantid)`,
-			`CREATE INDEX idxu0f5st3d4b4c55eh9kqyd3yk ON note USING btree (tweetid)`,
-			`CREATE INDEX ts_idx ON note USING gin (summary_ts)`,
-		} {
-			if _, err := db.ExecContext(ctx, idxSQL); err != nil {
-				close(indexDone)
-				setImportFailed(jobID, "failed to rebuild index: "+err.Error())
+		if n == 0 {
+			db.QueryRowContext(ctx, expandSQL(ctx, `SELECT status FROM {import_history} WHERE job_id = $1`), jobID).Scan(&status)
+			if status != "downloading" && status != "importing" && status != "indexing" {
 				return
 			}
 		}
 
-		close(indexDone)
-
-		var importDuration int
-		err = db.QueryRowContext(ctx, `SELECT EXTRACT(EPOCH FROM (NOW() - import_started_at))::INTEGER FROM import_history WHERE job_id = $1`, jobID).Scan(&importDuration)
-		if err != nil {
-			importDuration = 0
-		}
+		query = expandSQL(ctx, `SELECT id, logged_at, level, message, attrs FROM {import_logs} WHERE job_id = $1 AND id > $2 ORDER BY id`)
+		args = args[:2]
 
-		var dataDate string
-		if len(files) > 0 {
-			dataDate = strings.Split(files[0].FileName, "-notes-")[0]
-		}
-
-		_, err = db.ExecContext(ctx, `UPDATE import_history SET status = 'completed', total_rows = $1, completed_at = NOW(), import_duration = $2, data_date = $4 WHERE job_id = $3`, totalRows, importDuration, jobID, dataDate)
-		if err != nil {
-			setImportFailed(jobID, "failed to mark import completed: "+err.Error())
+		select {
+		case <-ctx.Done():
 			return
+		case <-time.After(time.Second):
 		}
+	}
+}
 
-		logger.Info("Import completed", "rows", totalRows, "files", totalFiles)
-	}(limit)
+func writeJobLogs(ctx context.Context, enc *json.Encoder, query string, args []any, lastID *int64) (int, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var e JobLogEntry
+		var attrs []byte
+		if err := rows.Scan(&e.ID, &e.Time, &e.Level, &e.Message, &attrs); err != nil {
+			return n, err
+		}
+		json.Unmarshal(attrs, &e.Attrs)
+		enc.Encode(e)
+		*lastID = e.ID
+		n++
+	}
+	return n, rows.Err()
 }
 
 func healthCheck(w http.ResponseWriter, r *http.Request) {
@@ -428,14 +979,11 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func getLatestAvailableDate(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	for i := 0; i < 7; i++ {
 		date := getDateDaysAgo(i)
-		url := fmt.Sprintf("https://ton.twimg.com/birdwatch-public-data/%s/notes/notes-00000.zip",
-			formatDateForURL(date))
-
-		req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+		req, err := newSnapshotRequest(ctx, "HEAD", snapshotURL(date, 0))
 		if err != nil {
 			continue
 		}
@@ -459,14 +1007,14 @@ func getLatestAvailableDate(w http.ResponseWriter, r *http.Request) {
 }
 
 func getLastImportDate(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	var dataDate string
-	err := db.QueryRowContext(ctx, `
-		SELECT data_date::text FROM import_history
-		WHERE status = 'completed' AND data_date IS NOT NULL
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT data_date::text FROM {import_history}
+		WHERE status IN ('completed', 'completed_with_warnings', 'skipped_unchanged') AND data_date IS NOT NULL
 		ORDER BY completed_at DESC LIMIT 1
-	`).Scan(&dataDate)
+	`)).Scan(&dataDate)
 
 	if err == sql.ErrNoRows {
 		w.Header().Set("Content-Type", "application/json")
@@ -475,7 +1023,7 @@ func getLastImportDate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err != nil {
-		writeProblem(w, http.StatusInternalServerError, "Internal Server Error", "Failed to query: "+err.Error())
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to query: "+err.Error())
 		return
 	}
 
@@ -484,23 +1032,28 @@ func getLastImportDate(w http.ResponseWriter, r *http.Request) {
 }
 
 func getSchedulerStatus(w http.ResponseWriter, r *http.Request) {
-	scheduler.mu.RLock()
-	defer scheduler.mu.RUnlock()
+	ctx := r.Context()
+	ws := workspaceFromContext(ctx)
+	ws.scheduler.mu.RLock()
+	defer ws.scheduler.mu.RUnlock()
 
-	ctx := context.Background()
 	var lastDataDate string
-	db.QueryRowContext(ctx, `
-		SELECT data_date::text FROM import_history
-		WHERE status = 'completed' AND data_date IS NOT NULL
+	db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT data_date::text FROM {import_history}
+		WHERE status IN ('completed', 'completed_with_warnings', 'skipped_unchanged') AND data_date IS NOT NULL
 		ORDER BY completed_at DESC LIMIT 1
-	`).Scan(&lastDataDate)
+	`)).Scan(&lastDataDate)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"enabled":        autoImportEnabled,
-		"interval":       autoImportInterval.String(),
-		"last_check":     scheduler.lastCheck,
-		"next_run":       scheduler.nextRun,
+		"interval":       ws.Interval.String(),
+		"last_check":     ws.scheduler.lastCheck,
+		"next_run":       ws.scheduler.nextRun,
 		"last_data_date": lastDataDate,
+		"poll_enabled":   upstreamPollEnabled,
+		"poll_interval":  upstreamPollInterval.String(),
+		"last_poll":      ws.scheduler.lastPoll,
+		"next_poll":      ws.scheduler.nextPoll,
 	})
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	instanceID           = getEnv("INSTANCE_ID", defaultInstanceID())
+	jobHeartbeatInterval = getEnvDuration("JOB_HEARTBEAT_INTERVAL", 15*time.Second)
+	jobHeartbeatTimeout  = getEnvDuration("JOB_HEARTBEAT_TIMEOUT", 2*time.Minute)
+	autoResumeImports    = getEnvBool("AUTO_RESUME_IMPORTS", true)
+	importMaxRuntime     = getEnvDuration("IMPORT_MAX_RUNTIME", 6*time.Hour)
+	importStallAfter     = getEnvDuration("IMPORT_STALL_AFTER", 5*time.Minute)
+)
+
+// jobProgress holds, for each job running here, when it last made progress
+// (unix nanoseconds); the heartbeat carries it to progress_at.
+var jobProgress sync.Map
+
+// touchJobProgress records that jobID downloaded bytes, copied rows, finished
+// a file or built index blocks.
+func touchJobProgress(jobID string) {
+	if p, ok := jobProgress.Load(jobID); ok {
+		p.(*atomic.Int64).Store(time.Now().UnixNano())
+	}
+}
+
+// isStalled reports whether a running job's heartbeat has kept coming for
+// IMPORT_STALL_AFTER without it making progress, measured on the database
+// clock of both timestamps.
+func isStalled(h *HistoryEntry) bool {
+	switch h.Status {
+	case "downloading", "importing", "indexing":
+	default:
+		return false
+	}
+	return h.HeartbeatAt != nil && h.ProgressAt != nil && h.HeartbeatAt.Sub(*h.ProgressAt) >= importStallAfter
+}
+
+// defaultInstanceID is the hostname, which stays the same when a container
+// restarts, so a restarted replica reclaims its own jobs immediately instead
+// of waiting for their heartbeat to go stale.
+func defaultInstanceID() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startJobHeartbeat claims jobID for this instance and keeps its heartbeat
+// fresh, along with when it last made progress, until the returned stop
+// function is called.
+func startJobHeartbeat(ctx context.Context, jobID string) (stop func()) {
+	progress := new(atomic.Int64)
+	progress.Store(time.Now().UnixNano())
+	jobProgress.Store(jobID, progress)
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET owner_instance = $1, heartbeat_at = NOW(), progress_at = NOW() WHERE job_id = $2`), instanceID, jobID)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				idle := time.Since(time.Unix(0, progress.Load())).Seconds()
+				if _, err := db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET heartbeat_at = NOW(), progress_at = NOW() - make_interval(secs => $3) WHERE job_id = $1 AND owner_instance = $2`), jobID, instanceID, idle); err != nil {
+					jobLogger(ctx, jobID).Warn("Failed to record job heartbeat", "error", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		jobProgress.Delete(jobID)
+	}
+}
+
+// withImportDeadline bounds one run of an import by IMPORT_MAX_RUNTIME, or not
+// at all when it is 0; a resumed job starts with a fresh allowance.
+func withImportDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if importMaxRuntime <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, importMaxRuntime)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// The importer reaches upstream, the archive format and the database through
+// these interfaces; runImport uses the package-level implementations below,
+// which tests replace with the in-memory fakes in fakes_test.go.
+var (
+	snapshotFetcher   Fetcher   = httpFetcher{}
+	snapshotExtractor Extractor = cacheExtractor{}
+	noteLoader        Loader    = copyLoader{}
+)
+
+// jobStatus takes the download phase's progress writes to import_history and
+// import_files. It is db once connected; tests record the writes instead.
+var jobStatus execer
+
+var errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// Fetcher lists and downloads snapshot files.
+type Fetcher interface {
+	// LatestDate returns the newest date within lookbackDays that has a
+	// snapshot.
+	LatestDate(ctx context.Context, lookbackDays int) (string, error)
+	// Discover returns the sizes of date's files, in index order.
+	Discover(ctx context.Context, date string) []int64
+	// Fetch opens file index of date from offset, returning the whole file's
+	// size and the offset the body actually starts at (0 when the source
+	// restarted from the beginning). It fails with errRangeNotSatisfiable when
+	// offset does not fit the file.
+	Fetch(ctx context.Context, date string, index int, offset int64) (io.ReadCloser, int64, int64, error)
+}
+
+// Extractor turns a cached snapshot file into the TSV to load.
+type Extractor interface {
+	Extract(zipPath string, index int) (string, error)
+}
+
+// Loader loads one TSV, or its first limit rows when limit > 0, into table
+// and returns the rows loaded.
+type Loader interface {
+	CopyFile(ctx context.Context, ex execer, session *loadSession, table string, plan columnPlan, columnTypes map[string]string, path string, limit int, upsert bool) (int64, error)
+}
+
+type httpFetcher struct{}
+
+func (httpFetcher) LatestDate(ctx context.Context, lookbackDays int) (string, error) {
+	for i := 0; i < lookbackDays; i++ {
+		date := getDateDaysAgo(i)
+		req, err := newSnapshotRequest(ctx, "GET", snapshotURL(date, 0))
+		if err != nil {
+			continue
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			return date, nil
+		}
+	}
+
+	return "", fmt.Errorf("no data files found in the last %d days", lookbackDays)
+}
+
+func (httpFetcher) Discover(ctx context.Context, date string) []int64 {
+	var sizes []int64
+	for i := 0; i < 100; i++ {
+		req, err := newSnapshotRequest(ctx, "HEAD", snapshotURL(date, i))
+		if err != nil {
+			return sizes
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return sizes
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return sizes
+		}
+		sizes = append(sizes, resp.ContentLength)
+	}
+	return sizes
+}
+
+func (httpFetcher) Fetch(ctx context.Context, date string, index int, offset int64) (io.ReadCloser, int64, int64, error) {
+	url := snapshotURL(date, index)
+	req, err := newSnapshotRequest(ctx, "GET", url)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		resp.Body.Close()
+		return nil, 0, 0, errRangeNotSatisfiable
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		return resp.Body, offset + resp.ContentLength, offset, nil
+	case resp.StatusCode == http.StatusOK:
+		return resp.Body, resp.ContentLength, 0, nil
+	default:
+		resp.Body.Close()
+		return nil, 0, 0, fmt.Errorf("failed to download %s: status %d", url, resp.StatusCode)
+	}
+}
+
+type cacheExtractor struct{}
+
+func (cacheExtractor) Extract(zipPath string, index int) (string, error) {
+	return unpackCachedFile(zipPath, index)
+}
+
+type copyLoader struct{}
+
+func (copyLoader) CopyFile(ctx context.Context, ex execer, session *loadSession, table string, plan columnPlan, columnTypes map[string]string, path string, limit int, upsert bool) (int64, error) {
+	return copyNoteFile(ctx, ex, session, table, plan, columnTypes, path, limit, upsert)
+}
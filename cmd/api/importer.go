@@ -4,20 +4,33 @@ import (
 	"archive/zip"
 	"bufio"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-var dataDir = "/home/data"
+var (
+	dataDir                = "/home/data"
+	rowEstimateSampleLines = getEnvInt("ROW_ESTIMATE_SAMPLE_LINES", 1000)
+)
 
 func (pt *progressTracker) Read(p []byte) (int, error) {
 	n, err := pt.reader.Read(p)
 	pt.bytesRead += int64(n)
+	if n > 0 {
+		touchJobProgress(pt.jobID)
+	}
 
 	now := time.Now()
 	currentPct := 0
@@ -32,163 +45,219 @@ func (pt *progressTracker) Read(p []byte) (int, error) {
 		elapsed := now.Sub(pt.startTime)
 		var speedStr string
 		if elapsed > 0 {
-			bytesPerSec := float64(pt.bytesRead) / elapsed.Seconds()
+			bytesPerSec := float64(pt.bytesRead-pt.startOffset) / elapsed.Seconds()
 			speedStr = formatSpeed(bytesPerSec)
 		}
 
-		db.ExecContext(pt.ctx,
-			`UPDATE import_history SET download_percentage = $1, download_speed = $2, download_duration = EXTRACT(EPOCH FROM (NOW() - started_at))::INTEGER, file_size = $3, total_files = $4, current_file_index = $5 WHERE job_id = $6`,
-			currentPct, speedStr, pt.totalBytes, pt.totalFiles, pt.currentFileIndex, pt.jobID)
+		if !pt.overlapped {
+			jobStatus.ExecContext(pt.ctx,
+				expandSQL(pt.ctx, `UPDATE {import_history} SET download_percentage = $1, download_speed = $2, download_duration = EXTRACT(EPOCH FROM (NOW() - started_at))::INTEGER, file_size = $3, total_files = $4, current_file_index = $5 WHERE job_id = $6`),
+				currentPct, speedStr, pt.totalBytes, pt.totalFiles, pt.currentFileIndex, pt.jobID)
+		}
+		jobStatus.ExecContext(pt.ctx, expandSQL(pt.ctx, `UPDATE {import_files} SET bytes_downloaded = $1 WHERE job_id = $2 AND file_index = $3`), pt.bytesRead, pt.jobID, pt.currentFileIndex)
 	}
 
 	return n, err
 }
 
-func discoverFileCount(ctx context.Context, date string) int {
-	baseURL := fmt.Sprintf("https://ton.twimg.com/birdwatch-public-data/%s/notes/", formatDateForURL(date))
+func discoverFiles(ctx context.Context, date string) []int64 {
+	return snapshotFetcher.Discover(ctx, date)
+}
 
-	for i := 0; i < 100; i++ {
-		url := baseURL + fmt.Sprintf("notes-%05d.zip", i)
-		req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
-		if err != nil {
-			return i
-		}
+func findLatestDate(ctx context.Context, lookbackDays int) (string, error) {
+	return snapshotFetcher.LatestDate(ctx, lookbackDays)
+}
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return i
-		}
-		resp.Body.Close()
+func downloadNotesWithProgress(ctx context.Context, date string, jobID string, concurrency int, sel []int) ([]FileInfo, error) {
+	pipe, err := startSnapshotPipeline(ctx, date, jobID, concurrency, 0, sel)
+	if err != nil {
+		return nil, err
+	}
+	defer pipe.stop()
 
-		if resp.StatusCode != http.StatusOK {
-			return i
+	files := make([]FileInfo, pipe.len())
+	for i := range files {
+		if files[i], err = pipe.wait(i); err != nil {
+			return nil, err
 		}
 	}
-	return 100
+	return files, nil
 }
 
-func downloadNotesWithProgress(ctx context.Context, lookbackDays int, jobID string) ([]FileInfo, error) {
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %w", err)
-	}
-
-	var date string
-	var found bool
-	for i := 0; i < lookbackDays; i++ {
-		date = getDateDaysAgo(i)
-		url := fmt.Sprintf("https://ton.twimg.com/birdwatch-public-data/%s/notes/notes-00000.zip",
-			formatDateForURL(date))
-
-		resp, err := http.Get(url)
-		if err != nil {
-			continue
-		}
-		resp.Body.Close()
+// prepareDownloads discovers the snapshot's files, reserves cache space for
+// the selected ones not cached yet and registers them as pending, returning
+// their indexes, names and sizes.
+func prepareDownloads(ctx context.Context, date, jobID string, sel []int) ([]int, []string, []int64, error) {
+	log := jobLogger(ctx, jobID)
+	dir := workspaceFromContext(ctx).dataDir()
 
-		if resp.StatusCode == http.StatusOK {
-			found = true
-			break
-		}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	if !found {
-		return nil, fmt.Errorf("no data files found in the last %d days", lookbackDays)
+	available := discoverFiles(ctx, date)
+	if len(available) == 0 {
+		return nil, nil, nil, fmt.Errorf("no files found for date %s", date)
 	}
-
-	totalFiles := discoverFileCount(ctx, date)
-	if totalFiles == 0 {
-		return nil, fmt.Errorf("no files found for date %s", date)
+	indexes, err := selectFileIndexes(len(available), sel)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	var fileNames []string
-	for i := 0; i < totalFiles; i++ {
-		fileNames = append(fileNames, fmt.Sprintf("%s-notes-%05d.zip", date, i))
+	var sizes []int64
+	var needed int64
+	for _, i := range indexes {
+		name := fmt.Sprintf("%s-notes-%05d.zip", date, i)
+		fileNames = append(fileNames, name)
+		sizes = append(sizes, available[i])
+		if _, ok := cachedFileSize(filepath.Join(dir, name)); !ok {
+			needed += available[i]
+		}
+	}
+	if err := ensureCacheSpace(dir, date, needed, log); err != nil {
+		return nil, nil, nil, err
 	}
-	fileNamesStr := strings.Join(fileNames, ",")
+	fileList, _ := json.Marshal(fileNames)
 
-	db.ExecContext(ctx, `UPDATE import_history SET total_files = $1, current_file_index = 0, file_names = $2 WHERE job_id = $3`, totalFiles, fileNamesStr, jobID)
+	jobStatus.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET total_files = $1, current_file_index = $2, file_list = $3 WHERE job_id = $4`), len(indexes), indexes[0], fileList, jobID)
 
-	var files []FileInfo
-	for i := 0; i < totalFiles; i++ {
-		filename := fmt.Sprintf("%s-%s", date, formatFileName(i)+".zip")
-		filepath := filepath.Join(dataDir, filename)
-		urlFilename := formatFileName(i) + ".zip"
-		url := fmt.Sprintf("https://ton.twimg.com/birdwatch-public-data/%s/notes/%s",
-			formatDateForURL(date), urlFilename)
+	for k, i := range indexes {
+		jobStatus.ExecContext(ctx, expandSQL(ctx, `
+			INSERT INTO {import_files} (job_id, file_index, file_name, file_size, status)
+			VALUES ($1, $2, $3, $4, 'pending')
+			ON CONFLICT (job_id, file_index) DO NOTHING`),
+			jobID, i, fileNames[k], sizes[k])
+	}
+	return indexes, fileNames, sizes, nil
+}
 
-		var fileSize int64
-		var cached bool
+// fetchSnapshotFile downloads (unless cached) and unpacks one snapshot file.
+// Several may run at once; spaceMu serialises the cache quota checks so
+// concurrent downloads don't evict each other's space. When overlapped with
+// the load, progress goes to import_files only, since current_file_index then
+// tracks the file being loaded.
+func fetchSnapshotFile(ctx context.Context, date, jobID string, i, totalFiles int, spaceMu *sync.Mutex, overlapped bool) (FileInfo, error) {
+	log := jobLogger(ctx, jobID)
+	dir := workspaceFromContext(ctx).dataDir()
 
-		if _, err := os.Stat(filepath); err == nil {
-			logger.Info("File already exists", "path", filepath)
-			info, _ := os.Stat(filepath)
-			fileSize = info.Size()
-			cached = true
+	downloadStart := time.Now()
+	filename := fmt.Sprintf("%s-%s", date, formatFileName(i)+".zip")
+	filepath := filepath.Join(dir, filename)
 
-			db.ExecContext(ctx, `UPDATE import_history SET current_file_index = $1, file_size = $2, download_cached = $3, download_percentage = 100 WHERE job_id = $4`, i, fileSize, cached, jobID)
-		} else {
-			logger.Info("Downloading file", "url", url, "path", filepath)
+	var fileSize int64
+	var cached bool
 
-			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
-			}
-
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				return nil, fmt.Errorf("failed to download %s: %w", url, err)
-			}
-			defer resp.Body.Close()
+	if size, ok := cachedFileSize(filepath); ok {
+		log.Info("File already exists", "path", filepath)
+		fileSize = size
+		cached = true
 
-			if resp.StatusCode != http.StatusOK {
-				return nil, fmt.Errorf("failed to download %s: status %d", url, resp.StatusCode)
-			}
+		if !overlapped {
+			jobStatus.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET current_file_index = $1, file_size = $2, download_cached = $3, download_percentage = 100 WHERE job_id = $4`), i, fileSize, cached, jobID)
+		}
+	} else {
+		partPath := filepath + ".part"
+		var offset int64
+		if info, err := os.Stat(partPath); err == nil {
+			offset = info.Size()
+		}
 
-			totalBytes := resp.ContentLength
-			tracker := &progressTracker{
-				reader:           resp.Body,
-				totalBytes:       totalBytes,
-				startTime:        time.Now(),
-				lastUpdate:       time.Now(),
-				ctx:              ctx,
-				jobID:            jobID,
-				fileName:         filename,
-				totalFiles:       totalFiles,
-				currentFileIndex: i,
-			}
+		if offset > 0 {
+			log.Info("Resuming partial download", "date", date, "index", i, "path", partPath, "offset", offset)
+		} else {
+			log.Info("Downloading file", "date", date, "index", i, "path", filepath)
+		}
 
-			outFile, err := os.Create(filepath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create file: %w", err)
-			}
-			defer outFile.Close()
+		body, totalBytes, start, err := snapshotFetcher.Fetch(ctx, date, i, offset)
+		if errors.Is(err, errRangeNotSatisfiable) {
+			log.Warn("Partial download does not match the remote file, restarting it", "path", partPath)
+			os.Remove(partPath)
+			return fetchSnapshotFile(ctx, date, jobID, i, totalFiles, spaceMu, overlapped)
+		}
+		if err != nil {
+			return FileInfo{}, err
+		}
+		defer body.Close()
 
-			_, err = io.Copy(outFile, tracker)
-			if err != nil {
-				os.Remove(filepath)
-				return nil, fmt.Errorf("failed to write file: %w", err)
-			}
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if start > 0 {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		offset = start
 
-			fileSize = totalBytes
-			logger.Info("Downloaded file", "path", filepath)
+		tracker := &progressTracker{
+			reader:           body,
+			totalBytes:       totalBytes,
+			bytesRead:        offset,
+			startOffset:      offset,
+			startTime:        time.Now(),
+			lastUpdate:       time.Now(),
+			ctx:              ctx,
+			jobID:            jobID,
+			fileName:         filename,
+			totalFiles:       totalFiles,
+			currentFileIndex: i,
+			overlapped:       overlapped,
 		}
 
-		db.ExecContext(ctx, `UPDATE import_history SET current_file_index = $1, file_size = $2, download_cached = $3 WHERE job_id = $4`, i, fileSize, cached, jobID)
+		outFile, err := os.OpenFile(partPath, flags, 0644)
+		if err != nil {
+			return FileInfo{}, fmt.Errorf("failed to create file: %w", err)
+		}
+		defer outFile.Close()
 
-		tsvPath, err := extractTSV(filepath, i)
+		// A failed or interrupted transfer keeps its .part file so the next
+		// attempt, or the automatic resume after a crash, continues from there.
+		_, err = io.Copy(outFile, tracker)
+		if err == nil {
+			err = outFile.Close()
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to extract %s: %w", filepath, err)
+			return FileInfo{}, fmt.Errorf("failed to write file: %w", err)
+		}
+		if err := os.Rename(partPath, filepath); err != nil {
+			return FileInfo{}, fmt.Errorf("failed to write file: %w", err)
 		}
+		jobStatus.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_files} SET bytes_downloaded = $1 WHERE job_id = $2 AND file_index = $3`), totalBytes, jobID, i)
 
-		files = append(files, FileInfo{
-			ZipPath:  filepath,
-			TSVPath:  tsvPath,
-			FileName: filename,
-			FileSize: fileSize,
-		})
+		fileSize = totalBytes
+		log.Info("Downloaded file", "path", filepath)
 	}
 
-	return files, nil
+	if !overlapped {
+		jobStatus.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET current_file_index = $1, file_size = $2, download_cached = $3 WHERE job_id = $4`), i, fileSize, cached, jobID)
+	}
+
+	spaceMu.Lock()
+	err := ensureCacheSpace(dir, date, extractionSpace(filepath), log)
+	spaceMu.Unlock()
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	tsvPath, err := snapshotExtractor.Extract(filepath, i)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to extract %s: %w", filepath, err)
+	}
+
+	jobStatus.ExecContext(ctx, expandSQL(ctx, `
+		INSERT INTO {import_files} AS f (job_id, file_index, file_name, file_size, status, cached, download_duration)
+		VALUES ($1, $2, $3, $4, 'downloaded', $5, $6)
+		ON CONFLICT (job_id, file_index) DO UPDATE SET
+			file_size = EXCLUDED.file_size,
+			cached = EXCLUDED.cached,
+			download_duration = EXCLUDED.download_duration,
+			status = CASE WHEN f.status = 'imported' THEN 'imported' ELSE 'downloaded' END`),
+		jobID, i, filename, fileSize, cached, int(time.Since(downloadStart).Seconds()))
+
+	return FileInfo{
+		Index:    i,
+		ZipPath:  filepath,
+		TSVPath:  tsvPath,
+		FileName: filename,
+		FileSize: fileSize,
+	}, nil
 }
 
 func extractTSV(zipPath string, fileIndex int) (string, error) {
@@ -199,6 +268,7 @@ func extractTSV(zipPath string, fileIndex int) (string, error) {
 	defer reader.Close()
 
 	tsvPath := zipPath[:len(zipPath)-4] + ".tsv"
+	os.Remove(tsvPath)
 	expectedTSV := fmt.Sprintf("notes-%05d.tsv", fileIndex)
 
 	for _, file := range reader.File {
@@ -228,102 +298,950 @@ func extractTSV(zipPath string, fileIndex int) (string, error) {
 	return "", fmt.Errorf("%s not found in zip", expectedTSV)
 }
 
-func countTSVRows(tsvPath string) (int, error) {
+// estimateTSVRows extrapolates a file's row count from the average length of
+// its first ROW_ESTIMATE_SAMPLE_LINES rows, and is exact for files that short.
+func estimateTSVRows(tsvPath string) (int, error) {
 	file, err := os.Open(tsvPath)
 	if err != nil {
 		return 0, err
 	}
 	defer file.Close()
 
-	buf := make([]byte, 32*1024)
-	count := 0
-	for {
-		n, err := file.Read(buf)
-		if n > 0 {
-			for _, b := range buf[:n] {
-				if b == '\n' {
-					count++
-				}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, nil
+	}
+
+	var lines int
+	var sampled int64
+	for lines < max(rowEstimateSampleLines, 1) {
+		line, err := reader.ReadString('\n')
+		sampled += int64(len(line))
+		if len(line) > 0 && strings.HasSuffix(line, "\n") {
+			lines++
+		}
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int((info.Size() - int64(len(header))) * int64(lines) / sampled), nil
+}
+
+// previousFileRows returns the rows a completed full import loaded from a file
+// with the same content hash as this job's file.
+func previousFileRows(ctx context.Context, jobID string, fileIndex int) (int, bool) {
+	var rows int
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT p.rows_imported FROM {import_files} f
+		JOIN {import_files} p ON p.content_hash = f.content_hash AND p.job_id <> f.job_id
+		JOIN {import_history} h ON h.job_id = p.job_id
+		WHERE f.job_id = $1 AND f.file_index = $2 AND p.rows_imported IS NOT NULL
+		  AND h.status IN ('completed', 'completed_with_warnings') AND h.snapshot_fingerprint IS NOT NULL
+		ORDER BY h.completed_at DESC LIMIT 1
+	`), jobID, fileIndex).Scan(&rows)
+	return rows, err == nil
+}
+
+// importJob carries one import's state from phase to phase. Each phase
+// returns false once it has stopped the job, having recorded why (failed,
+// paused, cancelled or skipped as unchanged).
+type importJob struct {
+	ctx   context.Context
+	work  context.Context
+	jobID string
+	opts  importOptions
+	ws    *workspace
+	log   *slog.Logger
+
+	upsert     bool
+	unlogged   bool
+	partial    bool
+	keyChanged bool
+	date       string
+	sel        []int
+
+	// With PIPELINE_IMPORT the load starts once the first file is extracted
+	// and takes the others from pipe as they arrive; only files[:prepared] is
+	// known until then.
+	files    []FileInfo
+	pipe     *snapshotPipeline
+	prepared int
+
+	// Expected rows are estimates until each file's COPY reports its exact
+	// count; total_rows is reconciled file by file.
+	hashes            []string
+	expectedRows      []int
+	expectedTotalRows int
+	linked            int
+
+	plan         columnPlan
+	columnTypes  map[string]string
+	imported     map[int]bool
+	importedRows int
+
+	session      *loadSession
+	ex           execer
+	tx           *sql.Tx
+	keepPrevious bool
+	targetTable  string
+	indexSuffix  string
+
+	mu             sync.Mutex
+	cumulativeRows int
+	totalRows      int
+	fileCounts     []fileRowCount
+	warnings       []string
+}
+
+func runImport(jobID string, opts importOptions) {
+	ws := opts.workspace
+	if ws == nil {
+		ws = defaultWorkspace
+	}
+	ctx := withImportJobID(withWorkspace(context.Background(), ws), jobID)
+	defer opts.lock.release()
+
+	log := jobLogger(ctx, jobID)
+	if opts.requestID != "" {
+		log = log.With("request_id", opts.requestID)
+	}
+
+	stopHeartbeat := startJobHeartbeat(ctx, jobID)
+	defer stopHeartbeat()
+
+	// Downloads, COPY and index builds run under work so that a hung one is
+	// cancelled at IMPORT_MAX_RUNTIME; bookkeeping keeps using ctx.
+	work, cancelWork := withImportDeadline(ctx)
+	defer cancelWork()
+
+	j := &importJob{ctx: ctx, work: work, jobID: jobID, opts: opts, ws: ws, log: log, upsert: opts.mode == loadModeUpsert}
+	j.unlogged = unloggedLoad && !j.upsert
+	defer j.stop()
+
+	if isImportAborted(ctx, jobID) {
+		log.Info("Import aborted before start")
+		return
+	}
+
+	if !j.resolveSnapshot() || !j.fetch() || !j.prepare() || !j.openLoad() || !j.load() || !j.index() || !j.finalize() {
+		return
+	}
+	j.postProcess()
+}
+
+// stop releases what the phases left open, putting the previous dataset back
+// when a direct load failed.
+func (j *importJob) stop() {
+	if j.keepPrevious {
+		var status string
+		db.QueryRowContext(j.ctx, expandSQL(j.ctx, `SELECT status FROM {import_history} WHERE job_id = $1`), j.jobID).Scan(&status)
+		if status == "failed" {
+			restore := restorePreviousNote
+			if j.session.conn != nil {
+				restore = func(ctx context.Context) error { return restorePreviousNoteOn(ctx, j.session.conn) }
 			}
+			if err := restore(j.ctx); err != nil {
+				j.log.Error("Failed to restore previous dataset", "error", err)
+			} else {
+				j.log.Info("Restored previous dataset")
+			}
+		}
+	}
+	if j.tx != nil {
+		j.tx.Rollback()
+	}
+	if j.session != nil {
+		j.session.Close()
+	}
+	if j.pipe != nil {
+		j.pipe.stop()
+	}
+}
+
+func (j *importJob) fail(code, msg string) {
+	if errors.Is(j.work.Err(), context.DeadlineExceeded) {
+		code, msg = importErrTimeout, fmt.Sprintf("exceeded IMPORT_MAX_RUNTIME of %s: %s", importMaxRuntime, msg)
+	}
+	setImportFailed(j.ctx, j.jobID, code, msg)
+}
+
+func (j *importJob) aborted() bool {
+	if isImportAborted(j.ctx, j.jobID) {
+		j.fail(importErrCancelled, "Aborted by user")
+		return true
+	}
+	return false
+}
+
+// resolveSnapshot picks the snapshot date, restoring a resumed job's settings
+// or recording a new job's.
+func (j *importJob) resolveSnapshot() bool {
+	ctx, opts := j.ctx, &j.opts
+	if opts.resume {
+		var dataDate sql.NullString
+		var keyID string
+		db.QueryRowContext(ctx, expandSQL(ctx, `SELECT data_date::text, COALESCE(offline, false), COALESCE(load_mode, 'truncate'), COALESCE(file_range, ''), COALESCE(row_limit, 0), COALESCE(pseudonym_key, '') FROM {import_history} WHERE job_id = $1`), j.jobID).Scan(&dataDate, &opts.offline, &opts.mode, &opts.files, &opts.limit, &keyID)
+		if !dataDate.Valid {
+			j.fail(importErrSnapshotNotFound, "cannot resume: snapshot date unknown")
+			return false
+		}
+		if keyID != pseudonymKeyID() {
+			j.fail(importErrSchemaMismatch, "cannot resume: PSEUDONYMIZE_SECRET changed since the import started")
+			return false
+		}
+		j.date = dataDate.String
+		j.upsert = opts.mode == loadModeUpsert
+		j.unlogged = unloggedLoad && !j.upsert
+	} else {
+		var err error
+		switch {
+		case opts.offline && opts.date != "":
+			j.date = opts.date
+		case opts.offline:
+			j.date, err = latestLocalDate(j.ws.dataDir())
+		default:
+			j.date, err = findLatestDate(ctx, 7)
 		}
 		if err != nil {
-			break
+			j.fail(importErrSnapshotNotFound, err.Error())
+			return false
 		}
+		db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET data_date = $1, offline = $2, file_range = NULLIF($3, ''), row_limit = NULLIF($4, 0), pseudonym_key = NULLIF($5, '') WHERE job_id = $6`), j.date, opts.offline, opts.files, opts.limit, pseudonymKeyID(), j.jobID)
+	}
+
+	// A subset of the files is loaded like a limited one: it is never
+	// fingerprinted as the snapshot nor compared with full imports.
+	var err error
+	if j.sel, err = parseFileRange(opts.files); err != nil {
+		j.fail(importErrInternal, err.Error())
+		return false
 	}
-	return count - 1, nil
+	j.partial = opts.limit > 0 || j.sel != nil
+
+	// Rows pseudonymized with another secret (or none) would no longer join
+	// with the ones an upsert keeps, and an unchanged snapshot still has to be
+	// reloaded under the new secret.
+	prevKeyID, hasPrev := lastPseudonymKey(ctx, j.jobID)
+	j.keyChanged = hasPrev && prevKeyID != pseudonymKeyID()
+	if j.keyChanged && j.upsert {
+		j.fail(importErrSchemaMismatch, "PSEUDONYMIZE_SECRET changed since the last import; run a truncate import to re-pseudonymize all notes")
+		return false
+	}
+	return true
 }
 
-func truncateTSV(tsvPath string, maxLines int) error {
-	if maxLines <= 0 {
-		return nil
+// fetch downloads and extracts the snapshot, or collects it from the data
+// directory for an offline import. A pipelined fetch only waits for the
+// first file.
+func (j *importJob) fetch() bool {
+	ctx := j.ctx
+	publishImportEvent(ctx, event{Type: eventImportStarted, JobID: j.jobID, DataDate: j.date})
+
+	var err error
+	switch {
+	case j.opts.offline:
+		j.log.Info("Offline import, using local files only", "date", j.date)
+		j.files, err = collectLocalFiles(ctx, j.date, j.jobID, j.sel)
+	case pipelineImport:
+		j.pipe, err = startSnapshotPipeline(j.work, j.date, j.jobID, j.opts.concurrency, pipelineBufferFiles, j.sel)
+		if err == nil {
+			j.files = make([]FileInfo, j.pipe.len())
+			j.files[0], err = j.pipe.wait(0)
+		}
+	default:
+		j.files, err = downloadNotesWithProgress(j.work, j.date, j.jobID, j.opts.concurrency, j.sel)
+	}
+	if errors.Is(err, errImportPaused) {
+		setImportPaused(ctx, j.jobID)
+		return false
+	}
+	if err != nil {
+		j.fail(failureCode(err, importErrDownloadFailed), err.Error())
+		return false
 	}
 
-	file, err := os.Open(tsvPath)
+	if !j.opts.offline && j.pipe == nil {
+		cleanupOldFiles(j.ws.dataDir(), j.date)
+	}
+	return !j.aborted()
+}
+
+// prepareFile fingerprints files[i] and records its expected row count.
+func (j *importJob) prepareFile(i int) error {
+	f := j.files[i]
+	hash, n, err := fingerprintFile(j.ctx, j.jobID, f.Index, f, j.log)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to fingerprint snapshot: %w", err)
 	}
-	defer file.Close()
+	j.hashes[i] = hash
+	j.linked += n
 
-	tmpPath := tsvPath + ".tmp"
-	outFile, err := os.Create(tmpPath)
+	rows, ok := 0, false
+	if j.opts.limit == 0 {
+		rows, ok = previousFileRows(j.ctx, j.jobID, f.Index)
+	}
+	if !ok {
+		estimate, err := estimateTSVRows(f.TSVPath)
+		if err != nil {
+			j.log.Warn("Failed to estimate row count", "file", f.FileName, "error", err)
+			return nil
+		}
+		rows = estimate
+	}
+	if j.opts.limit > 0 {
+		rows = min(rows, j.opts.limit)
+	}
+	j.expectedRows[i] = rows
+	j.expectedTotalRows += rows
+	touchJobProgress(j.jobID)
+	db.ExecContext(j.ctx, expandSQL(j.ctx, `UPDATE {import_files} SET expected_rows = $1 WHERE job_id = $2 AND file_index = $3`), rows, j.jobID, f.Index)
+	return nil
+}
+
+func (j *importJob) recordFingerprint() string {
+	if j.linked > 0 {
+		j.log.Info("Hard-linked cache files identical to another snapshot date", "files", j.linked)
+	}
+	fingerprint := snapshotFingerprint(j.hashes)
+	if !j.partial {
+		db.ExecContext(j.ctx, expandSQL(j.ctx, `UPDATE {import_history} SET snapshot_fingerprint = $1 WHERE job_id = $2`), fingerprint, j.jobID)
+	}
+	return fingerprint
+}
+
+// prepare fingerprints the fetched files, skips a snapshot identical to the
+// last import, reconciles the TSV header with the note table and reads the
+// checkpoints of a resumed job.
+func (j *importJob) prepare() bool {
+	ctx := j.ctx
+	j.hashes = make([]string, len(j.files))
+	j.expectedRows = make([]int, len(j.files))
+	j.prepared = 1
+	if j.pipe == nil {
+		j.prepared = len(j.files)
+	}
+	for i := range j.prepared {
+		if err := j.prepareFile(i); err != nil {
+			j.fail(failureCode(err, importErrInternal), err.Error())
+			return false
+		}
+	}
+
+	// A pipelined load starts before the whole snapshot can be fingerprinted,
+	// so it cannot be skipped as unchanged.
+	if j.pipe == nil {
+		fingerprint := j.recordFingerprint()
+		if !j.partial {
+			if prev, prevJobID, prevRows, ok := previousFingerprint(ctx, j.jobID); ok && prev == fingerprint && skipUnchanged && !j.opts.force && !j.keyChanged {
+				j.log.Info("Snapshot identical to the last completed import, skipping load", "previous_job_id", prevJobID)
+				db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET status = 'skipped_unchanged', total_rows = $1, completed_at = NOW(), import_duration = 0 WHERE job_id = $2`), prevRows, j.jobID)
+				publishImportEvent(ctx, event{Type: eventImportSkipped, JobID: j.jobID, DataDate: j.date, Rows: &prevRows})
+				return false
+			}
+		}
+	}
+
+	var schemaVersion string
+	var err error
+	j.plan, j.columnTypes, schemaVersion, err = reconcileSchema(ctx, j.files[:j.prepared], j.log)
 	if err != nil {
-		return err
+		j.fail(importErrSchemaMismatch, "schema drift: "+err.Error())
+		return false
+	}
+	if j.plan.purged, err = purgedParticipants(ctx); err != nil {
+		j.fail(importErrDatabase, "failed to read purged participants: "+err.Error())
+		return false
+	}
+	if j.plan.dropsPurged() {
+		j.log.Info("Dropping rows of purged participants", "participants", len(j.plan.purged))
 	}
-	defer outFile.Close()
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET schema_version = $1 WHERE job_id = $2`), schemaVersion, j.jobID)
 
-	reader := bufio.NewReader(file)
-	for i := 0; i < maxLines; i++ {
-		line, err := reader.ReadBytes('\n')
+	var fileNames []string
+	var totalSize int64
+	if j.pipe != nil {
+		fileNames, totalSize = j.pipe.names, j.pipe.totalSize()
+	} else {
+		for _, f := range j.files {
+			fileNames = append(fileNames, f.FileName)
+			totalSize += f.FileSize
+		}
+	}
+	fileList, _ := json.Marshal(fileNames)
+
+	j.imported, j.importedRows, err = importedFiles(ctx, j.jobID)
+	if err != nil {
+		j.fail(importErrDatabase, "failed to read file checkpoints: "+err.Error())
+		return false
+	}
+	if transactionalLoad && !j.unlogged && len(j.imported) > 0 {
+		j.log.Info("Transactional load ignores file checkpoints; reloading all files", "files_already_imported", len(j.imported))
+		j.imported, j.importedRows = map[int]bool{}, 0
+	}
+
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET status = 'importing', download_percentage = 100, total_rows = $1, file_size = $2, import_started_at = NOW(), files_processed = $3, file_list = $4 WHERE job_id = $5`), j.expectedTotalRows, totalSize, len(j.imported), fileList, j.jobID)
+	return !j.aborted()
+}
+
+// openLoad opens the load session (and transaction) and readies the target
+// table: the load table, the live table with the previous dataset set aside,
+// or the live table truncated with its indexes dropped.
+func (j *importJob) openLoad() bool {
+	ctx := j.ctx
+	var err error
+	j.session, err = openLoadSession(ctx, j.opts.lock)
+	if err != nil {
+		j.fail(importErrDatabase, "failed to open load session: "+err.Error())
+		return false
+	}
+
+	j.ex = j.session.conn
+	if transactionalLoad && !j.unlogged {
+		j.tx, err = j.session.conn.BeginTx(ctx, nil)
 		if err != nil {
-			if len(line) > 0 {
-				outFile.Write(line)
+			j.fail(importErrDatabase, "failed to begin load transaction: "+err.Error())
+			return false
+		}
+		j.ex = j.tx
+	}
+
+	// A direct load sets the previous dataset aside as note_previous and puts
+	// it back should the job fail; it is dropped once the job completes.
+	j.keepPrevious = rollbackOnFailure && j.tx == nil && !j.unlogged && !j.upsert
+
+	j.targetTable = "note"
+	if j.unlogged {
+		j.targetTable, j.indexSuffix = loadTable, "_load"
+		fresh, err := prepareLoadTable(ctx, j.ex, len(j.imported) > 0)
+		if err != nil {
+			j.fail(importErrDatabase, err.Error())
+			return false
+		}
+		if fresh && len(j.imported) > 0 {
+			j.log.Info("Load table missing; reloading all files", "files_already_imported", len(j.imported))
+			j.imported, j.importedRows = map[int]bool{}, 0
+		}
+	} else if j.keepPrevious {
+		if len(j.imported) > 0 && !previousNoteExists(ctx) {
+			j.log.Info("Previous dataset was restored; reloading all files", "files_already_imported", len(j.imported))
+			j.imported, j.importedRows = map[int]bool{}, 0
+		}
+	} else if !j.upsert {
+		if _, err := j.ex.ExecContext(ctx, dropNoteIndexesSQL(ctx)); err != nil {
+			j.fail(importErrDatabase, "failed to drop indexes: "+err.Error())
+			return false
+		}
+	}
+
+	if len(j.imported) > 0 {
+		j.log.Info("Resuming import", "files_already_imported", len(j.imported))
+	} else if j.keepPrevious {
+		if err := preserveNote(ctx, j.ex); err != nil {
+			j.fail(importErrDatabase, err.Error())
+			return false
+		}
+	} else if !j.unlogged && !j.upsert {
+		if _, err := j.ex.ExecContext(ctx, expandSQL(ctx, `TRUNCATE {note}`)); err != nil {
+			j.fail(importErrDatabase, "failed to truncate table: "+err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// load COPYs each file not already checkpointed, reporting COPY progress
+// every half second.
+func (j *importJob) load() bool {
+	ctx := j.ctx
+	j.cumulativeRows, j.totalRows = j.importedRows, j.importedRows
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		lastTotal := -1
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(500 * time.Millisecond):
+				tuplesProcessed, err := j.session.copyProgress(ctx)
+				if err == nil {
+					j.mu.Lock()
+					currentTotal := j.cumulativeRows + tuplesProcessed
+					j.mu.Unlock()
+					if currentTotal != lastTotal {
+						lastTotal = currentTotal
+						touchJobProgress(j.jobID)
+					}
+					db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET rows_processed = $1, import_duration = EXTRACT(EPOCH FROM (NOW() - import_started_at))::INTEGER WHERE job_id = $2`), currentTotal, j.jobID)
+				}
+			}
+		}
+	}()
+
+	for i := range j.files {
+		if i >= j.prepared && !j.awaitFile(i) {
+			return false
+		}
+		if j.imported[j.files[i].Index] {
+			if j.pipe != nil {
+				j.pipe.release()
 			}
-			break
+			continue
+		}
+		if j.aborted() {
+			return false
+		}
+		if isPauseRequested(ctx, j.jobID) {
+			setImportPaused(ctx, j.jobID)
+			return false
+		}
+		if !j.loadFile(i) {
+			return false
+		}
+		if j.pipe != nil {
+			j.pipe.release()
 		}
-		outFile.Write(line)
 	}
 
-	outFile.Close()
-	os.Rename(tmpPath, tsvPath)
-	return nil
+	if j.pipe != nil {
+		j.recordFingerprint()
+		cleanupOldFiles(j.ws.dataDir(), j.date)
+	}
+	return true
 }
 
-func setImportFailed(jobID, errMsg string) {
-	db.ExecContext(context.Background(), `UPDATE import_history SET status = 'failed', error_message = $1, completed_at = NOW() WHERE job_id = $2`, errMsg, jobID)
+// awaitFile takes the pipelined files[i] once it is extracted and prepares it.
+func (j *importJob) awaitFile(i int) bool {
+	f, err := j.pipe.wait(i)
+	if errors.Is(err, errImportPaused) {
+		setImportPaused(j.ctx, j.jobID)
+		return false
+	}
+	if err != nil {
+		j.fail(failureCode(err, importErrDownloadFailed), err.Error())
+		return false
+	}
+	j.files[i] = f
+	if err := checkTSVHeader(f, j.files[0]); err != nil {
+		j.fail(importErrSchemaMismatch, "schema drift: "+err.Error())
+		return false
+	}
+	if err := j.prepareFile(i); err != nil {
+		j.fail(failureCode(err, importErrInternal), err.Error())
+		return false
+	}
+	db.ExecContext(j.ctx, expandSQL(j.ctx, `UPDATE {import_history} SET total_rows = $1 WHERE job_id = $2`), max(j.expectedTotalRows, j.cumulativeRows), j.jobID)
+	return true
 }
 
-func sanitizeImportStatus() {
-	ctx := context.Background()
+// loadFile COPYs files[i], retrying transient failures, and checkpoints it.
+func (j *importJob) loadFile(i int) bool {
+	ctx, f := j.ctx, j.files[i]
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET current_file_index = $1 WHERE job_id = $2`), f.Index, j.jobID)
+	copyStart := time.Now()
 
-	_, err := db.ExecContext(ctx, `
-		UPDATE import_history 
-		SET status = 'failed', error_message = 'Interrupted'
-		WHERE status IN ('importing', 'downloading', 'indexing')
-	`)
+	var rowsAffected int64
+	attempts, err := withRetry(j.work, j.log, "copy "+f.FileName, func() error {
+		if j.tx != nil {
+			return copyNoteFileInSavepoint(j.work, j.tx, j.session, j.targetTable, j.plan, j.columnTypes, f.TSVPath, j.opts.limit, j.upsert, &rowsAffected)
+		}
+		var err error
+		rowsAffected, err = noteLoader.CopyFile(j.work, j.session.conn, j.session, j.targetTable, j.plan, j.columnTypes, f.TSVPath, j.opts.limit, j.upsert)
+		if err != nil && isTransientDBError(err) {
+			if rerr := j.session.renew(ctx); rerr != nil {
+				j.log.Warn("Failed to renew load session", "error", rerr)
+			}
+			j.ex = j.session.conn
+		}
+		return err
+	})
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_files} SET copy_attempts = $1 WHERE job_id = $2 AND file_index = $3`), attempts, j.jobID, f.Index)
 	if err != nil {
-		logger.Warn("Failed to sanitize import status", "error", err)
-		return
+		j.fail(failureCode(err, importErrCopyFailed), "failed to import "+f.FileName+": "+err.Error())
+		return false
 	}
 
-	logger.Info("Cleared any running import jobs")
+	j.log.Info("COPY command output", "file", f.FileName, "rows_affected", rowsAffected)
+
+	j.mu.Lock()
+	j.cumulativeRows += int(rowsAffected)
+	j.totalRows = j.cumulativeRows
+	j.mu.Unlock()
+
+	j.fileCounts = append(j.fileCounts, fileRowCount{FileName: f.FileName, Expected: j.expectedRows[i], Loaded: int(rowsAffected)})
+	j.expectedTotalRows += int(rowsAffected) - j.expectedRows[i]
+	j.expectedRows[i] = int(rowsAffected)
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET total_rows = $1 WHERE job_id = $2`), max(j.expectedTotalRows, j.cumulativeRows), j.jobID)
+
+	var checkpoint execer = db
+	if j.tx != nil {
+		checkpoint = j.tx
+	}
+	checkpoint.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_files} SET status = 'imported', rows_imported = $1, imported_at = NOW(), import_duration = $2 WHERE job_id = $3 AND file_index = $4`), rowsAffected, int(time.Since(copyStart).Seconds()), j.jobID, f.Index)
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET files_processed = $1 WHERE job_id = $2`), i+1, j.jobID)
+	j.log.Info("File imported", "file", f.FileName, "current", i+1, "total", len(j.files))
+	touchJobProgress(j.jobID)
+	return true
 }
 
-func cleanupOldFiles(keepDate string) {
-	entries, err := os.ReadDir(dataDir)
+// index checks the loaded row counts, then rebuilds the note indexes (and
+// swaps the load table in), reporting build progress every two seconds.
+func (j *importJob) index() bool {
+	ctx := j.ctx
+
+	// Aborting only spares the live table when the load went to the load
+	// table, a transaction or kept the previous dataset aside; otherwise a
+	// direct load has already replaced it.
+	if rowCountCheck != rowCountCheckOff {
+		j.warnings = reconcileRowCounts(ctx, j.jobID, j.fileCounts, j.cumulativeRows, !j.upsert && !j.partial)
+		if len(j.warnings) > 0 && rowCountCheck == rowCountCheckAbort && (j.tx != nil || j.unlogged || j.keepPrevious) {
+			j.fail(importErrRowCount, "row count check failed: "+strings.Join(j.warnings, "; "))
+			return false
+		}
+		if len(j.warnings) > 0 {
+			j.log.Warn("Row counts outside tolerance", "warnings", j.warnings, "tolerance", rowCountTolerance)
+		}
+	}
+
+	go db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET status = 'indexing', indexing_started_at = NOW() WHERE job_id = $1`), j.jobID)
+
+	indexDone := make(chan struct{})
+	defer close(indexDone)
+	go func() {
+		var lastPhase string
+		lastBlocks := -1
+		for {
+			select {
+			case <-indexDone:
+				return
+			case <-time.After(2 * time.Second):
+				var phase string
+				var blocksDone, blocksTotal int
+				err := db.QueryRowContext(ctx, `
+					SELECT COALESCE(phase,''), COALESCE(blocks_done,0), COALESCE(blocks_total,0)
+					FROM pg_stat_progress_create_index WHERE pid = $1`, j.session.pid.Load()).Scan(&phase, &blocksDone, &blocksTotal)
+				if err == nil {
+					if phase != lastPhase || blocksDone != lastBlocks {
+						lastPhase, lastBlocks = phase, blocksDone
+						touchJobProgress(j.jobID)
+					}
+					db.ExecContext(ctx, expandSQL(ctx, `
+						UPDATE {import_history} SET index_phase = $1, index_blocks_done = $2, index_blocks_total = $3
+						WHERE job_id = $4`), phase, blocksDone, blocksTotal, j.jobID)
+				}
+			}
+		}
+	}()
+
+	indexes := noteIndexes
+	if j.upsert {
+		indexes = nil
+	}
+	for _, idx := range indexes {
+		if _, err := j.ex.ExecContext(j.work, createIndexSQL(ctx, idx, j.targetTable, j.indexSuffix)); err != nil {
+			j.fail(failureCode(err, importErrIndexFailed), "failed to rebuild index: "+err.Error())
+			return false
+		}
+	}
+
+	if j.unlogged {
+		if err := swapLoadTable(ctx, j.ex); err != nil {
+			j.fail(importErrDatabase, err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// finalize commits the load and marks the job completed.
+func (j *importJob) finalize() bool {
+	ctx := j.ctx
+	if j.tx != nil {
+		if err := j.tx.Commit(); err != nil {
+			j.fail(importErrDatabase, "failed to commit load transaction: "+err.Error())
+			return false
+		}
+	}
+	j.session.Close()
+
+	if j.upsert {
+		if err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COUNT(*) FROM {note}`)).Scan(&j.totalRows); err != nil {
+			j.log.Warn("Failed to count notes after upsert", "error", err)
+		}
+	}
+
+	var importDuration int
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT EXTRACT(EPOCH FROM (NOW() - import_started_at))::INTEGER FROM {import_history} WHERE job_id = $1`), j.jobID).Scan(&importDuration)
 	if err != nil {
-		logger.Warn("Failed to read data directory", "error", err)
-		return
+		importDuration = 0
 	}
 
-	for _, entry := range entries {
-		name := entry.Name()
-		if !strings.HasPrefix(name, keepDate) {
-			path := filepath.Join(dataDir, name)
-			if err := os.Remove(path); err != nil {
-				logger.Warn("Failed to remove old file", "path", path, "error", err)
-			} else {
-				logger.Info("Removed old file", "path", path)
+	status := "completed"
+	if len(j.warnings) > 0 {
+		status = "completed_with_warnings"
+	}
+	_, err = db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET status = $5, warnings = $6, total_rows = $1, completed_at = NOW(), import_duration = $2, data_date = $4 WHERE job_id = $3`), j.totalRows, importDuration, j.jobID, j.date, status, j.warnings)
+	if err != nil {
+		j.fail(importErrDatabase, "failed to mark import completed: "+err.Error())
+		return false
+	}
+	if j.keepPrevious {
+		if err := dropPreviousNote(ctx); err != nil {
+			j.log.Warn("Failed to drop previous dataset", "error", err)
+		}
+	}
+
+	invalidateResponseCache(ctx)
+	j.log.Info("Import completed", "rows", j.totalRows, "files", len(j.files))
+	publishImportEvent(ctx, event{Type: eventImportCompleted, JobID: j.jobID, DataDate: j.date, Rows: &j.totalRows})
+	return true
+}
+
+// postProcess runs the optional work that follows a completed import; its
+// failures are logged without failing the job.
+func (j *importJob) postProcess() {
+	ctx, log := j.ctx, j.log
+	if d, err := rollupParticipantDistribution(ctx, j.jobID); err != nil {
+		log.Error("Failed to compute participant distribution", "error", err)
+	} else {
+		log.Info("Computed participant distribution", "participants", d.Participants, "gini", d.Gini)
+	}
+
+	if embedder != nil {
+		embedded, err := embedNotes(ctx, log)
+		db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET notes_embedded = $1 WHERE job_id = $2`), embedded, j.jobID)
+		if err != nil {
+			log.Error("Failed to embed note summaries", "error", err, "embedded", embedded)
+		} else {
+			log.Info("Embedded note summaries", "notes", embedded)
+		}
+	}
+
+	if duplicatesEnabled {
+		pairs, err := detectDuplicates(ctx, log)
+		if err != nil {
+			log.Error("Failed to detect near-duplicate notes", "error", err)
+		} else {
+			log.Info("Detected near-duplicate notes", "pairs", pairs)
+		}
+	}
+
+	if topicsEnabled {
+		topics, err := clusterTopics(ctx, log)
+		if err != nil {
+			log.Error("Failed to cluster note topics", "error", err)
+		} else {
+			log.Info("Clustered note topics", "topics", topics)
+		}
+	}
+
+	invalidateResponseCache(ctx)
+
+	if digestEnabled() {
+		sendImportDigest(ctx, j.jobID, j.date, j.totalRows, log)
+	}
+	notifyNoteStatusChanges(ctx, j.jobID, j.date, log)
+
+	if publisher != nil {
+		published, err := publishNoteChanges(ctx, j.jobID, j.date, log)
+		db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET events_published = $1 WHERE job_id = $2`), published, j.jobID)
+		if err != nil {
+			log.Error("Failed to publish note change events", "error", err, "published", published)
+			return
+		}
+		log.Info("Published note change events", "events", published)
+	}
+}
+
+func importedFiles(ctx context.Context, jobID string) (map[int]bool, int, error) {
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT file_index, COALESCE(rows_imported, 0) FROM {import_files} WHERE job_id = $1 AND status = 'imported'`), jobID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	imported := make(map[int]bool)
+	var totalRows int
+	for rows.Next() {
+		var index, rowCount int
+		if err := rows.Scan(&index, &rowCount); err != nil {
+			return nil, 0, err
+		}
+		imported[index] = true
+		totalRows += rowCount
+	}
+	return imported, totalRows, rows.Err()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// copyNoteFile loads one TSV into table. Upserts always go through the staging
+// table since COPY cannot resolve conflicts on noteid.
+// With COPY_FROM_STDIN the file is streamed over the session connection
+// instead of being read by the server from the shared data directory; a limit
+// always streams, stopping after the first limit rows.
+func copyNoteFile(ctx context.Context, ex execer, session *loadSession, table string, plan columnPlan, columnTypes map[string]string, path string, limit int, upsert bool) (int64, error) {
+	copyInto := func(target string) (int64, error) {
+		if copyFromStdin || limit > 0 || plan.transformsRows() {
+			return session.copyFrom(ctx, fmt.Sprintf(`COPY %s FROM STDIN %s`, target, copyOptions), path, limit, plan)
+		}
+		res, err := ex.ExecContext(ctx, fmt.Sprintf(`COPY %s FROM '%s' %s`, target, path, copyOptions))
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+
+	if plan.direct() && !upsert {
+		return copyInto(fmt.Sprintf(`%s (%s)`, qualifiedTable(ctx, table), quoteColumns(plan.targets())))
+	}
+
+	if _, err := ex.ExecContext(ctx, `DROP TABLE IF EXISTS pg_temp.note_import_staging`); err != nil {
+		return 0, fmt.Errorf("failed to reset staging table: %w", err)
+	}
+	if _, err := ex.ExecContext(ctx, `CREATE TEMP TABLE note_import_staging (`+plan.stagingColumns()+`)`); err != nil {
+		return 0, fmt.Errorf("failed to create staging table: %w", err)
+	}
+	defer ex.ExecContext(context.Background(), `DROP TABLE IF EXISTS pg_temp.note_import_staging`)
+
+	if _, err := copyInto(`note_import_staging`); err != nil {
+		return 0, err
+	}
+	if _, err := rejectInvalidRows(ctx, ex, plan, columnTypes, path); err != nil {
+		return 0, err
+	}
+	insert := fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM note_import_staging`, qualifiedTable(ctx, table), quoteColumns(plan.targets()), plan.selectExpressions(columnTypes))
+	if upsert {
+		insert += ` ON CONFLICT (noteid) DO UPDATE SET ` + plan.upsertAssignments()
+	}
+	res, err := ex.ExecContext(ctx, insert)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert mapped rows: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// copyNoteFileInSavepoint wraps one file's load in a savepoint so a failed
+// attempt can be rolled back and retried without aborting the whole load
+// transaction.
+func copyNoteFileInSavepoint(ctx context.Context, tx *sql.Tx, session *loadSession, table string, plan columnPlan, columnTypes map[string]string, path string, limit int, upsert bool, rows *int64) error {
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT file_copy`); err != nil {
+		return err
+	}
+	n, err := noteLoader.CopyFile(ctx, tx, session, table, plan, columnTypes, path, limit, upsert)
+	if err != nil {
+		tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT file_copy`)
+		return err
+	}
+	*rows = n
+	_, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT file_copy`)
+	return err
+}
+
+var errImportPaused = errors.New("import paused")
+
+func setImportPaused(ctx context.Context, jobID string) {
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET status = 'paused', pause_requested = false, paused_at = NOW() WHERE job_id = $1`), jobID)
+	jobLogger(ctx, jobID).Info("Import paused")
+	publishImportEvent(ctx, event{Type: eventImportPaused, JobID: jobID})
+}
+
+func setImportFailed(ctx context.Context, jobID, code, errMsg string) {
+	jobLogger(ctx, jobID).Error("Import failed", "error", errMsg, "error_code", code)
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET status = 'failed', error_message = $1, error_code = $2, completed_at = NOW() WHERE job_id = $3`), errMsg, code, jobID)
+	invalidateResponseCache(ctx)
+	publishImportEvent(ctx, event{Type: eventImportFailed, JobID: jobID, Error: errMsg, ErrorCode: code})
+}
+
+// failureCode classifies err as disk_full or cancelled when it is one, and
+// falls back to the code of the phase that failed.
+func failureCode(err error, fallback string) string {
+	switch {
+	case errors.Is(err, errCacheQuotaExceeded), errors.Is(err, syscall.ENOSPC):
+		return importErrDiskFull
+	case errors.Is(err, context.Canceled):
+		return importErrCancelled
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "53100" {
+		return importErrDiskFull
+	}
+	return fallback
+}
+
+// sanitizeImportStatus deals with jobs left running by a process that died:
+// those owned by this instance or with a stale heartbeat are resumed from their
+// file checkpoints, or failed as interrupted when resume is false.
+func sanitizeImportStatus(resume bool) {
+	const stale = `status IN ('importing', 'downloading', 'indexing')
+		AND (owner_instance IS NULL OR owner_instance = $1 OR heartbeat_at IS NULL OR heartbeat_at < NOW() - make_interval(secs => $2))`
+
+	for _, ws := range workspaceOrder {
+		ctx := withWorkspace(context.Background(), ws)
+
+		if !resume {
+			res, err := db.ExecContext(ctx, expandSQL(ctx, `
+				UPDATE {import_history}
+				SET status = 'failed', error_message = 'Interrupted', error_code = 'interrupted'
+				WHERE `+stale), instanceID, jobHeartbeatTimeout.Seconds())
+			if err != nil {
+				logger.Warn("Failed to sanitize import status", "workspace", ws.Name, "error", err)
+				continue
+			}
+			n, _ := res.RowsAffected()
+			logger.Info("Cleared interrupted import jobs", "workspace", ws.Name, "jobs", n, "instance", instanceID)
+			if n > 0 && rollbackOnFailure {
+				if err := restorePreviousNote(ctx); err != nil {
+					logger.Warn("Failed to restore previous dataset", "workspace", ws.Name, "error", err)
+				}
+			}
+			continue
+		}
+
+		rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+			UPDATE {import_history}
+			SET status = 'downloading', pause_requested = false, owner_instance = $1, heartbeat_at = NOW()
+			WHERE `+stale+`
+			RETURNING job_id, data_date IS NOT NULL, COALESCE(offline, false), COALESCE(load_mode, 'truncate')
+		`), instanceID, jobHeartbeatTimeout.Seconds())
+		if err != nil {
+			logger.Warn("Failed to sanitize import status", "workspace", ws.Name, "error", err)
+			continue
+		}
+		resumable := map[string]importOptions{}
+		for rows.Next() {
+			var id string
+			opts := importOptions{workspace: ws}
+			if err := rows.Scan(&id, &opts.resume, &opts.offline, &opts.mode); err != nil {
+				logger.Warn("Failed to read interrupted import", "workspace", ws.Name, "error", err)
+				continue
+			}
+			resumable[id] = opts
+		}
+		rows.Close()
+
+		for id, opts := range resumable {
+			lock, err := tryImportLock(ctx)
+			if err != nil || lock == nil {
+				jobLogger(ctx, id).Warn("Another import holds the import lock; not resuming", "instance", instanceID)
+				setImportFailed(ctx, id, importErrInterrupted, "Interrupted")
+				continue
 			}
+			opts.lock = lock
+			jobLogger(ctx, id).Info("Resuming interrupted import", "instance", instanceID, "from_checkpoint", opts.resume)
+			go runImport(id, opts)
 		}
 	}
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const testDate = "2024-01-02"
+
+func snapshotBytes(n int) []byte {
+	return bytes.Repeat([]byte("x"), n)
+}
+
+func TestFetchSnapshotFileResumesPartialDownload(t *testing.T) {
+	ctx, dir := testWorkspace(t)
+	data := snapshotBytes(1000)
+	fetcher := &memFetcher{Files: map[string][][]byte{testDate: {data}}, FailAfter: map[int]int64{0: 400}}
+	rec := useImportFakes(t, fetcher, memExtractor{TSV: map[int]string{0: "noteId\n1\n"}}, nil)
+	var spaceMu sync.Mutex
+
+	_, err := fetchSnapshotFile(ctx, testDate, "job", 0, 1, &spaceMu, false)
+	if !errors.Is(err, errFakeTransfer) {
+		t.Fatalf("interrupted fetch: got %v, want %v", err, errFakeTransfer)
+	}
+	zipPath := filepath.Join(dir, testDate+"-notes-00000.zip")
+	if info, err := os.Stat(zipPath + ".part"); err != nil || info.Size() != 400 {
+		t.Fatalf("partial file after interruption: %v, %v", info, err)
+	}
+
+	fetcher.FailAfter = nil
+	f, err := fetchSnapshotFile(ctx, testDate, "job", 0, 1, &spaceMu, false)
+	if err != nil {
+		t.Fatalf("resumed fetch: %v", err)
+	}
+	if got := fetcher.Fetches(); !slices.Equal(got, []string{testDate + "/0@0", testDate + "/0@400"}) {
+		t.Errorf("fetches = %v", got)
+	}
+	if got, _ := os.ReadFile(zipPath); !bytes.Equal(got, data) {
+		t.Errorf("resumed file has %d bytes, want the %d of the original", len(got), len(data))
+	}
+	if _, err := os.Stat(zipPath + ".part"); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+	if f.FileSize != 1000 || f.TSVPath != strings.TrimSuffix(zipPath, ".zip")+".tsv" {
+		t.Errorf("file info = %+v", f)
+	}
+	if rec.Count("bytes_downloaded") == 0 {
+		t.Error("download progress was not recorded")
+	}
+}
+
+func TestFetchSnapshotFileRestartsMismatchedPart(t *testing.T) {
+	ctx, dir := testWorkspace(t)
+	fetcher := &memFetcher{Files: map[string][][]byte{testDate: {snapshotBytes(1000)}}}
+	useImportFakes(t, fetcher, memExtractor{}, nil)
+	zipPath := filepath.Join(dir, testDate+"-notes-00000.zip")
+	if err := os.WriteFile(zipPath+".part", snapshotBytes(2000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fetchSnapshotFile(ctx, testDate, "job", 0, 1, &sync.Mutex{}, false); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if got := fetcher.Fetches(); !slices.Equal(got, []string{testDate + "/0@2000", testDate + "/0@0"}) {
+		t.Errorf("fetches = %v", got)
+	}
+	if info, err := os.Stat(zipPath); err != nil || info.Size() != 1000 {
+		t.Errorf("restarted file: %v, %v", info, err)
+	}
+}
+
+func TestFetchSnapshotFileBadArchive(t *testing.T) {
+	ctx, dir := testWorkspace(t)
+	fetcher := &memFetcher{Files: map[string][][]byte{testDate: {snapshotBytes(10)}}}
+	useImportFakes(t, fetcher, memExtractor{Errors: map[int]error{0: zip.ErrFormat}}, nil)
+
+	_, err := fetchSnapshotFile(ctx, testDate, "job", 0, 1, &sync.Mutex{}, false)
+	if !errors.Is(err, zip.ErrFormat) || !strings.Contains(err.Error(), "failed to extract") {
+		t.Fatalf("got %v, want an extraction error wrapping %v", err, zip.ErrFormat)
+	}
+	if _, err := os.Stat(filepath.Join(dir, testDate+"-notes-00000.zip")); err != nil {
+		t.Errorf("downloaded file not kept in the cache: %v", err)
+	}
+}
+
+func TestPrepareDownloadsRegistersSelectedFiles(t *testing.T) {
+	ctx, _ := testWorkspace(t)
+	today := getDateDaysAgo(0)
+	fetcher := &memFetcher{Files: map[string][][]byte{today: {snapshotBytes(1), snapshotBytes(2), snapshotBytes(3)}}}
+	rec := useImportFakes(t, fetcher, nil, nil)
+
+	indexes, names, sizes, err := prepareDownloads(ctx, today, "job", []int{0, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(indexes, []int{0, 2}) || !slices.Equal(sizes, []int64{1, 3}) {
+		t.Errorf("indexes = %v, sizes = %v", indexes, sizes)
+	}
+	if len(names) != 2 || names[1] != today+"-notes-00002.zip" {
+		t.Errorf("names = %v", names)
+	}
+	if n := rec.Count("INSERT INTO"); n != 2 {
+		t.Errorf("%d files registered, want 2", n)
+	}
+
+	if _, _, _, err := prepareDownloads(ctx, today, "job", []int{5}); err == nil {
+		t.Error("out-of-range selection accepted")
+	}
+	if _, _, _, err := prepareDownloads(ctx, "1999-01-01", "job", nil); err == nil {
+		t.Error("missing snapshot accepted")
+	}
+}
+
+func writeZip(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range entries {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTSV(t *testing.T) {
+	dir := t.TempDir()
+
+	good := filepath.Join(dir, "good.zip")
+	writeZip(t, good, map[string]string{"notes-00003.tsv": "noteId\n1\n"})
+	tsvPath, err := extractTSV(good, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(tsvPath); string(got) != "noteId\n1\n" {
+		t.Errorf("extracted %q", got)
+	}
+
+	wrong := filepath.Join(dir, "wrong.zip")
+	writeZip(t, wrong, map[string]string{"notes-00000.tsv": ""})
+	if _, err := extractTSV(wrong, 3); err == nil || !strings.Contains(err.Error(), "not found in zip") {
+		t.Errorf("missing entry: got %v", err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.zip")
+	os.WriteFile(corrupt, []byte("not a zip"), 0644)
+	if _, err := extractTSV(corrupt, 0); err == nil || !strings.Contains(err.Error(), "failed to open zip") {
+		t.Errorf("corrupt archive: got %v", err)
+	}
+}
+
+// copyWithRetry loads path through noteLoader the way runImport does outside
+// a transaction.
+func copyWithRetry(t *testing.T, path string) (int, int64, error) {
+	t.Helper()
+	var rows int64
+	attempts, err := withRetry(t.Context(), logger, "copy", func() error {
+		var err error
+		rows, err = noteLoader.CopyFile(t.Context(), nil, nil, "note", columnPlan{}, nil, path, 0, false)
+		return err
+	})
+	return attempts, rows, err
+}
+
+func useFastRetries(t *testing.T, maxRetries int) {
+	prevMax, prevBackoff := copyMaxRetries, copyRetryBackoff
+	copyMaxRetries, copyRetryBackoff = maxRetries, time.Millisecond
+	t.Cleanup(func() { copyMaxRetries, copyRetryBackoff = prevMax, prevBackoff })
+}
+
+func TestCopyRetriesTransientErrors(t *testing.T) {
+	useFastRetries(t, 3)
+	path := filepath.Join(t.TempDir(), "notes-00000.tsv")
+	os.WriteFile(path, []byte("noteId\n1\n2\n"), 0644)
+	loader := &fakeLoader{Errors: map[string][]error{path: {&pgconn.PgError{Code: "40P01"}, &pgconn.PgError{Code: "57P01"}}}}
+	useImportFakes(t, nil, nil, loader)
+
+	attempts, rows, err := copyWithRetry(t, path)
+	if err != nil || attempts != 3 || rows != 2 {
+		t.Errorf("attempts = %d, rows = %d, err = %v; want 3, 2, nil", attempts, rows, err)
+	}
+}
+
+func TestCopyFailsOnPermanentError(t *testing.T) {
+	useFastRetries(t, 3)
+	path := filepath.Join(t.TempDir(), "notes-00000.tsv")
+	os.WriteFile(path, []byte("noteId\n1\n"), 0644)
+	badRow := &pgconn.PgError{Code: "22P02"}
+	loader := &fakeLoader{Errors: map[string][]error{path: {badRow}}}
+	useImportFakes(t, nil, nil, loader)
+
+	attempts, _, err := copyWithRetry(t, path)
+	if !errors.Is(err, badRow) || attempts != 1 {
+		t.Errorf("attempts = %d, err = %v; want 1, %v", attempts, err, badRow)
+	}
+	if len(loader.Loaded()) != 0 {
+		t.Errorf("rows recorded despite the failure: %v", loader.Loaded())
+	}
+}
+
+func TestCopyGivesUpAfterMaxRetries(t *testing.T) {
+	useFastRetries(t, 2)
+	path := filepath.Join(t.TempDir(), "notes-00000.tsv")
+	os.WriteFile(path, []byte("noteId\n1\n"), 0644)
+	deadlock := &pgconn.PgError{Code: "40P01"}
+	loader := &fakeLoader{Errors: map[string][]error{path: {deadlock, deadlock, deadlock, deadlock}}}
+	useImportFakes(t, nil, nil, loader)
+
+	attempts, _, err := copyWithRetry(t, path)
+	if !errors.Is(err, deadlock) || attempts != 3 || loader.Attempts(path) != 3 {
+		t.Errorf("attempts = %d (%d loads), err = %v; want 3 and %v", attempts, loader.Attempts(path), err, deadlock)
+	}
+}
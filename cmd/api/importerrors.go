@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxImportErrors caps how many rejected values GET /admin/imports/{job_id}/errors
+// returns; the count in the job log covers the rest.
+const maxImportErrors = 1000
+
+type importJobKey struct{}
+
+func withImportJobID(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, importJobKey{}, jobID)
+}
+
+func importJobIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(importJobKey{}).(string)
+	return id
+}
+
+type ImportError struct {
+	ID        int64     `json:"id"`
+	FileName  string    `json:"file_name"`
+	NoteID    *string   `json:"noteid,omitempty"`
+	Column    string    `json:"column"`
+	Value     *string   `json:"value,omitempty"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// rejectInvalidRows moves the staged rows that the insert's casts would fail
+// on into import_errors, one entry per bad value, so the rest of the file can
+// still load. Outside an import job (benchmarks) they are only dropped.
+func rejectInvalidRows(ctx context.Context, ex execer, plan columnPlan, columnTypes map[string]string, path string) (int64, error) {
+	checks := plan.checks(columnTypes)
+	if len(checks) == 0 {
+		return 0, nil
+	}
+
+	jobID := importJobIDFromContext(ctx)
+	if jobID != "" {
+		noteID := "NULL"
+		for _, c := range plan.Columns {
+			if c.Source == "noteid" {
+				noteID = quoteIdent(c.Source)
+			}
+		}
+		for _, c := range checks {
+			_, err := ex.ExecContext(ctx, expandSQL(ctx, `
+				INSERT INTO {import_errors} (job_id, file_name, noteid, column_name, value, error, created_at)
+				SELECT $1, $2, `+noteID+`, $3, `+quoteIdent(c.Source)+`, $4, NOW()
+				FROM note_import_staging WHERE NOT `+c.Valid), jobID, filepath.Base(path), c.Source, "invalid "+c.Type+" value")
+			if err != nil {
+				return 0, fmt.Errorf("failed to record invalid rows: %w", err)
+			}
+		}
+	}
+
+	valid := make([]string, len(checks))
+	for i, c := range checks {
+		valid[i] = c.Valid
+	}
+	res, err := ex.ExecContext(ctx, `DELETE FROM note_import_staging WHERE NOT (`+strings.Join(valid, " AND ")+`)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to drop invalid rows: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if n > 0 && jobID != "" {
+		jobLogger(ctx, jobID).Warn("Rejected rows with invalid values", "file", filepath.Base(path), "rows", n)
+	}
+	return n, err
+}
+
+func getImportErrors(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	jobID := r.PathValue("job_id")
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT EXISTS (SELECT 1 FROM {import_history} WHERE job_id = $1)`), jobID).Scan(&exists); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import: "+err.Error())
+		return
+	}
+	if !exists {
+		writeProblem(w, http.StatusNotFound, errCodeImportNotFound, "Import job not found")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT id, file_name, noteid, column_name, value, error, created_at
+		FROM {import_errors}
+		WHERE job_id = $1
+		ORDER BY id
+		LIMIT $2
+	`), jobID, maxImportErrors)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import errors: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	errs := []ImportError{}
+	for rows.Next() {
+		var e ImportError
+		var noteID, value sql.NullString
+		if err := rows.Scan(&e.ID, &e.FileName, &noteID, &e.Column, &value, &e.Error, &e.CreatedAt); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to read import errors: "+err.Error())
+			return
+		}
+		e.NoteID = nullStringToStrPtr(noteID)
+		e.Value = nullStringToStrPtr(value)
+		errs = append(errs, e)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to read import errors: "+err.Error())
+		return
+	}
+	writeList(w, r, errs)
+}
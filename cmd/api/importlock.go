@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+// importLock is a session-level advisory lock on the workspace's import
+// tables, held on its own connection for as long as a job runs; the job's load
+// session runs on that same connection. Postgres drops the lock when the
+// connection goes away, so a crashed replica never leaves it behind.
+type importLock struct {
+	conn *sql.Conn
+	key  string
+}
+
+func importLockKey(ctx context.Context) string {
+	return "x-notes-import:" + qualifiedTable(ctx, "import_history")
+}
+
+// tryImportLock returns nil, without error, when another session holds the
+// lock.
+func tryImportLock(ctx context.Context) (*importLock, error) {
+	key := importLockKey(ctx)
+	conn, ok, err := lockConn(ctx, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &importLock{conn: conn, key: key}, nil
+}
+
+func lockConn(ctx context.Context, key string) (*sql.Conn, bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	var ok bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, key).Scan(&ok); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+	return conn, true, nil
+}
+
+// reacquire moves the lock to a fresh connection after the one holding it
+// broke, which released it server-side; it fails if another session took the
+// lock in between.
+func (l *importLock) reacquire(ctx context.Context) error {
+	l.conn.Close()
+	conn, ok, err := lockConn(ctx, l.key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("import lock taken by another session")
+	}
+	l.conn = conn
+	return nil
+}
+
+func (l *importLock) release() {
+	if l == nil || l.conn == nil {
+		return
+	}
+	l.conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, l.key)
+	l.conn.Close()
+	l.conn = nil
+}
+
+// lockImports takes the import lock for a handler about to start a job,
+// answering 409 or 500 itself when it cannot.
+func lockImports(w http.ResponseWriter, ctx context.Context) *importLock {
+	lock, err := tryImportLock(ctx)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to take import lock: "+err.Error())
+		return nil
+	}
+	if lock == nil {
+		writeProblem(w, http.StatusConflict, errCodeImportInProgress, "Import already in progress")
+	}
+	return lock
+}
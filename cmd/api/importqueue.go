@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// QueueEntry is a job that is running or will run in the workspace. Imports
+// run one at a time and an import requested while one is in flight is
+// refused rather than queued, so the queue is the job in flight followed by
+// the scheduler's and poller's next attempts; there are no priorities.
+type QueueEntry struct {
+	Position              int          `json:"position"`
+	Kind                  string       `json:"kind"`
+	JobID                 *string      `json:"job_id,omitempty"`
+	Status                *string      `json:"status,omitempty"`
+	TriggeredBy           string       `json:"triggered_by"`
+	Options               QueueOptions `json:"options"`
+	Conditional           bool         `json:"conditional"`
+	StartedAt             *time.Time   `json:"started_at,omitempty"`
+	EstimatedStartAt      *time.Time   `json:"estimated_start_at,omitempty"`
+	EstimatedCompletionAt *time.Time   `json:"estimated_completion_at,omitempty"`
+}
+
+type QueueOptions struct {
+	Mode      string   `json:"mode"`
+	Offline   bool     `json:"offline"`
+	FileRange *string  `json:"file_range,omitempty"`
+	Limit     *int     `json:"limit,omitempty"`
+	Labels    []string `json:"labels"`
+}
+
+const (
+	queueKindCurrent   = "current"
+	queueKindScheduled = "scheduled"
+	queueKindPoll      = "poll"
+)
+
+// typicalImportDuration is the median run time of the last completed imports,
+// 0 when there are none.
+func typicalImportDuration(ctx context.Context) time.Duration {
+	var secs float64
+	db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM completed_at - started_at)), 0)
+		FROM (SELECT started_at, completed_at FROM {import_history}
+		      WHERE status IN ('completed', 'completed_with_warnings') AND completed_at IS NOT NULL
+		      ORDER BY completed_at DESC LIMIT 10) recent
+	`)).Scan(&secs)
+	return time.Duration(secs * float64(time.Second))
+}
+
+// getImportQueue lists the import in flight and the scheduled and polled
+// attempts after it, with when each is expected to start: an attempt that
+// falls before the running import is expected to finish is refused, so it is
+// pushed to the first attempt after that. Nothing after a paused import has
+// an estimate since it holds the workspace until resumed or aborted.
+func getImportQueue(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ws := workspaceFromContext(ctx)
+
+	h, err := currentImport(ctx)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import: "+err.Error())
+		return
+	}
+	typical := typicalImportDuration(ctx)
+
+	queue := []QueueEntry{}
+	var busyUntil *time.Time
+	blocked := false
+	if h != nil {
+		if h.Files, err = getImportFiles(ctx, h.JobID); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import files: "+err.Error())
+			return
+		}
+		computeOverallProgress(h)
+		e := QueueEntry{
+			Kind:                  queueKindCurrent,
+			JobID:                 &h.JobID,
+			Status:                &h.Status,
+			Options:               QueueOptions{Mode: h.Mode, Offline: h.Offline, FileRange: h.FileRange, Limit: h.Limit, Labels: h.Labels},
+			StartedAt:             &h.StartedAt,
+			EstimatedStartAt:      &h.StartedAt,
+			EstimatedCompletionAt: h.EstimatedCompletionAt,
+		}
+		if h.TriggeredBy != nil {
+			e.TriggeredBy = *h.TriggeredBy
+		}
+		if e.EstimatedCompletionAt == nil && h.Status != "paused" && typical > 0 {
+			eta := h.StartedAt.Add(typical)
+			e.EstimatedCompletionAt = &eta
+		}
+		busyUntil = e.EstimatedCompletionAt
+		blocked = h.Status == "paused" || busyUntil == nil
+		queue = append(queue, e)
+	}
+
+	estimate := func(at time.Time, every time.Duration) (*time.Time, *time.Time) {
+		if blocked {
+			return nil, nil
+		}
+		for busyUntil != nil && at.Before(*busyUntil) && every > 0 {
+			at = at.Add(every)
+		}
+		if typical == 0 {
+			return &at, nil
+		}
+		done := at.Add(typical)
+		return &at, &done
+	}
+
+	ws.scheduler.mu.RLock()
+	nextRun, nextPoll := ws.scheduler.nextRun, ws.scheduler.nextPoll
+	ws.scheduler.mu.RUnlock()
+
+	scheduled := QueueOptions{Mode: loadModeTruncate, Labels: []string{}}
+	var upcoming []QueueEntry
+	if autoImportEnabled && !nextRun.IsZero() {
+		e := QueueEntry{Kind: queueKindScheduled, TriggeredBy: triggerSchedule, Options: scheduled, Conditional: true}
+		e.EstimatedStartAt, e.EstimatedCompletionAt = estimate(nextRun, ws.Interval)
+		upcoming = append(upcoming, e)
+	}
+	if upstreamPollEnabled && !nextPoll.IsZero() {
+		e := QueueEntry{Kind: queueKindPoll, TriggeredBy: triggerSchedule, Options: scheduled, Conditional: true}
+		e.EstimatedStartAt, e.EstimatedCompletionAt = estimate(nextPoll, upstreamPollInterval)
+		upcoming = append(upcoming, e)
+	}
+	slices.SortStableFunc(upcoming, func(a, b QueueEntry) int {
+		if a.EstimatedStartAt == nil || b.EstimatedStartAt == nil {
+			return 0
+		}
+		return a.EstimatedStartAt.Compare(*b.EstimatedStartAt)
+	})
+	queue = append(queue, upcoming...)
+	for i := range queue {
+		queue[i].Position = i
+	}
+
+	writeList(w, r, queue)
+}
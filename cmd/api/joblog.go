@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type jobLogRecord struct {
+	jobID     string
+	workspace string
+	time      time.Time
+	level     string
+	message   string
+	attrs     map[string]string
+}
+
+// jobLogsMu guards sending on jobLogs against flushJobLogs closing it, so
+// that records logged after the flush are only written to the main log.
+var (
+	jobLogs        = make(chan jobLogRecord, 1000)
+	jobLogsDrained = make(chan struct{})
+	jobLogsMu      sync.RWMutex
+	jobLogsClosed  bool
+)
+
+type jobLogHandler struct {
+	slog.Handler
+	jobID     string
+	workspace string
+}
+
+func newJobLogHandler(next slog.Handler) *jobLogHandler {
+	return &jobLogHandler{Handler: next}
+}
+
+func (h *jobLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	jobID, workspace := h.jobID, h.workspace
+	attrs := make(map[string]string)
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "job_id":
+			jobID = a.Value.String()
+		case "workspace":
+			workspace = a.Value.String()
+		default:
+			attrs[a.Key] = a.Value.String()
+		}
+		return true
+	})
+
+	if jobID != "" {
+		jobLogsMu.RLock()
+		if !jobLogsClosed {
+			select {
+			case jobLogs <- jobLogRecord{jobID: jobID, workspace: workspace, time: r.Time, level: r.Level.String(), message: r.Message, attrs: attrs}:
+			default:
+			}
+		}
+		jobLogsMu.RUnlock()
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *jobLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	jobID, workspace := h.jobID, h.workspace
+	for _, a := range attrs {
+		switch a.Key {
+		case "job_id":
+			jobID = a.Value.String()
+		case "workspace":
+			workspace = a.Value.String()
+		}
+	}
+	return &jobLogHandler{Handler: h.Handler.WithAttrs(attrs), jobID: jobID, workspace: workspace}
+}
+
+func (h *jobLogHandler) WithGroup(name string) slog.Handler {
+	return &jobLogHandler{Handler: h.Handler.WithGroup(name), jobID: h.jobID, workspace: h.workspace}
+}
+
+func startJobLogWriter() {
+	go func() {
+		for rec := range jobLogs {
+			attrs, _ := json.Marshal(rec.attrs)
+			ws, ok := workspaces[rec.workspace]
+			if !ok {
+				ws = defaultWorkspace
+			}
+			ctx := withWorkspace(context.Background(), ws)
+			db.ExecContext(ctx, expandSQL(ctx, `
+				INSERT INTO {import_logs} (job_id, logged_at, level, message, attrs)
+				VALUES ($1, $2, $3, $4, $5)
+			`), rec.jobID, rec.time, rec.level, rec.message, string(attrs))
+		}
+		close(jobLogsDrained)
+	}()
+}
+
+// flushJobLogs stops accepting job log records and waits for the queued ones
+// to be written.
+func flushJobLogs(timeout time.Duration) {
+	jobLogsMu.Lock()
+	if jobLogsClosed {
+		jobLogsMu.Unlock()
+		return
+	}
+	jobLogsClosed = true
+	close(jobLogs)
+	jobLogsMu.Unlock()
+	select {
+	case <-jobLogsDrained:
+	case <-time.After(timeout):
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	kafkaBrokers           = getEnv("KAFKA_BROKERS", "")
+	kafkaTopic             = getEnv("KAFKA_TOPIC", "x-notes.notes")
+	kafkaSerialization     = getEnv("KAFKA_SERIALIZATION", "json")
+	kafkaSchemaRegistryURL = strings.TrimSuffix(getEnv("KAFKA_SCHEMA_REGISTRY_URL", ""), "/")
+)
+
+const noteEventAvroSchema = `{
+	"type": "record",
+	"name": "NoteEvent",
+	"namespace": "io.xnotes",
+	"fields": [
+		{"name": "type", "type": "string"},
+		{"name": "workspace", "type": ["null", "string"], "default": null},
+		{"name": "job_id", "type": "string"},
+		{"name": "note_id", "type": ["null", "long"], "default": null},
+		{"name": "data_date", "type": ["null", "string"], "default": null},
+		{"name": "emitted_at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "note", "type": ["null", {"type": "map", "values": ["null", "long", "double", "string", "boolean"]}], "default": null},
+		{"name": "rows", "type": ["null", "long"], "default": null},
+		{"name": "error", "type": ["null", "string"], "default": null},
+		{"name": "error_code", "type": ["null", "string"], "default": null}
+	]
+}`
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+	encode func(event) ([]byte, error)
+}
+
+func newKafkaPublisher() (*kafkaPublisher, error) {
+	if kafkaBrokers == "" {
+		return nil, fmt.Errorf("KAFKA_BROKERS is required when EVENTS_BACKEND=kafka")
+	}
+
+	p := &kafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(strings.Split(kafkaBrokers, ",")...),
+			Topic:                  kafkaTopic,
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+			BatchSize:              noteEventsBatchSize,
+			BatchTimeout:           100 * time.Millisecond,
+		},
+	}
+
+	switch kafkaSerialization {
+	case "json":
+		p.encode = func(e event) ([]byte, error) { return json.Marshal(e) }
+	case "avro":
+		encode, err := newAvroEncoder()
+		if err != nil {
+			return nil, err
+		}
+		p.encode = encode
+	default:
+		return nil, fmt.Errorf("unknown KAFKA_SERIALIZATION %q", kafkaSerialization)
+	}
+
+	return p, nil
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, events []event) error {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, e := range events {
+		value, err := p.encode(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s event: %w", e.Type, err)
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:     []byte(e.key()),
+			Value:   value,
+			Headers: []kafka.Header{{Key: "event-type", Value: []byte(e.Type)}},
+		})
+	}
+	return p.writer.WriteMessages(ctx, msgs...)
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// newAvroEncoder encodes events as Avro binary. With a schema registry the
+// payload uses the Confluent wire format (magic byte + schema id prefix).
+func newAvroEncoder() (func(event) ([]byte, error), error) {
+	codec, err := goavro.NewCodec(noteEventAvroSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile Avro schema: %w", err)
+	}
+
+	var prefix []byte
+	if kafkaSchemaRegistryURL != "" {
+		id, err := registerAvroSchema(kafkaTopic+"-value", codec.CanonicalSchema())
+		if err != nil {
+			return nil, err
+		}
+		prefix = binary.BigEndian.AppendUint32([]byte{0}, uint32(id))
+	}
+
+	return func(e event) ([]byte, error) {
+		return codec.BinaryFromNative(bytes.Clone(prefix), avroNative(e))
+	}, nil
+}
+
+func avroNative(e event) map[string]any {
+	native := map[string]any{
+		"type":       e.Type,
+		"workspace":  nil,
+		"job_id":     e.JobID,
+		"note_id":    nil,
+		"data_date":  nil,
+		"emitted_at": e.EmittedAt,
+		"note":       nil,
+		"rows":       nil,
+		"error":      nil,
+		"error_code": nil,
+	}
+	if e.Workspace != "" {
+		native["workspace"] = goavro.Union("string", e.Workspace)
+	}
+	if e.NoteID != nil {
+		native["note_id"] = goavro.Union("long", *e.NoteID)
+	}
+	if e.DataDate != "" {
+		native["data_date"] = goavro.Union("string", e.DataDate)
+	}
+	if e.Rows != nil {
+		native["rows"] = goavro.Union("long", int64(*e.Rows))
+	}
+	if e.Error != "" {
+		native["error"] = goavro.Union("string", e.Error)
+	}
+	if e.ErrorCode != "" {
+		native["error_code"] = goavro.Union("string", e.ErrorCode)
+	}
+	if e.Note != nil {
+		note := make(map[string]any, len(e.Note))
+		for k, v := range e.Note {
+			note[k] = avroValue(v)
+		}
+		native["note"] = goavro.Union("map", note)
+	}
+	return native
+}
+
+func avroValue(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return goavro.Union("long", i)
+		}
+		f, _ := v.Float64()
+		return goavro.Union("double", f)
+	case bool:
+		return goavro.Union("boolean", v)
+	case string:
+		return goavro.Union("string", v)
+	default:
+		b, _ := json.Marshal(v)
+		return goavro.Union("string", string(b))
+	}
+}
+
+func registerAvroSchema(subject, schema string) (int, error) {
+	body, _ := json.Marshal(map[string]string{"schema": schema})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", kafkaSchemaRegistryURL+"/subjects/"+subject+"/versions", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register Avro schema: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to register Avro schema: status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode schema registry response: %w", err)
+	}
+	return result.ID, nil
+}
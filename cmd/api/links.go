@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const noteURLPrefix = "https://x.com/i/birdwatch/n/"
+
+// NoteLinks gathers the URLs a UI shows for a note, so that clients need not
+// know the X URL forms or the snapshot layout.
+type NoteLinks struct {
+	NoteID        int64          `json:"note_id"`
+	TweetID       string         `json:"tweet_id"`
+	NoteURL       string         `json:"note_url"`
+	TweetURL      *string        `json:"tweet_url"`
+	SnapshotDate  *string        `json:"snapshot_date,omitempty"`
+	SnapshotFiles []SnapshotLink `json:"snapshot_files"`
+}
+
+// SnapshotLink is one file of the snapshot the dataset was loaded from:
+// where upstream publishes it and, while this instance still caches it, where
+// /cache serves it in the same layout.
+type SnapshotLink struct {
+	Index       int     `json:"index"`
+	UpstreamURL string  `json:"upstream_url"`
+	MirrorURL   *string `json:"mirror_url,omitempty"`
+}
+
+// getNoteLinks returns a note's permalinks along with the snapshot files of
+// the last completed import. The dataset does not record which file a note
+// came from, so every file of that snapshot is listed.
+func getNoteLinks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	noteID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Note ID must be an integer")
+		return
+	}
+
+	links := NoteLinks{NoteID: noteID, SnapshotFiles: []SnapshotLink{}}
+	var tweetURL sql.NullString
+	err = db.QueryRowContext(ctx, expandSQL(ctx, `SELECT tweetid, tweet_url FROM {note} n WHERE noteid = $1 AND `+notExcluded("n")), noteID).Scan(&links.TweetID, &tweetURL)
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeNoteNotFound, "Note not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get note: "+err.Error())
+		return
+	}
+	links.NoteURL = noteURLPrefix + strconv.FormatInt(noteID, 10)
+	links.TweetURL = nullStringToStrPtr(tweetURL)
+
+	var jobID string
+	var dataDate sql.NullTime
+	err = db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT job_id, data_date FROM {import_history}
+		WHERE status IN ('completed', 'completed_with_warnings') AND data_date IS NOT NULL
+		ORDER BY completed_at DESC LIMIT 1
+	`)).Scan(&jobID, &dataDate)
+	if err != nil && err != sql.ErrNoRows {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get snapshot: "+err.Error())
+		return
+	}
+	if dataDate.Valid {
+		date := dataDate.Time.Format("2006-01-02")
+		links.SnapshotDate = &date
+
+		files, err := getImportFiles(ctx, jobID)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import files: "+err.Error())
+			return
+		}
+		dir := workspaceFromContext(ctx).dataDir()
+		for _, f := range files {
+			l := SnapshotLink{Index: f.Index, UpstreamURL: snapshotURL(date, f.Index)}
+			zipName := date + "-" + formatFileName(f.Index) + ".zip"
+			mirrorName := zipName
+			if _, err := os.Stat(filepath.Join(dir, zipName)); err != nil {
+				mirrorName = filepath.Base(compressedCachePath(filepath.Join(dir, zipName)))
+				if _, err := os.Stat(filepath.Join(dir, mirrorName)); err != nil {
+					mirrorName = ""
+				}
+			}
+			if mirrorName != "" {
+				u := "/cache/" + formatDateForURL(date) + "/notes/" + strings.TrimPrefix(mirrorName, date+"-")
+				l.MirrorURL = &u
+			}
+			links.SnapshotFiles = append(links.SnapshotFiles, l)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+var (
+	loadSessionSettings = parseSessionSettings(getEnv("LOAD_SESSION_SETTINGS", "synchronous_commit=off,maintenance_work_mem=512MB,statement_timeout=0,lock_timeout=60s"))
+	transactionalLoad   = getEnvBool("TRANSACTIONAL_LOAD", false)
+	copyFromStdin       = getEnvBool("COPY_FROM_STDIN", false)
+)
+
+type sessionSetting struct {
+	Name  string
+	Value string
+}
+
+func parseSessionSettings(s string) []sessionSetting {
+	var settings []sessionSetting
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			continue
+		}
+		settings = append(settings, sessionSetting{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+	}
+	return settings
+}
+
+// loadSession pins one pooled connection for COPY and index builds so that
+// bulk-load settings apply to that session only and are reset before the
+// connection goes back to the pool. An import runs it on the connection that
+// holds its import lock rather than taking a second one. Its backend PID
+// identifies its rows in the pg_stat_progress_* views, which may list other
+// sessions' COPYs too.
+type loadSession struct {
+	conn       *sql.Conn
+	lock       *importLock
+	pid        atomic.Int32
+	rowsCopied atomic.Int64
+}
+
+// openLoadSession borrows lock's connection when lock is non-nil, and
+// acquires one of its own otherwise.
+func openLoadSession(ctx context.Context, lock *importLock) (*loadSession, error) {
+	s := &loadSession{lock: lock}
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *loadSession) connect(ctx context.Context) error {
+	var conn *sql.Conn
+	if s.lock != nil {
+		conn = s.lock.conn
+	} else {
+		var err error
+		if conn, err = db.Conn(ctx); err != nil {
+			return fmt.Errorf("failed to acquire connection: %w", err)
+		}
+	}
+	for _, setting := range loadSessionSettings {
+		if _, err := conn.ExecContext(ctx, `SELECT set_config($1, $2, false)`, setting.Name, setting.Value); err != nil {
+			s.closeConn(conn)
+			return fmt.Errorf("failed to set %s: %w", setting.Name, err)
+		}
+	}
+	var pid int32
+	if err := conn.QueryRowContext(ctx, `SELECT pg_backend_pid()`).Scan(&pid); err != nil {
+		s.closeConn(conn)
+		return fmt.Errorf("failed to get backend pid: %w", err)
+	}
+	s.conn = conn
+	s.pid.Store(pid)
+	return nil
+}
+
+// closeConn closes conn unless it belongs to the import lock, which closes it
+// on release.
+func (s *loadSession) closeConn(conn *sql.Conn) {
+	if s.lock == nil {
+		conn.Close()
+	}
+}
+
+// copyFrom streams the file at path through stmt, a COPY ... FROM STDIN, on the
+// session connection, counting rows client-side as they are sent.
+func (s *loadSession) copyFrom(ctx context.Context, stmt, path string, limit int, plan columnPlan) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s.rowsCopied.Store(0)
+	var src io.Reader = f
+	if limit > 0 {
+		src = &lineLimiter{r: f, left: int64(limit) + 1}
+	}
+	if plan.transformsRows() {
+		tr := plan.transformReader(src)
+		defer tr.Close()
+		src = tr
+	}
+	r := &lineCounter{r: src, lines: &s.rowsCopied}
+
+	var rows int64
+	err = s.conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		tag, err := c.Conn().PgConn().CopyFrom(ctx, r, stmt)
+		rows = tag.RowsAffected()
+		return err
+	})
+	return rows, err
+}
+
+// copyProgress reports the rows the current COPY on this session has
+// processed: counted client-side when streaming, else read from
+// pg_stat_progress_copy by backend PID.
+func (s *loadSession) copyProgress(ctx context.Context) (int, error) {
+	if copyFromStdin {
+		return int(max(s.rowsCopied.Load()-1, 0)), nil
+	}
+	var tuples int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(tuples_processed, 0) FROM pg_stat_progress_copy WHERE pid = $1`, s.pid.Load()).Scan(&tuples)
+	return tuples, err
+}
+
+// lineCounter counts newlines read through it; with a header line, the count
+// minus one is the number of rows sent so far.
+type lineCounter struct {
+	r     io.Reader
+	lines *atomic.Int64
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.lines.Add(int64(bytes.Count(p[:n], []byte{'\n'})))
+	return n, err
+}
+
+// lineLimiter ends the stream after its first left lines, the header and the
+// rows a limited import loads, leaving the file itself untouched.
+type lineLimiter struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *lineLimiter) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		return 0, io.EOF
+	}
+	n, err := l.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			if l.left--; l.left == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return n, err
+}
+
+// renew replaces a broken session connection; on a borrowed one it moves the
+// import lock to the new connection too.
+func (s *loadSession) renew(ctx context.Context) error {
+	if s.lock != nil {
+		if err := s.lock.reacquire(ctx); err != nil {
+			return err
+		}
+	} else {
+		s.conn.Close()
+	}
+	return s.connect(ctx)
+}
+
+func (s *loadSession) Close() {
+	if s.conn == nil {
+		return
+	}
+	ctx := context.Background()
+	for _, setting := range loadSessionSettings {
+		s.conn.ExecContext(ctx, `SELECT set_config($1, reset_val, false) FROM pg_settings WHERE name = $1`, setting.Name)
+	}
+	s.closeConn(s.conn)
+	s.conn = nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+var unloggedLoad = getEnvBool("UNLOGGED_LOAD", false)
+
+const loadTable = "note_load"
+
+type noteIndex struct {
+	Name       string
+	Definition string
+}
+
+var noteIndexes = []noteIndex{
+	{"idx3yl33mmhbcw582lic7c7fqqu4", "USING btree (created_at)"},
+	{"idxovqwtw36x36lo9smq4lbxjcps", "USING btree (noteauthorparticipantid)"},
+	{"idxu0f5st3d4b4c55eh9kqyd3yk", "USING btree (tweet_id)"},
+	{"ts_idx", "USING gin (summary_ts)"},
+}
+
+// derivedNoteColumnsSQL lists note's generated columns as a text[] literal, to
+// leave them out of note documents: they only restate the loaded columns.
+const derivedNoteColumnsSQL = `ARRAY['summary_ts', 'tweet_id', 'tweet_url', 'created_date', 'is_media_note', 'is_collaborative_note', 'has_trustworthy_sources']`
+
+func dropNoteIndexesSQL(ctx context.Context) string {
+	names := make([]string, len(noteIndexes))
+	for i, idx := range noteIndexes {
+		names[i] = expandSQL(ctx, `{schema}.{prefix}`+idx.Name)
+	}
+	return `DROP INDEX IF EXISTS ` + strings.Join(names, ", ")
+}
+
+func createIndexSQL(ctx context.Context, idx noteIndex, table, suffix string) string {
+	return fmt.Sprintf(`CREATE INDEX %s%s%s ON %s %s`, tablePrefix, idx.Name, suffix, qualifiedTable(ctx, table), idx.Definition)
+}
+
+// prepareLoadTable creates the UNLOGGED shadow of note that an UNLOGGED_LOAD
+// import copies into. A shadow left by an interrupted run of the same job is
+// kept when resuming so file checkpoints stay valid; it reports whether the
+// table was (re)created empty.
+func prepareLoadTable(ctx context.Context, ex execer, resuming bool) (bool, error) {
+	if resuming {
+		var exists bool
+		db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, qualifiedTable(ctx, loadTable)).Scan(&exists)
+		if exists {
+			return false, nil
+		}
+	}
+
+	if _, err := ex.ExecContext(ctx, expandSQL(ctx, `DROP TABLE IF EXISTS {note_load}`)); err != nil {
+		return false, fmt.Errorf("failed to drop %s: %w", tableName(loadTable), err)
+	}
+	if _, err := ex.ExecContext(ctx, expandSQL(ctx, `CREATE UNLOGGED TABLE {note_load} (LIKE {note} INCLUDING DEFAULTS INCLUDING GENERATED INCLUDING CONSTRAINTS)`)); err != nil {
+		return false, fmt.Errorf("failed to create %s: %w", tableName(loadTable), err)
+	}
+	return true, nil
+}
+
+// swapLoadTable makes the fully loaded and indexed shadow table durable and
+// atomically replaces note with it, renaming constraints and indexes back to
+// the names the rest of the code expects.
+func swapLoadTable(ctx context.Context, ex execer) error {
+	if _, err := ex.ExecContext(ctx, expandSQL(ctx, `ALTER TABLE {note_load} ADD CONSTRAINT {prefix}note_load_pkey PRIMARY KEY (noteid)`)); err != nil {
+		return fmt.Errorf("failed to add primary key: %w", err)
+	}
+	if _, err := ex.ExecContext(ctx, expandSQL(ctx, `ALTER TABLE {note_load} SET LOGGED`)); err != nil {
+		return fmt.Errorf("failed to set %s logged: %w", tableName(loadTable), err)
+	}
+
+	stmts := []string{
+		expandSQL(ctx, `DROP TABLE {note}`),
+		expandSQL(ctx, `ALTER TABLE {note_load} RENAME TO {prefix}note`),
+		expandSQL(ctx, `ALTER TABLE {note} RENAME CONSTRAINT {prefix}note_load_pkey TO {prefix}note_pkey`),
+	}
+	for _, idx := range noteIndexes {
+		stmts = append(stmts, expandSQL(ctx, fmt.Sprintf(`ALTER INDEX {schema}.{prefix}%s_load RENAME TO {prefix}%s`, idx.Name, idx.Name)))
+	}
+
+	if err := inTransaction(ctx, ex, stmts); err != nil {
+		return fmt.Errorf("failed to swap tables: %w", err)
+	}
+
+	ex.ExecContext(ctx, `NOTIFY pgrst, 'reload schema'`)
+	return nil
+}
+
+var rollbackOnFailure = getEnvBool("ROLLBACK_ON_FAILURE", true)
+
+const previousTable = "note_previous"
+
+// renameNoteSQL moves a note table with its primary key and indexes from one
+// name to another; suffixes are appended to note and its index names.
+func renameNoteSQL(ctx context.Context, from, to string) []string {
+	stmts := []string{
+		expandSQL(ctx, fmt.Sprintf(`ALTER TABLE {note%s} RENAME TO {prefix}note%s`, from, to)),
+		expandSQL(ctx, fmt.Sprintf(`ALTER TABLE {note%s} RENAME CONSTRAINT {prefix}note%s_pkey TO {prefix}note%s_pkey`, to, from, to)),
+	}
+	for _, idx := range noteIndexes {
+		stmts = append(stmts, expandSQL(ctx, fmt.Sprintf(`ALTER INDEX IF EXISTS {schema}.{prefix}%s%s RENAME TO {prefix}%s%s`, idx.Name, from, idx.Name, to)))
+	}
+	return stmts
+}
+
+// inTransaction runs stmts on ex between BEGIN and COMMIT; ex is a pinned
+// connection, so the statements share the transaction.
+func inTransaction(ctx context.Context, ex execer, stmts []string) error {
+	if _, err := ex.ExecContext(ctx, `BEGIN`); err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if _, err := ex.ExecContext(ctx, stmt); err != nil {
+			ex.ExecContext(ctx, `ROLLBACK`)
+			return err
+		}
+	}
+	_, err := ex.ExecContext(ctx, `COMMIT`)
+	return err
+}
+
+// preserveNote sets the current note aside as note_previous, indexes and all,
+// and puts an empty note in its place for a direct load to fill; a leftover
+// note_previous from an earlier run is dropped first.
+func preserveNote(ctx context.Context, ex execer) error {
+	stmts := []string{expandSQL(ctx, `DROP TABLE IF EXISTS {note_previous}`)}
+	stmts = append(stmts, renameNoteSQL(ctx, "", "_previous")...)
+	stmts = append(stmts,
+		expandSQL(ctx, `CREATE TABLE {note} (LIKE {note_previous} INCLUDING DEFAULTS INCLUDING GENERATED INCLUDING CONSTRAINTS)`),
+		expandSQL(ctx, `ALTER TABLE {note} ADD CONSTRAINT {prefix}note_pkey PRIMARY KEY (noteid)`),
+	)
+	if err := inTransaction(ctx, ex, stmts); err != nil {
+		return fmt.Errorf("failed to set aside previous dataset: %w", err)
+	}
+	ex.ExecContext(ctx, `NOTIFY pgrst, 'reload schema'`)
+	return nil
+}
+
+func previousNoteExists(ctx context.Context) bool {
+	var exists bool
+	db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, qualifiedTable(ctx, previousTable)).Scan(&exists)
+	return exists
+}
+
+// restorePreviousNote puts note_previous back in place of a partially loaded
+// note; it is a no-op when there is nothing set aside.
+func restorePreviousNote(ctx context.Context) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return restorePreviousNoteOn(ctx, conn)
+}
+
+// restorePreviousNoteOn is restorePreviousNote on a connection the caller
+// already holds, such as a failed job's load session.
+func restorePreviousNoteOn(ctx context.Context, ex execer) error {
+	if !previousNoteExists(ctx) {
+		return nil
+	}
+	stmts := []string{expandSQL(ctx, `DROP TABLE {note}`)}
+	stmts = append(stmts, renameNoteSQL(ctx, "_previous", "")...)
+	if err := inTransaction(ctx, ex, stmts); err != nil {
+		return fmt.Errorf("failed to restore previous dataset: %w", err)
+	}
+	ex.ExecContext(ctx, `NOTIFY pgrst, 'reload schema'`)
+	return nil
+}
+
+func dropPreviousNote(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, expandSQL(ctx, `DROP TABLE IF EXISTS {note_previous}`))
+	return err
+}
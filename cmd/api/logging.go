@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+var (
+	logLevelSetting = getEnv("LOG_LEVEL", "info")
+	logLevel        = new(slog.LevelVar)
+)
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return level, err
+}
+
+// reloadConfig reopens log files, puts the log level back to LOG_LEVEL,
+// undoing any runtime override, and re-reads COLUMN_MAPPING_FILE; a mapping
+// that fails to load leaves the current one in place.
+func reloadConfig() error {
+	reopenLogFiles()
+	if level, err := parseLogLevel(logLevelSetting); err == nil {
+		logLevel.Set(level)
+	}
+	if err := loadColumnMapping(); err != nil {
+		logger.Error("Config reload failed", "error", err)
+		return err
+	}
+	logger.Info("Config reloaded", "log_level", logLevel.Level().String())
+	return nil
+}
+
+func watchReloadSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			logger.Info("Received SIGHUP, reloading config")
+			reloadConfig()
+		}
+	}()
+}
+
+func getLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"level": strings.ToLower(logLevel.Level().String())})
+}
+
+func putLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	level, err := parseLogLevel(req.Level)
+	if err != nil || req.Level == "" {
+		writeFieldProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid log level", []FieldError{{Field: "level", Detail: "must be debug, info, warn or error"}})
+		return
+	}
+
+	previous := logLevel.Level()
+	logLevel.Set(level)
+	logger.Warn("Log level changed", "from", previous.String(), "to", level.String())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"level": strings.ToLower(level.String())})
+}
+
+func postConfigReload(w http.ResponseWriter, r *http.Request) {
+	if err := reloadConfig(); err != nil {
+		writeProblem(w, http.StatusUnprocessableEntity, errCodeInvalidRequest, "Config reload failed: "+err.Error())
+		return
+	}
+	getLogLevel(w, r)
+}
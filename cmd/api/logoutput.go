@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	logFormat      = getEnv("LOG_FORMAT", "json")
+	logOutput      = getEnv("LOG_OUTPUT", "stdout")
+	logErrorOutput = getEnv("LOG_ERROR_OUTPUT", "")
+	logMaxSize     = getEnv("LOG_MAX_SIZE", "100MB")
+	logMaxFiles    = getEnvInt("LOG_MAX_FILES", 5)
+)
+
+// logFiles are the file outputs in use, reopened on config reload so that an
+// external logrotate can move them away.
+var logFiles []*rotatingFile
+
+// newLogHandler builds the process handler from LOG_FORMAT, LOG_OUTPUT and
+// LOG_ERROR_OUTPUT; outputs other than stdout and stderr are file paths,
+// rotated at LOG_MAX_SIZE.
+func newLogHandler() (slog.Handler, error) {
+	if logFormat != "json" && logFormat != "text" {
+		return nil, fmt.Errorf("LOG_FORMAT must be json or text, got %q", logFormat)
+	}
+	maxSize, err := parseByteSize(logMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("LOG_MAX_SIZE: %w", err)
+	}
+
+	out, err := openLogOutput(logOutput, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	h := newFormatHandler(out)
+	if logErrorOutput == "" || logErrorOutput == logOutput {
+		return h, nil
+	}
+
+	errOut, err := openLogOutput(logErrorOutput, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return &splitHandler{out: h, errs: newFormatHandler(errOut)}, nil
+}
+
+func newFormatHandler(w io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if logFormat == "text" {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+func openLogOutput(name string, maxSize int64) (io.Writer, error) {
+	switch name {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	f := &rotatingFile{path: name, maxSize: maxSize, maxFiles: logMaxFiles}
+	if err := f.open(); err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	logFiles = append(logFiles, f)
+	return f, nil
+}
+
+func reopenLogFiles() {
+	for _, f := range logFiles {
+		f.reopen()
+	}
+}
+
+// splitHandler sends error records to errs and everything else to out.
+type splitHandler struct {
+	out, errs slog.Handler
+}
+
+func (h *splitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level >= slog.LevelError {
+		return h.errs.Enabled(ctx, level)
+	}
+	return h.out.Enabled(ctx, level)
+}
+
+func (h *splitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		return h.errs.Handle(ctx, r)
+	}
+	return h.out.Handle(ctx, r)
+}
+
+func (h *splitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &splitHandler{out: h.out.WithAttrs(attrs), errs: h.errs.WithAttrs(attrs)}
+}
+
+func (h *splitHandler) WithGroup(name string) slog.Handler {
+	return &splitHandler{out: h.out.WithGroup(name), errs: h.errs.WithGroup(name)}
+}
+
+// rotatingFile appends to path and, once a write would take it past maxSize,
+// shifts path.1..path.{maxFiles-1} up by one and starts a new file.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	r.f = nil
+	if r.maxFiles <= 1 {
+		os.Remove(r.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles-1))
+		for i := r.maxFiles - 2; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		os.Rename(r.path, r.path+".1")
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) reopen() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	if err := r.open(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reopen log file %s: %v\n", r.path, err)
+	}
+}
@@ -3,13 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
-
-	_ "github.com/lib/pq"
 )
 
 var (
@@ -18,19 +19,30 @@ var (
 	autoImportEnabled     = getEnvBool("AUTO_IMPORT_ENABLED", true)
 	autoImportInterval    = getEnvDuration("AUTO_IMPORT_INTERVAL", time.Hour)
 	adminControlsDisabled = getEnvBool("ADMIN_CONTROLS_DISABLED", false)
+	importOnEmpty         = getEnvBool("IMPORT_ON_EMPTY", false)
 )
 
-type schedulerState struct {
-	mu        sync.RWMutex
-	lastCheck time.Time
-	nextRun   time.Time
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		return value == "true" || value == "1"
+	}
+	return defaultValue
 }
 
-var scheduler = &schedulerState{}
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
 
-func getEnvBool(key string, defaultValue bool) bool {
+func getEnvFloat(key string, defaultValue float64) float64 {
 	if value := os.Getenv(key); value != "" {
-		return value == "true" || value == "1"
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
 	}
 	return defaultValue
 }
@@ -50,15 +62,79 @@ func startAutoImporter() {
 		return
 	}
 
-	checkAndImport := func() {
-		ctx := context.Background()
+	for _, ws := range workspaceOrder {
+		startWorkspaceScheduler(ws)
+	}
+}
+
+// triggerImport starts an import in ws through the API, as the scheduler does,
+// and returns the response status.
+func triggerImport(ctx context.Context, ws *workspace, triggeredBy string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://127.0.0.1:"+port+"/admin/imports", strings.NewReader(`{"triggered_by":"`+triggeredBy+`"}`))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-API-Key", internalAPIKey)
+	req.Header.Set("X-Workspace", ws.Name)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// startInitialImports imports into every workspace whose note table is empty
+// and has no import in flight, so a fresh deployment needs no manual POST.
+func startInitialImports() {
+	if !importOnEmpty {
+		return
+	}
+
+	for _, ws := range workspaceOrder {
+		ctx := withWorkspace(context.Background(), ws)
+		var hasNotes bool
+		if err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT EXISTS (SELECT 1 FROM {note})`)).Scan(&hasNotes); err != nil {
+			logger.Warn("Failed to check for notes", "workspace", ws.Name, "error", err)
+			continue
+		}
+		if hasNotes {
+			continue
+		}
+
+		status, err := triggerImport(ctx, ws, triggerStartup)
+		if err != nil {
+			logger.Warn("Failed to trigger initial import", "workspace", ws.Name, "error", err)
+			continue
+		}
+		switch status {
+		case http.StatusCreated:
+			logger.Info("Note table empty, started initial import", "workspace", ws.Name)
+		case http.StatusConflict:
+			logger.Info("Note table empty but an import is already in progress", "workspace", ws.Name)
+		default:
+			logger.Warn("Failed to trigger initial import", "workspace", ws.Name, "status", status)
+		}
+	}
+}
+
+func startWorkspaceScheduler(ws *workspace) {
+	logger := logger.With("workspace", ws.Name)
+	ctx := withWorkspace(context.Background(), ws)
+	scheduler := ws.scheduler
 
+	checkAndImport := func() {
 		latestReq, err := http.NewRequestWithContext(ctx, "GET", "http://127.0.0.1:"+port+"/admin/imports/latest-available", nil)
 		if err != nil {
 			logger.Warn("Failed to create latest-available request", "error", err)
 			return
 		}
 
+		latestReq.Header.Set("X-API-Key", internalAPIKey)
+		latestReq.Header.Set("X-Workspace", ws.Name)
+
 		latestResp, err := http.DefaultClient.Do(latestReq)
 		if err != nil {
 			logger.Warn("Failed to check latest-available", "error", err)
@@ -81,6 +157,9 @@ func startAutoImporter() {
 			return
 		}
 
+		lastReq.Header.Set("X-API-Key", internalAPIKey)
+		lastReq.Header.Set("X-Workspace", ws.Name)
+
 		lastResp, err := http.DefaultClient.Do(lastReq)
 		if err != nil {
 			logger.Warn("Failed to check last-import-date", "error", err)
@@ -100,12 +179,16 @@ func startAutoImporter() {
 		if latest.Date > last.Date {
 			logger.Info("New data available, triggering import", "latest", latest.Date, "last", last.Date)
 
-			createReq, err := http.NewRequestWithContext(ctx, "POST", "http://127.0.0.1:"+port+"/admin/imports", nil)
+			createReq, err := http.NewRequestWithContext(ctx, "POST", "http://127.0.0.1:"+port+"/admin/imports", strings.NewReader(`{"triggered_by":"schedule"}`))
 			if err != nil {
 				logger.Warn("Failed to create import request", "error", err)
 				return
 			}
 
+			createReq.Header.Set("X-API-Key", internalAPIKey)
+			createReq.Header.Set("X-Workspace", ws.Name)
+			createReq.Header.Set("Content-Type", "application/json")
+
 			createResp, err := http.DefaultClient.Do(createReq)
 			if err != nil {
 				logger.Warn("Failed to trigger import", "error", err)
@@ -114,7 +197,7 @@ func startAutoImporter() {
 			createResp.Body.Close()
 		} else {
 			logger.Info("No new data available", "latest", latest.Date, "last", last.Date)
-			_, err := db.ExecContext(ctx, `INSERT INTO import_history (started_at, status, data_date) VALUES (NOW(), 'skipped', $1)`, latest.Date)
+			_, err := db.ExecContext(ctx, expandSQL(ctx, `INSERT INTO {import_history} (started_at, status, data_date) VALUES (NOW(), 'skipped', $1)`), latest.Date)
 			if err != nil {
 				logger.Warn("Failed to insert skipped record", "error", err)
 			}
@@ -122,18 +205,18 @@ func startAutoImporter() {
 	}
 
 	var lastImportTime time.Time
-	err := db.QueryRowContext(context.Background(), `SELECT COALESCE(MAX(COALESCE(data_date::timestamp, started_at)), '1970-01-01') FROM import_history WHERE status = 'completed'`).Scan(&lastImportTime)
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COALESCE(MAX(COALESCE(data_date::timestamp, started_at)), '1970-01-01') FROM {import_history} WHERE status IN ('completed', 'completed_with_warnings', 'skipped_unchanged')`)).Scan(&lastImportTime)
 	if err != nil {
 		logger.Warn("Failed to get last import time", "error", err)
-	} else if time.Since(lastImportTime) >= autoImportInterval {
-		logger.Info("Last import older than interval, checking for updates", "lastImport", lastImportTime, "interval", autoImportInterval)
+	} else if time.Since(lastImportTime) >= ws.Interval {
+		logger.Info("Last import older than interval, checking for updates", "lastImport", lastImportTime, "interval", ws.Interval)
 		checkAndImport()
 	}
 
-	ticker := time.NewTicker(autoImportInterval)
+	ticker := time.NewTicker(ws.Interval)
 	scheduler.lastCheck = time.Now()
-	scheduler.nextRun = scheduler.lastCheck.Add(autoImportInterval)
-	logger.Info("Auto-update scheduler started", "interval", autoImportInterval)
+	scheduler.nextRun = scheduler.lastCheck.Add(ws.Interval)
+	logger.Info("Auto-update scheduler started", "interval", ws.Interval)
 
 	go func() {
 		for {
@@ -141,7 +224,7 @@ func startAutoImporter() {
 			case <-ticker.C:
 				scheduler.mu.Lock()
 				scheduler.lastCheck = time.Now()
-				scheduler.nextRun = scheduler.lastCheck.Add(autoImportInterval)
+				scheduler.nextRun = scheduler.lastCheck.Add(ws.Interval)
 				scheduler.mu.Unlock()
 
 				checkAndImport()
@@ -156,47 +239,272 @@ func getVersion(w http.ResponseWriter, r *http.Request) {
 }
 
 func getConfig(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, len(workspaceOrder))
+	for i, ws := range workspaceOrder {
+		names[i] = ws.Name
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"admin_controls_disabled": adminControlsDisabled,
+		"auth_enabled":            authEnabled,
+		"export_formats":          availableExportFormats(),
+		"workspaces":              names,
 	})
 }
 
 func main() {
-	logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	once := flag.Bool("once", false, "run a single import in the foreground and exit (non-zero on failure)")
+	onceWorkspace := flag.String("workspace", defaultWorkspaceName, "workspace to import into with --once")
+	onceLimit := flag.Int("limit", 0, "load at most this many rows per file with --once (0 = no limit)")
+	onceFiles := flag.String("files", "", "with --once, load only these snapshot file indexes, e.g. 0-3 or 0,2,5-7")
+	onceOffline := flag.Bool("offline", false, "with --once, import files already in the data directory instead of downloading")
+	onceDate := flag.String("date", "", "with --offline, snapshot date (YYYY-MM-DD) to import; defaults to the newest local one")
+	generate := flag.Bool("generate", false, "write a synthetic snapshot to the workspace data directory and exit, or import it with --once")
+	generateNotes := flag.Int("generate-notes", 10000, "notes in the --generate snapshot")
+	generateFiles := flag.Int("generate-files", 1, "files to split the --generate snapshot into")
+	generateRatings := flag.Int("generate-ratings", 0, "average ratings per note written next to the --generate snapshot (0 = none)")
+	generateSeed := flag.Int64("seed", 1, "random seed for --generate and --mock-upstream")
+	replicateTo := flag.String("replicate-to", "", "copy the --workspace notes into the Postgres at this connection string and exit")
+	replicateTable := flag.String("replicate-table", "note", "with --replicate-to, target table, optionally schema-qualified; created when missing")
+	replicateMode := flag.String("replicate-mode", replicateTruncate, "with --replicate-to, truncate to replace the target table's rows or append to add to them")
+	mockUpstream := flag.Bool("mock-upstream", false, "serve a synthetic snapshot (sized by --generate-notes and --generate-files) in the upstream layout and download from it instead of the public dataset")
+	flag.Parse()
+
+	level, err := parseLogLevel(logLevelSetting)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid LOG_LEVEL %q: %v\n", logLevelSetting, err)
+		os.Exit(1)
+	}
+	logLevel.Set(level)
+	handler, err := newLogHandler()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid log configuration: %v\n", err)
+		os.Exit(1)
+	}
+	logger = slog.New(newJobLogHandler(handler))
+
+	if err := validateTableNaming(); err != nil {
+		logger.Error("Invalid table naming configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadCacheQuota(); err != nil {
+		logger.Error("Invalid cache quota configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := validateCacheCompression(); err != nil {
+		logger.Error("Invalid cache compression configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := validateCopyOptions(); err != nil {
+		logger.Error("Invalid COPY configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := validateRowCountCheck(); err != nil {
+		logger.Error("Invalid row count check configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadReadTimeouts(); err != nil {
+		logger.Error("Invalid read timeout configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadPreflight(); err != nil {
+		logger.Error("Invalid pre-flight configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := loadWorkspaces(); err != nil {
+		logger.Error("Invalid workspace configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if *generate {
+		ws, ok := workspaces[*onceWorkspace]
+		if !ok {
+			logger.Error("Unknown workspace", "workspace", *onceWorkspace)
+			os.Exit(2)
+		}
+		if *onceDate == "" {
+			*onceDate = time.Now().UTC().Format("2006-01-02")
+		}
+		if err := generateSnapshot(ws.dataDir(), *onceDate, *generateNotes, *generateFiles, *generateRatings, *generateSeed); err != nil {
+			logger.Error("Failed to generate synthetic snapshot", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Generated synthetic snapshot", "dir", ws.dataDir(), "date", *onceDate, "notes", *generateNotes, "files", *generateFiles)
+		if !*once {
+			os.Exit(0)
+		}
+		*onceOffline = true
+	}
+
+	if *mockUpstream {
+		date, err := startMockUpstream(*generateNotes, *generateFiles, *generateSeed)
+		if err != nil {
+			logger.Error("Failed to start mock upstream", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("Serving synthetic snapshot as upstream", "url", snapshotBaseURL, "date", date, "notes", *generateNotes, "files", *generateFiles)
+	}
 
 	if err := initDBWithRetry(30, time.Second); err != nil {
 		logger.Error("Failed to connect to database", "error", err)
 		os.Exit(1)
 	}
-	defer db.Close()
+	defer closeDB()
+	startDBHealthMonitor()
 
-	sanitizeImportStatus()
+	if err := migrateSchema(); err != nil {
+		logger.Error("Failed to migrate database schema", "error", err)
+		os.Exit(1)
+	}
+
+	applyQueryRoleTimeout()
+
+	if err := loadColumnMapping(); err != nil {
+		logger.Error("Failed to load column mapping", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initEventPublisher(); err != nil {
+		logger.Error("Failed to initialize event publisher", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initEmbeddings(); err != nil {
+		logger.Error("Failed to initialize embeddings", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initDuplicates(); err != nil {
+		logger.Error("Failed to initialize duplicate detection", "error", err)
+		os.Exit(1)
+	}
+
+	if err := initTopics(); err != nil {
+		logger.Error("Failed to initialize topic clustering", "error", err)
+		os.Exit(1)
+	}
+
+	exportBucket, err = newExportStore()
+	if err != nil {
+		logger.Error("Invalid export bucket configuration", "error", err)
+		os.Exit(1)
+	}
+
+	startJobLogWriter()
+	watchReloadSignal()
+	sanitizeImportStatus(autoResumeImports && !*once)
+	sanitizeBackupStatus()
+	sanitizeExportStatus()
+
+	if *replicateTo != "" {
+		code := runReplicateOnce(*onceWorkspace, *replicateTo, *replicateTable, *replicateMode)
+		closeDB()
+		os.Exit(code)
+	}
+
+	if *once {
+		if _, err := parseFileRange(*onceFiles); err != nil {
+			logger.Error("Invalid --files", "error", err)
+			closeDB()
+			os.Exit(2)
+		}
+		code := runImportOnce(*onceWorkspace, importOptions{limit: *onceLimit, files: *onceFiles, offline: *onceOffline, date: *onceDate})
+		closeDB()
+		os.Exit(code)
+	}
 
 	http.HandleFunc("/health", healthCheck)
+	http.HandleFunc("GET /freshness", withReadTimeout(getFreshness))
 	http.HandleFunc("/version", getVersion)
 	http.HandleFunc("/config", getConfig)
-	http.HandleFunc("GET /admin/imports/current", getImportCurrent)
-	http.HandleFunc("GET /admin/imports/{job_id}", getImportByID)
+	http.HandleFunc("GET /admin/imports", withReadTimeout(listImports))
+	http.HandleFunc("GET /admin/imports/current", withReadTimeout(getImportCurrent))
+	http.HandleFunc("GET /admin/imports/{job_id}", withReadTimeout(getImportByID))
 	http.HandleFunc("POST /admin/imports", createImport)
 	http.HandleFunc("POST /admin/imports/{job_id}/abort", abortImport)
 	http.HandleFunc("DELETE /admin/imports/{job_id}", abortImport)
+	http.HandleFunc("POST /admin/imports/{job_id}/retry", retryImportAsChild)
+	http.HandleFunc("POST /admin/imports/{job_id}/force-fail", forceFailImport)
+	http.HandleFunc("POST /admin/imports/{job_id}/force-complete", forceCompleteImport)
+	http.HandleFunc("GET /admin/imports/{job_id}/logs", getImportLogs)
+	http.HandleFunc("GET /admin/imports/{job_id}/errors", withReadTimeout(getImportErrors))
+	http.HandleFunc("POST /admin/imports/current/pause", pauseImport)
+	http.HandleFunc("POST /admin/imports/current/resume", resumeImport)
 	http.HandleFunc("GET /admin/imports/latest-available", getLatestAvailableDate)
-	http.HandleFunc("GET /admin/imports/last-import-date", getLastImportDate)
-	http.HandleFunc("GET /admin/imports/scheduler", getSchedulerStatus)
+	http.HandleFunc("GET /admin/imports/last-import-date", withReadTimeout(getLastImportDate))
+	http.HandleFunc("GET /admin/imports/scheduler", withReadTimeout(getSchedulerStatus))
+	http.HandleFunc("GET /imports/performance", withReadTimeout(getImportPerformance))
+	http.HandleFunc("GET /imports/compare", withReadTimeout(getImportComparison))
+	http.HandleFunc("GET /imports/queue", withReadTimeout(getImportQueue))
+	http.HandleFunc("GET /admin/preflight", withReadTimeout(getPreflight))
+	http.HandleFunc("POST /imports/{job_id}/retry", retryImportAsChild)
+	http.HandleFunc("POST /admin/benchmark", postBenchmark)
+	http.HandleFunc("POST /admin/backup", createBackup)
+	http.HandleFunc("POST /admin/restore", restoreBackup)
+	http.HandleFunc("GET /admin/backups", withReadTimeout(listBackups))
+	http.HandleFunc("GET /admin/backups/{backup_id}", withReadTimeout(getBackup))
+	http.HandleFunc("POST /query", postQuery)
+	http.HandleFunc("POST /exports", createExport)
+	http.HandleFunc("GET /exports", withReadTimeout(listExports))
+	http.HandleFunc("GET /exports/{export_id}", withReadTimeout(getExport))
+	http.HandleFunc("GET /exports/{export_id}/download", downloadExport)
+	http.HandleFunc("GET /admin/replication/notes", streamNotesCopy)
+	registerDebugRoutes()
+	http.HandleFunc("GET /metrics", getMetrics)
+	http.HandleFunc("GET /admin/log-level", getLogLevel)
+	http.HandleFunc("PUT /admin/log-level", putLogLevel)
+	http.HandleFunc("POST /admin/config/reload", postConfigReload)
+	http.HandleFunc("GET /admin/keys", withReadTimeout(listAPIKeys))
+	http.HandleFunc("POST /admin/keys", createAPIKey)
+	http.HandleFunc("DELETE /admin/keys/{id}", deleteAPIKey)
+	http.HandleFunc("GET /admin/exclusions", withReadTimeout(listExclusions))
+	http.HandleFunc("POST /admin/exclusions", createExclusions)
+	http.HandleFunc("DELETE /admin/exclusions/{id}", deleteExclusion)
+	http.HandleFunc("GET /admin/purges", withReadTimeout(listPurges))
+	http.HandleFunc("POST /admin/purges", createPurge)
+	http.HandleFunc("GET /webhooks", withReadTimeout(listWebhooks))
+	http.HandleFunc("POST /webhooks", createWebhook)
+	http.HandleFunc("GET /webhooks/{id}", withReadTimeout(getWebhook))
+	http.HandleFunc("PUT /webhooks/{id}", updateWebhook)
+	http.HandleFunc("DELETE /webhooks/{id}", deleteWebhook)
+	http.HandleFunc("POST /webhooks/{id}/secret", rotateWebhookSecret)
+	http.HandleFunc("GET /notes", withResponseCache(withReadTimeout(listNotes)))
+	http.HandleFunc("GET /notes/{id}", withResponseCache(withReadTimeout(getNote)))
+	http.HandleFunc("GET /notes/similar", withResponseCache(withReadTimeout(getSimilarNotes)))
+	http.HandleFunc("GET /notes/{id}/duplicates", withResponseCache(withReadTimeout(getNoteDuplicates)))
+	http.HandleFunc("GET /notes/{id}/links", withResponseCache(withReadTimeout(getNoteLinks)))
+	http.HandleFunc("GET /notes/tweet", withResponseCache(withReadTimeout(getTweetNotes)))
+	http.HandleFunc("GET /aggregate", withResponseCache(withReadTimeout(getAggregate)))
+	http.HandleFunc("GET /participants/distribution", withResponseCache(withReadTimeout(getParticipantDistribution)))
+	http.HandleFunc("GET /topics", withResponseCache(withReadTimeout(listTopics)))
+	http.HandleFunc("GET /topics/{id}/notes", withResponseCache(withReadTimeout(getTopicNotes)))
+	http.HandleFunc("GET /cache", listCachedFiles)
+	http.HandleFunc("GET /cache/{file}", getCachedFile)
+	http.HandleFunc("GET /cache/{year}/{month}/{day}/notes/{file}", getMirroredFile)
 
+	ensureAdminAPIKey()
 	logger.Info("Starting API server", "port", port)
 	go func() {
-		if err := http.ListenAndServe(":"+port, nil); err != nil {
+		if err := http.ListenAndServe(":"+port, requestIDMiddleware(workspaceMiddleware(dbHealthMiddleware(authMiddleware(http.DefaultServeMux))))); err != nil {
 			logger.Error("Failed to start server", "error", err)
 			os.Exit(1)
 		}
 	}()
 
+	startFlightServer()
+
 	time.Sleep(time.Second)
+	startInitialImports()
 	startAutoImporter()
+	startUpstreamPollers()
 
 	select {
 	case <-make(chan struct{}):
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var metricsCacheTTL = getEnvDuration("METRICS_CACHE_TTL", 5*time.Minute)
+
+// datasetMetrics are per-workspace gauges about the loaded notes, cached for
+// METRICS_CACHE_TTL since counting the note table is too slow for every scrape.
+type datasetMetrics struct {
+	collectedAt      time.Time
+	notes            int64
+	byClassification map[string]int64
+	dataAgeHours     *float64
+	lastImportAt     *time.Time
+	lastImportAdded  *int64
+}
+
+var (
+	metricsMu    sync.Mutex
+	metricsCache = map[string]*datasetMetrics{}
+)
+
+func collectDatasetMetrics(ctx context.Context) (*datasetMetrics, error) {
+	m := &datasetMetrics{collectedAt: time.Now(), byClassification: map[string]int64{}}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT COALESCE(classification, ''), COUNT(*) FROM {note} GROUP BY 1`))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var class string
+		var n int64
+		if err := rows.Scan(&class, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.byClassification[class] = n
+		m.notes += n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var dataDate sql.NullTime
+	var completedAt sql.NullTime
+	var totalRows, prevRows sql.NullInt64
+	err = db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT data_date, completed_at, total_rows,
+		       LEAD(total_rows) OVER (ORDER BY completed_at DESC)
+		FROM {import_history}
+		WHERE status IN ('completed', 'completed_with_warnings') AND completed_at IS NOT NULL
+		ORDER BY completed_at DESC LIMIT 1
+	`)).Scan(&dataDate, &completedAt, &totalRows, &prevRows)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if dataDate.Valid {
+		age := time.Since(dataDate.Time).Hours()
+		m.dataAgeHours = &age
+	}
+	m.lastImportAt = nullTimeToTimePtr(completedAt)
+	if totalRows.Valid {
+		added := totalRows.Int64 - prevRows.Int64
+		m.lastImportAdded = &added
+	}
+	return m, nil
+}
+
+func cachedDatasetMetrics(ctx context.Context, ws *workspace) (*datasetMetrics, error) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if m, ok := metricsCache[ws.Name]; ok && time.Since(m.collectedAt) < metricsCacheTTL {
+		return m, nil
+	}
+	m, err := collectDatasetMetrics(withWorkspace(ctx, ws))
+	if err != nil {
+		return nil, err
+	}
+	metricsCache[ws.Name] = m
+	return m, nil
+}
+
+// getMetrics serves the dataset gauges of every workspace in the Prometheus
+// text format.
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("xnotes_database_up", "Whether the database answered the last health check.")
+	if dbUnavailable.Load() {
+		b.WriteString("xnotes_database_up 0\n")
+	} else {
+		b.WriteString("xnotes_database_up 1\n")
+	}
+
+	counter := func(name, help string, v int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+	counter("xnotes_response_cache_hits_total", "Read responses served from the response cache.", respCache.hits.Load())
+	counter("xnotes_response_cache_misses_total", "Cacheable read responses computed from the database.", respCache.misses.Load())
+	counter("xnotes_response_cache_evictions_total", "Responses evicted from the response cache to make room.", respCache.evictions.Load())
+	gauge("xnotes_response_cache_entries", "Responses held in the response cache.")
+	fmt.Fprintf(&b, "xnotes_response_cache_entries %d\n", respCache.len())
+
+	all := map[string]*datasetMetrics{}
+	for _, ws := range workspaceOrder {
+		if dbUnavailable.Load() {
+			continue
+		}
+		m, err := cachedDatasetMetrics(r.Context(), ws)
+		if err != nil {
+			logger.Warn("Failed to collect dataset metrics", "workspace", ws.Name, "error", err)
+			continue
+		}
+		all[ws.Name] = m
+	}
+
+	gauge("xnotes_notes", "Notes in the note table.")
+	for _, ws := range workspaceOrder {
+		if m := all[ws.Name]; m != nil {
+			fmt.Fprintf(&b, "xnotes_notes{workspace=%q} %d\n", ws.Name, m.notes)
+		}
+	}
+
+	gauge("xnotes_notes_by_classification", "Notes in the note table per classification.")
+	for _, ws := range workspaceOrder {
+		m := all[ws.Name]
+		if m == nil {
+			continue
+		}
+		classes := make([]string, 0, len(m.byClassification))
+		for c := range m.byClassification {
+			classes = append(classes, c)
+		}
+		sort.Strings(classes)
+		for _, c := range classes {
+			fmt.Fprintf(&b, "xnotes_notes_by_classification{workspace=%q,classification=%q} %d\n", ws.Name, c, m.byClassification[c])
+		}
+	}
+
+	gauge("xnotes_data_age_hours", "Hours since the snapshot date of the last completed import.")
+	for _, ws := range workspaceOrder {
+		if m := all[ws.Name]; m != nil && m.dataAgeHours != nil {
+			fmt.Fprintf(&b, "xnotes_data_age_hours{workspace=%q} %g\n", ws.Name, *m.dataAgeHours)
+		}
+	}
+
+	gauge("xnotes_last_import_timestamp_seconds", "Completion time of the last completed import.")
+	for _, ws := range workspaceOrder {
+		if m := all[ws.Name]; m != nil && m.lastImportAt != nil {
+			fmt.Fprintf(&b, "xnotes_last_import_timestamp_seconds{workspace=%q} %d\n", ws.Name, m.lastImportAt.Unix())
+		}
+	}
+
+	gauge("xnotes_last_import_notes_added", "Change in row count between the last two completed imports.")
+	for _, ws := range workspaceOrder {
+		if m := all[ws.Name]; m != nil && m.lastImportAdded != nil {
+			fmt.Fprintf(&b, "xnotes_last_import_notes_added{workspace=%q} %d\n", ws.Name, *m.lastImportAdded)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
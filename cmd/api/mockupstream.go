@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var mockUpstreamAddr = getEnv("MOCK_UPSTREAM_ADDR", "127.0.0.1:0")
+
+var mockUpstreamFilePattern = regexp.MustCompile(`^notes-\d{5}\.zip$`)
+
+// startMockUpstream serves a synthetic snapshot dated today under the
+// upstream URL layout (/YYYY/MM/DD/notes/notes-NNNNN.zip, with HEAD and Range
+// support) and points SNAPSHOT_BASE_URL at it, so discovery, download,
+// extraction and import can run without network access.
+func startMockUpstream(notes, files int, seed int64) (string, error) {
+	dir, err := os.MkdirTemp("", "x-notes-mock-upstream-")
+	if err != nil {
+		return "", err
+	}
+	date := getDateDaysAgo(0)
+	if err := generateSnapshot(dir, date, notes, files, 0, seed); err != nil {
+		return "", fmt.Errorf("failed to generate snapshot: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", mockUpstreamAddr)
+	if err != nil {
+		return "", err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{year}/{month}/{day}/notes/{file}", func(w http.ResponseWriter, r *http.Request) {
+		if !mockUpstreamFilePattern.MatchString(r.PathValue("file")) {
+			http.NotFound(w, r)
+			return
+		}
+		name := fmt.Sprintf("%s-%s-%s-%s", r.PathValue("year"), r.PathValue("month"), r.PathValue("day"), r.PathValue("file"))
+		http.ServeFile(w, r, filepath.Join(dir, name))
+	})
+	go http.Serve(ln, mux)
+
+	snapshotBaseURL = "http://" + ln.Addr().String()
+	snapshotAPIKey = ""
+	return date, nil
+}
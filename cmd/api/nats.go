@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var (
+	natsURL           = getEnv("NATS_URL", nats.DefaultURL)
+	natsStream        = getEnv("NATS_STREAM", "XNOTES")
+	natsSubjectPrefix = getEnv("NATS_SUBJECT_PREFIX", "xnotes")
+)
+
+type natsPublisher struct {
+	conn *nats.Conn
+	js   jetstream.JetStream
+}
+
+func newNATSPublisher() (*natsPublisher, error) {
+	conn, err := nats.Connect(natsURL, nats.Name("x-notes-api"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open JetStream: %w", err)
+	}
+
+	_, err = js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
+		Name:     natsStream,
+		Subjects: []string{natsSubjectPrefix + ".>"},
+		Storage:  jetstream.FileStorage,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create JetStream stream %s: %w", natsStream, err)
+	}
+
+	return &natsPublisher{conn: conn, js: js}, nil
+}
+
+// Publish sends the batch asynchronously and waits for every JetStream ack,
+// so a returned nil means the stream has persisted all events. Message IDs
+// let the stream drop duplicates when a batch is retried.
+func (p *natsPublisher) Publish(ctx context.Context, events []event) error {
+	futures := make([]jetstream.PubAckFuture, 0, len(events))
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s event: %w", e.Type, err)
+		}
+		msg := nats.NewMsg(natsSubjectPrefix + "." + e.Type)
+		msg.Data = data
+		msg.Header.Set("event-type", e.Type)
+
+		f, err := p.js.PublishMsgAsync(msg, jetstream.WithMsgID(strings.Join([]string{e.JobID, e.Type, e.key()}, ":")))
+		if err != nil {
+			return fmt.Errorf("failed to publish %s event: %w", e.Type, err)
+		}
+		futures = append(futures, f)
+	}
+
+	for _, f := range futures {
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			return fmt.Errorf("JetStream rejected event: %w", err)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (p *natsPublisher) Close() error {
+	p.conn.Drain()
+	return nil
+}
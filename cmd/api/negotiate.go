@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mediaJSON   = "application/json"
+	mediaCSV    = "text/csv"
+	mediaNDJSON = "application/x-ndjson"
+)
+
+// listMediaType picks the first of JSON, CSV and NDJSON listed in Accept,
+// defaulting to JSON.
+func listMediaType(r *http.Request) string {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case mediaJSON, mediaCSV, mediaNDJSON:
+			return mt
+		}
+	}
+	return mediaJSON
+}
+
+// writeList encodes items as the JSON array the list endpoints return, or as
+// CSV (one column per top-level JSON field, nested values JSON-encoded) or
+// NDJSON when the client asks for them.
+func writeList[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	writeListPage(w, r, items, nil, nil)
+}
+
+// listPage places a page of items in its whole list: Next and Prev are the
+// queries of the neighbouring pages, nil when there is none.
+type listPage struct {
+	Total      int
+	Estimated  bool
+	Next       url.Values
+	Prev       url.Values
+	NextCursor string
+	PrevCursor string
+}
+
+type ListLinks struct {
+	Self string  `json:"self"`
+	Next *string `json:"next,omitempty"`
+	Prev *string `json:"prev,omitempty"`
+}
+
+type ListEnvelope struct {
+	Items          any       `json:"items"`
+	Total          int       `json:"total"`
+	TotalEstimated bool      `json:"total_estimated,omitempty"`
+	NextCursor     *string   `json:"next_cursor,omitempty"`
+	PrevCursor     *string   `json:"prev_cursor,omitempty"`
+	Links          ListLinks `json:"links"`
+}
+
+func pageLink(r *http.Request, q url.Values) *string {
+	if q == nil {
+		return nil
+	}
+	link := r.URL.Path + "?" + q.Encode()
+	return &link
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// writeListPage is writeList keeping only fields, in their order (nil keeps
+// every field), and, given a page, wrapping JSON in a ListEnvelope. CSV and
+// NDJSON stay bare rows; the page is also carried by the X-Total-Count,
+// X-Next-Cursor and Link headers whatever the format.
+func writeListPage[T any](w http.ResponseWriter, r *http.Request, items []T, fields []listField, page *listPage) {
+	w.Header().Add("Vary", "Accept")
+	var out any = items
+	if fields != nil {
+		projected := make([]projectedItem, len(items))
+		for i, item := range items {
+			projected[i] = projectedItem{fields, reflect.ValueOf(item)}
+		}
+		out = projected
+	}
+	if page != nil {
+		env := ListEnvelope{
+			Items:          out,
+			Total:          page.Total,
+			TotalEstimated: page.Estimated,
+			NextCursor:     optionalString(page.NextCursor),
+			PrevCursor:     optionalString(page.PrevCursor),
+			Links:          ListLinks{Self: r.URL.RequestURI(), Next: pageLink(r, page.Next), Prev: pageLink(r, page.Prev)},
+		}
+		out = env
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+		if page.NextCursor != "" {
+			w.Header().Set("X-Next-Cursor", page.NextCursor)
+		}
+		var links []string
+		if env.Links.Next != nil {
+			links = append(links, "<"+*env.Links.Next+`>; rel="next"`)
+		}
+		if env.Links.Prev != nil {
+			links = append(links, "<"+*env.Links.Prev+`>; rel="prev"`)
+		}
+		if len(links) > 0 {
+			w.Header().Set("Link", strings.Join(links, ", "))
+		}
+	}
+	switch listMediaType(r) {
+	case mediaCSV:
+		w.Header().Set("Content-Type", mediaCSV)
+		writeCSVList(w, items, fields)
+	case mediaNDJSON:
+		w.Header().Set("Content-Type", mediaNDJSON)
+		enc := json.NewEncoder(w)
+		for _, item := range items {
+			if fields != nil {
+				enc.Encode(projectedItem{fields, reflect.ValueOf(item)})
+			} else {
+				enc.Encode(item)
+			}
+		}
+	default:
+		w.Header().Set("Content-Type", mediaJSON)
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.Encode(out)
+	}
+}
+
+type listField struct {
+	name  string
+	index int
+}
+
+func listFields[T any]() []listField {
+	t := reflect.TypeFor[T]()
+	var fields []listField
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "-" || !t.Field(i).IsExported() {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		fields = append(fields, listField{name, i})
+	}
+	return fields
+}
+
+// fieldKey folds case and underscores so that noteId, noteid and note_id all
+// name the same field or column.
+func fieldKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "_", ""))
+}
+
+// parseListFields resolves a fields= list against T's JSON names; "" keeps
+// every field (nil).
+func parseListFields[T any](s string) ([]listField, error) {
+	names := splitList(s)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	all := listFields[T]()
+	var fields []listField
+	for _, name := range names {
+		i := slices.IndexFunc(all, func(f listField) bool { return fieldKey(f.name) == fieldKey(name) })
+		if i < 0 {
+			known := make([]string, len(all))
+			for j, f := range all {
+				known[j] = f.name
+			}
+			return nil, fmt.Errorf("unknown field %q; fields are %s", name, strings.Join(known, ", "))
+		}
+		if !slices.Contains(fields, all[i]) {
+			fields = append(fields, all[i])
+		}
+	}
+	return fields, nil
+}
+
+// projectedItem encodes the selected fields of a struct as a JSON object,
+// keeping nulls so that every row has the same keys.
+type projectedItem struct {
+	fields []listField
+	v      reflect.Value
+}
+
+func (p projectedItem) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range p.fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.name)
+		value, err := json.Marshal(p.v.Field(f.index).Interface())
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func writeCSVList[T any](w http.ResponseWriter, items []T, fields []listField) {
+	if fields == nil {
+		fields = listFields[T]()
+	}
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(names)
+	record := make([]string, len(fields))
+	for _, item := range items {
+		v := reflect.ValueOf(item)
+		for i, f := range fields {
+			record[i] = csvValue(v.Field(f.index))
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+}
+
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case string:
+		return x
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return ""
+		}
+		fallthrough
+	case reflect.Struct:
+		b, _ := json.Marshal(v.Interface())
+		return string(b)
+	}
+	return fmt.Sprint(v.Interface())
+}
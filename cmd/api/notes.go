@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	tweetIDPattern   = regexp.MustCompile(`^[0-9]{1,20}$`)
+	tweetStatusPaths = regexp.MustCompile(`^/(?:[A-Za-z0-9_]+|i/web|i)/status(?:es)?/([0-9]{1,20})(?:/|$)`)
+	tweetHosts       = []string{"twitter.com", "x.com"}
+)
+
+type TweetNote struct {
+	NoteID                  int64   `json:"note_id"`
+	TweetID                 string  `json:"tweet_id"`
+	NoteAuthorParticipantID *string `json:"note_author_participant_id,omitempty"`
+	CreatedAtMillis         *int64  `json:"created_at_millis,omitempty"`
+	Classification          *string `json:"classification,omitempty"`
+	Summary                 *string `json:"summary,omitempty"`
+}
+
+// parseTweetURL extracts the status ID from twitter.com and x.com links,
+// including the mobile., www. and /i/web/status forms; a bare ID is accepted
+// as-is.
+func parseTweetURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if tweetIDPattern.MatchString(raw) {
+		return raw, nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", errors.New("url is not a valid URL")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	host = strings.TrimPrefix(host, "mobile.")
+	if !slices.Contains(tweetHosts, host) {
+		return "", errors.New("url must be a twitter.com or x.com link")
+	}
+
+	m := tweetStatusPaths.FindStringSubmatch(u.Path)
+	if m == nil {
+		return "", errors.New("url does not point to a tweet")
+	}
+	return m[1], nil
+}
+
+func getNote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	noteID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Note ID must be an integer")
+		return
+	}
+
+	var n TweetNote
+	var authorID, classification, summary sql.NullString
+	var createdAt sql.NullInt64
+	err = db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT noteid, tweetid, noteauthorparticipantid, (EXTRACT(EPOCH FROM created_at) * 1000)::bigint, classification, summary
+		FROM {note} n
+		WHERE noteid = $1 AND `+notExcluded("n")+`
+	`), noteID).Scan(&n.NoteID, &n.TweetID, &authorID, &createdAt, &classification, &summary)
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeNoteNotFound, "Note not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get note: "+err.Error())
+		return
+	}
+	n.NoteAuthorParticipantID = nullStringToStrPtr(authorID)
+	n.CreatedAtMillis = nullInt64ToInt64Ptr(createdAt)
+	n.Classification = nullStringToStrPtr(classification)
+	n.Summary = nullStringToStrPtr(summary)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n)
+}
+
+func getTweetNotes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	fields, err := parseListFields[TweetNote](r.URL.Query().Get("fields"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	tweetID := r.URL.Query().Get("tweet_id")
+	if raw := r.URL.Query().Get("url"); raw != "" {
+		id, err := parseTweetURL(raw)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+			return
+		}
+		tweetID = id
+	}
+	if tweetID == "" {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "tweet_id or url is required")
+		return
+	}
+	if !tweetIDPattern.MatchString(tweetID) {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "tweet_id must be numeric")
+		return
+	}
+	id, err := strconv.ParseInt(tweetID, 10, 64)
+	if err != nil {
+		writeListPage(w, r, []TweetNote{}, fields, &listPage{})
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT noteid, tweetid, noteauthorparticipantid, (EXTRACT(EPOCH FROM created_at) * 1000)::bigint, classification, summary
+		FROM {note} n
+		WHERE tweet_id = $1 AND `+notExcluded("n")+`
+		ORDER BY created_at DESC NULLS LAST, noteid
+	`), id)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get tweet notes: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	notes := []TweetNote{}
+	for rows.Next() {
+		var n TweetNote
+		var authorID, classification, summary sql.NullString
+		var createdAt sql.NullInt64
+		if err := rows.Scan(&n.NoteID, &n.TweetID, &authorID, &createdAt, &classification, &summary); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get tweet notes: "+err.Error())
+			return
+		}
+		n.NoteAuthorParticipantID = nullStringToStrPtr(authorID)
+		n.CreatedAtMillis = nullInt64ToInt64Ptr(createdAt)
+		n.Classification = nullStringToStrPtr(classification)
+		n.Summary = nullStringToStrPtr(summary)
+		notes = append(notes, n)
+	}
+
+	writeListPage(w, r, notes, fields, &listPage{Total: len(notes)})
+}
+
+// parseTweetIDs keeps the ids that fit note.tweet_id; others cannot match.
+func parseTweetIDs(ids []string) []int64 {
+	var parsed []int64
+	for _, id := range ids {
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			parsed = append(parsed, n)
+		}
+	}
+	return parsed
+}
+
+// listNotes pages through the notes, newest note ID first, optionally
+// filtered by classification, author and creation time. Pages are keyed on
+// noteid; without a filter the total is the planner's estimate, since an
+// exact count of the whole table is too slow to run per page.
+func listNotes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	fields, err := parseListFields[TweetNote](q.Get("fields"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > 500 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = l
+	}
+
+	where := []string{notExcluded("n")}
+	var args []any
+
+	if v := q.Get("classification"); v != "" {
+		args = append(args, strings.Split(v, ","))
+		where = append(where, fmt.Sprintf("classification = ANY($%d)", len(args)))
+	}
+
+	if v := q.Get("author"); v != "" {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf("noteauthorparticipantid = $%d", len(args)))
+	}
+
+	if v := q.Get("from"); v != "" {
+		from, err := parseFilterTime(v, false)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "from must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+			return
+		}
+		args = append(args, from)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+
+	if v := q.Get("to"); v != "" {
+		to, err := parseFilterTime(v, true)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "to must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+			return
+		}
+		args = append(args, to)
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	page := &listPage{}
+	if len(args) == 0 {
+		page.Estimated = true
+		db.QueryRowContext(ctx, `SELECT GREATEST(reltuples, 0)::bigint FROM pg_class WHERE oid = to_regclass($1)`, qualifiedTable(ctx, "note")).Scan(&page.Total)
+	} else if err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COUNT(*) FROM {note} n WHERE `+strings.Join(where, " AND ")), args...).Scan(&page.Total); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to count notes: "+err.Error())
+		return
+	}
+
+	// A before page is read upwards from the cursor and flipped back below.
+	cursor, before := q.Get("cursor"), q.Get("before")
+	if cursor != "" && before != "" {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "cursor and before are exclusive")
+		return
+	}
+	scan := "DESC"
+	if cursor != "" || before != "" {
+		cursorID, err := strconv.ParseInt(cursor+before, 10, 64)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid cursor")
+			return
+		}
+		op := "<"
+		if before != "" {
+			op, scan = ">", "ASC"
+		}
+		args = append(args, cursorID)
+		where = append(where, fmt.Sprintf("noteid %s $%d", op, len(args)))
+	}
+
+	args = append(args, limit+1)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(expandSQL(ctx, `
+		SELECT noteid, tweetid, noteauthorparticipantid, (EXTRACT(EPOCH FROM created_at) * 1000)::bigint, classification, summary
+		FROM {note} n
+		WHERE %s
+		ORDER BY noteid %s
+		LIMIT $%d
+	`), strings.Join(where, " AND "), scan, len(args)), args...)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list notes: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	notes := []TweetNote{}
+	for rows.Next() {
+		var n TweetNote
+		var tweetID, authorID, classification, summary sql.NullString
+		var createdAt sql.NullInt64
+		if err := rows.Scan(&n.NoteID, &tweetID, &authorID, &createdAt, &classification, &summary); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list notes: "+err.Error())
+			return
+		}
+		n.TweetID = tweetID.String
+		n.NoteAuthorParticipantID = nullStringToStrPtr(authorID)
+		n.CreatedAtMillis = nullInt64ToInt64Ptr(createdAt)
+		n.Classification = nullStringToStrPtr(classification)
+		n.Summary = nullStringToStrPtr(summary)
+		notes = append(notes, n)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list notes: "+err.Error())
+		return
+	}
+	more := len(notes) > limit
+	if more {
+		notes = notes[:limit]
+	}
+	if before != "" {
+		slices.Reverse(notes)
+	}
+
+	pageQuery := func(k, v string) url.Values {
+		pq := r.URL.Query()
+		pq.Del("cursor")
+		pq.Del("before")
+		pq.Set(k, v)
+		return pq
+	}
+	hasNext, hasPrev := more, cursor != ""
+	if before != "" {
+		hasNext, hasPrev = true, more
+	}
+	if len(notes) > 0 {
+		if hasNext {
+			page.NextCursor = strconv.FormatInt(notes[len(notes)-1].NoteID, 10)
+			page.Next = pageQuery("cursor", page.NextCursor)
+		}
+		if hasPrev {
+			page.PrevCursor = strconv.FormatInt(notes[0].NoteID, 10)
+			page.Prev = pageQuery("before", page.PrevCursor)
+		}
+	}
+
+	writeListPage(w, r, notes, fields, page)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportStore is an S3-compatible bucket that completed exports are moved to.
+// GCS works through its XML API endpoint with HMAC keys.
+type exportStore struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// exportBucket is nil when EXPORT_S3_BUCKET is unset and exports stay on
+// local disk.
+var exportBucket *exportStore
+
+var exportURLExpiry = getEnvDuration("EXPORT_URL_EXPIRY", time.Hour)
+
+var objectStoreHTTPClient = &http.Client{Timeout: 6 * time.Hour}
+
+func newExportStore() (*exportStore, error) {
+	bucket := os.Getenv("EXPORT_S3_BUCKET")
+	if bucket == "" {
+		return nil, nil
+	}
+	region := getEnv("EXPORT_S3_REGION", getEnv("AWS_REGION", "us-east-1"))
+	endpoint, err := url.Parse(getEnv("EXPORT_S3_ENDPOINT", "https://s3."+region+".amazonaws.com"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("EXPORT_S3_ENDPOINT %q is not a URL", getEnv("EXPORT_S3_ENDPOINT", ""))
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+		return nil, fmt.Errorf("EXPORT_S3_BUCKET needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return &exportStore{
+		endpoint:     endpoint,
+		bucket:       bucket,
+		prefix:       strings.Trim(os.Getenv("EXPORT_S3_PREFIX"), "/"),
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}, nil
+}
+
+func (s *exportStore) key(workspace, name string) string {
+	return strings.TrimPrefix(strings.Join([]string{s.prefix, workspace, name}, "/"), "/")
+}
+
+// awsURIEncode escapes everything but the RFC 3986 unreserved characters, as
+// SigV4 canonical requests require.
+func awsURIEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || keepSlash && c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// presign returns a path-style URL for method on key, signed with SigV4 query
+// parameters and valid for expiry (at most seven days).
+func (s *exportStore) presign(method, key string, expiry time.Duration, extra url.Values, now time.Time) string {
+	now = now.UTC()
+	date := now.Format("20060102")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	path := strings.TrimSuffix(s.endpoint.Path, "/") + "/" + awsURIEncode(s.bucket, false) + "/" + awsURIEncode(key, true)
+
+	q := url.Values{}
+	for k, v := range extra {
+		q[k] = v
+	}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	q.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(min(expiry, 7*24*time.Hour).Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if s.sessionToken != "" {
+		q.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	var params []string
+	for _, k := range slices.Sorted(maps.Keys(q)) {
+		params = append(params, awsURIEncode(k, false)+"="+awsURIEncode(q.Get(k), false))
+	}
+	query := strings.Join(params, "&")
+
+	canonical := strings.Join([]string{method, path, query, "host:" + s.endpoint.Host, "", "host", "UNSIGNED-PAYLOAD"}, "\n")
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	k := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	k = hmacSHA256(k, s.region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	return s.endpoint.Scheme + "://" + s.endpoint.Host + path + "?" + query + "&X-Amz-Signature=" + signature
+}
+
+func (s *exportStore) do(ctx context.Context, method, key string, body io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.presign(method, key, 15*time.Minute, nil, time.Now()), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := objectStoreHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// upload PUTs the file at path as key in a single request, so objects are
+// limited to the 5 GiB single-PUT maximum.
+func (s *exportStore) upload(ctx context.Context, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, http.MethodPut, key, f, info.Size())
+}
+
+// download GETs key into path, through a temporary file so that a broken
+// transfer never leaves a partial one behind.
+func (s *exportStore) download(ctx context.Context, key, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.presign(http.MethodGet, key, 15*time.Minute, nil, time.Now()), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := objectStoreHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path + ".tmp")
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *exportStore) delete(ctx context.Context, key string) error {
+	return s.do(ctx, http.MethodDelete, key, nil, 0)
+}
+
+// downloadURL is a pre-signed GET of key that saves as name, valid for
+// EXPORT_URL_EXPIRY.
+func (s *exportStore) downloadURL(key, name string) (string, time.Time) {
+	now := time.Now()
+	extra := url.Values{"response-content-disposition": {`attachment; filename="` + name + `"`}}
+	return s.presign(http.MethodGet, key, exportURLExpiry, extra, now), now.Add(exportURLExpiry).UTC()
+}
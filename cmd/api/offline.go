@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+)
+
+var localNoteFilePattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-notes-\d{5}\.(zip|tsv|tsv\.zst)$`)
+
+// latestLocalDate returns the newest snapshot date with note files present in
+// dir.
+func latestLocalDate(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	var dates []string
+	for _, entry := range entries {
+		if m := localNoteFilePattern.FindStringSubmatch(entry.Name()); m != nil && !entry.IsDir() {
+			dates = append(dates, m[1])
+		}
+	}
+	if len(dates) == 0 {
+		return "", fmt.Errorf("no note files found in %s", dir)
+	}
+	return slices.Max(dates), nil
+}
+
+// localNoteFile reports the name of the local file for one snapshot index:
+// the zip (or its zstd replacement) when cached, else an extracted TSV.
+func localNoteFile(dir, date string, i int) (string, bool) {
+	base := filepath.Join(dir, fmt.Sprintf("%s-%s", date, formatFileName(i)))
+	if _, ok := cachedFileSize(base + ".zip"); ok {
+		return filepath.Base(base + ".zip"), true
+	}
+	if _, err := os.Stat(base + ".tsv"); err == nil {
+		return filepath.Base(base + ".tsv"), true
+	}
+	return "", false
+}
+
+// collectLocalFiles is the offline counterpart of downloadNotesWithProgress:
+// it picks up {date}-notes-NNNNN.zip or .tsv.zst (unpacking it) or an already
+// extracted .tsv for consecutive indexes starting at 0, or for the indexes in
+// sel, and records them as cached downloads.
+func collectLocalFiles(ctx context.Context, date string, jobID string, sel []int) ([]FileInfo, error) {
+	log := jobLogger(ctx, jobID)
+	dir := workspaceFromContext(ctx).dataDir()
+
+	var files []FileInfo
+	for k := 0; sel == nil || k < len(sel); k++ {
+		i := k
+		if sel != nil {
+			i = sel[k]
+		}
+		base := filepath.Join(dir, fmt.Sprintf("%s-%s", date, formatFileName(i)))
+		zipPath, tsvPath := base+".zip", base+".tsv"
+
+		size, ok := cachedFileSize(zipPath)
+		if ok {
+			var err error
+			if tsvPath, err = snapshotExtractor.Extract(zipPath, i); err != nil {
+				return nil, fmt.Errorf("failed to extract %s: %w", zipPath, err)
+			}
+		} else if info, err := os.Stat(tsvPath); errors.Is(err, os.ErrNotExist) {
+			if sel != nil {
+				return nil, fmt.Errorf("no local file %d for date %s in %s", i, date, dir)
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		} else {
+			size = info.Size()
+		}
+
+		log.Info("Using local file", "path", tsvPath)
+		files = append(files, FileInfo{
+			Index:    i,
+			ZipPath:  zipPath,
+			TSVPath:  tsvPath,
+			FileName: filepath.Base(zipPath),
+			FileSize: size,
+		})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no local files found for date %s in %s", date, dir)
+	}
+
+	var fileNames []string
+	var totalSize int64
+	for _, f := range files {
+		fileNames = append(fileNames, f.FileName)
+		totalSize += f.FileSize
+	}
+	fileList, _ := json.Marshal(fileNames)
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {import_history} SET total_files = $1, current_file_index = $2, file_list = $3, file_size = $4, download_cached = true, download_percentage = 100 WHERE job_id = $5`), len(files), files[len(files)-1].Index, fileList, totalSize, jobID)
+
+	for _, f := range files {
+		db.ExecContext(ctx, expandSQL(ctx, `
+			INSERT INTO {import_files} AS f (job_id, file_index, file_name, file_size, status, cached, download_duration)
+			VALUES ($1, $2, $3, $4, 'downloaded', true, 0)
+			ON CONFLICT (job_id, file_index) DO UPDATE SET
+				file_size = EXCLUDED.file_size,
+				cached = true,
+				status = CASE WHEN f.status = 'imported' THEN 'imported' ELSE 'downloaded' END`),
+			jobID, f.Index, f.FileName, f.FileSize)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// runImportOnce creates and runs a single import in the foreground for
+// batch schedulers such as a Kubernetes CronJob, returning the process exit
+// code.
+func runImportOnce(workspaceName string, opts importOptions) int {
+	ws, ok := workspaces[workspaceName]
+	if !ok {
+		logger.Error("Unknown workspace", "workspace", workspaceName)
+		return 2
+	}
+	ctx := withWorkspace(context.Background(), ws)
+
+	if report := runPreflight(ctx); !report.OK {
+		logger.Error("Import refused by pre-flight checks", "workspace", ws.Name, "failed", report.failures())
+		return 1
+	}
+
+	lock, err := tryImportLock(ctx)
+	if err != nil || lock == nil {
+		logger.Error("Import already in progress", "workspace", ws.Name, "error", err)
+		return 1
+	}
+	defer lock.release()
+
+	var active int
+	db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COUNT(*) FROM {import_history} WHERE status IN ('importing', 'downloading', 'indexing', 'paused')`)).Scan(&active)
+	if active > 0 {
+		logger.Error("Import already in progress or paused", "workspace", ws.Name)
+		return 1
+	}
+
+	var jobID string
+	err = db.QueryRowContext(ctx, expandSQL(ctx, `
+		INSERT INTO {import_history} (started_at, status, download_percentage, rows_processed, labels, triggered_by, triggered_by_name, owner_instance, heartbeat_at)
+		VALUES (NOW(), 'downloading', 0, 0, $1, $2, $3, $4, NOW())
+		RETURNING job_id
+	`), []string{}, triggerSchedule, "once", instanceID).Scan(&jobID)
+	if err != nil {
+		logger.Error("Failed to create import job", "error", err)
+		return 1
+	}
+
+	opts.workspace = ws
+	opts.lock = lock
+	runImport(jobID, opts)
+	defer flushJobLogs(10 * time.Second)
+
+	var status string
+	var errMsg *string
+	if err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT status, error_message FROM {import_history} WHERE job_id = $1`), jobID).Scan(&status, &errMsg); err != nil {
+		logger.Error("Failed to read import result", "job_id", jobID, "error", err)
+		return 1
+	}
+	if status != "completed" && status != "completed_with_warnings" {
+		detail := status
+		if errMsg != nil {
+			detail = fmt.Sprintf("%s: %s", status, *errMsg)
+		}
+		logger.Error("Import did not complete", "job_id", jobID, "status", detail)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ParticipantBucket counts the participants who wrote between Min and Max
+// notes; buckets double in width (1, 2-3, 4-7, ...).
+type ParticipantBucket struct {
+	Min          int `json:"min"`
+	Max          int `json:"max"`
+	Participants int `json:"participants"`
+	Notes        int `json:"notes"`
+}
+
+type ParticipantDistribution struct {
+	JobID         string              `json:"job_id"`
+	ComputedAt    time.Time           `json:"computed_at"`
+	Participants  int                 `json:"participants"`
+	Notes         int                 `json:"notes"`
+	Mean          float64             `json:"mean"`
+	Median        float64             `json:"median"`
+	P90           float64             `json:"p90"`
+	P99           float64             `json:"p99"`
+	Max           int                 `json:"max"`
+	Gini          float64             `json:"gini"`
+	Top1PctShare  float64             `json:"top_1pct_share"`
+	Top10PctShare float64             `json:"top_10pct_share"`
+	Buckets       []ParticipantBucket `json:"buckets"`
+}
+
+// summarizeParticipants computes the distribution of counts, one per
+// participant; counts is sorted in place.
+func summarizeParticipants(counts []int) ParticipantDistribution {
+	d := ParticipantDistribution{Participants: len(counts), Buckets: []ParticipantBucket{}}
+	if len(counts) == 0 {
+		return d
+	}
+	slices.Sort(counts)
+
+	var weighted float64
+	for i, n := range counts {
+		d.Notes += n
+		weighted += float64(i+1) * float64(n)
+
+		b := int(math.Log2(float64(n)))
+		for len(d.Buckets) <= b {
+			lo := 1 << len(d.Buckets)
+			d.Buckets = append(d.Buckets, ParticipantBucket{Min: lo, Max: 2*lo - 1})
+		}
+		d.Buckets[b].Participants++
+		d.Buckets[b].Notes += n
+	}
+
+	total, size := float64(d.Notes), float64(len(counts))
+	d.Mean = total / size
+	d.Median = quantile(counts, 0.5)
+	d.P90 = quantile(counts, 0.9)
+	d.P99 = quantile(counts, 0.99)
+	d.Max = counts[len(counts)-1]
+	d.Gini = 2*weighted/(size*total) - (size+1)/size
+	d.Top1PctShare = topShare(counts, 0.01, total)
+	d.Top10PctShare = topShare(counts, 0.1, total)
+	return d
+}
+
+// quantile interpolates linearly between the sorted counts around q.
+func quantile(sorted []int, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(pos)
+	if lo+1 >= len(sorted) {
+		return float64(sorted[lo])
+	}
+	return float64(sorted[lo]) + (pos-float64(lo))*float64(sorted[lo+1]-sorted[lo])
+}
+
+// topShare is the share of all notes written by the most active fraction of
+// participants, at least one.
+func topShare(sorted []int, fraction, total float64) float64 {
+	k := max(int(math.Ceil(fraction*float64(len(sorted)))), 1)
+	var notes int
+	for _, n := range sorted[len(sorted)-k:] {
+		notes += n
+	}
+	return float64(notes) / total
+}
+
+// rollupParticipantDistribution records the notes-per-participant
+// distribution of the loaded dataset against the import that produced it.
+func rollupParticipantDistribution(ctx context.Context, jobID string) (ParticipantDistribution, error) {
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT COUNT(*) FROM {note}
+		WHERE noteauthorparticipantid IS NOT NULL
+		GROUP BY noteauthorparticipantid
+	`))
+	if err != nil {
+		return ParticipantDistribution{}, err
+	}
+	defer rows.Close()
+
+	var counts []int
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			return ParticipantDistribution{}, err
+		}
+		counts = append(counts, n)
+	}
+	if err := rows.Err(); err != nil {
+		return ParticipantDistribution{}, err
+	}
+
+	d := summarizeParticipants(counts)
+	d.JobID = jobID
+	buckets, _ := json.Marshal(d.Buckets)
+	err = db.QueryRowContext(ctx, expandSQL(ctx, `
+		INSERT INTO {participant_distribution} (job_id, computed_at, participants, notes, mean, median, p90, p99, max, gini, top_1pct_share, top_10pct_share, buckets)
+		VALUES ($1, NOW(), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (job_id) DO UPDATE SET computed_at = EXCLUDED.computed_at, participants = EXCLUDED.participants, notes = EXCLUDED.notes,
+			mean = EXCLUDED.mean, median = EXCLUDED.median, p90 = EXCLUDED.p90, p99 = EXCLUDED.p99, max = EXCLUDED.max, gini = EXCLUDED.gini,
+			top_1pct_share = EXCLUDED.top_1pct_share, top_10pct_share = EXCLUDED.top_10pct_share, buckets = EXCLUDED.buckets
+		RETURNING computed_at
+	`), jobID, d.Participants, d.Notes, d.Mean, d.Median, d.P90, d.P99, d.Max, d.Gini, d.Top1PctShare, d.Top10PctShare, buckets).Scan(&d.ComputedAt)
+	return d, err
+}
+
+// getParticipantDistribution returns the rollup of the given job_id, or of the
+// latest import that has one.
+func getParticipantDistribution(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var d ParticipantDistribution
+	var buckets []byte
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT job_id, computed_at, participants, notes, mean, median, p90, p99, max, gini, top_1pct_share, top_10pct_share, buckets
+		FROM {participant_distribution}
+		WHERE $1 = '' OR job_id::text = $1
+		ORDER BY computed_at DESC
+		LIMIT 1
+	`), r.URL.Query().Get("job_id")).Scan(&d.JobID, &d.ComputedAt, &d.Participants, &d.Notes, &d.Mean, &d.Median, &d.P90, &d.P99, &d.Max,
+		&d.Gini, &d.Top1PctShare, &d.Top10PctShare, &buckets)
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeDistributionNotFound, "No participant distribution computed yet; it is built after each completed import")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get participant distribution: "+err.Error())
+		return
+	}
+	json.Unmarshal(buckets, &d.Buckets)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
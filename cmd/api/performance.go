@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type ImportPerformance struct {
+	JobID       string       `json:"job_id"`
+	StartedAt   time.Time    `json:"started_at"`
+	CompletedAt time.Time    `json:"completed_at"`
+	DataDate    *string      `json:"data_date,omitempty"`
+	Mode        string       `json:"mode"`
+	TotalRows   int          `json:"total_rows"`
+	Files       *int         `json:"files,omitempty"`
+	Bytes       *int64       `json:"bytes,omitempty"`
+	Cached      bool         `json:"cached"`
+	Phases      ImportPhases `json:"phases"`
+	RowsPerSec  *float64     `json:"rows_per_sec,omitempty"`
+	MBPerSec    *float64     `json:"mb_per_sec,omitempty"`
+}
+
+// ImportPhases are wall-clock seconds: download runs from job start to the
+// first COPY (discovery, download, extraction and fingerprinting), load from
+// there to the index rebuild, and index to completion.
+type ImportPhases struct {
+	Download *float64 `json:"download_seconds,omitempty"`
+	Load     *float64 `json:"load_seconds,omitempty"`
+	Index    *float64 `json:"index_seconds,omitempty"`
+	Total    float64  `json:"total_seconds"`
+}
+
+// ImportPerformanceTrend compares the newest run with the median of the
+// earlier runs in the window.
+type ImportPerformanceTrend struct {
+	Runs                  int      `json:"runs"`
+	MedianRowsPerSec      *float64 `json:"median_rows_per_sec,omitempty"`
+	MedianMBPerSec        *float64 `json:"median_mb_per_sec,omitempty"`
+	MedianTotalSeconds    *float64 `json:"median_total_seconds,omitempty"`
+	LatestRowsPerSecDelta *float64 `json:"latest_rows_per_sec_change_pct,omitempty"`
+	LatestMBPerSecDelta   *float64 `json:"latest_mb_per_sec_change_pct,omitempty"`
+	LatestTotalDelta      *float64 `json:"latest_total_seconds_change_pct,omitempty"`
+}
+
+type ImportPerformanceResponse struct {
+	Runs  []ImportPerformance    `json:"runs"`
+	Trend ImportPerformanceTrend `json:"trend"`
+}
+
+const performanceColumns = `job_id, started_at, completed_at, data_date::text, COALESCE(load_mode, 'truncate'),
+		       COALESCE(total_rows, 0), total_files, file_size, COALESCE(download_cached, false),
+		       EXTRACT(EPOCH FROM (import_started_at - started_at))::float8,
+		       EXTRACT(EPOCH FROM (indexing_started_at - import_started_at))::float8,
+		       EXTRACT(EPOCH FROM (completed_at - indexing_started_at))::float8,
+		       EXTRACT(EPOCH FROM (completed_at - started_at))::float8`
+
+func scanImportPerformance(row rowScanner) (ImportPerformance, error) {
+	var p ImportPerformance
+	var dataDate sql.NullString
+	var files, size sql.NullInt64
+	var download, load, index sql.NullFloat64
+	if err := row.Scan(&p.JobID, &p.StartedAt, &p.CompletedAt, &dataDate, &p.Mode, &p.TotalRows, &files, &size, &p.Cached,
+		&download, &load, &index, &p.Phases.Total); err != nil {
+		return p, err
+	}
+	p.DataDate = nullStringToStrPtr(dataDate)
+	p.Files = nullInt64ToIntPtr(files)
+	p.Bytes = nullInt64ToInt64Ptr(size)
+	p.Phases.Download = nullFloat64ToFloat64Ptr(download)
+	p.Phases.Load = nullFloat64ToFloat64Ptr(load)
+	p.Phases.Index = nullFloat64ToFloat64Ptr(index)
+
+	if p.Phases.Load != nil && *p.Phases.Load > 0 {
+		v := float64(p.TotalRows) / *p.Phases.Load
+		p.RowsPerSec = &v
+	}
+	if !p.Cached && p.Bytes != nil && p.Phases.Download != nil && *p.Phases.Download > 0 {
+		v := float64(*p.Bytes) / (1 << 20) / *p.Phases.Download
+		p.MBPerSec = &v
+	}
+	return p, nil
+}
+
+func getImportPerformance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	limit := 20
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+
+	mode := q.Get("mode")
+	if mode != "" && mode != loadModeTruncate && mode != loadModeUpsert {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "mode must be truncate or upsert")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT `+performanceColumns+`
+		FROM {import_history}
+		WHERE status IN ('completed', 'completed_with_warnings') AND completed_at IS NOT NULL
+		  AND ($1 = '' OR COALESCE(load_mode, 'truncate') = $1)
+		ORDER BY completed_at DESC
+		LIMIT $2
+	`), mode, limit)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import performance: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	runs := []ImportPerformance{}
+	for rows.Next() {
+		p, err := scanImportPerformance(rows)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import performance: "+err.Error())
+			return
+		}
+		runs = append(runs, p)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import performance: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ImportPerformanceResponse{Runs: runs, Trend: performanceTrend(runs)})
+}
+
+// performanceTrend expects runs newest first.
+func performanceTrend(runs []ImportPerformance) ImportPerformanceTrend {
+	t := ImportPerformanceTrend{Runs: len(runs)}
+	if len(runs) < 2 {
+		return t
+	}
+	latest, earlier := runs[0], runs[1:]
+
+	t.MedianRowsPerSec = medianOf(earlier, func(p ImportPerformance) *float64 { return p.RowsPerSec })
+	t.MedianMBPerSec = medianOf(earlier, func(p ImportPerformance) *float64 { return p.MBPerSec })
+	t.MedianTotalSeconds = medianOf(earlier, func(p ImportPerformance) *float64 { return &p.Phases.Total })
+
+	t.LatestRowsPerSecDelta = percentChange(latest.RowsPerSec, t.MedianRowsPerSec)
+	t.LatestMBPerSecDelta = percentChange(latest.MBPerSec, t.MedianMBPerSec)
+	t.LatestTotalDelta = percentChange(&latest.Phases.Total, t.MedianTotalSeconds)
+	return t
+}
+
+func medianOf(runs []ImportPerformance, value func(ImportPerformance) *float64) *float64 {
+	var values []float64
+	for _, p := range runs {
+		if v := value(p); v != nil {
+			values = append(values, *v)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	slices.Sort(values)
+	m := values[len(values)/2]
+	if len(values)%2 == 0 {
+		m = (values[len(values)/2-1] + m) / 2
+	}
+	return &m
+}
+
+func percentChange(v, base *float64) *float64 {
+	if v == nil || base == nil || *base == 0 {
+		return nil
+	}
+	pct := (*v - *base) / *base * 100
+	return &pct
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	pipelineImport      = getEnvBool("PIPELINE_IMPORT", false)
+	pipelineBufferFiles = getEnvInt("PIPELINE_BUFFER_FILES", 2)
+)
+
+var errDownloadStopped = errors.New("download stopped after an earlier file failed")
+
+// snapshotPipeline downloads and extracts a snapshot's files in the
+// background, in index order, so the load can start on a file while later
+// ones are still downloading. With a buffer, at most that many files (or the
+// download concurrency, if higher) are downloaded or downloading ahead of the
+// one being loaded.
+type snapshotPipeline struct {
+	indexes []int
+	names   []string
+	sizes   []int64
+	files   []FileInfo
+	errs    []error
+	ready   []chan struct{}
+	slots   chan struct{}
+	cancel  context.CancelFunc
+}
+
+// startSnapshotPipeline starts fetching the files of date selected by sel
+// (all when nil) with up to concurrency parallel downloads; buffer 0 fetches
+// them all without waiting for the load.
+func startSnapshotPipeline(ctx context.Context, date, jobID string, concurrency, buffer int, sel []int) (*snapshotPipeline, error) {
+	indexes, names, sizes, err := prepareDownloads(ctx, date, jobID, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(sizes)
+	overlapped := buffer > 0
+	if !overlapped {
+		buffer = n
+	}
+	p := &snapshotPipeline{
+		indexes: indexes,
+		names:   names,
+		sizes:   sizes,
+		files:   make([]FileInfo, n),
+		errs:    make([]error, n),
+		ready:   make([]chan struct{}, n),
+		slots:   make(chan struct{}, max(buffer, concurrency, 1)),
+	}
+	for i := range p.ready {
+		p.ready[i] = make(chan struct{})
+	}
+
+	ctx, p.cancel = context.WithCancel(ctx)
+	go p.run(ctx, date, jobID, concurrency, overlapped)
+	return p, nil
+}
+
+func (p *snapshotPipeline) run(ctx context.Context, date, jobID string, concurrency int, overlapped bool) {
+	sem := make(chan struct{}, max(concurrency, 1))
+	var failed atomic.Bool
+	var spaceMu sync.Mutex
+	for i := range p.files {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			p.abandon(i, ctx.Err())
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			p.abandon(i, ctx.Err())
+			return
+		}
+		if failed.Load() {
+			p.abandon(i, errDownloadStopped)
+			return
+		}
+		if isPauseRequested(ctx, jobID) {
+			p.abandon(i, errImportPaused)
+			return
+		}
+		go func() {
+			defer func() { <-sem }()
+			p.files[i], p.errs[i] = fetchSnapshotFile(ctx, date, jobID, p.indexes[i], len(p.files), &spaceMu, overlapped)
+			if p.errs[i] != nil {
+				failed.Store(true)
+			}
+			close(p.ready[i])
+		}()
+	}
+}
+
+// abandon fails the files from index i on, which were never started.
+func (p *snapshotPipeline) abandon(i int, err error) {
+	for ; i < len(p.files); i++ {
+		p.errs[i] = err
+		close(p.ready[i])
+	}
+}
+
+func (p *snapshotPipeline) len() int {
+	return len(p.files)
+}
+
+func (p *snapshotPipeline) totalSize() int64 {
+	var n int64
+	for _, s := range p.sizes {
+		n += s
+	}
+	return n
+}
+
+// wait blocks until file i is extracted or has failed.
+func (p *snapshotPipeline) wait(i int) (FileInfo, error) {
+	<-p.ready[i]
+	return p.files[i], p.errs[i]
+}
+
+// release frees the buffer slot of a file the load is done with.
+func (p *snapshotPipeline) release() {
+	select {
+	case <-p.slots:
+	default:
+	}
+}
+
+// stop cancels downloads still running; partial files are kept for resume.
+func (p *snapshotPipeline) stop() {
+	p.cancel()
+}
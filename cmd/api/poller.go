@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+var (
+	upstreamPollEnabled  = getEnvBool("UPSTREAM_POLL_ENABLED", false)
+	upstreamPollInterval = getEnvDuration("UPSTREAM_POLL_INTERVAL", 15*time.Minute)
+	upstreamPollJitter   = getEnvFloat("UPSTREAM_POLL_JITTER", 0.2)
+)
+
+// jitteredInterval spreads d by ±UPSTREAM_POLL_JITTER so that instances and
+// workspaces do not poll upstream in lockstep.
+func jitteredInterval(d time.Duration) time.Duration {
+	j := min(max(upstreamPollJitter, 0), 1)
+	return time.Duration(float64(d) * (1 + j*(2*rand.Float64()-1)))
+}
+
+// newerSnapshotDate HEADs the first file of each day from today back to the
+// day after last and returns the newest one upstream has published.
+func newerSnapshotDate(ctx context.Context, last string) (string, bool) {
+	for i := 0; i < 7; i++ {
+		date := getDateDaysAgo(i)
+		if date <= last {
+			break
+		}
+		req, err := newSnapshotRequest(ctx, "HEAD", snapshotURL(date, 0))
+		if err != nil {
+			return "", false
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return date, true
+		}
+	}
+	return "", false
+}
+
+func startUpstreamPollers() {
+	if !upstreamPollEnabled {
+		return
+	}
+	for _, ws := range workspaceOrder {
+		go pollUpstream(ws)
+	}
+}
+
+func pollUpstream(ws *workspace) {
+	logger := logger.With("workspace", ws.Name)
+	ctx := withWorkspace(context.Background(), ws)
+	scheduler := ws.scheduler
+	logger.Info("Upstream poller started", "interval", upstreamPollInterval, "jitter", upstreamPollJitter)
+
+	for {
+		wait := jitteredInterval(upstreamPollInterval)
+		scheduler.mu.Lock()
+		scheduler.nextPoll = time.Now().Add(wait)
+		scheduler.mu.Unlock()
+		time.Sleep(wait)
+
+		scheduler.mu.Lock()
+		scheduler.lastPoll = time.Now()
+		scheduler.mu.Unlock()
+
+		if h, err := currentImport(ctx); err == nil && h != nil {
+			continue
+		}
+
+		var last string
+		db.QueryRowContext(ctx, expandSQL(ctx, `
+			SELECT data_date::text FROM {import_history}
+			WHERE status IN ('completed', 'completed_with_warnings', 'skipped_unchanged') AND data_date IS NOT NULL
+			ORDER BY completed_at DESC LIMIT 1
+		`)).Scan(&last)
+
+		date, ok := newerSnapshotDate(ctx, last)
+		if !ok {
+			continue
+		}
+
+		logger.Info("Upstream published a newer snapshot, triggering import", "latest", date, "last", last)
+		status, err := triggerImport(ctx, ws, triggerSchedule)
+		if err != nil {
+			logger.Warn("Failed to trigger import", "error", err)
+		} else if status != http.StatusCreated {
+			logger.Warn("Failed to trigger import", "status", status)
+		}
+	}
+}
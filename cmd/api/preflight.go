@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	preflightMinFreeDisk        = getEnv("PREFLIGHT_MIN_FREE_DISK", "2GiB")
+	preflightMaxReplicationLag  = getEnvDuration("PREFLIGHT_MAX_REPLICATION_LAG", 5*time.Minute)
+	preflightMinFreeConnections = getEnvInt("PREFLIGHT_MIN_FREE_CONNECTIONS", 5)
+)
+
+var preflightMinFreeDiskBytes int64
+
+func loadPreflight() error {
+	n, err := parseByteSize(preflightMinFreeDisk)
+	if err != nil {
+		return fmt.Errorf("PREFLIGHT_MIN_FREE_DISK: %w", err)
+	}
+	preflightMinFreeDiskBytes = n
+	return nil
+}
+
+const (
+	preflightPassed  = "passed"
+	preflightFailed  = "failed"
+	preflightSkipped = "skipped"
+)
+
+type PreflightCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+type PreflightReport struct {
+	OK        bool             `json:"ok"`
+	CheckedAt time.Time        `json:"checked_at"`
+	Checks    []PreflightCheck `json:"checks"`
+}
+
+func (r PreflightReport) failures() []string {
+	var failed []string
+	for _, c := range r.Checks {
+		if c.Status == preflightFailed {
+			failed = append(failed, c.Name+": "+c.Detail)
+		}
+	}
+	return failed
+}
+
+// runPreflight checks that an import can run in the context's workspace: free
+// disk in its data directory, replica lag, applied migrations and spare
+// connection slots. A check whose threshold is 0 is skipped.
+func runPreflight(ctx context.Context) PreflightReport {
+	report := PreflightReport{OK: true, CheckedAt: time.Now()}
+	for _, check := range []func(context.Context) PreflightCheck{checkFreeDisk, checkReplicationLag, checkMigrations, checkConnectionSlots} {
+		c := check(ctx)
+		if c.Status == preflightFailed {
+			report.OK = false
+		}
+		report.Checks = append(report.Checks, c)
+	}
+	return report
+}
+
+func checkFreeDisk(ctx context.Context) PreflightCheck {
+	c := PreflightCheck{Name: "disk_space"}
+	if preflightMinFreeDiskBytes <= 0 {
+		c.Status, c.Detail = preflightSkipped, "PREFLIGHT_MIN_FREE_DISK is 0"
+		return c
+	}
+	dir := workspaceFromContext(ctx).dataDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		c.Status, c.Detail = preflightFailed, "data directory is not writable: "+err.Error()
+		return c
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		c.Status, c.Detail = preflightFailed, "cannot read free space: "+err.Error()
+		return c
+	}
+	free := int64(st.Bavail) * int64(st.Bsize)
+	c.Detail = fmt.Sprintf("%s free in %s, %s required", formatBytes(free), dir, formatBytes(preflightMinFreeDiskBytes))
+	c.Status = preflightPassed
+	if free < preflightMinFreeDiskBytes {
+		c.Status = preflightFailed
+	}
+	return c
+}
+
+// checkReplicationLag reads the replay lag of the primary's streaming
+// replicas; without any, or when this database is itself a standby, there is
+// nothing to wait for.
+func checkReplicationLag(ctx context.Context) PreflightCheck {
+	c := PreflightCheck{Name: "replication_lag"}
+	if preflightMaxReplicationLag <= 0 {
+		c.Status, c.Detail = preflightSkipped, "PREFLIGHT_MAX_REPLICATION_LAG is 0"
+		return c
+	}
+	var replicas int
+	var lag float64
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM MAX(replay_lag)), 0) FROM pg_stat_replication`).Scan(&replicas, &lag)
+	if err != nil {
+		c.Status, c.Detail = preflightFailed, "cannot read pg_stat_replication: "+err.Error()
+		return c
+	}
+	if replicas == 0 {
+		c.Status, c.Detail = preflightPassed, "no streaming replicas"
+		return c
+	}
+	d := time.Duration(lag * float64(time.Second)).Round(time.Second)
+	c.Detail = fmt.Sprintf("largest replay lag %s across %d replicas, at most %s allowed", d, replicas, preflightMaxReplicationLag)
+	c.Status = preflightPassed
+	if d > preflightMaxReplicationLag {
+		c.Status = preflightFailed
+	}
+	return c
+}
+
+// checkMigrations compares the migrations recorded in the workspace with this
+// build's: fewer means the schema was reset or restored from an older dump
+// since startup, more means a newer release migrated it.
+func checkMigrations(ctx context.Context) PreflightCheck {
+	c := PreflightCheck{Name: "migrations"}
+	var applied int
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COALESCE(MAX(migrations), 0) FROM {schema_migrations}`)).Scan(&applied)
+	if err != nil {
+		c.Status, c.Detail = preflightFailed, "cannot read applied migrations: "+err.Error()
+		return c
+	}
+	switch {
+	case applied < len(schemaMigrations):
+		c.Status = preflightFailed
+		c.Detail = fmt.Sprintf("%d of %d migrations applied; restart to apply the rest", applied, len(schemaMigrations))
+	case applied > len(schemaMigrations):
+		c.Status = preflightFailed
+		c.Detail = fmt.Sprintf("schema has %d migrations, this build knows %d; upgrade this instance", applied, len(schemaMigrations))
+	default:
+		c.Status, c.Detail = preflightPassed, fmt.Sprintf("all %d migrations applied", applied)
+	}
+	return c
+}
+
+func checkConnectionSlots(ctx context.Context) PreflightCheck {
+	c := PreflightCheck{Name: "connection_slots"}
+	if preflightMinFreeConnections <= 0 {
+		c.Status, c.Detail = preflightSkipped, "PREFLIGHT_MIN_FREE_CONNECTIONS is 0"
+		return c
+	}
+	var free, maxConns int
+	err := db.QueryRowContext(ctx, `
+		SELECT current_setting('max_connections')::int - current_setting('superuser_reserved_connections')::int - (SELECT COUNT(*) FROM pg_stat_activity WHERE backend_type = 'client backend'),
+		       current_setting('max_connections')::int
+	`).Scan(&free, &maxConns)
+	if err != nil {
+		c.Status, c.Detail = preflightFailed, "cannot count connections: "+err.Error()
+		return c
+	}
+	c.Detail = fmt.Sprintf("%d of %d connection slots free, %d required", free, maxConns, preflightMinFreeConnections)
+	c.Status = preflightPassed
+	if free < preflightMinFreeConnections {
+		c.Status = preflightFailed
+	}
+	return c
+}
+
+// requirePreflight answers 503 preflight_failed, naming the failed checks,
+// unless every check passes.
+func requirePreflight(w http.ResponseWriter, ctx context.Context) bool {
+	report := runPreflight(ctx)
+	if report.OK {
+		return true
+	}
+	failed := report.failures()
+	logger.Warn("Import refused by pre-flight checks", "failed", failed)
+	writeProblem(w, http.StatusServiceUnavailable, errCodePreflightFailed, "Pre-flight checks failed: "+strings.Join(failed, "; "))
+	return false
+}
+
+// getPreflight runs the pre-flight checks on demand, answering 503 when any
+// fails so it can back a readiness probe.
+func getPreflight(w http.ResponseWriter, r *http.Request) {
+	report := runPreflight(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !report.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	downloadPhaseWeight = 40
+	importPhaseWeight   = 50
+	indexPhaseWeight    = 10
+)
+
+// computeOverallProgress fills the download, import and overall percentages.
+// Files are weighted by byte size in both phases so that a few large files do
+// not make file-count progress misleading; within the file being imported,
+// progress is its share of that file's expected rows.
+func computeOverallProgress(h *HistoryEntry) {
+	switch h.Status {
+	case "downloading", "importing", "indexing", "paused":
+	default:
+		return
+	}
+
+	downloadFraction := downloadProgress(h)
+	importFraction := importProgress(h)
+	var indexFraction float64
+
+	switch h.Status {
+	case "indexing":
+		downloadFraction, importFraction = 1, 1
+		if h.IndexBlocksTotal != nil && *h.IndexBlocksTotal > 0 && h.IndexBlocksDone != nil {
+			indexFraction = float64(*h.IndexBlocksDone) / float64(*h.IndexBlocksTotal)
+		}
+	case "importing":
+		// A pipelined load imports while later files still download.
+		if !slices.ContainsFunc(h.Files, func(f ImportFile) bool { return f.Status == "pending" }) {
+			downloadFraction = 1
+		}
+	}
+
+	downloadPct := int(downloadFraction * 100)
+	importPct := int(importFraction * 100)
+	h.DownloadProgress = &downloadPct
+	h.ImportProgress = &importPct
+
+	pct := int(downloadFraction*downloadPhaseWeight + importFraction*importPhaseWeight + indexFraction*indexPhaseWeight)
+	h.Percentage = &pct
+
+	if pct <= 0 || h.Status == "paused" {
+		return
+	}
+	elapsed := time.Since(h.StartedAt)
+	eta := h.StartedAt.Add(elapsed * 100 / time.Duration(pct))
+	h.EstimatedCompletionAt = &eta
+}
+
+func downloadProgress(h *HistoryEntry) float64 {
+	var totalBytes, doneBytes int64
+	for _, f := range h.Files {
+		if f.Size == nil {
+			continue
+		}
+		totalBytes += *f.Size
+		switch {
+		case f.Status != "pending":
+			doneBytes += *f.Size
+		case f.BytesDownloaded != nil:
+			doneBytes += min(*f.BytesDownloaded, *f.Size)
+		case h.CurrentFileIndex != nil && *h.CurrentFileIndex == f.Index && h.DownloadPercentage != nil:
+			doneBytes += *f.Size * int64(*h.DownloadPercentage) / 100
+		}
+	}
+	if totalBytes == 0 {
+		return 0
+	}
+	return float64(doneBytes) / float64(totalBytes)
+}
+
+// importProgress falls back to rows processed over total rows when file sizes
+// or expected row counts are not known yet.
+func importProgress(h *HistoryEntry) float64 {
+	if h.RowsProcessed == nil || *h.RowsProcessed == 0 {
+		return 0
+	}
+
+	var totalBytes, doneBytes float64
+	importedRows := 0
+	var current *ImportFile
+	for i, f := range h.Files {
+		if f.Size == nil {
+			return rowFraction(h)
+		}
+		totalBytes += float64(*f.Size)
+		// Files load in index order; a transactional load only marks them
+		// imported at commit.
+		switch {
+		case f.Status == "imported" || h.CurrentFileIndex != nil && f.Index < *h.CurrentFileIndex:
+			doneBytes += float64(*f.Size)
+			if f.RowsImported != nil {
+				importedRows += *f.RowsImported
+			} else if f.ExpectedRows != nil {
+				importedRows += *f.ExpectedRows
+			}
+		case h.CurrentFileIndex != nil && *h.CurrentFileIndex == f.Index:
+			current = &h.Files[i]
+		}
+	}
+	if totalBytes == 0 {
+		return rowFraction(h)
+	}
+
+	if current != nil && current.ExpectedRows != nil && *current.ExpectedRows > 0 {
+		inFile := min(float64(max(*h.RowsProcessed-importedRows, 0))/float64(*current.ExpectedRows), 1)
+		doneBytes += inFile * float64(*current.Size)
+	}
+	return min(doneBytes/totalBytes, 1)
+}
+
+func rowFraction(h *HistoryEntry) float64 {
+	if h.TotalRows == nil || *h.TotalRows <= 0 || h.RowsProcessed == nil {
+		return 0
+	}
+	return min(float64(*h.RowsProcessed)/float64(*h.TotalRows), 1)
+}
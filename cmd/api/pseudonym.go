@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// pseudonymizeSecret, when set, keys an HMAC-SHA256 that replaces every
+// participant ID column on import, so datasets loaded with the same secret
+// still join on participant without holding the raw identifiers.
+var pseudonymizeSecret = getEnv("PSEUDONYMIZE_SECRET", "")
+
+func init() {
+	registerFieldTransform("hmac", func(columnMappingEntry) (fieldTransform, error) {
+		if pseudonymizeSecret == "" {
+			return nil, errors.New("hmac transform needs PSEUDONYMIZE_SECRET")
+		}
+		return pseudonymizeField, nil
+	})
+}
+
+func pseudonymize(id string) string {
+	return hex.EncodeToString(hmacSHA256([]byte(pseudonymizeSecret), id))
+}
+
+func pseudonymizeField(id string) (string, error) {
+	return pseudonymize(id), nil
+}
+
+// pseudonymKeyID identifies the secret in import history without revealing
+// it, so imports made with different secrets can be told apart; "" when
+// pseudonymization is off.
+func pseudonymKeyID() string {
+	if pseudonymizeSecret == "" {
+		return ""
+	}
+	return pseudonymize("x-notes-pseudonym-key")[:12]
+}
+
+// isParticipantColumn matches the participant ID columns of every dataset:
+// noteAuthorParticipantId in notes, raterParticipantId in ratings and
+// participantId in enrollment.
+func isParticipantColumn(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), "participantid")
+}
+
+// lastPseudonymKey is the pseudonym key of the latest completed import other
+// than jobID, false when there is none.
+func lastPseudonymKey(ctx context.Context, jobID string) (string, bool) {
+	var key string
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT COALESCE(pseudonym_key, '') FROM {import_history}
+		WHERE job_id <> $1 AND status IN ('completed', 'completed_with_warnings')
+		ORDER BY completed_at DESC LIMIT 1
+	`), jobID).Scan(&key)
+	return key, err == nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ParticipantPurge records that a participant's rows were deleted. Only a
+// SHA-256 of the participant ID is kept, enough for later imports to drop the
+// participant's rows again without the purge list holding the ID itself.
+type ParticipantPurge struct {
+	ParticipantHash string    `json:"participant_hash"`
+	NotesDeleted    int64     `json:"notes_deleted"`
+	Reason          *string   `json:"reason,omitempty"`
+	PurgedBy        *string   `json:"purged_by,omitempty"`
+	PurgedAt        time.Time `json:"purged_at"`
+}
+
+type PurgeRequest struct {
+	ParticipantID string `json:"participant_id"`
+	Reason        string `json:"reason"`
+}
+
+// purgeDerivedTables hold rows keyed by noteid that go with a purged note.
+var purgeDerivedTables = []string{"note_embeddings", "note_duplicates", "note_topics"}
+
+func participantHash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanPurge(row rowScanner) (ParticipantPurge, error) {
+	var p ParticipantPurge
+	var reason, purgedBy sql.NullString
+	err := row.Scan(&p.ParticipantHash, &p.NotesDeleted, &reason, &purgedBy, &p.PurgedAt)
+	p.Reason = nullStringToStrPtr(reason)
+	p.PurgedBy = nullStringToStrPtr(purgedBy)
+	return p, err
+}
+
+// purgedParticipants is the set of purged participant hashes that imports
+// drop rows for.
+func purgedParticipants(ctx context.Context) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT participant_hash FROM {participant_purges}`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	purged := map[string]bool{}
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		purged[h] = true
+	}
+	return purged, rows.Err()
+}
+
+// dropsPurged reports whether the plan has a participant column whose rows
+// must be checked against the purge list before COPY.
+func (p columnPlan) dropsPurged() bool {
+	if len(p.purged) == 0 {
+		return false
+	}
+	for _, c := range p.Columns {
+		if isParticipantColumn(c.Source) || isParticipantColumn(c.Target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPurgedRow matches a row on the raw participant ID as read from the file,
+// before any pseudonymization.
+func (p columnPlan) isPurgedRow(fields []string) bool {
+	for i, c := range p.Columns {
+		if fields[i] == copyNull || !isParticipantColumn(c.Source) && !isParticipantColumn(c.Target) {
+			continue
+		}
+		if p.purged[participantHash(unquoteCopyField(fields[i]))] {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteParticipantRows deletes the participant's notes from note and, when
+// one is set aside, note_previous, along with what was derived from them. The
+// participant's pseudonym is matched too for data loaded with
+// PSEUDONYMIZE_SECRET.
+func deleteParticipantRows(ctx context.Context, tx *sql.Tx, participantID string) (int64, error) {
+	ids := []string{participantID}
+	if pseudonymizeSecret != "" {
+		ids = append(ids, pseudonymize(participantID))
+	}
+
+	rows, err := tx.QueryContext(ctx, expandSQL(ctx, `DELETE FROM {note} WHERE noteauthorparticipantid = ANY($1) RETURNING noteid`), ids)
+	if err != nil {
+		return 0, err
+	}
+	var noteIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		noteIDs = append(noteIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if previousNoteExists(ctx) {
+		if _, err := tx.ExecContext(ctx, expandSQL(ctx, `DELETE FROM {note_previous} WHERE noteauthorparticipantid = ANY($1)`), ids); err != nil {
+			return 0, err
+		}
+	}
+	if len(noteIDs) == 0 {
+		return 0, nil
+	}
+	for _, t := range purgeDerivedTables {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, qualifiedTable(ctx, t)).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if !exists {
+			continue
+		}
+		stmt := `DELETE FROM {` + t + `} WHERE noteid = ANY($1)`
+		if t == "note_duplicates" {
+			stmt += ` OR duplicate_noteid = ANY($1)`
+		}
+		if _, err := tx.ExecContext(ctx, expandSQL(ctx, stmt), noteIDs); err != nil {
+			return 0, fmt.Errorf("%s: %w", t, err)
+		}
+	}
+	return int64(len(noteIDs)), nil
+}
+
+func listPurges(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT participant_hash, notes_deleted, reason, purged_by, purged_at FROM {participant_purges} ORDER BY purged_at DESC`))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list purges: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	purges := []ParticipantPurge{}
+	for rows.Next() {
+		p, err := scanPurge(rows)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list purges: "+err.Error())
+			return
+		}
+		purges = append(purges, p)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list purges: "+err.Error())
+		return
+	}
+	writeList(w, r, purges)
+}
+
+// createPurge deletes a participant's rows and records the purge in one
+// transaction. An import already past reading the purge list can load the rows
+// back; purging the participant again deletes them and adds to the count.
+func createPurge(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req PurgeRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	req.ParticipantID = strings.TrimSpace(req.ParticipantID)
+	if req.ParticipantID == "" {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "participant_id is required")
+		return
+	}
+
+	var reason, purgedBy *string
+	if req.Reason != "" {
+		reason = &req.Reason
+	}
+	if p, ok := principalFromContext(ctx); ok {
+		purgedBy = &p.Name
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to purge participant: "+err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteParticipantRows(ctx, tx, req.ParticipantID)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to purge participant: "+err.Error())
+		return
+	}
+	purge, err := scanPurge(tx.QueryRowContext(ctx, expandSQL(ctx, `
+		INSERT INTO {participant_purges} AS p (participant_hash, notes_deleted, reason, purged_by, purged_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (participant_hash) DO UPDATE SET notes_deleted = p.notes_deleted + EXCLUDED.notes_deleted,
+			reason = COALESCE(EXCLUDED.reason, p.reason), purged_by = EXCLUDED.purged_by, purged_at = EXCLUDED.purged_at
+		RETURNING participant_hash, notes_deleted, reason, purged_by, purged_at
+	`), participantHash(req.ParticipantID), deleted, reason, purgedBy))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to record purge: "+err.Error())
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to purge participant: "+err.Error())
+		return
+	}
+	invalidateResponseCache(ctx)
+	logger.Info("Participant purged", "participant_hash", purge.ParticipantHash, "notes_deleted", deleted)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(purge)
+}
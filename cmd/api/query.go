@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+var (
+	queryRole     = getEnv("QUERY_ROLE", "")
+	queryPassword = getEnv("QUERY_PASSWORD", "")
+	queryMaxConns = getEnvInt("QUERY_MAX_CONNS", 4)
+	queryTimeout  = getEnvDuration("QUERY_TIMEOUT", 30*time.Second)
+	queryMaxRows  = getEnvInt("QUERY_MAX_ROWS", 10000)
+)
+
+// queryDB logs in as QUERY_ROLE itself rather than switching to it with SET
+// ROLE, which a query could undo with set_config('role', ...) and run as the
+// server's own user.
+var (
+	queryPool *pgxpool.Pool
+	queryDB   *sql.DB
+)
+
+// openQueryDB opens queryDB from the main pool's settings with QUERY_ROLE's
+// credentials; connections are made on first use.
+func openQueryDB(config *pgxpool.Config) error {
+	if queryRole == "" {
+		return nil
+	}
+	config = config.Copy()
+	config.ConnConfig.User = queryRole
+	config.ConnConfig.Password = queryPassword
+	config.MaxConns = int32(max(queryMaxConns, 1))
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return fmt.Errorf("failed to open query pool: %w", err)
+	}
+	queryPool, queryDB = pool, stdlib.OpenDBFromPool(pool)
+	return nil
+}
+
+var querySelectRe = regexp.MustCompile(`(?i)^(select|with)\b`)
+
+type QueryRequest struct {
+	SQL     string `json:"sql"`
+	Format  string `json:"format"`
+	MaxRows int    `json:"max_rows"`
+}
+
+type QueryResult struct {
+	Columns   []string `json:"columns"`
+	Rows      [][]any  `json:"rows"`
+	RowCount  int      `json:"row_count"`
+	Truncated bool     `json:"truncated"`
+}
+
+func validateQueryRequest(req QueryRequest) (string, []FieldError) {
+	var errs []FieldError
+	stmt := strings.TrimSuffix(strings.TrimSpace(req.SQL), ";")
+	switch {
+	case stmt == "":
+		errs = append(errs, FieldError{Field: "sql", Detail: "is required"})
+	case strings.Contains(stmt, ";"):
+		errs = append(errs, FieldError{Field: "sql", Detail: "must be a single statement"})
+	case !querySelectRe.MatchString(stmt):
+		errs = append(errs, FieldError{Field: "sql", Detail: "must be a SELECT or WITH query"})
+	}
+	if req.Format != "" && req.Format != "json" && req.Format != "csv" {
+		errs = append(errs, FieldError{Field: "format", Detail: "must be json or csv"})
+	}
+	if req.MaxRows < 0 || req.MaxRows > queryMaxRows {
+		errs = append(errs, FieldError{Field: "max_rows", Detail: fmt.Sprintf("must be between 1 and %d", queryMaxRows)})
+	}
+	return stmt, errs
+}
+
+// runQuery executes stmt in a read-only transaction on queryDB, so QUERY_ROLE's
+// grants are what actually sandbox the caller; {table} placeholders expand to
+// the workspace's tables like in the server's own queries. The timeout is also
+// enforced client-side, since the statement itself can lift
+// statement_timeout, and the row cap is applied in SQL so the server stops
+// producing rows past it.
+func runQuery(r *http.Request, stmt string, maxRows int) (QueryResult, error) {
+	ctx := r.Context()
+	if queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, queryTimeout)
+		defer cancel()
+	}
+	result := QueryResult{Rows: [][]any{}}
+
+	tx, err := queryDB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", queryTimeout.Milliseconds())); err != nil {
+		return result, err
+	}
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT * FROM (%s\n) q LIMIT %d", expandSQL(ctx, stmt), maxRows+1))
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	if result.Columns, err = rows.Columns(); err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		if len(result.Rows) == maxRows {
+			result.Truncated = true
+			break
+		}
+		values := make([]any, len(result.Columns))
+		ptrs := make([]any, len(values))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return result, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	result.RowCount = len(result.Rows)
+	return result, rows.Err()
+}
+
+func postQuery(w http.ResponseWriter, r *http.Request) {
+	if queryDB == nil {
+		writeProblem(w, http.StatusNotFound, errCodeQueryDisabled, "Ad-hoc queries are not enabled (set QUERY_ROLE)")
+		return
+	}
+
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+	stmt, fieldErrs := validateQueryRequest(req)
+	if len(fieldErrs) > 0 {
+		writeFieldProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid query", fieldErrs)
+		return
+	}
+	maxRows := queryMaxRows
+	if req.MaxRows > 0 {
+		maxRows = req.MaxRows
+	}
+
+	result, err := runQuery(r, stmt, maxRows)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case isTimeoutError(r.Context(), err):
+			writeProblem(w, http.StatusGatewayTimeout, errCodeQueryTimeout, fmt.Sprintf("Query exceeded the %s timeout", queryTimeout))
+		case errors.As(err, &pgErr):
+			writeProblem(w, http.StatusBadRequest, errCodeQueryFailed, "Query failed: "+pgErr.Message)
+		default:
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Query failed: "+err.Error())
+		}
+		return
+	}
+
+	if req.Format == "csv" || req.Format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		writeQueryCSV(w, result)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func writeQueryCSV(w http.ResponseWriter, result QueryResult) {
+	w.Header().Set("Content-Type", "text/csv")
+	if result.Truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(result.Columns)
+	record := make([]string, len(result.Columns))
+	for _, row := range result.Rows {
+		for i, v := range row {
+			switch v := v.(type) {
+			case nil:
+				record[i] = ""
+			case time.Time:
+				record[i] = v.Format(time.RFC3339Nano)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+}
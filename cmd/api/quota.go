@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var cacheMaxSize = getEnv("CACHE_MAX_SIZE", "")
+
+var cacheMaxBytes int64
+
+var errCacheQuotaExceeded = errors.New("cache quota exceeded")
+
+func parseByteSize(raw string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(raw))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			mult = int64(1) << (10 * (i + 1))
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", raw)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+func loadCacheQuota() error {
+	if cacheMaxSize == "" {
+		return nil
+	}
+	n, err := parseByteSize(cacheMaxSize)
+	if err != nil {
+		return fmt.Errorf("CACHE_MAX_SIZE: %w", err)
+	}
+	cacheMaxBytes = n
+	return nil
+}
+
+// cacheUsage sums the snapshot files in dir per date, counting hard-linked
+// files once.
+func cacheUsage(dir string) (int64, map[string]int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var total int64
+	byDate := make(map[string]int64)
+	var seen []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if slices.ContainsFunc(seen, func(s os.FileInfo) bool { return s.Size() == info.Size() && os.SameFile(s, info) }) {
+			continue
+		}
+		seen = append(seen, info)
+		total += info.Size()
+		if m := localNoteFilePattern.FindStringSubmatch(entry.Name()); m != nil {
+			byDate[m[1]] += info.Size()
+		}
+	}
+	return total, byDate, nil
+}
+
+// ensureCacheSpace evicts the oldest snapshot dates other than keepDate until
+// needed more bytes fit under CACHE_MAX_SIZE, and fails without touching
+// keepDate's files when that is not enough.
+func ensureCacheSpace(dir, keepDate string, needed int64, log *slog.Logger) error {
+	if cacheMaxBytes == 0 {
+		return nil
+	}
+
+	for {
+		used, byDate, err := cacheUsage(dir)
+		if err != nil {
+			return fmt.Errorf("failed to measure cache: %w", err)
+		}
+		if used+needed <= cacheMaxBytes {
+			return nil
+		}
+
+		var dates []string
+		for d := range byDate {
+			if d != keepDate {
+				dates = append(dates, d)
+			}
+		}
+		if len(dates) == 0 {
+			return fmt.Errorf("%w: %s more needed but %s of CACHE_MAX_SIZE %s is in use by this snapshot and other files",
+				errCacheQuotaExceeded, formatBytes(needed), formatBytes(used), formatBytes(cacheMaxBytes))
+		}
+
+		oldest := slices.Min(dates)
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if m := localNoteFilePattern.FindStringSubmatch(entry.Name()); m != nil && m[1] == oldest {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+		}
+		log.Info("Evicted cached snapshot to stay under quota", "date", oldest, "freed", formatBytes(byDate[oldest]))
+	}
+}
+
+// extractionSpace is the extra disk space extracting zipPath will take, net of
+// a previous extraction it replaces.
+func extractionSpace(zipPath string) int64 {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return 0
+	}
+	defer reader.Close()
+
+	var n int64
+	for _, f := range reader.File {
+		n += int64(f.UncompressedSize64)
+	}
+	if info, err := os.Stat(strings.TrimSuffix(zipPath, ".zip") + ".tsv"); err == nil {
+		n -= info.Size()
+	}
+	return max(n, 0)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	readTimeout          = getEnvDuration("READ_TIMEOUT", 15*time.Second)
+	readTimeoutsSetting  = getEnv("READ_TIMEOUTS", "GET /aggregate=60s,GET /imports/compare=60s")
+	readTimeoutOverrides = map[string]time.Duration{}
+)
+
+// loadReadTimeouts parses READ_TIMEOUTS, comma-separated route patterns as
+// registered in main, each with the timeout that replaces READ_TIMEOUT for it.
+func loadReadTimeouts() error {
+	for _, pair := range strings.Split(readTimeoutsSetting, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		pattern, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("READ_TIMEOUTS entry %q is not pattern=duration", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d < 0 {
+			return fmt.Errorf("READ_TIMEOUTS entry %q: invalid duration", pair)
+		}
+		readTimeoutOverrides[strings.TrimSpace(pattern)] = d
+	}
+	return nil
+}
+
+// isTimeoutError reports whether err is a query cut short by a context
+// deadline or by the server's statement_timeout.
+func isTimeoutError(ctx context.Context, err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "57014" {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// timeoutWriter replaces the 5xx a handler writes for a query that ran out of
+// time with a 504, so each handler need not tell timeouts from other errors.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	timeout time.Duration
+	gateway bool
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	if status >= http.StatusInternalServerError && errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.gateway = true
+		writeProblem(w.ResponseWriter, http.StatusGatewayTimeout, errCodeQueryTimeout, fmt.Sprintf("Request exceeded the %s timeout", w.timeout))
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if w.gateway {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withReadTimeout bounds a read handler's request context by READ_TIMEOUT or
+// its READ_TIMEOUTS override; pgx cancels the running query on the server once
+// the deadline passes. A zero timeout leaves the handler unbounded.
+func withReadTimeout(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := readTimeout
+		if d, ok := readTimeoutOverrides[r.Pattern]; ok {
+			timeout = d
+		}
+		if timeout <= 0 {
+			h(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		h(&timeoutWriter{ResponseWriter: w, ctx: ctx, timeout: timeout}, r.WithContext(ctx))
+	}
+}
+
+// applyQueryRoleTimeout sets QUERY_TIMEOUT as the default statement_timeout of
+// QUERY_ROLE, a backstop for clients such as PostgREST that log in as that
+// role directly rather than through POST /query.
+func applyQueryRoleTimeout() {
+	if queryRole == "" || queryTimeout <= 0 {
+		return
+	}
+	stmt := fmt.Sprintf("ALTER ROLE %s SET statement_timeout = %d", quoteIdent(queryRole), queryTimeout.Milliseconds())
+	if _, err := db.ExecContext(context.Background(), stmt); err != nil {
+		logger.Warn("Failed to set statement_timeout for the query role", "role", queryRole, "error", err)
+	}
+}
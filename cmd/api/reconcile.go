@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+const (
+	rowCountCheckOff   = "off"
+	rowCountCheckWarn  = "warn"
+	rowCountCheckAbort = "abort"
+)
+
+var (
+	rowCountCheck     = getEnv("ROW_COUNT_CHECK", rowCountCheckWarn)
+	rowCountTolerance = getEnvFloat("ROW_COUNT_TOLERANCE", 0.2)
+)
+
+func validateRowCountCheck() error {
+	switch rowCountCheck {
+	case rowCountCheckOff, rowCountCheckWarn, rowCountCheckAbort:
+	default:
+		return fmt.Errorf("ROW_COUNT_CHECK must be off, warn or abort, got %q", rowCountCheck)
+	}
+	if rowCountTolerance < 0 || rowCountTolerance >= 1 {
+		return fmt.Errorf("ROW_COUNT_TOLERANCE must be between 0 and 1, got %g", rowCountTolerance)
+	}
+	return nil
+}
+
+// fileRowCount pairs the rows a file was expected to hold, from an earlier
+// load of the same content or the TSV estimate, with the rows COPY loaded.
+type fileRowCount struct {
+	FileName string
+	Expected int
+	Loaded   int
+}
+
+// reconcileRowCounts describes each way the load strays beyond
+// rowCountTolerance: a file whose count is off its expected rows, or a full
+// load that holds fewer rows than the previous completed full import.
+func reconcileRowCounts(ctx context.Context, jobID string, files []fileRowCount, loaded int, full bool) []string {
+	var warnings []string
+	for _, f := range files {
+		if f.Expected > 0 && math.Abs(float64(f.Loaded-f.Expected)) > rowCountTolerance*float64(f.Expected) {
+			warnings = append(warnings, fmt.Sprintf("%s loaded %d rows, expected about %d", f.FileName, f.Loaded, f.Expected))
+		}
+	}
+
+	if !full {
+		return warnings
+	}
+	var previous int
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `
+		SELECT total_rows FROM {import_history}
+		WHERE status IN ('completed', 'completed_with_warnings') AND job_id <> $1
+		  AND COALESCE(load_mode, 'truncate') = 'truncate' AND snapshot_fingerprint IS NOT NULL AND total_rows > 0
+		ORDER BY completed_at DESC LIMIT 1
+	`), jobID).Scan(&previous)
+	if err == nil && float64(loaded) < float64(previous)*(1-rowCountTolerance) {
+		warnings = append(warnings, fmt.Sprintf("loaded %d rows, %.0f%% fewer than the previous import's %d", loaded, float64(previous-loaded)/float64(previous)*100, previous))
+	}
+	return warnings
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// fieldTransform rewrites one non-NULL field of a row on its way to COPY.
+type fieldTransform func(value string) (string, error)
+
+// fieldTransforms builds the transform a column mapping entry names in its
+// transform field; registerFieldTransform adds callbacks to it.
+var fieldTransforms = map[string]func(entry columnMappingEntry) (fieldTransform, error){
+	"redact": func(entry columnMappingEntry) (fieldTransform, error) {
+		return func(string) (string, error) { return entry.Replacement, nil }, nil
+	},
+	"regex": func(entry columnMappingEntry) (fieldTransform, error) {
+		if entry.Pattern == "" {
+			return nil, fmt.Errorf("regex transform needs a pattern")
+		}
+		re, err := regexp.Compile(entry.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		return func(v string) (string, error) { return re.ReplaceAllString(v, entry.Replacement), nil }, nil
+	},
+	"hash": func(columnMappingEntry) (fieldTransform, error) {
+		return func(v string) (string, error) {
+			sum := sha256.Sum256([]byte(v))
+			return hex.EncodeToString(sum[:]), nil
+		}, nil
+	},
+}
+
+// registerFieldTransform makes build available as transform name in the
+// column mapping, for deployments that redact in code; call it before the
+// mapping is loaded.
+func registerFieldTransform(name string, build func(entry columnMappingEntry) (fieldTransform, error)) {
+	fieldTransforms[name] = build
+}
+
+func buildFieldTransform(entry columnMappingEntry) (fieldTransform, error) {
+	build, ok := fieldTransforms[entry.Transform]
+	if !ok {
+		names := make([]string, 0, len(fieldTransforms))
+		for name := range fieldTransforms {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("unknown transform %q; transforms are %s", entry.Transform, strings.Join(names, ", "))
+	}
+	return build(entry)
+}
+
+// transformsRows reports whether any column is rewritten or rows of purged
+// participants dropped before COPY, which then has to stream the file.
+func (p columnPlan) transformsRows() bool {
+	return p.dropsPurged() || slices.ContainsFunc(p.Columns, func(c plannedColumn) bool { return c.transform != nil })
+}
+
+// transformReader applies the plan's field transforms to the rows read from
+// r, passing the header through. Rows are split on the delimiter like the
+// header is, so one whose field count differs from the header's fails the
+// load rather than loading a field into the wrong column unredacted. Close
+// the returned reader once done with it so the transform stops.
+func (p columnPlan) transformReader(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(p.transformRows(r, pw))
+	}()
+	return pr
+}
+
+func (p columnPlan) transformRows(r io.Reader, w io.Writer) error {
+	br := bufio.NewReaderSize(r, 1<<20)
+	bw := bufio.NewWriterSize(w, 1<<20)
+	header := true
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if !header {
+				if line, err = p.transformLine(line); err != nil {
+					return fmt.Errorf("line %d: %w", n, err)
+				}
+			}
+			header = false
+			if _, err := bw.Write(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p columnPlan) transformLine(line []byte) ([]byte, error) {
+	body := bytes.TrimRight(line, "\r\n")
+	eol := line[len(body):]
+	fields := strings.Split(string(body), copyDelimiter)
+	if len(fields) != len(p.Columns) {
+		return nil, fmt.Errorf("%d fields where the header has %d", len(fields), len(p.Columns))
+	}
+	if p.dropsPurged() && p.isPurgedRow(fields) {
+		return nil, nil
+	}
+	for i, c := range p.Columns {
+		if c.transform == nil || fields[i] == copyNull {
+			continue
+		}
+		v, err := c.transform(unquoteCopyField(fields[i]))
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", c.Source, err)
+		}
+		if fields[i], err = quoteCopyField(v); err != nil {
+			return nil, fmt.Errorf("column %s: %w", c.Source, err)
+		}
+	}
+	return append([]byte(strings.Join(fields, copyDelimiter)), eol...), nil
+}
+
+// unquoteCopyField strips CSV quoting from a field; text format fields are
+// transformed as they are, escapes included.
+func unquoteCopyField(f string) string {
+	q := copyQuoteChar
+	if copyFormat != "csv" || len(f) < 2 || !strings.HasPrefix(f, q) || !strings.HasSuffix(f, q) {
+		return f
+	}
+	f = strings.ReplaceAll(f[1:len(f)-1], copyEscapeChar+q, q)
+	if copyEscapeChar != q {
+		f = strings.ReplaceAll(f, copyEscapeChar+copyEscapeChar, copyEscapeChar)
+	}
+	return f
+}
+
+func quoteCopyField(v string) (string, error) {
+	special := strings.Contains(v, copyDelimiter) || strings.ContainsAny(v, "\r\n")
+	if copyFormat != "csv" {
+		if special || strings.Contains(v, `\`) {
+			return "", fmt.Errorf("transformed value needs escaping, which is only supported with COPY_FORMAT=csv")
+		}
+		return v, nil
+	}
+	if special || strings.Contains(v, copyQuoteChar) || v == copyNull {
+		if copyQuoteChar == copyNoQuote {
+			return "", fmt.Errorf("transformed value needs quoting but COPY_QUOTE is none")
+		}
+		if copyEscapeChar != copyQuoteChar {
+			v = strings.ReplaceAll(v, copyEscapeChar, copyEscapeChar+copyEscapeChar)
+		}
+		return copyQuoteChar + strings.ReplaceAll(v, copyQuoteChar, copyEscapeChar+copyQuoteChar) + copyQuoteChar, nil
+	}
+	return v, nil
+}
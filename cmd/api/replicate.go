@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	replicateTruncate = "truncate"
+	replicateAppend   = "append"
+)
+
+type noteColumn struct {
+	Name string
+	Type string
+}
+
+// replicatedColumns lists the note table's columns with their full types, so
+// a target table can be created to match.
+func replicatedColumns(ctx context.Context) ([]noteColumn, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT attname, format_type(atttypid, atttypmod)
+		FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped AND attgenerated = ''
+		ORDER BY attnum
+	`, qualifiedTable(ctx, "note"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []noteColumn
+	for rows.Next() {
+		var c noteColumn
+		if err := rows.Scan(&c.Name, &c.Type); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func noteColumnNames(columns []noteColumn) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// copyNotesTo streams the loaded notes, less excluded ones, to out in COPY
+// text format with columns in the given order, straight from the server's
+// COPY TO STDOUT.
+func copyNotesTo(ctx context.Context, out io.Writer, columns []string) (int64, error) {
+	conn, err := dbPool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	stmt := expandSQL(ctx, `COPY (SELECT `+quoteColumns(columns)+` FROM {note} n WHERE `+notExcluded("n")+`) TO STDOUT`)
+	tag, err := conn.Conn().PgConn().CopyTo(ctx, out, stmt)
+	return tag.RowsAffected(), err
+}
+
+// streamNotesCopy answers with the notes as a COPY text stream that a
+// downstream database loads with COPY ... FROM STDIN, using the column list
+// from X-Copy-Columns.
+func streamNotesCopy(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	columns, err := replicatedColumns(ctx)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to read note columns: "+err.Error())
+		return
+	}
+	names := noteColumnNames(columns)
+
+	w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+	w.Header().Set("X-Copy-Columns", strings.Join(names, ","))
+	rows, err := copyNotesTo(ctx, w, names)
+	if err != nil {
+		logger.Warn("Note COPY stream ended early", "rows", rows, "error", err)
+		return
+	}
+	logger.Info("Streamed notes", "rows", rows)
+}
+
+// replicateNotes copies the workspace's notes into table on the Postgres at
+// dsn in one transaction, piping COPY TO on this database into COPY FROM on
+// the target. The table is created from the note table's column types when
+// missing; mode truncate empties it first, append adds to it.
+func replicateNotes(ctx context.Context, dsn, table, mode string) (int64, error) {
+	if mode != replicateTruncate && mode != replicateAppend {
+		return 0, fmt.Errorf("mode must be %s or %s", replicateTruncate, replicateAppend)
+	}
+	columns, err := replicatedColumns(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read note columns: %w", err)
+	}
+	names := noteColumnNames(columns)
+
+	target, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to target: %w", err)
+	}
+	defer target.Close(context.Background())
+
+	tx, err := target.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	ident := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+	defs := make([]string, len(columns))
+	for i, c := range columns {
+		defs[i] = quoteIdent(c.Name) + " " + c.Type
+	}
+	if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+ident+` (`+strings.Join(defs, ", ")+`)`); err != nil {
+		return 0, fmt.Errorf("failed to create target table: %w", err)
+	}
+	if mode == replicateTruncate {
+		if _, err := tx.Exec(ctx, `TRUNCATE `+ident); err != nil {
+			return 0, fmt.Errorf("failed to truncate target table: %w", err)
+		}
+	}
+
+	pr, pw := io.Pipe()
+	copied := make(chan error, 1)
+	go func() {
+		_, err := copyNotesTo(ctx, pw, names)
+		pw.CloseWithError(err)
+		copied <- err
+	}()
+	tag, err := tx.Conn().PgConn().CopyFrom(ctx, pr, `COPY `+ident+` (`+quoteColumns(names)+`) FROM STDIN`)
+	pr.CloseWithError(err)
+	srcErr := <-copied
+	if err != nil && (srcErr == nil || errors.Is(srcErr, io.ErrClosedPipe) || errors.Is(err, srcErr)) {
+		return 0, fmt.Errorf("failed to load target table: %w", err)
+	}
+	if srcErr != nil {
+		return 0, fmt.Errorf("failed to read notes: %w", srcErr)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// runReplicateOnce is --replicate-to: it replicates one workspace and returns
+// the process exit code.
+func runReplicateOnce(wsName, dsn, table, mode string) int {
+	ws, ok := workspaces[wsName]
+	if !ok {
+		logger.Error("Unknown workspace", "workspace", wsName)
+		return 2
+	}
+	start := time.Now()
+	rows, err := replicateNotes(withWorkspace(context.Background(), ws), dsn, table, mode)
+	if err != nil {
+		logger.Error("Replication failed", "workspace", ws.Name, "table", table, "error", err)
+		return 1
+	}
+	logger.Info("Replication completed", "workspace", ws.Name, "table", table, "mode", mode, "rows", rows, "duration", time.Since(start).Round(time.Millisecond))
+	return 0
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	responseCacheSize     = getEnvInt("RESPONSE_CACHE_SIZE", 1000)
+	responseCacheTTL      = getEnvDuration("RESPONSE_CACHE_TTL", 10*time.Minute)
+	responseCacheMaxEntry = getEnvInt("RESPONSE_CACHE_MAX_ENTRY_BYTES", 1<<20)
+)
+
+type cachedResponse struct {
+	key       string
+	workspace string
+	header    http.Header
+	body      []byte
+	storedAt  time.Time
+}
+
+// responseCache is an LRU of successful read responses. Entries are keyed by
+// workspace, path, query and negotiated media type and are dropped per
+// workspace whenever its dataset changes; the TTL bounds how long an instance
+// serves a dataset that another instance has since replaced.
+type responseCache struct {
+	mu          sync.Mutex
+	entries     map[string]*list.Element
+	lru         *list.List
+	generations map[string]uint64
+
+	hits, misses, evictions atomic.Int64
+}
+
+var respCache = &responseCache{entries: map[string]*list.Element{}, lru: list.New(), generations: map[string]uint64{}}
+
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cachedResponse)
+	if time.Since(e.storedAt) >= responseCacheTTL {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+// put stores e unless its workspace was invalidated since generation was
+// read, which would cache a response computed from the replaced dataset.
+func (c *responseCache) put(e *cachedResponse, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generations[e.workspace] != generation {
+		return
+	}
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > responseCacheSize {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *responseCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cachedResponse).key)
+}
+
+func (c *responseCache) generation(ws string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generations[ws]
+}
+
+func (c *responseCache) invalidate(ws string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generations[ws]++
+	dropped := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*cachedResponse).workspace == ws {
+			c.remove(el)
+			dropped++
+		}
+		el = next
+	}
+	return dropped
+}
+
+func (c *responseCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// invalidateResponseCache drops the cached responses of the context's
+// workspace; called wherever the notes it serves change.
+func invalidateResponseCache(ctx context.Context) {
+	if responseCacheSize <= 0 {
+		return
+	}
+	ws := workspaceFromContext(ctx)
+	if n := respCache.invalidate(ws.Name); n > 0 {
+		logger.Debug("Response cache invalidated", "workspace", ws.Name, "entries", n)
+	}
+}
+
+// cacheRecorder passes the response through while keeping a copy of a 200
+// body small enough to cache.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     []byte
+	overflow bool
+}
+
+func (w *cacheRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status == http.StatusOK && !w.overflow {
+		if len(w.body)+len(b) > responseCacheMaxEntry {
+			w.overflow, w.body = true, nil
+		} else {
+			w.body = append(w.body, b...)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withResponseCache serves repeated reads of h from the response cache.
+// Clients can skip it with Cache-Control: no-cache; the X-Cache header says
+// whether a response came from it.
+func withResponseCache(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if responseCacheSize <= 0 || responseCacheTTL <= 0 {
+			h(w, r)
+			return
+		}
+		ws := workspaceFromContext(r.Context()).Name
+		key := ws + "\x00" + listMediaType(r) + "\x00" + r.URL.Path + "?" + r.URL.RawQuery
+
+		w.Header().Add("Vary", "Accept")
+		if !strings.Contains(r.Header.Get("Cache-Control"), "no-cache") {
+			if e, ok := respCache.get(key); ok {
+				respCache.hits.Add(1)
+				for k, v := range e.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("X-Cache", "HIT")
+				w.Write(e.body)
+				return
+			}
+		}
+		respCache.misses.Add(1)
+		w.Header().Set("X-Cache", "MISS")
+
+		generation := respCache.generation(ws)
+		rec := &cacheRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status != http.StatusOK || rec.overflow {
+			return
+		}
+		header := w.Header().Clone()
+		header.Del("X-Cache")
+		header.Del(requestIDHeader)
+		respCache.put(&cachedResponse{key: key, workspace: ws, header: header, body: rec.body, storedAt: time.Now()}, generation)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	copyMaxRetries   = getEnvInt("COPY_MAX_RETRIES", 3)
+	copyRetryBackoff = getEnvDuration("COPY_RETRY_BACKOFF", 2*time.Second)
+)
+
+// isTransientDBError reports whether err is worth retrying: serialization
+// failures, deadlocks, lock timeouts, server restarts and dropped connections.
+func isTransientDBError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code[:2] {
+		case "08", "53":
+			return true
+		}
+		switch pgErr.Code {
+		case "40001", "40P01", "55P03", "57P01", "57P02", "57P03":
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func withRetry(ctx context.Context, log *slog.Logger, what string, fn func() error) (int, error) {
+	backoff := copyRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransientDBError(err) || attempt > copyMaxRetries {
+			return attempt, err
+		}
+
+		log.Warn("Transient database error, retrying", "operation", what, "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// verifyCachedFiles checks the failed job's cached archives against the TSV
+// hashes it recorded: a file whose extracted TSV is still cached and hashes
+// the same is reused, one whose TSV differs is deleted so that the retry
+// downloads it again. Files the parent never hashed are left to the normal
+// cache check.
+func verifyCachedFiles(ctx context.Context, parentID string, log *slog.Logger) (reused, discarded int, err error) {
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT file_name, content_hash FROM {import_files}
+		WHERE job_id = $1 AND content_hash IS NOT NULL
+		ORDER BY file_index
+	`), parentID)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	dir := workspaceFromContext(ctx).dataDir()
+	for rows.Next() {
+		var name, hash string
+		if err := rows.Scan(&name, &hash); err != nil {
+			return reused, discarded, err
+		}
+		zipPath := filepath.Join(dir, name)
+		tsvPath := strings.TrimSuffix(zipPath, ".zip") + ".tsv"
+		cached, err := hashFile(tsvPath)
+		if err != nil {
+			continue
+		}
+		if cached == hash {
+			reused++
+			continue
+		}
+		log.Warn("Cached file no longer matches the failed import's hash, discarding it", "file", name)
+		for _, p := range []string{zipPath, compressedCachePath(zipPath), tsvPath} {
+			os.Remove(p)
+		}
+		discarded++
+	}
+	return reused, discarded, rows.Err()
+}
+
+// retryImportAsChild starts a new job for the failed job's snapshot date,
+// recording the failed one as its parent, instead of resuming it in place. It
+// serves both POST /imports/{job_id}/retry and the older
+// POST /admin/imports/{job_id}/retry.
+func retryImportAsChild(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
+	parentID := r.PathValue("job_id")
+
+	if !requirePreflight(w, ctx) {
+		return
+	}
+	lock := lockImports(w, ctx)
+	if lock == nil {
+		return
+	}
+	defer func() { lock.release() }()
+
+	var active int
+	db.QueryRowContext(ctx, expandSQL(ctx, `SELECT COUNT(*) FROM {import_history} WHERE status IN ('importing', 'downloading', 'indexing', 'paused')`)).Scan(&active)
+	if active > 0 {
+		writeProblem(w, http.StatusConflict, errCodeImportInProgress, "Import already in progress or paused")
+		return
+	}
+
+	var status string
+	var dataDate sql.NullString
+	err := db.QueryRowContext(ctx, expandSQL(ctx, `SELECT status, data_date::text FROM {import_history} WHERE job_id = $1`), parentID).Scan(&status, &dataDate)
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeImportNotFound, "Import job not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get import: "+err.Error())
+		return
+	}
+	if status != "failed" {
+		writeProblem(w, http.StatusConflict, errCodeImportNotRetryable, "Only failed imports can be retried")
+		return
+	}
+	if !dataDate.Valid {
+		writeProblem(w, http.StatusConflict, errCodeSnapshotNotFound, "Import has no snapshot date to retry")
+		return
+	}
+
+	triggeredBy := triggerUser
+	var triggeredByName *string
+	if p, ok := principalFromContext(r.Context()); ok {
+		triggeredBy, triggeredByName = p.Kind, &p.Name
+	}
+
+	var jobID string
+	err = db.QueryRowContext(ctx, expandSQL(ctx, `
+		INSERT INTO {import_history} (started_at, status, download_percentage, rows_processed, labels, note, triggered_by, triggered_by_name,
+		                              load_mode, offline, file_range, row_limit, data_date, parent_job_id, owner_instance, heartbeat_at)
+		SELECT NOW(), 'downloading', 0, 0, labels, note, $2, $3, load_mode, offline, file_range, row_limit, data_date, job_id, $4, NOW()
+		FROM {import_history} WHERE job_id = $1
+		RETURNING job_id
+	`), parentID, triggeredBy, triggeredByName, instanceID).Scan(&jobID)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to create retry job: "+err.Error())
+		return
+	}
+
+	log := jobLogger(ctx, jobID)
+	reused, discarded, err := verifyCachedFiles(ctx, parentID, log)
+	if err != nil {
+		log.Warn("Failed to verify cached files of the failed import", "parent_job_id", parentID, "error", err)
+	}
+	log.Info("Retrying failed import as a new job", "parent_job_id", parentID, "date", dataDate.String, "cached_files_reused", reused, "cached_files_discarded", discarded)
+
+	w.Header().Set("Location", "/admin/imports/"+jobID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"message": "Retry started", "job_id": jobID, "parent_job_id": parentID, "cached_files_reused": reused, "cached_files_discarded": discarded})
+
+	go runImport(jobID, importOptions{resume: true, force: true, requestID: requestIDFromContext(r.Context()), workspace: workspaceFromContext(ctx), lock: lock})
+	lock = nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// checkConstraint is a CHECK that restricts a text column to a list of
+// values, kept in sync with the code by schemaMigrations.
+type checkConstraint struct {
+	column string
+	values []string
+}
+
+var (
+	importFileStatusCheck = checkConstraint{"status", []string{"pending", "downloaded", "imported"}}
+	importStatusCheck     = checkConstraint{"status", importStatuses}
+	importTriggerCheck    = checkConstraint{"triggered_by", importTriggers}
+)
+
+// definition is the constraint as pg_get_constraintdef prints it.
+func (c checkConstraint) definition() string {
+	values := make([]string, len(c.values))
+	for i, v := range c.values {
+		values[i] = "'" + v + "'::text"
+	}
+	return fmt.Sprintf("CHECK ((%s = ANY (ARRAY[%s])))", c.column, strings.Join(values, ", "))
+}
+
+// dropStaleCheck drops the constraint only when its definition differs from
+// c, so an unchanged one isn't rebuilt (ACCESS EXCLUSIVE and a full
+// revalidation) on every start; addCheck then recreates what was dropped.
+func dropStaleCheck(table, name string, c checkConstraint) string {
+	return fmt.Sprintf(`DO $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = '{%[1]s}'::regclass AND conname = '{prefix}%[2]s' AND pg_get_constraintdef(oid) <> $def$%[3]s$def$) THEN
+			ALTER TABLE {%[1]s} DROP CONSTRAINT {prefix}%[2]s;
+		END IF;
+	END $$`, table, name, c.definition())
+}
+
+func addCheck(table, name string, c checkConstraint) string {
+	values := make([]string, len(c.values))
+	for i, v := range c.values {
+		values[i] = "'" + v + "'"
+	}
+	return fmt.Sprintf(`DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = '{%[1]s}'::regclass AND conname = '{prefix}%[2]s') THEN
+			ALTER TABLE {%[1]s} ADD CONSTRAINT {prefix}%[2]s CHECK (%[3]s IN (%[4]s));
+		END IF;
+	END $$`, table, name, c.column, strings.Join(values, ", "))
+}
+
+var schemaMigrations = []string{
+	`CREATE SCHEMA IF NOT EXISTS {schema}`,
+	`CREATE TABLE IF NOT EXISTS {import_history} (
+		id SERIAL PRIMARY KEY,
+		job_id UUID DEFAULT gen_random_uuid() NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		completed_at TIMESTAMP,
+		total_rows INT,
+		status TEXT NOT NULL,
+		error_message TEXT,
+		download_percentage INT,
+		download_speed TEXT,
+		download_eta TEXT,
+		created_at TIMESTAMP DEFAULT NOW(),
+		rows_processed INT,
+		download_completed_at TIMESTAMP,
+		download_cached BOOLEAN DEFAULT false,
+		download_duration INT,
+		import_started_at TIMESTAMP,
+		import_duration INT,
+		file_size BIGINT,
+		total_files INT,
+		current_file_index INT,
+		files_processed INT,
+		file_names TEXT,
+		data_date DATE,
+		indexing_started_at TIMESTAMP,
+		index_phase TEXT,
+		index_blocks_done INT,
+		index_blocks_total INT
+	)`,
+	`CREATE INDEX IF NOT EXISTS {prefix}idx_import_history_started_at ON {import_history}(started_at DESC)`,
+	`CREATE TABLE IF NOT EXISTS {note} (
+		noteid bigint NOT NULL,
+		noteauthorparticipantid character varying(255),
+		created_at timestamptz,
+		created_date date GENERATED ALWAYS AS ((created_at AT TIME ZONE 'UTC')::date) STORED,
+		tweetid character varying(255),
+		classification character varying(255),
+		believable character varying(255),
+		harmful character varying(255),
+		validationdifficulty character varying(255),
+		misleadingother integer NOT NULL,
+		misleadingfactualerror integer NOT NULL,
+		misleadingmanipulatedmedia integer NOT NULL,
+		misleadingoutdatedinformation integer NOT NULL,
+		misleadingmissingimportantcontext integer NOT NULL,
+		misleadingunverifiedclaimasfact integer NOT NULL,
+		misleadingsatire integer NOT NULL,
+		notmisleadingother integer NOT NULL,
+		notmisleadingfactuallycorrect integer NOT NULL,
+		notmisleadingoutdatedbutnotwhenwritten integer NOT NULL,
+		notmisleadingclearlysatire integer NOT NULL,
+		notmisleadingpersonalopinion integer NOT NULL,
+		trustworthysources integer NOT NULL,
+		summary character varying(8192),
+		ismedianote integer NOT NULL,
+		iscollaborativenote integer NOT NULL,
+		summary_ts tsvector GENERATED ALWAYS AS (to_tsvector('english'::regconfig, (summary)::text)) STORED,
+		CONSTRAINT {prefix}note_pkey PRIMARY KEY (noteid)
+	)`,
+	`CREATE TABLE IF NOT EXISTS {import_files} (
+		job_id UUID NOT NULL,
+		file_index INT NOT NULL,
+		file_name TEXT NOT NULL,
+		file_size BIGINT,
+		status TEXT CHECK (status IN ('pending', 'downloaded', 'imported')) NOT NULL,
+		expected_rows INT,
+		rows_imported INT,
+		cached BOOLEAN,
+		download_duration INT,
+		import_duration INT,
+		imported_at TIMESTAMP,
+		PRIMARY KEY (job_id, file_index)
+	)`,
+	`CREATE TABLE IF NOT EXISTS {import_logs} (
+		id BIGSERIAL PRIMARY KEY,
+		job_id UUID NOT NULL,
+		logged_at TIMESTAMPTZ NOT NULL,
+		level TEXT NOT NULL,
+		message TEXT NOT NULL,
+		attrs JSONB
+	)`,
+	`CREATE INDEX IF NOT EXISTS {prefix}idx_import_logs_job_id ON {import_logs}(job_id, id)`,
+	`CREATE TABLE IF NOT EXISTS {api_keys} (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		role TEXT CHECK (role IN ('reader', 'admin')) NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP
+	)`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS pause_requested BOOLEAN DEFAULT false`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS paused_at TIMESTAMP`,
+	`ALTER TABLE {import_files} ADD COLUMN IF NOT EXISTS expected_rows INT`,
+	`ALTER TABLE {import_files} ADD COLUMN IF NOT EXISTS cached BOOLEAN`,
+	`ALTER TABLE {import_files} ADD COLUMN IF NOT EXISTS download_duration INT`,
+	`ALTER TABLE {import_files} ADD COLUMN IF NOT EXISTS import_duration INT`,
+	dropStaleCheck("import_files", "import_files_status_check", importFileStatusCheck),
+	addCheck("import_files", "import_files_status_check", importFileStatusCheck),
+	dropStaleCheck("import_history", "import_history_status_check", importStatusCheck),
+	addCheck("import_history", "import_history_status_check", importStatusCheck),
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS labels TEXT[] DEFAULT '{}'`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS note TEXT`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS triggered_by TEXT`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS triggered_by_name TEXT`,
+	dropStaleCheck("import_history", "import_history_triggered_by_check", importTriggerCheck),
+	addCheck("import_history", "import_history_triggered_by_check", importTriggerCheck),
+	`CREATE INDEX IF NOT EXISTS {prefix}idx_import_history_labels ON {import_history} USING GIN (labels)`,
+	`CREATE TABLE IF NOT EXISTS {note_schema_versions} (
+		version TEXT PRIMARY KEY,
+		columns TEXT[] NOT NULL,
+		first_seen_at TIMESTAMP NOT NULL
+	)`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS schema_version TEXT`,
+	`CREATE TABLE IF NOT EXISTS {note_fingerprints} (
+		noteid BIGINT PRIMARY KEY,
+		hash TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS events_published INT`,
+	`ALTER TABLE {import_files} ADD COLUMN IF NOT EXISTS copy_attempts INT`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS notes_embedded INT`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS offline BOOLEAN DEFAULT false`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS snapshot_fingerprint TEXT`,
+	`ALTER TABLE {import_files} ADD COLUMN IF NOT EXISTS content_hash TEXT`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS error_code TEXT`,
+	`CREATE INDEX IF NOT EXISTS {prefix}idx_import_history_error_code ON {import_history}(error_code) WHERE error_code IS NOT NULL`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS load_mode TEXT DEFAULT 'truncate'`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS owner_instance TEXT`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP`,
+	`ALTER TABLE {import_files} ADD COLUMN IF NOT EXISTS bytes_downloaded BIGINT`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS file_list JSONB`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS parent_job_id UUID`,
+	`CREATE INDEX IF NOT EXISTS {prefix}idx_import_history_parent_job_id ON {import_history}(parent_job_id) WHERE parent_job_id IS NOT NULL`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS warnings TEXT[]`,
+	`CREATE TABLE IF NOT EXISTS {backup_history} (
+		id SERIAL PRIMARY KEY,
+		backup_id UUID DEFAULT gen_random_uuid() NOT NULL UNIQUE,
+		kind TEXT CHECK (kind IN ('backup', 'restore')) NOT NULL,
+		status TEXT CHECK (status IN ('running', 'completed', 'failed')) NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		completed_at TIMESTAMP,
+		file_name TEXT,
+		size_bytes BIGINT,
+		tables TEXT[],
+		source_backup_id UUID,
+		note TEXT,
+		error_message TEXT,
+		owner_instance TEXT
+	)`,
+	`ALTER TABLE {note} ADD COLUMN IF NOT EXISTS tweet_id bigint GENERATED ALWAYS AS (CASE WHEN tweetid ~ '^[0-9]{1,19}$' AND tweetid::numeric <= 9223372036854775807 THEN tweetid::bigint END) STORED`,
+	`ALTER TABLE {note} ADD COLUMN IF NOT EXISTS tweet_url text GENERATED ALWAYS AS (CASE WHEN tweetid ~ '^[0-9]+$' THEN 'https://x.com/i/status/' || tweetid END) STORED`,
+	`DO $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = '{note}'::regclass AND attname = 'createdatmillis' AND NOT attisdropped) THEN
+			ALTER TABLE {note} DROP COLUMN IF EXISTS created_at;
+			ALTER TABLE {note} DROP COLUMN IF EXISTS created_date;
+			ALTER TABLE {note} ALTER COLUMN createdatmillis TYPE timestamptz USING to_timestamp(createdatmillis / 1000.0);
+			ALTER TABLE {note} RENAME COLUMN createdatmillis TO created_at;
+		END IF;
+	END $$`,
+	`ALTER TABLE {note} ADD COLUMN IF NOT EXISTS is_media_note boolean GENERATED ALWAYS AS (ismedianote <> 0) STORED`,
+	`ALTER TABLE {note} ADD COLUMN IF NOT EXISTS is_collaborative_note boolean GENERATED ALWAYS AS (iscollaborativenote <> 0) STORED`,
+	`ALTER TABLE {note} ADD COLUMN IF NOT EXISTS has_trustworthy_sources boolean GENERATED ALWAYS AS (trustworthysources <> 0) STORED`,
+	`CREATE TABLE IF NOT EXISTS {participant_distribution} (
+		job_id UUID PRIMARY KEY,
+		computed_at TIMESTAMP NOT NULL,
+		participants INT NOT NULL,
+		notes INT NOT NULL,
+		mean DOUBLE PRECISION NOT NULL,
+		median DOUBLE PRECISION NOT NULL,
+		p90 DOUBLE PRECISION NOT NULL,
+		p99 DOUBLE PRECISION NOT NULL,
+		max INT NOT NULL,
+		gini DOUBLE PRECISION NOT NULL,
+		top_1pct_share DOUBLE PRECISION NOT NULL,
+		top_10pct_share DOUBLE PRECISION NOT NULL,
+		buckets JSONB NOT NULL
+	)`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS progress_at TIMESTAMP`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS file_range TEXT`,
+	`CREATE TABLE IF NOT EXISTS {export_jobs} (
+		id SERIAL PRIMARY KEY,
+		export_id UUID DEFAULT gen_random_uuid() NOT NULL UNIQUE,
+		status TEXT CHECK (status IN ('queued', 'running', 'completed', 'failed', 'expired')) NOT NULL,
+		format TEXT NOT NULL,
+		filters JSONB NOT NULL,
+		requested_by TEXT,
+		created_at TIMESTAMP NOT NULL,
+		started_at TIMESTAMP,
+		completed_at TIMESTAMP,
+		row_count BIGINT NOT NULL DEFAULT 0,
+		size_bytes BIGINT,
+		error_message TEXT,
+		owner_instance TEXT
+	)`,
+	`ALTER TABLE {export_jobs} ADD COLUMN IF NOT EXISTS object_key TEXT`,
+	`ALTER TABLE {export_jobs} ADD COLUMN IF NOT EXISTS fields TEXT[]`,
+	`CREATE TABLE IF NOT EXISTS {note_exclusions} (
+		noteid BIGINT PRIMARY KEY,
+		reason TEXT,
+		excluded_by TEXT,
+		excluded_at TIMESTAMP NOT NULL
+	)`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS pseudonym_key TEXT`,
+	`CREATE TABLE IF NOT EXISTS {participant_purges} (
+		participant_hash TEXT PRIMARY KEY,
+		notes_deleted BIGINT NOT NULL DEFAULT 0,
+		reason TEXT,
+		purged_by TEXT,
+		purged_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS {webhooks} (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL,
+		events TEXT[] NOT NULL,
+		filters JSONB NOT NULL DEFAULT '{}',
+		description TEXT,
+		active BOOLEAN NOT NULL DEFAULT true,
+		created_by TEXT,
+		created_at TIMESTAMP NOT NULL,
+		last_delivery_at TIMESTAMP,
+		last_status INT,
+		last_error TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS {note_statuses} (
+		noteid BIGINT PRIMARY KEY,
+		classification TEXT,
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	`ALTER TABLE {webhooks} ADD COLUMN IF NOT EXISTS secret TEXT`,
+	`CREATE TABLE IF NOT EXISTS {schema_migrations} (
+		id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+		migrations INTEGER NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`,
+	`ALTER TABLE {import_history} ADD COLUMN IF NOT EXISTS row_limit INTEGER`,
+	`ALTER TABLE {backup_history} ADD COLUMN IF NOT EXISTS object_key TEXT`,
+	`CREATE TABLE IF NOT EXISTS {import_errors} (
+		id BIGSERIAL PRIMARY KEY,
+		job_id UUID,
+		file_name TEXT NOT NULL,
+		noteid TEXT,
+		column_name TEXT NOT NULL,
+		value TEXT,
+		error TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`ALTER TABLE {note} ADD COLUMN IF NOT EXISTS created_date date GENERATED ALWAYS AS ((created_at AT TIME ZONE 'UTC')::date) STORED`,
+	`CREATE INDEX IF NOT EXISTS {prefix}idx_import_errors_job_id ON {import_errors}(job_id, id)`,
+}
+
+func migrateSchema() error {
+	for _, ws := range workspaceOrder {
+		ctx := withWorkspace(context.Background(), ws)
+		for i, stmt := range schemaMigrations {
+			if _, err := db.ExecContext(ctx, expandSQL(ctx, stmt)); err != nil {
+				return fmt.Errorf("failed to apply schema migration %d to workspace %s: %w", i, ws.Name, err)
+			}
+		}
+		_, err := db.ExecContext(ctx, expandSQL(ctx, `
+			INSERT INTO {schema_migrations} AS m (migrations, applied_at) VALUES ($1, NOW())
+			ON CONFLICT (id) DO UPDATE SET migrations = GREATEST(m.migrations, EXCLUDED.migrations), applied_at = EXCLUDED.applied_at
+		`), len(schemaMigrations))
+		if err != nil {
+			return fmt.Errorf("failed to record schema migrations in workspace %s: %w", ws.Name, err)
+		}
+	}
+	return nil
+}
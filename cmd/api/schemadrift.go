@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var schemaDriftAutoAdd = getEnvBool("SCHEMA_DRIFT_AUTO_ADD", false)
+
+var identifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+func readTSVHeader(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+
+	var columns []string
+	for _, c := range strings.Split(strings.TrimRight(line, "\r\n"), copyDelimiter) {
+		columns = append(columns, strings.ToLower(strings.TrimSpace(c)))
+	}
+	return columns, nil
+}
+
+type tableColumn struct {
+	Name     string
+	DataType string
+}
+
+func tableColumns(ctx context.Context, table string) ([]tableColumn, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT column_name, data_type
+		FROM information_schema.columns
+		WHERE table_schema = $1 AND table_name = $2 AND is_generated = 'NEVER'
+		ORDER BY ordinal_position
+	`, workspaceFromContext(ctx).Schema, tableName(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []tableColumn
+	for rows.Next() {
+		var c tableColumn
+		if err := rows.Scan(&c.Name, &c.DataType); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func schemaVersionOf(columns []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(columns, ",")))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quoteIdent(c)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// checkTSVHeader verifies that a file reaching a pipelined load has the same
+// header as the first one, which the load's column plan was built from.
+func checkTSVHeader(f, first FileInfo) error {
+	header, err := readTSVHeader(first.TSVPath)
+	if err != nil {
+		return fmt.Errorf("failed to read header of %s: %w", first.FileName, err)
+	}
+	h, err := readTSVHeader(f.TSVPath)
+	if err != nil {
+		return fmt.Errorf("failed to read header of %s: %w", f.FileName, err)
+	}
+	if !slices.Equal(header, h) {
+		return headerMismatch(f, first)
+	}
+	return nil
+}
+
+func headerMismatch(f, first FileInfo) error {
+	return fmt.Errorf("header of %s differs from %s", f.FileName, first.FileName)
+}
+
+// reconcileSchema compares the snapshot's TSV header, after column mapping,
+// with the note table and returns the load plan, the note column types and a
+// version hash of the header. New trailing columns are added when
+// SCHEMA_DRIFT_AUTO_ADD is set; anything else that would misalign data fails
+// the import.
+func reconcileSchema(ctx context.Context, files []FileInfo, log *slog.Logger) (columnPlan, map[string]string, string, error) {
+	var header []string
+	for _, f := range files {
+		h, err := readTSVHeader(f.TSVPath)
+		if err != nil {
+			return columnPlan{}, nil, "", fmt.Errorf("failed to read header of %s: %w", f.FileName, err)
+		}
+		if header == nil {
+			header = h
+			continue
+		}
+		if !slices.Equal(header, h) {
+			return columnPlan{}, nil, "", headerMismatch(f, files[0])
+		}
+	}
+	if len(header) == 0 {
+		return columnPlan{}, nil, "", fmt.Errorf("no TSV header found")
+	}
+
+	plan, err := planColumns(header)
+	if err != nil {
+		return columnPlan{}, nil, "", err
+	}
+
+	existing, err := tableColumns(ctx, "note")
+	if err != nil {
+		return columnPlan{}, nil, "", fmt.Errorf("failed to read note columns: %w", err)
+	}
+	columnTypes := map[string]string{}
+	for _, c := range existing {
+		columnTypes[c.Name] = c.DataType
+	}
+
+	lastKnown := -1
+	for i, c := range plan.Columns {
+		if _, ok := columnTypes[c.Target]; ok && !c.Skip {
+			lastKnown = i
+		}
+	}
+
+	var added []plannedColumn
+	for i, c := range plan.Columns {
+		if _, ok := columnTypes[c.Target]; ok || c.Skip {
+			continue
+		}
+		if i < lastKnown {
+			return columnPlan{}, nil, "", fmt.Errorf("upstream inserted column %q before existing columns; manual migration required", c.Source)
+		}
+		added = append(added, c)
+	}
+
+	targets := plan.targets()
+	var missing []string
+	for _, c := range existing {
+		if !slices.Contains(targets, c.Name) {
+			missing = append(missing, c.Name)
+		}
+	}
+	if len(missing) > 0 {
+		log.Warn("TSV header lacks note columns; they will be left at their defaults", "columns", missing)
+	}
+
+	if len(added) > 0 {
+		if !schemaDriftAutoAdd {
+			var names []string
+			for _, c := range added {
+				names = append(names, c.Source)
+			}
+			return columnPlan{}, nil, "", fmt.Errorf("upstream added columns %v; set SCHEMA_DRIFT_AUTO_ADD=true to add them automatically", names)
+		}
+		for _, c := range added {
+			colType := "TEXT"
+			if c.Cast != "" {
+				colType = castColumnTypes[c.Cast]
+			}
+			if _, err := db.ExecContext(ctx, expandSQL(ctx, `ALTER TABLE {note} ADD COLUMN IF NOT EXISTS `)+quoteIdent(c.Target)+` `+colType); err != nil {
+				return columnPlan{}, nil, "", fmt.Errorf("failed to add column %s: %w", c.Target, err)
+			}
+			columnTypes[c.Target] = strings.ToLower(colType)
+			log.Info("Added note column for upstream schema drift", "column", c.Target, "type", colType)
+		}
+		db.ExecContext(ctx, `NOTIFY pgrst, 'reload schema'`)
+	}
+
+	version := schemaVersionOf(header)
+	db.ExecContext(ctx, expandSQL(ctx, `
+		INSERT INTO {note_schema_versions} (version, columns, first_seen_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (version) DO NOTHING
+	`), version, header)
+
+	return plan, columnTypes, version, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// syntheticNoteColumns is the upstream notes TSV header, in file order.
+var syntheticNoteColumns = []string{
+	"noteId", "noteAuthorParticipantId", "createdAtMillis", "tweetId", "classification",
+	"believable", "harmful", "validationDifficulty",
+	"misleadingOther", "misleadingFactualError", "misleadingManipulatedMedia", "misleadingOutdatedInformation",
+	"misleadingMissingImportantContext", "misleadingUnverifiedClaimAsFact", "misleadingSatire",
+	"notMisleadingOther", "notMisleadingFactuallyCorrect", "notMisleadingOutdatedButNotWhenWritten",
+	"notMisleadingClearlySatire", "notMisleadingPersonalOpinion",
+	"trustworthySources", "summary", "isMediaNote", "isCollaborativeNote",
+}
+
+var syntheticWords = strings.Fields(`the claim in this post is missing context according to official data
+report shows video was edited image from older event source article states figures are incorrect
+study published government statement confirms outdated photo satire account quote taken out of
+context numbers were revised independent fact check original footage shows`)
+
+// syntheticRatingColumns is the upstream ratings TSV header, in file order.
+var syntheticRatingColumns = []string{
+	"noteId", "raterParticipantId", "createdAtMillis", "version", "agree", "disagree", "helpful", "notHelpful",
+	"helpfulnessLevel", "helpfulOther", "helpfulInformative", "helpfulClear", "helpfulEmpathetic",
+	"helpfulGoodSources", "helpfulUniqueContext", "helpfulAddressesClaim", "helpfulImportantContext",
+	"helpfulUnbiasedLanguage", "notHelpfulOther", "notHelpfulIncorrect", "notHelpfulSourcesMissingOrUnreliable",
+	"notHelpfulOpinionSpeculationOrBias", "notHelpfulMissingKeyPoints", "notHelpfulOutdated",
+	"notHelpfulHardToUnderstand", "notHelpfulArgumentativeOrBiased", "notHelpfulOffTopic",
+	"notHelpfulSpamHarassmentOrAbuse", "notHelpfulIrrelevantSources", "notHelpfulOpinionSpeculation",
+	"notHelpfulNoteNotNeeded", "ratedOnTweetId",
+}
+
+var (
+	syntheticStart = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	syntheticSpan  = time.Now().UnixMilli() - syntheticStart
+)
+
+// writeSyntheticNotesFile writes a notes TSV to path and returns its size.
+func writeSyntheticNotesFile(path string, rows int, firstID int64, rng *rand.Rand) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if err := writeSyntheticNotes(f, rows, firstID, rng); err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), f.Close()
+}
+
+// writeSyntheticNotes writes rows fabricated notes with ids from firstID, in
+// the upstream TSV format.
+func writeSyntheticNotes(out io.Writer, rows int, firstID int64, rng *rand.Rand) error {
+	w := bufio.NewWriterSize(out, 1<<20)
+	w.WriteString(strings.Join(syntheticNoteColumns, "\t") + "\n")
+
+	fields := make([]string, len(syntheticNoteColumns))
+	for i := range rows {
+		misleading := rng.IntN(3) > 0
+		fields[0] = fmt.Sprint(firstID + int64(i))
+		fields[1] = syntheticParticipantID(rng)
+		fields[2] = fmt.Sprint(syntheticCreatedAt(firstID + int64(i)))
+		fields[3] = fmt.Sprint(syntheticTweetID(firstID + int64(i)))
+		if misleading {
+			fields[4] = "MISINFORMED_OR_POTENTIALLY_MISLEADING"
+		} else {
+			fields[4] = "NOT_MISLEADING"
+		}
+		fields[5] = syntheticPick(rng, "BELIEVABLE_BY_MANY", "BELIEVABLE_BY_FEW", "")
+		fields[6] = syntheticPick(rng, "CONSIDERABLE_HARM", "LITTLE_HARM", "")
+		fields[7] = syntheticPick(rng, "EASY", "CHALLENGING", "")
+		for c := 8; c <= 14; c++ {
+			fields[c] = syntheticFlag(rng, misleading)
+		}
+		for c := 15; c <= 19; c++ {
+			fields[c] = syntheticFlag(rng, !misleading)
+		}
+		fields[20] = syntheticFlag(rng, true)
+		fields[21] = syntheticSummary(rng)
+		fields[22] = syntheticFlag(rng, rng.IntN(10) == 0)
+		fields[23] = "0"
+		w.WriteString(strings.Join(fields, "\t") + "\n")
+	}
+	return w.Flush()
+}
+
+// writeSyntheticRatings writes about perNote ratings for each of the notes
+// firstID..firstID+notes-1.
+func writeSyntheticRatings(out io.Writer, notes int, firstID int64, perNote int, rng *rand.Rand) error {
+	w := bufio.NewWriterSize(out, 1<<20)
+	w.WriteString(strings.Join(syntheticRatingColumns, "\t") + "\n")
+
+	fields := make([]string, len(syntheticRatingColumns))
+	for i := range notes {
+		noteID := firstID + int64(i)
+		for range rng.IntN(2*perNote + 1) {
+			helpful := rng.IntN(3)
+			fields[0] = fmt.Sprint(noteID)
+			fields[1] = syntheticParticipantID(rng)
+			fields[2] = fmt.Sprint(syntheticCreatedAt(noteID) + rng.Int64N(14*24*time.Hour.Milliseconds()))
+			fields[3] = "2"
+			fields[4], fields[5], fields[6], fields[7] = "0", "0", "0", "0"
+			fields[8] = []string{"NOT_HELPFUL", "SOMEWHAT_HELPFUL", "HELPFUL"}[helpful]
+			for c := 9; c <= 17; c++ {
+				fields[c] = syntheticFlag(rng, helpful == 2)
+			}
+			for c := 18; c <= 30; c++ {
+				fields[c] = syntheticFlag(rng, helpful == 0)
+			}
+			fields[31] = fmt.Sprint(syntheticTweetID(noteID))
+			w.WriteString(strings.Join(fields, "\t") + "\n")
+		}
+	}
+	return w.Flush()
+}
+
+// generateSnapshot writes a synthetic snapshot for date into dir in the layout
+// offline imports read: {date}-notes-NNNNN.zip holding notes-NNNNN.tsv, plus
+// matching {date}-ratings-NNNNN.zip files when ratingsPerNote > 0.
+func generateSnapshot(dir, date string, notes, files, ratingsPerNote int, seed int64) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	rng := rand.New(rand.NewPCG(uint64(seed), uint64(seed)))
+	files = max(files, 1)
+	firstID := int64(1_000_000_000_000_000_000)
+	for i := range files {
+		rows := notes / files
+		if i < notes%files {
+			rows++
+		}
+		err := writeZippedTSV(filepath.Join(dir, fmt.Sprintf("%s-notes-%05d.zip", date, i)), fmt.Sprintf("notes-%05d.tsv", i), func(w io.Writer) error {
+			return writeSyntheticNotes(w, rows, firstID, rng)
+		})
+		if err != nil {
+			return err
+		}
+		if ratingsPerNote > 0 {
+			err := writeZippedTSV(filepath.Join(dir, fmt.Sprintf("%s-ratings-%05d.zip", date, i)), fmt.Sprintf("ratings-%05d.tsv", i), func(w io.Writer) error {
+				return writeSyntheticRatings(w, rows, firstID, ratingsPerNote, rng)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		firstID += int64(rows)
+	}
+	return nil
+}
+
+// writeZippedTSV writes a zip holding one entry, replacing path atomically.
+func writeZippedTSV(path, entry string, write func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	ew, err := zw.Create(entry)
+	if err != nil {
+		return err
+	}
+	if err := write(ew); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func syntheticParticipantID(rng *rand.Rand) string {
+	return fmt.Sprintf("%016X%016X%016X%016X", rng.Uint64(), rng.Uint64(), rng.Uint64(), rng.Uint64())
+}
+
+// syntheticCreatedAt and syntheticTweetID derive a note's creation time and
+// tweet from its id so that ratings agree with their note; a few notes share
+// a tweet.
+func syntheticCreatedAt(noteID int64) int64 {
+	return syntheticStart + int64(uint64(noteID)*0x9E3779B97F4A7C15%uint64(syntheticSpan))
+}
+
+func syntheticTweetID(noteID int64) int64 {
+	return 1_300_000_000_000_000_000 + (noteID/3*7919)%600_000_000_000_000_000
+}
+
+func syntheticSummary(rng *rand.Rand) string {
+	words := make([]string, 12+rng.IntN(40))
+	for i := range words {
+		words[i] = syntheticWords[rng.IntN(len(syntheticWords))]
+	}
+	return strings.Join(words, " ") + fmt.Sprintf(" https://example.org/%d", rng.IntN(100000))
+}
+
+func syntheticPick(rng *rand.Rand, values ...string) string {
+	return values[rng.IntN(len(values))]
+}
+
+func syntheticFlag(rng *rand.Rand, likely bool) string {
+	if likely && rng.IntN(2) == 0 || !likely && rng.IntN(20) == 0 {
+		return "1"
+	}
+	return "0"
+}
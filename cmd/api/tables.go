@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	dbSchema    = getEnv("DB_SCHEMA", "public")
+	tablePrefix = getEnv("TABLE_PREFIX", "")
+)
+
+var managedTables = []string{
+	"note",
+	"note_load",
+	"note_previous",
+	"note_fingerprints",
+	"note_schema_versions",
+	"note_embeddings",
+	"note_duplicates",
+	"note_topics",
+	"note_benchmark",
+	"topic_clusters",
+	"import_history",
+	"import_files",
+	"import_logs",
+	"import_errors",
+	"backup_history",
+	"participant_distribution",
+	"api_keys",
+	"export_jobs",
+	"note_exclusions",
+	"participant_purges",
+	"webhooks",
+	"note_statuses",
+	"schema_migrations",
+}
+
+var tablePrefixPattern = regexp.MustCompile(`^[a-z0-9_]*$`)
+
+func validateTableNaming() error {
+	if !identifierPattern.MatchString(dbSchema) {
+		return fmt.Errorf("DB_SCHEMA %q is not a valid identifier", dbSchema)
+	}
+	if !tablePrefixPattern.MatchString(tablePrefix) {
+		return fmt.Errorf("TABLE_PREFIX %q may only contain lowercase letters, digits and underscores", tablePrefix)
+	}
+	return nil
+}
+
+func tableName(name string) string {
+	return tablePrefix + name
+}
+
+func qualifiedTableIn(schema, name string) string {
+	return quoteIdent(schema) + "." + quoteIdent(tableName(name))
+}
+
+func qualifiedTable(ctx context.Context, name string) string {
+	return qualifiedTableIn(workspaceFromContext(ctx).Schema, name)
+}
+
+// newSQLTemplate maps {table} placeholders to schema-qualified, prefixed table
+// names; {schema} and {prefix} are for statements that need the bare parts,
+// such as index and constraint names or RENAME targets, which cannot be
+// qualified.
+func newSQLTemplate(schema string) *strings.Replacer {
+	pairs := []string{"{schema}", quoteIdent(schema), "{prefix}", tablePrefix}
+	for _, t := range managedTables {
+		pairs = append(pairs, "{"+t+"}", qualifiedTableIn(schema, t))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// expandSQL resolves table placeholders against the workspace carried by ctx.
+func expandSQL(ctx context.Context, query string) string {
+	return workspaceFromContext(ctx).sql.Replace(query)
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	topicsEnabled    = getEnvBool("TOPICS_ENABLED", false)
+	topicsClusters   = getEnvInt("TOPICS_CLUSTERS", 20)
+	topicsSampleSize = getEnvInt("TOPICS_SAMPLE_SIZE", 20000)
+	topicsVocabSize  = getEnvInt("TOPICS_VOCAB_SIZE", 20000)
+)
+
+const (
+	topicsMinDocFreq  = 5
+	topicsMaxDocRatio = 0.5
+	topicsIterations  = 25
+	topicsTopTerms    = 8
+)
+
+var topicStopwords = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(`the and for that this with are was were not but have has had from they their them
+		its it's you your our out all any can will would could should been being into than then there these those about
+		what when where which who whom why how also just more most some such only other over very does did doing
+		http https www com twitter status note notes tweet tweets post posts says said`) {
+		m[w] = true
+	}
+	return m
+}()
+
+type sparseVector struct {
+	idx []int32
+	val []float32
+}
+
+type topicModel struct {
+	vocab     map[string]int32
+	terms     []string
+	idf       []float32
+	centroids [][]float32
+}
+
+type Topic struct {
+	ID    int      `json:"id"`
+	Size  int      `json:"size"`
+	Terms []string `json:"terms"`
+}
+
+type TopicNote struct {
+	NoteID         int64   `json:"note_id"`
+	TweetID        *string `json:"tweet_id,omitempty"`
+	Classification *string `json:"classification,omitempty"`
+	Summary        string  `json:"summary"`
+	Score          float64 `json:"score"`
+}
+
+func initTopics() error {
+	if !topicsEnabled {
+		return nil
+	}
+	if topicsClusters < 2 {
+		return fmt.Errorf("TOPICS_CLUSTERS must be at least 2")
+	}
+
+	for _, ws := range workspaceOrder {
+		ctx := withWorkspace(context.Background(), ws)
+		for _, stmt := range []string{
+			`CREATE TABLE IF NOT EXISTS {topic_clusters} (
+				id INT PRIMARY KEY,
+				size INT NOT NULL,
+				terms TEXT[] NOT NULL,
+				refreshed_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS {note_topics} (
+				noteid BIGINT PRIMARY KEY,
+				topic_id INT NOT NULL,
+				score REAL NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS {prefix}idx_note_topics_topic ON {note_topics}(topic_id, score DESC)`,
+		} {
+			if _, err := db.ExecContext(ctx, expandSQL(ctx, stmt)); err != nil {
+				return fmt.Errorf("failed to prepare topic tables in workspace %s: %w", ws.Name, err)
+			}
+		}
+	}
+
+	logger.Info("Topic clustering enabled", "clusters", topicsClusters, "sample_size", topicsSampleSize)
+	return nil
+}
+
+func tokenizeSummary(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	tokens := words[:0]
+	for _, w := range words {
+		w = strings.Trim(w, "'")
+		if len([]rune(w)) < 3 || topicStopwords[w] || strings.IndexFunc(w, unicode.IsLetter) < 0 {
+			continue
+		}
+		tokens = append(tokens, w)
+	}
+	return tokens
+}
+
+func (m *topicModel) vectorize(tokens []string) sparseVector {
+	counts := make(map[int32]int)
+	for _, t := range tokens {
+		if i, ok := m.vocab[t]; ok {
+			counts[i]++
+		}
+	}
+
+	v := sparseVector{idx: make([]int32, 0, len(counts)), val: make([]float32, 0, len(counts))}
+	var norm float64
+	for i, c := range counts {
+		w := float32(1+math.Log(float64(c))) * m.idf[i]
+		v.idx = append(v.idx, i)
+		v.val = append(v.val, w)
+		norm += float64(w * w)
+	}
+	if norm > 0 {
+		inv := float32(1 / math.Sqrt(norm))
+		for j := range v.val {
+			v.val[j] *= inv
+		}
+	}
+	return v
+}
+
+func (v sparseVector) dot(dense []float32) float32 {
+	var s float32
+	for j, i := range v.idx {
+		s += v.val[j] * dense[i]
+	}
+	return s
+}
+
+func (m *topicModel) nearest(v sparseVector) (int, float32) {
+	best, bestScore := -1, float32(-1)
+	for c, centroid := range m.centroids {
+		if s := v.dot(centroid); s > bestScore {
+			best, bestScore = c, s
+		}
+	}
+	return best, bestScore
+}
+
+func buildTopicVocabulary(docs [][]string) *topicModel {
+	df := make(map[string]int)
+	for _, tokens := range docs {
+		seen := make(map[string]bool, len(tokens))
+		for _, t := range tokens {
+			if !seen[t] {
+				seen[t] = true
+				df[t]++
+			}
+		}
+	}
+
+	maxDF := int(topicsMaxDocRatio * float64(len(docs)))
+	var terms []string
+	for t, n := range df {
+		if n >= topicsMinDocFreq && n <= maxDF {
+			terms = append(terms, t)
+		}
+	}
+	slices.SortFunc(terms, func(a, b string) int {
+		if df[a] != df[b] {
+			return df[b] - df[a]
+		}
+		return strings.Compare(a, b)
+	})
+	if len(terms) > topicsVocabSize {
+		terms = terms[:topicsVocabSize]
+	}
+
+	m := &topicModel{vocab: make(map[string]int32, len(terms)), terms: terms, idf: make([]float32, len(terms))}
+	for i, t := range terms {
+		m.vocab[t] = int32(i)
+		m.idf[i] = float32(math.Log(float64(len(docs)+1) / float64(df[t]+1)))
+	}
+	return m
+}
+
+// trainTopics runs spherical k-means (cosine similarity, k-means++ seeding)
+// over the sampled TF-IDF vectors. The seed is fixed so that re-running on
+// unchanged data yields the same clusters.
+func (m *topicModel) trainTopics(vectors []sparseVector, k int) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	dims := len(m.terms)
+
+	toDense := func(v sparseVector) []float32 {
+		d := make([]float32, dims)
+		for j, i := range v.idx {
+			d[i] = v.val[j]
+		}
+		return d
+	}
+
+	m.centroids = [][]float32{toDense(vectors[rng.IntN(len(vectors))])}
+	dist := make([]float64, len(vectors))
+	for len(m.centroids) < k {
+		var total float64
+		for i, v := range vectors {
+			_, s := m.nearest(v)
+			dist[i] = math.Max(0, 1-float64(s))
+			total += dist[i]
+		}
+		if total == 0 {
+			break
+		}
+		r := rng.Float64() * total
+		pick := len(vectors) - 1
+		for i, d := range dist {
+			if r -= d; r <= 0 {
+				pick = i
+				break
+			}
+		}
+		m.centroids = append(m.centroids, toDense(vectors[pick]))
+	}
+
+	assign := make([]int, len(vectors))
+	for i := range assign {
+		assign[i] = -1
+	}
+	for iter := 0; iter < topicsIterations; iter++ {
+		changed := 0
+		for i, v := range vectors {
+			if c, _ := m.nearest(v); c != assign[i] {
+				assign[i] = c
+				changed++
+			}
+		}
+		if changed == 0 {
+			break
+		}
+
+		sums := make([][]float32, len(m.centroids))
+		for c := range sums {
+			sums[c] = make([]float32, dims)
+		}
+		sizes := make([]int, len(m.centroids))
+		for i, v := range vectors {
+			c := assign[i]
+			sizes[c]++
+			for j, idx := range v.idx {
+				sums[c][idx] += v.val[j]
+			}
+		}
+		for c := range sums {
+			if sizes[c] == 0 {
+				sums[c] = toDense(vectors[rng.IntN(len(vectors))])
+				continue
+			}
+			var norm float64
+			for _, x := range sums[c] {
+				norm += float64(x * x)
+			}
+			if norm > 0 {
+				inv := float32(1 / math.Sqrt(norm))
+				for j := range sums[c] {
+					sums[c][j] *= inv
+				}
+			}
+		}
+		m.centroids = sums
+	}
+}
+
+func (m *topicModel) topTerms(c int) []string {
+	order := make([]int, len(m.terms))
+	for i := range order {
+		order[i] = i
+	}
+	centroid := m.centroids[c]
+	slices.SortFunc(order, func(a, b int) int {
+		switch {
+		case centroid[a] > centroid[b]:
+			return -1
+		case centroid[a] < centroid[b]:
+			return 1
+		}
+		return a - b
+	})
+
+	var terms []string
+	for _, i := range order[:min(topicsTopTerms, len(order))] {
+		if centroid[i] <= 0 {
+			break
+		}
+		terms = append(terms, m.terms[i])
+	}
+	return terms
+}
+
+// clusterTopics fits a TF-IDF vocabulary and k-means centroids on a random
+// sample of summaries, then streams every note through the model and replaces
+// the topic tables in a single transaction.
+func clusterTopics(ctx context.Context, log *slog.Logger) (int, error) {
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT summary FROM {note} WHERE summary IS NOT NULL ORDER BY random() LIMIT $1`), topicsSampleSize)
+	if err != nil {
+		return 0, err
+	}
+	var sample [][]string
+	for rows.Next() {
+		var summary string
+		if err := rows.Scan(&summary); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if tokens := tokenizeSummary(summary); len(tokens) > 0 {
+			sample = append(sample, tokens)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	model := buildTopicVocabulary(sample)
+	var vectors []sparseVector
+	for _, tokens := range sample {
+		if v := model.vectorize(tokens); len(v.idx) > 0 {
+			vectors = append(vectors, v)
+		}
+	}
+	if len(vectors) < topicsClusters {
+		return 0, fmt.Errorf("not enough notes to build %d topics (%d usable in sample)", topicsClusters, len(vectors))
+	}
+	model.trainTopics(vectors, topicsClusters)
+	log.Info("Trained topic model", "sample", len(vectors), "vocabulary", len(model.terms), "topics", len(model.centroids))
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, expandSQL(ctx, `DELETE FROM {note_topics}`)); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, expandSQL(ctx, `DELETE FROM {topic_clusters}`)); err != nil {
+		return 0, err
+	}
+
+	noteRows, err := dbPool.Query(ctx, expandSQL(ctx, `SELECT noteid, summary FROM {note} WHERE summary IS NOT NULL`))
+	if err != nil {
+		return 0, err
+	}
+	sizes := make([]int, len(model.centroids))
+	table := pgx.Identifier{workspaceFromContext(ctx).Schema, tableName("note_topics")}
+	_, err = tx.CopyFrom(ctx, table, []string{"noteid", "topic_id", "score"}, pgx.CopyFromFunc(func() ([]any, error) {
+		for noteRows.Next() {
+			var id int64
+			var summary string
+			if err := noteRows.Scan(&id, &summary); err != nil {
+				return nil, err
+			}
+			v := model.vectorize(tokenizeSummary(summary))
+			if len(v.idx) == 0 {
+				continue
+			}
+			c, score := model.nearest(v)
+			sizes[c]++
+			return []any{id, c, score}, nil
+		}
+		return nil, noteRows.Err()
+	}))
+	noteRows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy topic assignments: %w", err)
+	}
+
+	for c := range model.centroids {
+		if _, err := tx.Exec(ctx, expandSQL(ctx, `INSERT INTO {topic_clusters} (id, size, terms, refreshed_at) VALUES ($1, $2, $3, NOW())`), c, sizes[c], model.topTerms(c)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(model.centroids), nil
+}
+
+func listTopics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !topicsEnabled {
+		writeProblem(w, http.StatusNotFound, errCodeTopicsDisabled, "Topic clustering is not enabled (set TOPICS_ENABLED=true)")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT id, size, terms FROM {topic_clusters} ORDER BY size DESC, id`))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list topics: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	topics := []Topic{}
+	for rows.Next() {
+		var t Topic
+		if err := rows.Scan(&t.ID, &t.Size, scanArray(&t.Terms)); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list topics: "+err.Error())
+			return
+		}
+		topics = append(topics, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(topics)
+}
+
+func getTopicNotes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !topicsEnabled {
+		writeProblem(w, http.StatusNotFound, errCodeTopicsDisabled, "Topic clustering is not enabled (set TOPICS_ENABLED=true)")
+		return
+	}
+
+	topicID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Topic ID must be an integer")
+		return
+	}
+
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	var exists bool
+	db.QueryRowContext(ctx, expandSQL(ctx, `SELECT EXISTS (SELECT 1 FROM {topic_clusters} WHERE id = $1)`), topicID).Scan(&exists)
+	if !exists {
+		writeProblem(w, http.StatusNotFound, errCodeTopicNotFound, "Topic not found")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT n.noteid, n.tweetid, n.classification, n.summary, t.score
+		FROM {note_topics} t
+		JOIN {note} n ON n.noteid = t.noteid
+		WHERE t.topic_id = $1 AND `+notExcluded("n")+`
+		ORDER BY t.score DESC, n.noteid
+		LIMIT $2
+	`), topicID, limit)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get topic notes: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	notes := []TopicNote{}
+	for rows.Next() {
+		var n TopicNote
+		var tweetID, classification sql.NullString
+		if err := rows.Scan(&n.NoteID, &tweetID, &classification, &n.Summary, &n.Score); err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get topic notes: "+err.Error())
+			return
+		}
+		n.TweetID = nullStringToStrPtr(tweetID)
+		n.Classification = nullStringToStrPtr(classification)
+		notes = append(notes, n)
+	}
+
+	writeList(w, r, notes)
+}
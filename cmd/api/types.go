@@ -7,28 +7,72 @@ import (
 )
 
 type HistoryEntry struct {
-	ID                 int        `json:"id"`
-	JobID              string     `json:"job_id"`
-	StartedAt          time.Time  `json:"started_at"`
-	CompletedAt        *time.Time `json:"completed_at,omitempty"`
-	TotalRows          *int       `json:"total_rows,omitempty"`
-	Status             string     `json:"status"`
-	ErrorMessage       *string    `json:"error_message,omitempty"`
-	DownloadPercentage *int       `json:"download_percentage,omitempty"`
-	DownloadSpeed      *string    `json:"download_speed,omitempty"`
-	RowsProcessed      *int       `json:"rows_processed,omitempty"`
-	DownloadCached     *bool      `json:"download_cached,omitempty"`
-	DownloadDuration   *int       `json:"download_duration,omitempty"`
-	ImportDuration     *int       `json:"import_duration,omitempty"`
-	FileSize           *int64     `json:"file_size,omitempty"`
-	TotalFiles         *int       `json:"total_files,omitempty"`
-	CurrentFileIndex   *int       `json:"current_file_index,omitempty"`
-	FilesProcessed     *int       `json:"files_processed,omitempty"`
-	FileNames          *string    `json:"file_names,omitempty"`
-	IndexingStartedAt  *time.Time `json:"indexing_started_at,omitempty"`
-	IndexPhase         *string    `json:"index_phase,omitempty"`
-	IndexBlocksDone    *int       `json:"index_blocks_done,omitempty"`
-	IndexBlocksTotal   *int       `json:"index_blocks_total,omitempty"`
+	ID                    int          `json:"id"`
+	JobID                 string       `json:"job_id"`
+	StartedAt             time.Time    `json:"started_at"`
+	CompletedAt           *time.Time   `json:"completed_at,omitempty"`
+	TotalRows             *int         `json:"total_rows,omitempty"`
+	Status                string       `json:"status"`
+	ErrorMessage          *string      `json:"error_message,omitempty"`
+	ErrorCode             *string      `json:"error_code,omitempty"`
+	DownloadPercentage    *int         `json:"download_percentage,omitempty"`
+	DownloadSpeed         *string      `json:"download_speed,omitempty"`
+	RowsProcessed         *int         `json:"rows_processed,omitempty"`
+	DownloadCached        *bool        `json:"download_cached,omitempty"`
+	DownloadDuration      *int         `json:"download_duration,omitempty"`
+	ImportDuration        *int         `json:"import_duration,omitempty"`
+	FileSize              *int64       `json:"file_size,omitempty"`
+	TotalFiles            *int         `json:"total_files,omitempty"`
+	CurrentFileIndex      *int         `json:"current_file_index,omitempty"`
+	FilesProcessed        *int         `json:"files_processed,omitempty"`
+	FileNames             []string     `json:"file_names,omitempty"`
+	IndexingStartedAt     *time.Time   `json:"indexing_started_at,omitempty"`
+	IndexPhase            *string      `json:"index_phase,omitempty"`
+	IndexBlocksDone       *int         `json:"index_blocks_done,omitempty"`
+	IndexBlocksTotal      *int         `json:"index_blocks_total,omitempty"`
+	PauseRequested        bool         `json:"pause_requested"`
+	PausedAt              *time.Time   `json:"paused_at,omitempty"`
+	Labels                []string     `json:"labels"`
+	Note                  *string      `json:"note,omitempty"`
+	TriggeredBy           *string      `json:"triggered_by,omitempty"`
+	TriggeredByName       *string      `json:"triggered_by_name,omitempty"`
+	SchemaVersion         *string      `json:"schema_version,omitempty"`
+	EventsPublished       *int         `json:"events_published,omitempty"`
+	NotesEmbedded         *int         `json:"notes_embedded,omitempty"`
+	Offline               bool         `json:"offline"`
+	SnapshotFingerprint   *string      `json:"snapshot_fingerprint,omitempty"`
+	Mode                  string       `json:"mode"`
+	OwnerInstance         *string      `json:"owner_instance,omitempty"`
+	HeartbeatAt           *time.Time   `json:"heartbeat_at,omitempty"`
+	ProgressAt            *time.Time   `json:"progress_at,omitempty"`
+	Stalled               bool         `json:"stalled"`
+	FileRange             *string      `json:"file_range,omitempty"`
+	Limit                 *int         `json:"limit,omitempty"`
+	PseudonymKey          *string      `json:"pseudonym_key,omitempty"`
+	ParentJobID           *string      `json:"parent_job_id,omitempty"`
+	RetryJobIDs           []string     `json:"retry_job_ids,omitempty"`
+	Warnings              []string     `json:"warnings,omitempty"`
+	DownloadProgress      *int         `json:"download_progress,omitempty"`
+	ImportProgress        *int         `json:"import_progress,omitempty"`
+	Percentage            *int         `json:"percentage,omitempty"`
+	EstimatedCompletionAt *time.Time   `json:"estimated_completion_at,omitempty"`
+	Files                 []ImportFile `json:"files,omitempty"`
+}
+
+type ImportFile struct {
+	Index            int        `json:"index"`
+	Name             string     `json:"name"`
+	Size             *int64     `json:"size,omitempty"`
+	Status           string     `json:"status"`
+	Cached           *bool      `json:"cached,omitempty"`
+	ExpectedRows     *int       `json:"expected_rows,omitempty"`
+	RowsImported     *int       `json:"rows_imported,omitempty"`
+	DownloadDuration *int       `json:"download_duration,omitempty"`
+	ImportDuration   *int       `json:"import_duration,omitempty"`
+	ImportedAt       *time.Time `json:"imported_at,omitempty"`
+	CopyAttempts     *int       `json:"copy_attempts,omitempty"`
+	ContentHash      *string    `json:"content_hash,omitempty"`
+	BytesDownloaded  *int64     `json:"bytes_downloaded,omitempty"`
 }
 
 type ImportStatus struct {
@@ -49,14 +93,144 @@ type ImportStatus struct {
 	FilesProcessed     *int       `json:"files_processed,omitempty"`
 }
 
+type JobLogEntry struct {
+	ID      int64             `json:"id"`
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+const (
+	errCodeUnauthorized         = "unauthorized"
+	errCodeInvalidToken         = "invalid_token"
+	errCodeForbidden            = "forbidden"
+	errCodeInvalidRequest       = "invalid_request"
+	errCodeAPIKeyNotFound       = "api_key_not_found"
+	errCodeImportNotFound       = "import_not_found"
+	errCodeImportNotActive      = "import_not_active"
+	errCodeMethodNotAllowed     = "method_not_allowed"
+	errCodeImportInProgress     = "import_in_progress"
+	errCodeImportNotRetryable   = "import_not_retryable"
+	errCodeImportNotCompleted   = "import_not_completed"
+	errCodeSnapshotNotFound     = "snapshot_not_found"
+	errCodeImportNotPausable    = "import_not_pausable"
+	errCodeImportNotPaused      = "import_not_paused"
+	errCodeWorkspaceNotFound    = "workspace_not_found"
+	errCodeEmbeddingsDisabled   = "embeddings_disabled"
+	errCodeEmbeddingFailed      = "embedding_failed"
+	errCodeNoteNotFound         = "note_not_found"
+	errCodeDuplicatesDisabled   = "duplicates_disabled"
+	errCodeTopicsDisabled       = "topics_disabled"
+	errCodeTopicNotFound        = "topic_not_found"
+	errCodeCachedFileNotFound   = "cached_file_not_found"
+	errCodeBenchmarkInProgress  = "benchmark_in_progress"
+	errCodeQueryDisabled        = "query_disabled"
+	errCodeQueryFailed          = "query_failed"
+	errCodeBackupUnavailable    = "backup_unavailable"
+	errCodeBackupNotFound       = "backup_not_found"
+	errCodeDistributionNotFound = "distribution_not_found"
+	errCodeExportNotFound       = "export_not_found"
+	errCodeExportNotReady       = "export_not_ready"
+	errCodePreflightFailed      = "preflight_failed"
+	errCodeExclusionNotFound    = "exclusion_not_found"
+	errCodeWebhookNotFound      = "webhook_not_found"
+	errCodeDatabaseUnavailable  = "database_unavailable"
+	errCodeQueryTimeout         = "query_timeout"
+	errCodeInternalError        = "internal_error"
+)
+
+const (
+	importErrSnapshotNotFound = "snapshot_not_found"
+	importErrDownloadFailed   = "download_failed"
+	importErrDiskFull         = "disk_full"
+	importErrSchemaMismatch   = "schema_mismatch"
+	importErrCopyFailed       = "copy_failed"
+	importErrIndexFailed      = "index_failed"
+	importErrDatabase         = "database_error"
+	importErrCancelled        = "cancelled"
+	importErrInterrupted      = "interrupted"
+	importErrInternal         = "internal_error"
+	importErrRowCount         = "row_count_mismatch"
+	importErrTimeout          = "timeout"
+	importErrForceFailed      = "force_failed"
+)
+
 type Problem struct {
-	Type   string `json:"type"`
-	Title  string `json:"title"`
-	Status int    `json:"status"`
-	Detail string `json:"detail,omitempty"`
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      string       `json:"code"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+const (
+	loadModeTruncate = "truncate"
+	loadModeUpsert   = "upsert"
+)
+
+const maxDownloadConcurrency = 8
+
+type CreateImportRequest struct {
+	Limit       int      `json:"limit"`
+	Files       string   `json:"files"`
+	Date        string   `json:"date"`
+	Datasets    []string `json:"datasets"`
+	Mode        string   `json:"mode"`
+	DryRun      bool     `json:"dry_run"`
+	Offline     bool     `json:"offline"`
+	Force       bool     `json:"force"`
+	Concurrency int      `json:"concurrency"`
+	Labels      []string `json:"labels"`
+	Note        string   `json:"note"`
+	TriggeredBy string   `json:"triggered_by"`
+}
+
+type ImportPlan struct {
+	DryRun      bool     `json:"dry_run"`
+	DataDate    string   `json:"data_date"`
+	Datasets    []string `json:"datasets"`
+	Mode        string   `json:"mode"`
+	Offline     bool     `json:"offline"`
+	Limit       int      `json:"limit,omitempty"`
+	FileRange   string   `json:"file_range,omitempty"`
+	Concurrency int      `json:"concurrency"`
+	Files       []string `json:"files"`
+}
+
+type importOptions struct {
+	limit       int
+	files       string
+	resume      bool
+	offline     bool
+	force       bool
+	date        string
+	mode        string
+	concurrency int
+	requestID   string
+	workspace   *workspace
+	lock        *importLock
 }
 
 type FileInfo struct {
+	Index    int
 	ZipPath  string
 	TSVPath  string
 	FileName string
@@ -67,6 +241,7 @@ type progressTracker struct {
 	reader           io.Reader
 	totalBytes       int64
 	bytesRead        int64
+	startOffset      int64
 	lastUpdate       time.Time
 	lastPct          int
 	startTime        time.Time
@@ -75,4 +250,5 @@ type progressTracker struct {
 	fileName         string
 	totalFiles       int
 	currentFileIndex int
+	overlapped       bool
 }
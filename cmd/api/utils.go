@@ -52,15 +52,42 @@ func nullBoolToBoolPtr(n sql.NullBool) *bool {
 	return nil
 }
 
-func writeProblem(w http.ResponseWriter, status int, title, detail string) {
+func nullFloat64ToFloat64Ptr(n sql.NullFloat64) *float64 {
+	if n.Valid {
+		return &n.Float64
+	}
+	return nil
+}
+
+func writeProblem(w http.ResponseWriter, status int, code, detail string) {
+	writeFieldProblem(w, status, code, detail, nil)
+}
+
+func writeFieldProblem(w http.ResponseWriter, status int, code, detail string, fieldErrors []FieldError) {
+	requestID := w.Header().Get(requestIDHeader)
+
+	if status >= http.StatusInternalServerError {
+		logger.Error("Request failed", "request_id", requestID, "status", status, "code", code, "detail", detail)
+	} else {
+		logger.Info("Request rejected", "request_id", requestID, "status", status, "code", code, "detail", detail)
+	}
+
+	p := Problem{
+		Type:      "urn:x-notes:problem:" + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		RequestID: requestID,
+		Errors:    fieldErrors,
+	}
+	if requestID != "" {
+		p.Instance = "urn:x-notes:request:" + requestID
+	}
+
 	w.Header().Set("Content-Type", "application/problem+json")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(Problem{
-		Type:   fmt.Sprintf("https://httpstatuses.com/%d", status),
-		Title:  title,
-		Status: status,
-		Detail: detail,
-	})
+	json.NewEncoder(w).Encode(p)
 }
 
 func getDateDaysAgo(n int) string {
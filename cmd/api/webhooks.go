@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	webhookTimeout     = getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	webhookMaxAttempts = getEnvInt("WEBHOOK_MAX_ATTEMPTS", 3)
+	webhookBatchSize   = 500
+)
+
+var webhookHTTPClient = &http.Client{Timeout: webhookTimeout}
+
+const eventNoteStatusChanged = "note.status_changed"
+
+// webhookEventTypes are the events a subscription can name; note.status_changed
+// is a note whose classification differs from the previous import's.
+var webhookEventTypes = []string{eventImportStarted, eventImportCompleted, eventImportFailed, eventImportPaused, eventImportSkipped, eventNoteStatusChanged}
+
+// webhookFilterKeys are the event attributes a subscription can filter on. A
+// filter only applies to events that carry its attribute.
+var webhookFilterKeys = []string{"error_code", "from", "to", "tweet_id", "note_id"}
+
+type Webhook struct {
+	ID             int64               `json:"id"`
+	URL            string              `json:"url"`
+	Events         []string            `json:"events"`
+	Filters        map[string][]string `json:"filters"`
+	Description    *string             `json:"description,omitempty"`
+	Active         bool                `json:"active"`
+	CreatedBy      *string             `json:"created_by,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	LastDeliveryAt *time.Time          `json:"last_delivery_at,omitempty"`
+	LastStatus     *int                `json:"last_status,omitempty"`
+	LastError      *string             `json:"last_error,omitempty"`
+	Secret         string              `json:"secret,omitempty"`
+
+	secret string
+}
+
+type WebhookRequest struct {
+	URL         string              `json:"url"`
+	Events      []string            `json:"events"`
+	Filters     map[string][]string `json:"filters"`
+	Description string              `json:"description"`
+	Active      *bool               `json:"active"`
+	Secret      string              `json:"secret"`
+}
+
+// WebhookEvent carries only what changed: the job's outcome for import
+// events, the note's old and new classification for note.status_changed.
+type WebhookEvent struct {
+	Type      string    `json:"type"`
+	Workspace string    `json:"workspace,omitempty"`
+	JobID     string    `json:"job_id,omitempty"`
+	DataDate  string    `json:"data_date,omitempty"`
+	Rows      *int      `json:"rows,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	NoteID    *int64    `json:"note_id,omitempty"`
+	TweetID   *string   `json:"tweet_id,omitempty"`
+	From      *string   `json:"from,omitempty"`
+	To        *string   `json:"to,omitempty"`
+	EmittedAt time.Time `json:"emitted_at"`
+}
+
+type WebhookPayload struct {
+	WebhookID int64          `json:"webhook_id"`
+	Events    []WebhookEvent `json:"events"`
+}
+
+// attr is the event's value for a filter key, false when it has none.
+func (e WebhookEvent) attr(key string) (string, bool) {
+	switch key {
+	case "error_code":
+		return e.ErrorCode, e.ErrorCode != ""
+	case "from":
+		return derefOr(e.From, ""), e.Type == eventNoteStatusChanged
+	case "to":
+		return derefOr(e.To, ""), e.Type == eventNoteStatusChanged
+	case "tweet_id":
+		return derefOr(e.TweetID, ""), e.TweetID != nil
+	case "note_id":
+		if e.NoteID == nil {
+			return "", false
+		}
+		return strconv.FormatInt(*e.NoteID, 10), true
+	}
+	return "", false
+}
+
+func derefOr(s *string, fallback string) string {
+	if s == nil {
+		return fallback
+	}
+	return *s
+}
+
+func (h Webhook) matches(e WebhookEvent) bool {
+	if !slices.Contains(h.Events, e.Type) {
+		return false
+	}
+	for key, values := range h.Filters {
+		if v, ok := e.attr(key); ok && !slices.Contains(values, v) {
+			return false
+		}
+	}
+	return true
+}
+
+const webhookColumns = `id, url, events, filters, description, active, created_by, created_at, last_delivery_at, last_status, last_error, secret`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var h Webhook
+	var filters []byte
+	var description, createdBy, lastError, secret sql.NullString
+	var lastDelivery sql.NullTime
+	var lastStatus sql.NullInt64
+	err := row.Scan(&h.ID, &h.URL, scanArray(&h.Events), &filters, &description, &h.Active, &createdBy, &h.CreatedAt, &lastDelivery, &lastStatus, &lastError, &secret)
+	if err != nil {
+		return h, err
+	}
+	json.Unmarshal(filters, &h.Filters)
+	if h.Filters == nil {
+		h.Filters = map[string][]string{}
+	}
+	h.Description = nullStringToStrPtr(description)
+	h.CreatedBy = nullStringToStrPtr(createdBy)
+	h.LastDeliveryAt = nullTimeToTimePtr(lastDelivery)
+	h.LastStatus = nullInt64ToIntPtr(lastStatus)
+	h.LastError = nullStringToStrPtr(lastError)
+	h.secret = secret.String
+	return h, nil
+}
+
+func validateWebhookRequest(req WebhookRequest) []FieldError {
+	var errs []FieldError
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, FieldError{Field: "url", Detail: "must be an absolute http or https URL"})
+	}
+	if len(req.Events) == 0 {
+		errs = append(errs, FieldError{Field: "events", Detail: "must name at least one of " + strings.Join(webhookEventTypes, ", ")})
+	}
+	for i, e := range req.Events {
+		if !slices.Contains(webhookEventTypes, e) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("events[%d]", i), Detail: "unsupported event " + strconv.Quote(e) + "; events are " + strings.Join(webhookEventTypes, ", ")})
+		}
+	}
+	if req.Secret != "" && len(req.Secret) < minWebhookSecretLength {
+		errs = append(errs, FieldError{Field: "secret", Detail: fmt.Sprintf("must be at least %d characters", minWebhookSecretLength)})
+	}
+	for _, key := range slices.Sorted(maps.Keys(req.Filters)) {
+		if !slices.Contains(webhookFilterKeys, key) {
+			errs = append(errs, FieldError{Field: "filters." + key, Detail: "unknown filter; filters are " + strings.Join(webhookFilterKeys, ", ")})
+		} else if len(req.Filters[key]) == 0 {
+			errs = append(errs, FieldError{Field: "filters." + key, Detail: "must list at least one value"})
+		}
+	}
+	return errs
+}
+
+func decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (WebhookRequest, bool) {
+	var req WebhookRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return req, false
+	}
+	if errs := validateWebhookRequest(req); len(errs) > 0 {
+		writeFieldProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Invalid webhook", errs)
+		return req, false
+	}
+	if req.Filters == nil {
+		req.Filters = map[string][]string{}
+	}
+	return req, true
+}
+
+func webhookIDFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, errCodeInvalidRequest, "Webhook ID must be an integer")
+		return 0, false
+	}
+	return id, true
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT `+webhookColumns+` FROM {webhooks} ORDER BY id`))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list webhooks: "+err.Error())
+		return
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list webhooks: "+err.Error())
+			return
+		}
+		hooks = append(hooks, h)
+	}
+	if err := rows.Err(); err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to list webhooks: "+err.Error())
+		return
+	}
+	writeList(w, r, hooks)
+}
+
+func getWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := webhookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	h, err := scanWebhook(db.QueryRowContext(ctx, expandSQL(ctx, `SELECT `+webhookColumns+` FROM {webhooks} WHERE id = $1`), id))
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeWebhookNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to get webhook: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var description, createdBy *string
+	if req.Description != "" {
+		description = &req.Description
+	}
+	if p, ok := principalFromContext(ctx); ok {
+		createdBy = &p.Name
+	}
+	active := req.Active == nil || *req.Active
+	if req.Secret == "" {
+		req.Secret = generateWebhookSecret()
+	}
+	filters, _ := json.Marshal(req.Filters)
+	h, err := scanWebhook(db.QueryRowContext(ctx, expandSQL(ctx, `
+		INSERT INTO {webhooks} (url, events, filters, description, active, created_by, created_at, secret)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7)
+		RETURNING `+webhookColumns), req.URL, req.Events, filters, description, active, createdBy, req.Secret))
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to create webhook: "+err.Error())
+		return
+	}
+	logger.Info("Webhook created", "webhook_id", h.ID, "events", h.Events)
+	h.Secret = h.secret
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/webhooks/"+strconv.FormatInt(h.ID, 10))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h)
+}
+
+// updateWebhook replaces a subscription's URL, events, filters and
+// description; active and secret are left as they were when omitted.
+func updateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := webhookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var description *string
+	if req.Description != "" {
+		description = &req.Description
+	}
+	filters, _ := json.Marshal(req.Filters)
+	h, err := scanWebhook(db.QueryRowContext(ctx, expandSQL(ctx, `
+		UPDATE {webhooks} SET url = $2, events = $3, filters = $4, description = $5, active = COALESCE($6, active), secret = COALESCE(NULLIF($7, ''), secret)
+		WHERE id = $1
+		RETURNING `+webhookColumns), id, req.URL, req.Events, filters, description, req.Active, req.Secret))
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeWebhookNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to update webhook: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := webhookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	result, err := db.ExecContext(ctx, expandSQL(ctx, `DELETE FROM {webhooks} WHERE id = $1`), id)
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to delete webhook: "+err.Error())
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeProblem(w, http.StatusNotFound, errCodeWebhookNotFound, "Webhook not found")
+		return
+	}
+	logger.Info("Webhook deleted", "webhook_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// subscribedWebhooks are the active subscriptions to eventType.
+func subscribedWebhooks(ctx context.Context, eventType string) ([]Webhook, error) {
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `SELECT `+webhookColumns+` FROM {webhooks} WHERE active AND $1 = ANY(events) ORDER BY id`), eventType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hooks []Webhook
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// notifyWebhooks delivers events, all of one type, to each subscription whose
+// filters they pass, in the background so that imports never wait on a
+// receiver.
+func notifyWebhooks(ctx context.Context, eventType string, events []WebhookEvent) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		hooks, err := subscribedWebhooks(ctx, eventType)
+		if err != nil {
+			logger.Warn("Failed to load webhooks", "event", eventType, "error", err)
+			return
+		}
+		for _, h := range hooks {
+			var matched []WebhookEvent
+			for _, e := range events {
+				if h.matches(e) {
+					matched = append(matched, e)
+				}
+			}
+			for start := 0; start < len(matched); start += webhookBatchSize {
+				deliverWebhook(ctx, h, matched[start:min(start+webhookBatchSize, len(matched))])
+			}
+		}
+	}()
+}
+
+// deliverWebhook POSTs one batch, retrying network errors, 429s and 5xx up to
+// WEBHOOK_MAX_ATTEMPTS times with doubling backoff, and records the outcome
+// on the subscription.
+func deliverWebhook(ctx context.Context, h Webhook, events []WebhookEvent) {
+	body, _ := json.Marshal(WebhookPayload{WebhookID: h.ID, Events: events})
+	deliveryID := newRequestID()
+	backoff := time.Second
+	var status int
+	var lastErr error
+	for attempt := 1; attempt <= max(webhookMaxAttempts, 1); attempt++ {
+		status, lastErr = postWebhook(ctx, h, deliveryID, body)
+		if lastErr == nil || status != 0 && status != http.StatusTooManyRequests && status < 500 {
+			break
+		}
+		if attempt < webhookMaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	var errMsg *string
+	if lastErr != nil {
+		msg := lastErr.Error()
+		errMsg = &msg
+		logger.Warn("Webhook delivery failed", "webhook_id", h.ID, "events", len(events), "error", lastErr)
+	}
+	var lastStatus *int
+	if status != 0 {
+		lastStatus = &status
+	}
+	db.ExecContext(ctx, expandSQL(ctx, `UPDATE {webhooks} SET last_delivery_at = NOW(), last_status = $2, last_error = $3 WHERE id = $1`), h.ID, lastStatus, errMsg)
+}
+
+func postWebhook(ctx context.Context, h Webhook, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhookRequest(req, h.secret, deliveryID, body)
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.StatusCode, nil
+}
+
+func importWebhookEvent(e event) WebhookEvent {
+	return WebhookEvent{Type: e.Type, Workspace: e.Workspace, JobID: e.JobID, DataDate: e.DataDate, Rows: e.Rows, Error: e.Error, ErrorCode: e.ErrorCode, EmittedAt: e.EmittedAt}
+}
+
+// notifyNoteStatusChanges compares each note's classification with the one
+// recorded after the previous import and notifies note.status_changed
+// subscribers of those that differ. It only runs while someone subscribes;
+// the first run records the classifications without notifying.
+func notifyNoteStatusChanges(ctx context.Context, jobID, dataDate string, log *slog.Logger) {
+	hooks, err := subscribedWebhooks(ctx, eventNoteStatusChanged)
+	if err != nil || len(hooks) == 0 {
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, expandSQL(ctx, `
+		SELECT n.noteid, n.tweetid, s.classification, n.classification
+		FROM {note} n JOIN {note_statuses} s ON s.noteid = n.noteid
+		WHERE s.classification IS DISTINCT FROM n.classification AND `+notExcluded("n")))
+	if err != nil {
+		log.Warn("Failed to diff note statuses", "error", err)
+		return
+	}
+	var events []WebhookEvent
+	now := time.Now()
+	for rows.Next() {
+		e := WebhookEvent{Type: eventNoteStatusChanged, Workspace: eventWorkspace(ctx), JobID: jobID, DataDate: dataDate, EmittedAt: now}
+		var noteID int64
+		var tweetID, from, to sql.NullString
+		if err := rows.Scan(&noteID, &tweetID, &from, &to); err != nil {
+			rows.Close()
+			log.Warn("Failed to diff note statuses", "error", err)
+			return
+		}
+		e.NoteID, e.TweetID, e.From, e.To = &noteID, nullStringToStrPtr(tweetID), nullStringToStrPtr(from), nullStringToStrPtr(to)
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Warn("Failed to diff note statuses", "error", err)
+		return
+	}
+
+	if _, err := db.ExecContext(ctx, expandSQL(ctx, `
+		INSERT INTO {note_statuses} AS s (noteid, classification, updated_at)
+		SELECT noteid, classification, NOW() FROM {note}
+		ON CONFLICT (noteid) DO UPDATE SET classification = EXCLUDED.classification, updated_at = EXCLUDED.updated_at
+		WHERE s.classification IS DISTINCT FROM EXCLUDED.classification
+	`)); err != nil {
+		log.Warn("Failed to record note statuses", "error", err)
+		return
+	}
+	if len(events) > 0 {
+		log.Info("Notifying note status changes", "notes", len(events))
+		notifyWebhooks(ctx, eventNoteStatusChanged, events)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	webhookSignatureHeader = "X-Signature"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	minWebhookSecretLength = 16
+)
+
+func generateWebhookSecret() string {
+	return "whsec_" + generateAPIKey()
+}
+
+// webhookSignature is the X-Signature value for body sent at ts:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". Signing the
+// timestamp with the body lets receivers reject replays of an old delivery.
+func webhookSignature(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(hmacSHA256([]byte(secret), t+"."+string(body)))
+}
+
+// signWebhookRequest sets the delivery ID, which stays the same across
+// retries so that receivers can drop duplicates, and a signature made for
+// this attempt. Subscriptions without a secret are sent unsigned.
+func signWebhookRequest(req *http.Request, secret, deliveryID string, body []byte) {
+	now := time.Now()
+	req.Header.Set(webhookDeliveryHeader, deliveryID)
+	req.Header.Set(webhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	if secret != "" {
+		req.Header.Set(webhookSignatureHeader, webhookSignature(secret, now, body))
+	}
+}
+
+// rotateWebhookSecret replaces the subscription's signing secret and returns
+// the new one, the only time it is shown.
+func rotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := webhookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	secret := generateWebhookSecret()
+	h, err := scanWebhook(db.QueryRowContext(ctx, expandSQL(ctx, `UPDATE {webhooks} SET secret = $2 WHERE id = $1 RETURNING `+webhookColumns), id, secret))
+	if err == sql.ErrNoRows {
+		writeProblem(w, http.StatusNotFound, errCodeWebhookNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, errCodeInternalError, "Failed to rotate webhook secret: "+err.Error())
+		return
+	}
+	logger.Info("Webhook secret rotated", "webhook_id", h.ID)
+	h.Secret = h.secret
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
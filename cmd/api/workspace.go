@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkspaceName = "default"
+	workspacePathPrefix  = "/workspaces/"
+)
+
+type schedulerState struct {
+	mu        sync.RWMutex
+	lastCheck time.Time
+	nextRun   time.Time
+	lastPoll  time.Time
+	nextPoll  time.Time
+}
+
+type workspace struct {
+	Name     string
+	Schema   string
+	Interval time.Duration
+
+	scheduler *schedulerState
+	sql       *strings.Replacer
+}
+
+func newWorkspace(name, schema string, interval time.Duration) *workspace {
+	return &workspace{
+		Name:      name,
+		Schema:    schema,
+		Interval:  interval,
+		scheduler: &schedulerState{},
+		sql:       newSQLTemplate(schema),
+	}
+}
+
+func (ws *workspace) dataDir() string {
+	if ws.Name == defaultWorkspaceName {
+		return dataDir
+	}
+	return filepath.Join(dataDir, ws.Name)
+}
+
+var (
+	defaultWorkspace = newWorkspace(defaultWorkspaceName, dbSchema, autoImportInterval)
+	workspaces       = map[string]*workspace{defaultWorkspaceName: defaultWorkspace}
+	workspaceOrder   = []*workspace{defaultWorkspace}
+)
+
+// loadWorkspaces parses WORKSPACES, a comma-separated list of name[:interval]
+// entries. Each workspace gets its own Postgres schema named after it, its own
+// data directory and its own auto-import interval (AUTO_IMPORT_INTERVAL when
+// omitted); the default workspace keeps DB_SCHEMA.
+func loadWorkspaces() error {
+	schemas := map[string]string{dbSchema: defaultWorkspaceName}
+
+	for _, entry := range strings.Split(getEnv("WORKSPACES", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, intervalSpec, hasInterval := strings.Cut(entry, ":")
+		if !identifierPattern.MatchString(name) {
+			return fmt.Errorf("workspace name %q is not a valid identifier", name)
+		}
+		if _, exists := workspaces[name]; exists {
+			return fmt.Errorf("workspace %q is declared more than once", name)
+		}
+		if other, taken := schemas[name]; taken {
+			return fmt.Errorf("workspace %q would share schema %q with workspace %q", name, name, other)
+		}
+
+		interval := autoImportInterval
+		if hasInterval {
+			d, err := time.ParseDuration(intervalSpec)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("workspace %q has invalid interval %q", name, intervalSpec)
+			}
+			interval = d
+		}
+
+		ws := newWorkspace(name, name, interval)
+		workspaces[name] = ws
+		workspaceOrder = append(workspaceOrder, ws)
+		schemas[name] = name
+	}
+	return nil
+}
+
+type workspaceKey struct{}
+
+func withWorkspace(ctx context.Context, ws *workspace) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, ws)
+}
+
+func workspaceFromContext(ctx context.Context) *workspace {
+	if ws, ok := ctx.Value(workspaceKey{}).(*workspace); ok {
+		return ws
+	}
+	return defaultWorkspace
+}
+
+// workspaceMiddleware selects the workspace from a /workspaces/{name} path
+// prefix, which is stripped before routing, or from the X-Workspace header.
+func workspaceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get("X-Workspace")
+		if rest, ok := strings.CutPrefix(r.URL.Path, workspacePathPrefix); ok {
+			var path string
+			name, path, _ = strings.Cut(rest, "/")
+			u := *r.URL
+			u.Path = "/" + path
+			u.RawPath = ""
+			r = r.Clone(r.Context())
+			r.URL = &u
+		}
+
+		ws := defaultWorkspace
+		if name != "" {
+			var ok bool
+			if ws, ok = workspaces[name]; !ok {
+				writeProblem(w, http.StatusNotFound, errCodeWorkspaceNotFound, "Workspace '"+name+"' does not exist")
+				return
+			}
+		}
+
+		w.Header().Set("X-Workspace", ws.Name)
+		next.ServeHTTP(w, r.WithContext(withWorkspace(r.Context(), ws)))
+	})
+}
+
+// jobLogger tags records with the job and, outside the default workspace, the
+// workspace so the job log writer stores them in the right import_logs table.
+func jobLogger(ctx context.Context, jobID string) *slog.Logger {
+	log := logger.With("job_id", jobID)
+	if ws := workspaceFromContext(ctx); ws != defaultWorkspace {
+		log = log.With("workspace", ws.Name)
+	}
+	return log
+}
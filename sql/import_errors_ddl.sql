@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS import_errors (
+    id BIGSERIAL PRIMARY KEY,
+    job_id UUID,
+    file_name TEXT NOT NULL,
+    noteid TEXT,
+    column_name TEXT NOT NULL,
+    value TEXT,
+    error TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_errors_job_id ON import_errors(job_id, id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS import_files (
+    job_id UUID NOT NULL,
+    file_index INT NOT NULL,
+    file_name TEXT NOT NULL,
+    file_size BIGINT,
+    status TEXT CHECK (status IN ('pending', 'downloaded', 'imported')) NOT NULL,
+    expected_rows INT,
+    rows_imported INT,
+    cached BOOLEAN,
+    download_duration INT,
+    import_duration INT,
+    imported_at TIMESTAMP,
+    copy_attempts INT,
+    content_hash TEXT,
+    bytes_downloaded BIGINT,
+    PRIMARY KEY (job_id, file_index)
+);
@@ -4,7 +4,7 @@ CREATE TABLE IF NOT EXISTS import_history (
     started_at TIMESTAMP NOT NULL,
     completed_at TIMESTAMP,
     total_rows INT,
-    status TEXT CHECK (status IN ('importing', 'completed', 'failed', 'idle', 'downloading', 'indexing', 'skipped')) NOT NULL,
+    status TEXT CHECK (status IN ('importing', 'completed', 'failed', 'idle', 'downloading', 'indexing', 'skipped', 'paused', 'skipped_unchanged', 'completed_with_warnings')) NOT NULL,
     error_message TEXT,
     download_percentage INT,
     download_speed TEXT,
@@ -25,7 +25,32 @@ CREATE TABLE IF NOT EXISTS import_history (
     indexing_started_at TIMESTAMP,
     index_phase TEXT,
     index_blocks_done INT,
-    index_blocks_total INT
+    index_blocks_total INT,
+    pause_requested BOOLEAN DEFAULT false,
+    paused_at TIMESTAMP,
+    labels TEXT[] DEFAULT '{}',
+    note TEXT,
+    triggered_by TEXT CHECK (triggered_by IN ('user', 'api-key', 'schedule', 'startup')),
+    triggered_by_name TEXT,
+    schema_version TEXT,
+    events_published INT,
+    notes_embedded INT,
+    offline BOOLEAN DEFAULT false,
+    snapshot_fingerprint TEXT,
+    error_code TEXT,
+    load_mode TEXT DEFAULT 'truncate',
+    owner_instance TEXT,
+    heartbeat_at TIMESTAMP,
+    file_list JSONB,
+    parent_job_id UUID,
+    warnings TEXT[],
+    progress_at TIMESTAMP,
+    file_range TEXT,
+    pseudonym_key TEXT,
+    row_limit INTEGER
 );
 
 CREATE INDEX IF NOT EXISTS idx_import_history_started_at ON import_history(started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_import_history_labels ON import_history USING GIN (labels);
+CREATE INDEX IF NOT EXISTS idx_import_history_error_code ON import_history(error_code) WHERE error_code IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_import_history_parent_job_id ON import_history(parent_job_id) WHERE parent_job_id IS NOT NULL;
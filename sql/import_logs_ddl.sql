@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS import_logs (
+    id BIGSERIAL PRIMARY KEY,
+    job_id UUID NOT NULL,
+    logged_at TIMESTAMPTZ NOT NULL,
+    level TEXT NOT NULL,
+    message TEXT NOT NULL,
+    attrs JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_logs_job_id ON import_logs(job_id, id);
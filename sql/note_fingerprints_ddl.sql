@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS note_fingerprints (
+    noteid BIGINT PRIMARY KEY,
+    hash TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS note_schema_versions (
+    version TEXT PRIMARY KEY,
+    columns TEXT[] NOT NULL,
+    first_seen_at TIMESTAMP NOT NULL
+);
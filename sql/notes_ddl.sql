@@ -1,7 +1,7 @@
 CREATE TABLE note (
     noteid bigint NOT NULL,
     noteauthorparticipantid character varying(255),
-    createdatmillis bigint,
+    created_at timestamptz,
     tweetid character varying(255),
     classification character varying(255),
     believable character varying(255),
@@ -24,14 +24,21 @@ CREATE TABLE note (
     ismedianote integer NOT NULL,
     iscollaborativenote integer NOT NULL,
 
-    summary_ts tsvector GENERATED ALWAYS AS (to_tsvector('english'::regconfig, (summary)::text)) STORED
+    summary_ts tsvector GENERATED ALWAYS AS (to_tsvector('english'::regconfig, (summary)::text)) STORED,
+
+    tweet_id bigint GENERATED ALWAYS AS (CASE WHEN tweetid ~ '^[0-9]{1,19}$' AND tweetid::numeric <= 9223372036854775807 THEN tweetid::bigint END) STORED,
+    tweet_url text GENERATED ALWAYS AS (CASE WHEN tweetid ~ '^[0-9]+$' THEN 'https://x.com/i/status/' || tweetid END) STORED,
+    created_date date GENERATED ALWAYS AS ((created_at AT TIME ZONE 'UTC')::date) STORED,
+    is_media_note boolean GENERATED ALWAYS AS (ismedianote <> 0) STORED,
+    is_collaborative_note boolean GENERATED ALWAYS AS (iscollaborativenote <> 0) STORED,
+    has_trustworthy_sources boolean GENERATED ALWAYS AS (trustworthysources <> 0) STORED
 );
 
 
 ALTER TABLE ONLY public.note
     ADD CONSTRAINT note_pkey PRIMARY KEY (noteid);
 
-CREATE INDEX idx3yl33mmhbcw582lic7c7fqqu4 ON public.note USING btree (createdatmillis);
+CREATE INDEX idx3yl33mmhbcw582lic7c7fqqu4 ON public.note USING btree (created_at);
 CREATE INDEX idxovqwtw36x36lo9smq4lbxjcps ON public.note USING btree (noteauthorparticipantid);
-CREATE INDEX idxu0f5st3d4b4c55eh9kqyd3yk ON public.note USING btree (tweetid);
+CREATE INDEX idxu0f5st3d4b4c55eh9kqyd3yk ON public.note USING btree (tweet_id);
 CREATE INDEX ts_idx ON public.note USING gin (summary_ts);